@@ -13,10 +13,18 @@ package main
 
 
 import (
+	"bytes"
+	"crypto/md5"
 	"fmt"
+	"hash"
+	"io"
 	"os"
 	"math"
+	"math/bits"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"./flac"
 	"./wvencode"
 )
 
@@ -27,12 +35,37 @@ const usage3 string = "\n"
 const usage4 string = "  Options: \n       -bn = enable hybrid compression, n = 2.0 to 16.0 bits/sample\n"
 const usage5 string = "       -c  = create correction file (.wvc) for hybrid mode (=lossless)\n"
 const usage6 string = "       -cc = maximum hybrid compression (hurts lossy quality & decode speed)\n"
-const usage7 string = "       -f  = fast mode (fast, but some compromise in compression ratio)\n"
-const usage8 string = "       -h  = high quality (better compression in all modes, but slower)\n"
-const usage9 string = "       -hh = very high quality (best compression in all modes, but slowest\n"
-const usage10 string = "                              and NOT recommended for portable hardware use)\n"
-const usage11 string = "       -jn = joint-stereo override (0 = left/right, 1 = mid/side)\n"
-const usage12 string = "       -sn = noise shaping override (hybrid only, n = -1.0 to 1.0, 0 = off)\n"
+const usage7 string = "       -en = enable hybrid compression with a fixed maximum error instead of\n"
+const usage8 string = "             a bitrate, n = -6.0 to -144.0 dB relative to full scale\n"
+const usage9 string = "       -f  = fast mode (fast, but some compromise in compression ratio)\n"
+const usage10 string = "       -h  = high quality (better compression in all modes, but slower)\n"
+const usage11 string = "       -hh = very high quality (best compression in all modes, but slowest\n"
+const usage12 string = "                              and NOT recommended for portable hardware use)\n"
+const usage13 string = "       -jn = joint-stereo override (0 = left/right, 1 = mid/side, 2 = auto)\n"
+const usage14 string = "       -m  = compute & store MD5 signature of raw audio data\n"
+const usage15 string = "       -sn = noise shaping override (hybrid only, n = -1.0 to 1.0, 0 = off)\n"
+const usage16 string = "       --adaptive-blocks = choose the length of each block by trial packing\n"
+const usage17 string = "                           (lossless only, and slower)\n"
+const usage18 string = "       --target-size n = hybrid compression to a .wv file of n bytes, using\n"
+const usage19 string = "                         a fast analysis pass to set the bitrate of each block\n"
+const usage20 string = "       --target-tolerance n = allowed error of --target-size in percent (default 1)\n"
+const usage21 string = "       --raw = input is raw PCM with no header, described by these options\n"
+const usage22 string = "               (defaults are 44100 Hz, 2 channels, 16 bits, little-endian):\n"
+const usage23 string = "       --rate n       = sample rate of raw input in Hz\n"
+const usage24 string = "       --channels n   = number of channels of raw input (1 or 2)\n"
+const usage25 string = "       --bits n       = bits per sample of raw input (1 to 24)\n"
+const usage26 string = "       --endian little|big = byte order of raw input\n"
+const usage27 string = "       --signed / --unsigned = raw samples are signed or unsigned (default\n"
+const usage28 string = "                        is unsigned for 8 bits and signed otherwise)\n"
+
+// format flags of the CAF desc chunk and channel layout tags of the chan chunk
+const CAF_FORMAT_FLOAT uint = 1
+const CAF_FORMAT_LITTLE_ENDIAN uint = 2
+const CAF_LAYOUT_USE_DESCRIPTIONS uint = 0
+const CAF_LAYOUT_USE_BITMAP uint = (1 << 16)
+const CAF_LAYOUT_MONO uint = (100 << 16) | 1
+const CAF_LAYOUT_STEREO uint = (101 << 16) | 2
+const CAF_LAYOUT_STEREO_HEADPHONES uint = (102 << 16) | 2
 
 
 func usage() {
@@ -49,6 +82,22 @@ func usage() {
 	fmt.Printf(usage10)
 	fmt.Printf(usage11)
 	fmt.Printf(usage12)
+	fmt.Printf(usage13)
+	fmt.Printf(usage14)
+	fmt.Printf(usage15)
+	fmt.Printf(usage16)
+	fmt.Printf(usage17)
+	fmt.Printf(usage18)
+	fmt.Printf(usage19)
+	fmt.Printf(usage20)
+	fmt.Printf(usage21)
+	fmt.Printf(usage22)
+	fmt.Printf(usage23)
+	fmt.Printf(usage24)
+	fmt.Printf(usage25)
+	fmt.Printf(usage26)
+	fmt.Printf(usage27)
+	fmt.Printf(usage28)
 
 	os.Exit(1)
 }
@@ -59,7 +108,12 @@ func main() {
 	// destination WavPack file (.wv) and an optional WavPack correction file
 	// (.wvc) on the command-line. It supports all 4 encoding qualities in
 	// pure lossless, hybrid lossy and hybrid lossless modes. Valid input are
-	// mono or stereo integer WAV files with bitdepths from 8 to 24.
+	// mono or stereo integer WAV, AIFF or CAF files with bitdepths from 8 to
+	// 24, and CAF files with 32-bit floating point data (lossless only).
+	// DSD audio from DSF and DSDIFF files is also packed (lossless only),
+	// in which case -f selects the "fast" mode and the default is "high".
+	// Headerless (raw) PCM is also accepted with the --raw option, in which
+	// case the format must be described with the other long options.
 	// This program (and the tiny encoder) do not handle placing the WAV RIFF
 	// header into the WavPack file. The latest version of the regular WavPack
 	// unpacker (4.40) and the "tiny decoder" will generate the RIFF header
@@ -84,6 +138,15 @@ func main() {
 	var result int
 	var arg_idx int = 0
 	var numArgs int = 0
+	var raw_pcm int = wvencode.FALSE
+	var raw_rate int = 44100
+	var raw_channels int = 2
+	var raw_bits int = 16
+	var raw_endian string = "little"
+	var raw_signed int = -1 // not specified, so depends on the bits
+	var raw_options int = 0
+	var target_size int64 = 0
+	var target_tolerance float64 = 0 // percent, 1.0 if not specified
 
 	numArgs = len(os.Args)
 
@@ -95,7 +158,112 @@ func main() {
 			break
 		}
 
-		if os.Args[arg_idx][0] == '-' && len(os.Args[arg_idx]) > 1 {
+		if os.Args[arg_idx][0] == '-' && len(os.Args[arg_idx]) > 2 && os.Args[arg_idx][1] == '-' {
+			// long options (mostly describing raw input) can be given as
+			// either --option value or --option=value
+			var option string = os.Args[arg_idx][2:len(os.Args[arg_idx])]
+			var value string = ""
+			var has_value int = wvencode.FALSE
+
+			if i := strings.Index(option, "="); i >= 0 {
+				value = option[i+1 : len(option)]
+				option = option[0:i]
+				has_value = wvencode.TRUE
+			}
+
+			if (option == "rate") || (option == "channels") || (option == "bits") || (option == "endian") ||
+				(option == "target-size") || (option == "target-tolerance") {
+				if has_value == wvencode.FALSE {
+					arg_idx++
+
+					if arg_idx >= numArgs {
+						fmt.Printf("--%s needs a value!\n", option)
+						error_count++
+						break
+					}
+
+					value = os.Args[arg_idx]
+				}
+			} else if has_value == wvencode.TRUE {
+				fmt.Printf("illegal option: %s\n", os.Args[arg_idx])
+				error_count++
+			}
+
+			if option == "raw" {
+				raw_pcm = wvencode.TRUE
+			} else if option == "rate" {
+				pint, err := strconv.Atoi(value)
+
+				if (err != nil) || (pint < 1) || (pint > 0xffffff) {
+					fmt.Printf("--rate must be 1 to 16777215!\n")
+					error_count++
+				} else {
+					raw_rate = pint
+				}
+
+				raw_options++
+			} else if option == "channels" {
+				pint, err := strconv.Atoi(value)
+
+				if (err != nil) || (pint < 1) || (pint > 2) {
+					fmt.Printf("--channels must be 1 or 2!\n")
+					error_count++
+				} else {
+					raw_channels = pint
+				}
+
+				raw_options++
+			} else if option == "bits" {
+				pint, err := strconv.Atoi(value)
+
+				if (err != nil) || (pint < 1) || (pint > 24) {
+					fmt.Printf("--bits must be 1 to 24!\n")
+					error_count++
+				} else {
+					raw_bits = pint
+				}
+
+				raw_options++
+			} else if option == "endian" {
+				if (strings.ToLower(value) == "little") || (strings.ToLower(value) == "big") {
+					raw_endian = strings.ToLower(value)
+				} else {
+					fmt.Printf("--endian must be little or big!\n")
+					error_count++
+				}
+
+				raw_options++
+			} else if option == "adaptive-blocks" {
+				config.Flags |= wvencode.CONFIG_ADAPTIVE_BLOCKS
+			} else if option == "target-size" {
+				pint, err := strconv.ParseInt(value, 10, 64)
+
+				if (err != nil) || (pint < 1) {
+					fmt.Printf("--target-size must be a number of bytes!\n")
+					error_count++
+				} else {
+					target_size = pint
+				}
+			} else if option == "target-tolerance" {
+				pd, err := strconv.ParseFloat(value, 64)
+
+				if (err != nil) || (pd < 0.1) || (pd > 50.0) {
+					fmt.Printf("--target-tolerance must be 0.1 to 50.0 percent!\n")
+					error_count++
+				} else {
+					target_tolerance = pd
+				}
+			} else if option == "signed" {
+				raw_signed = wvencode.TRUE
+				raw_options++
+			} else if option == "unsigned" {
+				raw_signed = wvencode.FALSE
+				raw_options++
+			} else {
+				fmt.Printf("illegal option: %s\n", os.Args[arg_idx])
+				error_count++
+			}
+		} else if os.Args[arg_idx][0] == '-' && len(os.Args[arg_idx]) > 1 {
 			if os.Args[arg_idx][1] == 'c' || os.Args[arg_idx][1] == 'C' {
 				if len(os.Args[arg_idx]) > 2 {
 					if os.Args[arg_idx][2] == 'c' || os.Args[arg_idx][2] == 'C' {
@@ -175,6 +343,34 @@ func main() {
 					fmt.Printf("hybrid spec must be 2.0 to 16.0!\n")
 					error_count++
 				}
+			} else if os.Args[arg_idx][1] == 'e' || os.Args[arg_idx][1] == 'E' {
+
+				config.Flags = config.Flags | (wvencode.CONFIG_HYBRID_FLAG | wvencode.CONFIG_FIXED_ERROR)
+				config.Error_level = 0
+
+				if len(os.Args[arg_idx]) > 2 { // handle the case where the string is passed in form -e-60 (number beside e)
+					var substring string = os.Args[arg_idx][2:len(os.Args[arg_idx])]
+					pd, err := strconv.ParseFloat(substring, 64)
+					if err == nil {
+						config.Error_level = int(math.Floor((pd * 256.0)))
+					}
+				} else {
+					arg_idx++
+
+					if arg_idx >= numArgs {
+						break
+					}
+
+					pd, err := strconv.ParseFloat(os.Args[arg_idx], 64)
+					if err == nil {
+						config.Error_level = int(math.Floor((pd * 256.0)))
+					}
+				}
+
+				if (config.Error_level > -1536) || (config.Error_level < -36864) {
+					fmt.Printf("fixed error must be -6.0 to -144.0 dB!\n")
+					error_count++
+				}
 			} else if os.Args[arg_idx][1] == 'j' || os.Args[arg_idx][1] == 'J' {
 
 				var passedInt int = 0
@@ -207,10 +403,15 @@ func main() {
 					config.Flags = config.Flags & ^wvencode.CONFIG_JOINT_STEREO
 				} else if passedInt == 1 {
 					config.Flags = config.Flags | (wvencode.CONFIG_JOINT_OVERRIDE | wvencode.CONFIG_JOINT_STEREO)
+				} else if passedInt == 2 {
+					config.Flags = config.Flags & ^(wvencode.CONFIG_JOINT_OVERRIDE | wvencode.CONFIG_JOINT_STEREO)
+					config.Flags = config.Flags | wvencode.CONFIG_JOINT_AUTO
 				} else {
-					fmt.Printf("-j0 or -j1 only!\n")
+					fmt.Printf("-j0, -j1 or -j2 only!\n")
 					error_count++
 				}
+			} else if os.Args[arg_idx][1] == 'm' || os.Args[arg_idx][1] == 'M' {
+				config.Flags = config.Flags | wvencode.CONFIG_MD5_CHECKSUM
 			} else if os.Args[arg_idx][1] == 's' || os.Args[arg_idx][1] == 'S' {
 
 				if len(os.Args[arg_idx]) > 2 { // handle the case where the string is passed in form -s0 (number beside s)
@@ -274,6 +475,28 @@ func main() {
 		error_count++
 	}
 
+	if ((config.Flags & wvencode.CONFIG_FIXED_ERROR) != 0) && (config.Bitrate != 0) {
+		fmt.Printf("-b and -e options are mutually exclusive!\n")
+		error_count++
+	}
+
+	// a target size is hybrid mode with the bitrate worked out for each block
+	if target_size != 0 {
+		if (config.Flags & wvencode.CONFIG_HYBRID_FLAG) != 0 {
+			fmt.Printf("--target-size can't be used with -b or -e!\n")
+			error_count++
+		}
+
+		config.Flags |= wvencode.CONFIG_HYBRID_FLAG
+
+		if target_tolerance == 0 {
+			target_tolerance = 1.0
+		}
+	} else if target_tolerance != 0 {
+		fmt.Printf("--target-tolerance is only valid with --target-size!\n")
+		error_count++
+	}
+
 	if (config.Flags & wvencode.CONFIG_HYBRID_FLAG) != 0 {
 		if ((config.Flags & wvencode.CONFIG_CREATE_WVC) != 0) && (len(out2filename) == 0) {
 			fmt.Printf("need name for correction file!\n")
@@ -281,7 +504,7 @@ func main() {
 		}
 	} else {
 		if (config.Flags & (wvencode.CONFIG_SHAPE_OVERRIDE | wvencode.CONFIG_CREATE_WVC)) != 0 {
-			fmt.Printf("-s and -c options are for hybrid mode (-b) only!\n")
+			fmt.Printf("-s and -c options are for hybrid mode (-b or -e) only!\n")
 			error_count++
 		}
 	}
@@ -291,6 +514,29 @@ func main() {
 		error_count++
 	}
 
+	// the format of raw input is given entirely by the command-line
+	if raw_pcm == wvencode.TRUE {
+		config.Sample_rate = uint(raw_rate)
+		config.Num_channels = uint(raw_channels)
+		config.Bits_per_sample = raw_bits
+		config.Bytes_per_sample = (raw_bits + 7) / 8
+
+		if raw_endian == "big" {
+			config.Qmode |= wvencode.QMODE_BIG_ENDIAN
+		}
+
+		if config.Bytes_per_sample == 1 {
+			if raw_signed == wvencode.TRUE {
+				config.Qmode |= wvencode.QMODE_SIGNED_BYTES
+			}
+		} else if raw_signed == wvencode.FALSE {
+			config.Qmode |= wvencode.QMODE_UNSIGNED_WORDS
+		}
+	} else if raw_options != 0 {
+		fmt.Printf("the raw input options are only valid with --raw!\n")
+		error_count++
+	}
+
 	if error_count == 0 {
 		fmt.Printf(sign_on1)
 		fmt.Printf(sign_on2)
@@ -303,7 +549,11 @@ func main() {
 		usage()
 	}
 
-	result = pack_file(infilename, outfilename, out2filename, config)
+	if target_size != 0 {
+		result = pack_file_to_size(infilename, outfilename, out2filename, config, raw_pcm, target_size, target_tolerance)
+	} else {
+		result = pack_file(infilename, outfilename, out2filename, config, raw_pcm, nil)
+	}
 
 	if result > 0 {
 		fmt.Printf("error occured!\n")
@@ -313,25 +563,73 @@ func main() {
 }
 
 
+// The state of packing a file to a target size, which takes two passes over
+// the file. The analysis pass fills in the size of each block and the bytes
+// that are not in blocks of audio (such as tags), and the final pass plans
+// the bitrate of each of its blocks from these.
+type size_plan struct {
+	target_size   int64
+	block_sizes   []int // nil until the analysis pass is done
+	block_samples uint  // samples in each block of the analysis pass
+	overhead      int64 // bytes outside of the audio blocks
+	file_size     int64 // size of the file from the last pass
+}
+
+// This function packs "infilename" in hybrid mode to a WavPack file that is
+// within "tolerance" percent of "target_size" bytes. The file is first packed
+// in fast mode with the output thrown away, at a bitrate estimated from the
+// target size, to find out how big each block is at that bitrate. It is then
+// packed for real with the bitrate of each block scaled from the analysis so
+// that the blocks add up to the target (see WavpackSetTargetSize()).
+func pack_file_to_size(infilename string, outfilename string, out2filename string, config *wvencode.WavpackConfig, raw_pcm int, target_size int64, tolerance float64) int {
+	var analysis_config wvencode.WavpackConfig = *config
+	var result int
+	plan := new(size_plan)
+
+	plan.target_size = target_size
+
+	analysis_config.Flags &= ^(wvencode.CONFIG_HIGH_FLAG | wvencode.CONFIG_VERY_HIGH_FLAG |
+		wvencode.CONFIG_CREATE_WVC | wvencode.CONFIG_OPTIMIZE_WVC)
+	analysis_config.Flags |= wvencode.CONFIG_FAST_FLAG
+
+	result = pack_file(infilename, os.DevNull, "", &analysis_config, raw_pcm, plan)
+
+	if result != wvencode.NO_ERROR {
+		return result
+	}
+
+	result = pack_file(infilename, outfilename, out2filename, config, raw_pcm, plan)
+
+	if result != wvencode.NO_ERROR {
+		return result
+	}
+
+	if math.Abs(float64(plan.file_size-target_size)) > (float64(target_size) * tolerance / 100.0) {
+		fmt.Printf("%s is %d bytes, which is not within %.1f%% of the target size!\n",
+			outfilename, plan.file_size, tolerance)
+
+		return wvencode.SOFT_ERROR
+	}
+
+	return wvencode.NO_ERROR
+}
+
 // This function packs a single file "infilename" and stores the result at
 // "outfilename". If "out2filename" is specified, then the "correction"
 // file would go there. The files are opened and closed in this function
-// and the "config" structure specifies the mode of compression.
-func pack_file(infilename string, outfilename string, out2filename string, config *wvencode.WavpackConfig) int {
-	var total_samples uint = 0
+// and the "config" structure specifies the mode of compression. If
+// "raw_pcm" is TRUE then the input has no header and its format has
+// already been filled into "config". If "plan" is not nil then this is
+// one of the passes of pack_file_to_size().
+func pack_file(infilename string, outfilename string, out2filename string, config *wvencode.WavpackConfig, raw_pcm int, plan *size_plan) int {
+	var total_samples int64 = 0
 	var bcount int
 	var loc_config *wvencode.WavpackConfig = config
+	var flc *flac.FlacContext
+	var md5_context hash.Hash
+	var flac_md5_context hash.Hash
+	var md5_mismatch int = wvencode.FALSE
 	riff_chunk_header := make([]int, 12)
-	chunk_header := make([]int, 8)
-
-	var WaveHeader []int
-	var whBlockAlign int = 1
-	var whFormatTag int = 0
-	var whSubFormat int = 0
-	var whBitsPerSample int = 0
-	var whValidBitsPerSample int = 0
-	var whNumChannels uint = 0
-	var whSampleRate uint = 0
 
 	wpc := new(wvencode.WavpackContext)
 	var result int
@@ -344,7 +642,8 @@ func pack_file(infilename string, outfilename string, out2filename string, confi
 		return (result)
 	}
 
-	wv_file, err := os.OpenFile(outfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	// opened for reading also in case the first block has to be re-written
+	wv_file, err := os.OpenFile(outfilename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 
 	if err != nil {
 		fmt.Printf("Error creating output file %s - error code is %s\n", outfilename, err)
@@ -356,155 +655,95 @@ func pack_file(infilename string, outfilename string, out2filename string, confi
 
 	bcount = 0
 
-	// 12 is the size of the RIFF Chunk header
-	bcount = DoReadFile(din, riff_chunk_header, 12)
-
-	// ASCII values R = 82, I = 73, F = 70 (RIFF)
-	// ASCII values W = 87, A = 65, V = 86, E = 69 (WAVE)
-	if (bcount != 12) || (riff_chunk_header[0] != 82) || (riff_chunk_header[1] != 73) ||
-		(riff_chunk_header[2] != 70) || (riff_chunk_header[3] != 70) ||
-		(riff_chunk_header[8] != 87) || (riff_chunk_header[9] != 65) ||
-		(riff_chunk_header[10] != 86) || (riff_chunk_header[11] != 69) {
-
-		fmt.Printf("%s is not a valid .WAV file!\n", infilename)
+	if raw_pcm == wvencode.TRUE {
+		total_samples, result = setup_raw_input(din, infilename, loc_config, wpc)
+	} else {
+		// 12 is the size of the RIFF Chunk header
+		bcount = DoReadFile(din, riff_chunk_header, 12)
+
+		// ASCII values F = 70, O = 79, R = 82, M = 77 (FORM)
+		if (bcount == 12) && (riff_chunk_header[0] == 70) && (riff_chunk_header[1] == 79) &&
+			(riff_chunk_header[2] == 82) && (riff_chunk_header[3] == 77) {
+			total_samples, result = parse_aiff_header(din, infilename, riff_chunk_header, loc_config, wpc)
+		} else if (bcount == 12) && (riff_chunk_header[0] == 99) && (riff_chunk_header[1] == 97) &&
+			(riff_chunk_header[2] == 102) && (riff_chunk_header[3] == 102) {
+			// ASCII values c = 99, a = 97, f = 102 (caff)
+			total_samples, result = parse_caf_header(din, infilename, riff_chunk_header, loc_config, wpc)
+		} else if (bcount == 12) && (((riff_chunk_header[0] == 102) && (riff_chunk_header[1] == 76) &&
+			(riff_chunk_header[2] == 97) && (riff_chunk_header[3] == 67)) ||
+			((riff_chunk_header[0] == 73) && (riff_chunk_header[1] == 68) && (riff_chunk_header[2] == 51))) {
+			// ASCII values f = 102, L = 76, a = 97, C = 67 (fLaC) or I = 73, D = 68, 3 = 51 (ID3)
+			flc = new(flac.FlacContext)
+			total_samples, result = parse_flac_header(din, infilename, riff_chunk_header, loc_config, flc)
+		} else if (bcount == 12) && (riff_chunk_header[0] == 68) && (riff_chunk_header[1] == 83) &&
+			(riff_chunk_header[2] == 68) && (riff_chunk_header[3] == 32) {
+			// ASCII values D = 68, S = 83, space = 32 ('DSD ')
+			total_samples, result = parse_dsf_header(din, infilename, riff_chunk_header, loc_config, wpc)
+		} else if (bcount == 12) && (riff_chunk_header[0] == 70) && (riff_chunk_header[1] == 82) &&
+			(riff_chunk_header[2] == 77) && (riff_chunk_header[3] == 56) {
+			// ASCII values F = 70, R = 82, M = 77, 8 = 56 ('FRM8')
+			total_samples, result = parse_dff_header(din, infilename, riff_chunk_header, loc_config, wpc)
+		} else {
+			total_samples, result = parse_riff_header(din, infilename, riff_chunk_header, bcount, loc_config)
+		}
+	}
 
+	if result != wvencode.NO_ERROR {
 		wv_file.Close()
 
-		return wvencode.SOFT_ERROR
+		return result
 	}
 
-	// loop through all elements of the RIFF wav header (until the data chuck)
-	var chunkSize int = 0
-
-	for {
-		// ChunkHeader has a size of 8
-		bcount = DoReadFile(din, chunk_header, 8)
-
-		if bcount != 8 {
-			fmt.Printf("%s is not a valid .WAV file!\n", infilename)
-
+	// for a target size the bitrate starts out as the average that would fill
+	// it, and in the final pass each block is scaled from there
+	if plan != nil {
+		if total_samples <= 0 {
+			fmt.Printf("the length of %s must be known to pack it to a target size!\n", infilename)
 			wv_file.Close()
 
 			return wvencode.SOFT_ERROR
 		}
 
-		chunkSize = (chunk_header[4] & 0xFF) + ((chunk_header[5] & 0xFF) << 8) +
-			((chunk_header[6] & 0xFF) << 16) + ((chunk_header[7] & 0xFF) << 24)
-
-		// if it's the format chunk, we want to get some info out of there and
-		// make sure it's a .wav file we can handle
-		// ASCII values f = 102, m = 109, t = 116, space = 32 ('fmt ')
-		if (chunk_header[0] == 102) && (chunk_header[1] == 109) && (chunk_header[2] == 116) &&
-			(chunk_header[3] == 32) {
-			var supported int = wvencode.TRUE
-			var format int
-			var check int = 0
-
-			if (chunkSize >= 16) && (chunkSize <= 40) {
-				var ckSize int = chunkSize
-
-				WaveHeader = make([]int, ckSize)
-
-				bcount = DoReadFile(din, WaveHeader, ckSize)
-
-				if bcount != ckSize {
-					check = 1
-				}
-			} else {
-				check = 1
-			}
-
-			if check == 1 {
-				fmt.Printf("%s is not a valid .WAV file!\n", infilename)
-
-				wv_file.Close()
-
-				return wvencode.SOFT_ERROR
-			}
-
-			whFormatTag = (WaveHeader[0] & 0xFF) + ((WaveHeader[1] & 0xFF) << 8)
-
-			if (whFormatTag == 0xe) && (chunkSize == 40) {
-				whSubFormat = (WaveHeader[24] & 0xFF) + ((WaveHeader[25] & 0xFF) << 8)
-				format = whSubFormat
-			} else {
-				format = whFormatTag
-			}
-
-			whBitsPerSample = (WaveHeader[14] & 0xFF) + ((WaveHeader[15] & 0xFF) << 8)
-
-			if chunkSize == 40 {
-				whValidBitsPerSample = (WaveHeader[18] & 0xFF) +
-					((WaveHeader[19] & 0xFF) << 8)
-				loc_config.Bits_per_sample = whValidBitsPerSample
-			} else {
-				loc_config.Bits_per_sample = whBitsPerSample
-			}
-
-			if format != 1 {
-				supported = wvencode.FALSE
-			}
-
-			whBlockAlign = (WaveHeader[12] & 0xFF) + ((WaveHeader[13] & 0xFF) << 8)
-			whNumChannels = uint((WaveHeader[2] & 0xFF) + ((WaveHeader[3] & 0xFF) << 8))
-
-			if (whNumChannels == 0) || (whNumChannels > 2) ||
-				(math.Floor(float64(whBlockAlign/int(whNumChannels))) < math.Floor(float64((loc_config.Bits_per_sample+7)/8))) ||
-				(math.Floor(float64(whBlockAlign/int(whNumChannels))) > 3) ||
-				((whBlockAlign % int(whNumChannels)) > 0) {
-				supported = wvencode.FALSE
-			}
-
-			if (loc_config.Bits_per_sample < 1) || (loc_config.Bits_per_sample > 24) {
-				supported = wvencode.FALSE
-			}
-
-			whSampleRate = uint((WaveHeader[4] & 0xFF) + ((WaveHeader[5] & 0xFF) << 8) +
-				((WaveHeader[6] & 0xFF) << 16) + ((WaveHeader[7] & 0xFF) << 24))
-
-			if supported != wvencode.TRUE {
-				fmt.Printf("%s is an unsupported .WAV format!\n", infilename)
-
-				wv_file.Close()
-
-				return wvencode.SOFT_ERROR
-			}
-		} else if (chunk_header[0] == 100) && (chunk_header[1] == 97) &&
-			(chunk_header[2] == 116) && (chunk_header[3] == 97) {
-			// ASCII values d = 100, a = 97, t = 116
-			// looking for string 'data'
+		loc_config.Bitrate = int(plan.target_size * 8 * 256 / (total_samples * int64(loc_config.Num_channels)))
 
-			// on the data chunk, get size and exit loop
-			total_samples = uint(math.Floor(float64(chunkSize / whBlockAlign)))
+		if loc_config.Bitrate < 512 {
+			loc_config.Bitrate = 512
+		} else if loc_config.Bitrate > 4096 {
+			loc_config.Bitrate = 4096
+		}
+	}
 
-			break
-		} else { // just skip over unknown chunks
+	if wvencode.WavpackSetConfiguration64(wpc, loc_config, total_samples) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+		wv_file.Close()
 
-			var bytes_to_skip int = ((chunkSize + 1) & ^1)
-			var buff []int
+		return wvencode.HARD_ERROR
+	}
 
-			bcount = DoReadFile(din, buff, bytes_to_skip)
+	if (plan != nil) && (plan.block_sizes != nil) &&
+		(wvencode.WavpackSetTargetSize(wpc, plan.target_size-plan.overhead, plan.block_sizes, plan.block_samples) == wvencode.FALSE) {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+		wv_file.Close()
 
-			if bcount != bytes_to_skip {
-				fmt.Printf("error occurred in skipping bytes\n")
+		return wvencode.HARD_ERROR
+	}
 
-				wv_file.Close()
+	if (loc_config.Flags & wvencode.CONFIG_MD5_CHECKSUM) != 0 {
+		md5_context = md5.New()
 
-				//remove (outfilename);
-				return wvencode.SOFT_ERROR
-			}
+		// FLAC signatures are of the samples right-justified and with 8-bit
+		// samples signed, so unless that matches what a WAV file would hold
+		// the FLAC signature has to be calculated separately to check it
+		if (flc != nil) && ((flac.FlacGetBitsPerSample(flc)%8) != 0 || flac.FlacGetBitsPerSample(flc) == 8) {
+			flac_md5_context = md5.New()
 		}
 	}
 
-	loc_config.Bytes_per_sample = int(math.Floor(float64(whBlockAlign / int(whNumChannels))))
-	loc_config.Num_channels = whNumChannels
-	loc_config.Sample_rate = whSampleRate
-
-	wvencode.WavpackSetConfiguration(wpc, loc_config, total_samples)
+	var wvc_file *os.File
 
 	// if we are creating a "correction" file, open it now for writing
 	if len(out2filename) > 0 {
-		wvc_file, err := os.OpenFile(out2filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+		wvc_file, err = os.OpenFile(out2filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 
 		if err != nil {
 			fmt.Printf("Cannot open output file %s\n", out2filename)
@@ -516,10 +755,40 @@ func pack_file(infilename string, outfilename string, out2filename string, confi
 	}
 
 	// pack the audio portion of the file now
-	result = pack_audio(wpc, din)
+	if flc != nil {
+		result = pack_flac_audio(wpc, flc, md5_context, flac_md5_context)
+	} else if (loc_config.Qmode & wvencode.QMODE_DSD_AUDIO) != 0 {
+		result = pack_dsd_audio(wpc, din, md5_context)
+	} else {
+		result = pack_audio(wpc, din, md5_context)
+	}
 
 	din.Close() // we're now done with input file, so close
 
+	// if we're storing an MD5 signature, it goes in a block at the end of the
+	// file, and for FLAC files it is checked against the one the file had
+	if (result == wvencode.NO_ERROR) && (md5_context != nil) {
+		var md5_digest []byte = md5_context.Sum(nil)
+
+		// (no need to show it twice when packing to a target size)
+		if (plan == nil) || (plan.block_sizes != nil) {
+			fmt.Printf("original md5:  %x\n", md5_digest)
+		}
+		wvencode.WavpackStoreMD5Sum(wpc, md5_digest)
+
+		// an all-zero FLAC signature means that the encoder didn't store one
+		if (flc != nil) && !bytes.Equal(flac.FlacGetMD5Sum(flc), make([]byte, 16)) {
+			if flac_md5_context != nil {
+				md5_digest = flac_md5_context.Sum(nil)
+			}
+
+			if !bytes.Equal(md5_digest, flac.FlacGetMD5Sum(flc)) {
+				fmt.Printf("MD5 signature of %s does not match the decoded audio!\n", infilename)
+				md5_mismatch = wvencode.TRUE
+			}
+		}
+	}
+
 	// we're now done with any WavPack blocks, so flush any remaining data
 	if (result == wvencode.NO_ERROR) && (wvencode.WavpackFlushSamples(wpc) == 0) {
 		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
@@ -528,140 +797,1465 @@ func pack_file(infilename string, outfilename string, out2filename string, confi
 
 	// At this point we're done writing to the output files. However, in some
 	// situations we might have to back up and re-write the initial blocks.
-	// Currently the only case is if we're ignoring length.
-	if (result == wvencode.NO_ERROR) &&
-		(wvencode.WavpackGetNumSamples(wpc) != wvencode.WavpackGetSampleIndex(wpc)) {
+	// Currently the only case is if the length of the source was not known
+	// (such as a CAF file with an unknown data size).
+	if (result == wvencode.NO_ERROR) && (wvencode.WavpackGetNumSamples64(wpc) == -1) {
+		result = update_first_block(wpc, wv_file, outfilename)
+
+		if (result == wvencode.NO_ERROR) && (wvc_file != nil) {
+			result = update_first_block(wpc, wvc_file, out2filename)
+		}
+	} else if (result == wvencode.NO_ERROR) &&
+		(wvencode.WavpackGetNumSamples64(wpc) != wvencode.WavpackGetSampleIndex64(wpc)) {
 		fmt.Printf("couldn't read all samples, file may be corrupt!!\n")
 		result = wvencode.SOFT_ERROR
 	}
 
-	// at this point we're done with the files, so close 'em whether there
-	// were any other errors or not
+	// the tags of a FLAC file are carried over as an APEv2 tag, which goes
+	// after all the WavPack blocks
+	if (result == wvencode.NO_ERROR) && (flc != nil) {
+		result = write_flac_tags(wpc, flc)
+	}
+
+	if (result == wvencode.NO_ERROR) && (md5_mismatch == wvencode.TRUE) {
+		result = wvencode.SOFT_ERROR
+	}
+
+	// the analysis pass for a target size keeps the size of each block and of
+	// everything else that went into the file
+	if (result == wvencode.NO_ERROR) && (plan != nil) {
+		plan.file_size = wvencode.WavpackGetFileSize64(wpc)
+
+		if plan.block_sizes == nil {
+			plan.block_sizes, plan.block_samples = wvencode.WavpackGetBlockSizes(wpc)
+			plan.overhead = plan.file_size
+
+			for i := 0; i < len(plan.block_sizes); i++ {
+				plan.overhead -= int64(plan.block_sizes[i])
+			}
+		}
+	}
+
+	// at this point we're done with the files, so close 'em whether there
+	// were any other errors or not
+
+	errc := wv_file.Close()
+
+	if errc != nil {
+		if result == wvencode.NO_ERROR {
+			result = wvencode.SOFT_ERROR
+		}
+	}
+
+	if wvc_file != nil {
+		errc = wvc_file.Close()
+
+		if (errc != nil) && (result == wvencode.NO_ERROR) {
+			result = wvencode.SOFT_ERROR
+		}
+	}
+
+	// if there were any errors then return the error
+	if result != wvencode.NO_ERROR {
+		return result
+	}
+
+	return wvencode.NO_ERROR
+}
+
+// This function reads back the first block of a WavPack file that has just
+// been written and re-writes it with the actual number of samples. This is
+// needed when the number of samples was not known when the file was started.
+func update_first_block(wpc *wvencode.WavpackContext, outfile *os.File, outfilename string) int {
+	first_block := make([]byte, wvencode.WAVPACK_HEADER_SIZE)
+
+	if _, err := outfile.ReadAt(first_block, 0); err != nil {
+		fmt.Printf("couldn't read back the first block of %s!\n", outfilename)
+
+		return wvencode.SOFT_ERROR
+	}
+
+	wvencode.WavpackUpdateNumSamples(wpc, first_block)
+
+	if _, err := outfile.WriteAt(first_block, 0); err != nil {
+		fmt.Printf("couldn't update the first block of %s!\n", outfilename)
+
+		return wvencode.SOFT_ERROR
+	}
+
+	return wvencode.NO_ERROR
+}
+
+// This function handles raw (headerless) PCM input, whose format has already
+// been filled into the "config" structure from the command-line. The number
+// of samples is derived from the size of the file, but for anything other
+// than a regular file (such as a pipe) it is returned as -1
+// (unknown) and the data is just read to the end. Any partial sample at the
+// end of the data is ignored.
+func setup_raw_input(din *os.File, infilename string, loc_config *wvencode.WavpackConfig, wpc *wvencode.WavpackContext) (int64, int) {
+	var total_samples int64 = -1
+	var bytes_per_sample int64 = int64(loc_config.Bytes_per_sample) * int64(loc_config.Num_channels)
+	var extension string = "raw"
+
+	info, err := din.Stat()
+
+	if (err == nil) && info.Mode().IsRegular() {
+		if (info.Size() / bytes_per_sample) >= wvencode.MAX_WAVPACK_SAMPLES {
+			fmt.Printf("%s is too big!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		total_samples = info.Size() / bytes_per_sample
+	}
+
+	if len(filepath.Ext(infilename)) > 1 {
+		extension = strings.ToLower(filepath.Ext(infilename)[1:])
+	}
+
+	wvencode.WavpackSetFileInformation(wpc, extension, wvencode.WP_FORMAT_WAV)
+
+	return total_samples, wvencode.NO_ERROR
+}
+
+// This function parses the header of a RIFF WAV file, up to the start of the
+// data chunk, and fills in the "config" structure with the format details.
+// The 12 bytes of the RIFF chunk header have already been read into
+// "riff_chunk_header" and "bcount" holds how many were actually read. The
+// number of samples in the data chunk is returned along with the result.
+func parse_riff_header(din *os.File, infilename string, riff_chunk_header []int, bcount int, loc_config *wvencode.WavpackConfig) (int64, int) {
+	var total_samples int64 = 0
+	chunk_header := make([]int, 8)
+
+	var WaveHeader []int
+	var whBlockAlign int = 1
+	var whFormatTag int = 0
+	var whSubFormat int = 0
+	var whBitsPerSample int = 0
+	var whValidBitsPerSample int = 0
+	var whNumChannels uint = 0
+	var whSampleRate uint = 0
+
+	// ASCII values R = 82, I = 73, F = 70 (RIFF)
+	// ASCII values W = 87, A = 65, V = 86, E = 69 (WAVE)
+	if (bcount != 12) || (riff_chunk_header[0] != 82) || (riff_chunk_header[1] != 73) ||
+		(riff_chunk_header[2] != 70) || (riff_chunk_header[3] != 70) ||
+		(riff_chunk_header[8] != 87) || (riff_chunk_header[9] != 65) ||
+		(riff_chunk_header[10] != 86) || (riff_chunk_header[11] != 69) {
+
+		fmt.Printf("%s is not a valid .WAV file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	// loop through all elements of the RIFF wav header (until the data chuck)
+	var chunkSize int = 0
+
+	for {
+		// ChunkHeader has a size of 8
+		bcount = DoReadFile(din, chunk_header, 8)
+
+		if bcount != 8 {
+			fmt.Printf("%s is not a valid .WAV file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		chunkSize = (chunk_header[4] & 0xFF) + ((chunk_header[5] & 0xFF) << 8) +
+			((chunk_header[6] & 0xFF) << 16) + ((chunk_header[7] & 0xFF) << 24)
+
+		// if it's the format chunk, we want to get some info out of there and
+		// make sure it's a .wav file we can handle
+		// ASCII values f = 102, m = 109, t = 116, space = 32 ('fmt ')
+		if (chunk_header[0] == 102) && (chunk_header[1] == 109) && (chunk_header[2] == 116) &&
+			(chunk_header[3] == 32) {
+			var supported int = wvencode.TRUE
+			var format int
+			var check int = 0
+
+			if (chunkSize >= 16) && (chunkSize <= 40) {
+				var ckSize int = chunkSize
+
+				WaveHeader = make([]int, ckSize)
+
+				bcount = DoReadFile(din, WaveHeader, ckSize)
+
+				if bcount != ckSize {
+					check = 1
+				}
+			} else {
+				check = 1
+			}
+
+			if check == 1 {
+				fmt.Printf("%s is not a valid .WAV file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			whFormatTag = (WaveHeader[0] & 0xFF) + ((WaveHeader[1] & 0xFF) << 8)
+
+			if (whFormatTag == 0xe) && (chunkSize == 40) {
+				whSubFormat = (WaveHeader[24] & 0xFF) + ((WaveHeader[25] & 0xFF) << 8)
+				format = whSubFormat
+			} else {
+				format = whFormatTag
+			}
+
+			whBitsPerSample = (WaveHeader[14] & 0xFF) + ((WaveHeader[15] & 0xFF) << 8)
+
+			if chunkSize == 40 {
+				whValidBitsPerSample = (WaveHeader[18] & 0xFF) +
+					((WaveHeader[19] & 0xFF) << 8)
+				loc_config.Bits_per_sample = whValidBitsPerSample
+			} else {
+				loc_config.Bits_per_sample = whBitsPerSample
+			}
+
+			if format != 1 {
+				supported = wvencode.FALSE
+			}
+
+			whBlockAlign = (WaveHeader[12] & 0xFF) + ((WaveHeader[13] & 0xFF) << 8)
+			whNumChannels = uint((WaveHeader[2] & 0xFF) + ((WaveHeader[3] & 0xFF) << 8))
+
+			if (whNumChannels == 0) || (whNumChannels > 2) ||
+				(math.Floor(float64(whBlockAlign/int(whNumChannels))) < math.Floor(float64((loc_config.Bits_per_sample+7)/8))) ||
+				(math.Floor(float64(whBlockAlign/int(whNumChannels))) > 3) ||
+				((whBlockAlign % int(whNumChannels)) > 0) {
+				supported = wvencode.FALSE
+			}
+
+			if (loc_config.Bits_per_sample < 1) || (loc_config.Bits_per_sample > 24) {
+				supported = wvencode.FALSE
+			}
+
+			whSampleRate = uint((WaveHeader[4] & 0xFF) + ((WaveHeader[5] & 0xFF) << 8) +
+				((WaveHeader[6] & 0xFF) << 16) + ((WaveHeader[7] & 0xFF) << 24))
+
+			if supported != wvencode.TRUE {
+				fmt.Printf("%s is an unsupported .WAV format!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+		} else if (chunk_header[0] == 100) && (chunk_header[1] == 97) &&
+			(chunk_header[2] == 116) && (chunk_header[3] == 97) {
+			// ASCII values d = 100, a = 97, t = 116
+			// looking for string 'data'
+
+			// on the data chunk, get size and exit loop
+			total_samples = int64(math.Floor(float64(chunkSize / whBlockAlign)))
+
+			break
+		} else { // just skip over unknown chunks
+
+			var bytes_to_skip int = ((chunkSize + 1) & ^1)
+			var buff []int
+
+			bcount = DoReadFile(din, buff, bytes_to_skip)
+
+			if bcount != bytes_to_skip {
+				fmt.Printf("error occurred in skipping bytes\n")
+
+				//remove (outfilename);
+				return 0, wvencode.SOFT_ERROR
+			}
+		}
+	}
+
+	loc_config.Bytes_per_sample = int(math.Floor(float64(whBlockAlign / int(whNumChannels))))
+	loc_config.Num_channels = whNumChannels
+	loc_config.Sample_rate = whSampleRate
+
+	return total_samples, wvencode.NO_ERROR
+}
+
+// This function parses the header of an AIFF or AIFF-C file, up to the start
+// of the sound data in the SSND chunk, and fills in the "config" structure
+// with the format details. The 12 bytes of the FORM header have already been
+// read into "form_header". All chunk sizes and values in AIFF files are
+// big-endian. Everything read here is also kept and stored as the "wrapper"
+// of the WavPack file so that the original header can be restored. The
+// number of samples given in the COMM chunk is returned along with the
+// result.
+func parse_aiff_header(din *os.File, infilename string, form_header []int, loc_config *wvencode.WavpackConfig, wpc *wvencode.WavpackContext) (int64, int) {
+	var total_samples int64 = 0
+	var bcount int
+	chunk_header := make([]int, 8)
+	var header []byte
+	var is_aifc int = wvencode.FALSE
+	var got_comm int = wvencode.FALSE
+	var commNumChannels uint = 0
+	var commSampleSize int = 0
+	var commSampleRate uint = 0
+	var commCompression string = "NONE"
+	var extension string = "aif"
+
+	// ASCII values A = 65, I = 73, F = 70, C = 67 (AIFF or AIFC)
+	if (form_header[8] != 65) || (form_header[9] != 73) || (form_header[10] != 70) ||
+		((form_header[11] != 70) && (form_header[11] != 67)) {
+		fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if form_header[11] == 67 {
+		is_aifc = wvencode.TRUE
+		extension = "aifc"
+	}
+
+	header = append_header_bytes(header, form_header, 12)
+
+	// loop through all the chunks of the FORM (until the SSND chunk)
+	var chunkSize int = 0
+
+	for {
+		// ChunkHeader has a size of 8
+		bcount = DoReadFile(din, chunk_header, 8)
+
+		if bcount != 8 {
+			fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		header = append_header_bytes(header, chunk_header, 8)
+
+		chunkSize = ((chunk_header[4] & 0xFF) << 24) + ((chunk_header[5] & 0xFF) << 16) +
+			((chunk_header[6] & 0xFF) << 8) + (chunk_header[7] & 0xFF)
+
+		// ASCII values C = 67, O = 79, M = 77 ('COMM')
+		if (chunk_header[0] == 67) && (chunk_header[1] == 79) && (chunk_header[2] == 77) &&
+			(chunk_header[3] == 77) {
+			var supported int = wvencode.TRUE
+			var ckSize int = ((chunkSize + 1) & ^1)
+			var CommonChunk []int
+
+			if (chunkSize < 18) || (chunkSize > 256) || ((is_aifc == wvencode.TRUE) && (chunkSize < 22)) {
+				fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			CommonChunk = make([]int, ckSize)
+			bcount = DoReadFile(din, CommonChunk, ckSize)
+
+			if bcount != ckSize {
+				fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, CommonChunk, ckSize)
+
+			commNumChannels = uint(((CommonChunk[0] & 0xFF) << 8) + (CommonChunk[1] & 0xFF))
+			total_samples = int64(((CommonChunk[2] & 0xFF) << 24) + ((CommonChunk[3] & 0xFF) << 16) +
+				((CommonChunk[4] & 0xFF) << 8) + (CommonChunk[5] & 0xFF))
+			commSampleSize = ((CommonChunk[6] & 0xFF) << 8) + (CommonChunk[7] & 0xFF)
+			commSampleRate = extended_to_uint(CommonChunk[8:18])
+
+			if is_aifc == wvencode.TRUE {
+				commCompression = string([]byte{byte(CommonChunk[18]), byte(CommonChunk[19]),
+					byte(CommonChunk[20]), byte(CommonChunk[21])})
+			}
+
+			// only uncompressed big-endian ('NONE' and 'twos') and
+			// little-endian ('sowt') integer data is supported
+			if (commCompression == "NONE") || (commCompression == "twos") {
+				loc_config.Qmode = wvencode.QMODE_BIG_ENDIAN | wvencode.QMODE_SIGNED_BYTES
+			} else if commCompression == "sowt" {
+				loc_config.Qmode = wvencode.QMODE_SIGNED_BYTES
+			} else {
+				supported = wvencode.FALSE
+			}
+
+			if (commNumChannels == 0) || (commNumChannels > 2) ||
+				(commSampleSize < 1) || (commSampleSize > 24) || (commSampleRate == 0) {
+				supported = wvencode.FALSE
+			}
+
+			if supported != wvencode.TRUE {
+				fmt.Printf("%s is an unsupported .AIFF format!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			got_comm = wvencode.TRUE
+		} else if (chunk_header[0] == 83) && (chunk_header[1] == 83) &&
+			(chunk_header[2] == 78) && (chunk_header[3] == 68) {
+			// ASCII values S = 83, N = 78, D = 68
+			// looking for string 'SSND'
+			SoundDataChunk := make([]int, 8)
+			var offset int
+			var data_size int
+
+			if got_comm != wvencode.TRUE {
+				fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			bcount = DoReadFile(din, SoundDataChunk, 8)
+
+			if (bcount != 8) || (chunkSize < 8) {
+				fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, SoundDataChunk, 8)
+
+			offset = ((SoundDataChunk[0] & 0xFF) << 24) + ((SoundDataChunk[1] & 0xFF) << 16) +
+				((SoundDataChunk[2] & 0xFF) << 8) + (SoundDataChunk[3] & 0xFF)
+
+			// the audio data starts "offset" bytes into the chunk data
+			if offset > 0 {
+				var buff []int = make([]int, offset)
+
+				bcount = DoReadFile(din, buff, offset)
+
+				if bcount != offset {
+					fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+					return 0, wvencode.SOFT_ERROR
+				}
+
+				header = append_header_bytes(header, buff, offset)
+			}
+
+			data_size = chunkSize - 8 - offset
+			loc_config.Bits_per_sample = commSampleSize
+			loc_config.Bytes_per_sample = (commSampleSize + 7) / 8
+
+			if data_size < 0 {
+				fmt.Printf("%s is not a valid .AIFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			break
+		} else { // just skip over unknown chunks, but keep them in the header
+
+			var bytes_to_skip int = ((chunkSize + 1) & ^1)
+			var buff []int = make([]int, bytes_to_skip)
+
+			bcount = DoReadFile(din, buff, bytes_to_skip)
+
+			if bcount != bytes_to_skip {
+				fmt.Printf("error occurred in skipping bytes\n")
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, buff, bytes_to_skip)
+		}
+	}
+
+	loc_config.Num_channels = commNumChannels
+	loc_config.Sample_rate = commSampleRate
+
+	if len(filepath.Ext(infilename)) > 1 {
+		extension = strings.ToLower(filepath.Ext(infilename)[1:])
+	}
+
+	wvencode.WavpackSetFileInformation(wpc, extension, wvencode.WP_FORMAT_AIF)
+
+	if wvencode.WavpackAddWrapper(wpc, header) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+		return 0, wvencode.HARD_ERROR
+	}
+
+	return total_samples, wvencode.NO_ERROR
+}
+
+// This function converts the 80-bit IEEE 754 extended precision value used
+// for the sample rate in the AIFF COMM chunk into an integer (rounded to the
+// nearest value). Zero is returned for negative or unrepresentable values.
+func extended_to_uint(ext []int) uint {
+	var exponent int = ((ext[0] & 0x7F) << 8) + (ext[1] & 0xFF)
+	var mantissa uint64 = 0
+
+	for i := 2; i < 10; i++ {
+		mantissa = (mantissa << 8) | uint64(ext[i]&0xFF)
+	}
+
+	if ((ext[0] & 0x80) != 0) || (exponent == 0) || (exponent == 0x7FFF) || (mantissa == 0) {
+		return 0
+	}
+
+	// the mantissa has an explicit integer bit, so the value is
+	// mantissa * 2^(exponent - bias - 63)
+	var value float64 = math.Ldexp(float64(mantissa), exponent-16383-63)
+
+	if value > float64(math.MaxUint32) {
+		return 0
+	}
+
+	return uint(math.Floor(value + 0.5))
+}
+
+// This function appends the first "count" values of "buffer" (as returned by
+// DoReadFile()) to the byte slice holding the original file header.
+func append_header_bytes(header []byte, buffer []int, count int) []byte {
+	for i := 0; i < count; i++ {
+		header = append(header, byte(buffer[i]))
+	}
+
+	return header
+}
+
+// This function parses the header of a CAF (Core Audio Format) file, up to the
+// start of the audio data in the data chunk, and fills in the "config"
+// structure with the format details. The 12 bytes already read into
+// "caf_header" are the 8-byte file header and the type of the first chunk.
+// All values in CAF files are big-endian, and the chunk sizes are 64-bit
+// with no padding. Only linear PCM data is supported, which can be integer
+// (either endian) or 32-bit float. The channel layout in the chan chunk
+// (if any) is converted to a channel mask. Everything read here (up to and
+// including the edit count of the data chunk) is stored as the "wrapper" of
+// the WavPack file. The number of samples is returned along with the result,
+// and is -1 (unknown) if the data chunk size is -1, which means that
+// the audio data runs to the end of the file.
+func parse_caf_header(din *os.File, infilename string, caf_header []int, loc_config *wvencode.WavpackConfig, wpc *wvencode.WavpackContext) (int64, int) {
+	var total_samples int64 = 0
+	var bcount int
+	chunk_header := make([]int, 12)
+	var header []byte
+	var got_desc int = wvencode.FALSE
+	var descNumChannels uint = 0
+	var descBytesPerPacket int = 0
+	var descFormatFlags uint = 0
+	var descSampleRate uint = 0
+	var channel_mask uint = 0
+	var extension string = "caf"
+
+	// the file version must be 1
+	if (caf_header[4] != 0) || (caf_header[5] != 1) {
+		fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	header = append_header_bytes(header, caf_header, 12)
+
+	// the type of the first chunk was read along with the file header
+	copy(chunk_header, caf_header[8:12])
+
+	var header_bytes int = 4
+	var chunkSize int64 = 0
+
+	// loop through all the chunks of the file (until the data chunk)
+	for {
+		// the chunk header has a size of 12
+		bcount = DoReadFile(din, chunk_header[header_bytes:], 12-header_bytes)
+
+		if bcount != 12-header_bytes {
+			fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		header = append_header_bytes(header, chunk_header[header_bytes:], 12-header_bytes)
+		header_bytes = 0
+
+		chunkSize = 0
+
+		for i := 4; i < 12; i++ {
+			chunkSize = (chunkSize << 8) | int64(chunk_header[i]&0xFF)
+		}
+
+		// ASCII values d = 100, e = 101, s = 115, c = 99 ('desc')
+		if (chunk_header[0] == 100) && (chunk_header[1] == 101) && (chunk_header[2] == 115) &&
+			(chunk_header[3] == 99) {
+			var supported int = wvencode.TRUE
+			var DescChunk []int = make([]int, 32)
+			var descFormatID string
+			var descFramesPerPacket uint
+			var descBitsPerChannel int
+			var rate float64
+
+			if chunkSize != 32 {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			bcount = DoReadFile(din, DescChunk, 32)
+
+			if bcount != 32 {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, DescChunk, 32)
+
+			rate = math.Float64frombits(uint64(caf_be_uint(DescChunk[0:4]))<<32 | uint64(caf_be_uint(DescChunk[4:8])))
+			descFormatID = string([]byte{byte(DescChunk[8]), byte(DescChunk[9]), byte(DescChunk[10]),
+				byte(DescChunk[11])})
+			descFormatFlags = caf_be_uint(DescChunk[12:16])
+			descBytesPerPacket = int(caf_be_uint(DescChunk[16:20]))
+			descFramesPerPacket = caf_be_uint(DescChunk[20:24])
+			descNumChannels = caf_be_uint(DescChunk[24:28])
+			descBitsPerChannel = int(caf_be_uint(DescChunk[28:32]))
+
+			if (rate >= 1.0) && (rate <= float64(math.MaxUint32)) {
+				descSampleRate = uint(math.Floor(rate + 0.5))
+			}
+
+			if (descFormatID != "lpcm") || (descFramesPerPacket != 1) || (descSampleRate == 0) ||
+				(descNumChannels == 0) || (descNumChannels > 2) ||
+				((descBytesPerPacket % int(descNumChannels)) != 0) {
+				supported = wvencode.FALSE
+			} else {
+				loc_config.Bytes_per_sample = descBytesPerPacket / int(descNumChannels)
+				loc_config.Bits_per_sample = descBitsPerChannel
+
+				if (descFormatFlags & CAF_FORMAT_FLOAT) != 0 {
+					// only 32-bit floats are supported (no doubles)
+					if (loc_config.Bytes_per_sample != 4) || (descBitsPerChannel != 32) {
+						supported = wvencode.FALSE
+					}
+
+					loc_config.Flags |= wvencode.CONFIG_FLOAT_DATA
+				} else if (descBitsPerChannel < 1) || (descBitsPerChannel > 24) ||
+					(loc_config.Bytes_per_sample < ((descBitsPerChannel + 7) / 8)) ||
+					(loc_config.Bytes_per_sample > 3) {
+					supported = wvencode.FALSE
+				}
+			}
+
+			if supported != wvencode.TRUE {
+				fmt.Printf("%s is an unsupported .CAF format!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			if ((descFormatFlags & CAF_FORMAT_LITTLE_ENDIAN) == 0) && (loc_config.Bytes_per_sample > 1) {
+				loc_config.Qmode |= wvencode.QMODE_BIG_ENDIAN
+			}
+
+			// 8-bit samples in CAF files are always signed
+			if loc_config.Bytes_per_sample == 1 {
+				loc_config.Qmode |= wvencode.QMODE_SIGNED_BYTES
+			}
+
+			got_desc = wvencode.TRUE
+		} else if (chunk_header[0] == 99) && (chunk_header[1] == 104) &&
+			(chunk_header[2] == 97) && (chunk_header[3] == 110) {
+			// ASCII values c = 99, h = 104, a = 97, n = 110
+			// looking for string 'chan'
+			var ChanChunk []int
+
+			if (chunkSize < 12) || (chunkSize > 1024) {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			ChanChunk = make([]int, chunkSize)
+			bcount = DoReadFile(din, ChanChunk, int(chunkSize))
+
+			if bcount != int(chunkSize) {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, ChanChunk, int(chunkSize))
+
+			channel_mask = caf_layout_to_mask(ChanChunk)
+		} else if (chunk_header[0] == 100) && (chunk_header[1] == 97) &&
+			(chunk_header[2] == 116) && (chunk_header[3] == 97) {
+			// ASCII values d = 100, a = 97, t = 116
+			// looking for string 'data'
+			EditCount := make([]int, 4)
+
+			if got_desc != wvencode.TRUE {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			// the audio data follows the 4-byte edit count
+			bcount = DoReadFile(din, EditCount, 4)
+
+			if (bcount != 4) || ((chunkSize != -1) && (chunkSize < 4)) {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, EditCount, 4)
+
+			if chunkSize == -1 {
+				total_samples = -1 // unknown, read to the end of the file
+			} else if ((chunkSize - 4) / int64(descBytesPerPacket)) >= wvencode.MAX_WAVPACK_SAMPLES {
+				fmt.Printf("%s is too big!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			} else {
+				total_samples = (chunkSize - 4) / int64(descBytesPerPacket)
+			}
+
+			break
+		} else { // just skip over unknown chunks, but keep them in the header
+
+			var buff []int
+
+			if (chunkSize < 0) || (chunkSize > 0x1000000) {
+				fmt.Printf("%s is not a valid .CAF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			buff = make([]int, chunkSize)
+			bcount = DoReadFile(din, buff, int(chunkSize))
+
+			if bcount != int(chunkSize) {
+				fmt.Printf("error occurred in skipping bytes\n")
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			header = append_header_bytes(header, buff, int(chunkSize))
+		}
+	}
+
+	loc_config.Num_channels = descNumChannels
+	loc_config.Sample_rate = descSampleRate
+	loc_config.Channel_mask = channel_mask
+
+	if len(filepath.Ext(infilename)) > 1 {
+		extension = strings.ToLower(filepath.Ext(infilename)[1:])
+	}
+
+	wvencode.WavpackSetFileInformation(wpc, extension, wvencode.WP_FORMAT_CAF)
+
+	if wvencode.WavpackAddWrapper(wpc, header) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+		return 0, wvencode.HARD_ERROR
+	}
+
+	return total_samples, wvencode.NO_ERROR
+}
 
-	errc := wv_file.Close()
+// This function converts the channel layout of a CAF chan chunk into a
+// Microsoft channel mask. The layout is given by a tag, which can also say
+// that the layout is given by a bitmap (which is the same as a channel mask)
+// or by a list of channel descriptions, in which case each channel label
+// from 1 (left) to 18 (top back right) is the same as the bit position (+1)
+// in the mask. Zero is returned if there is no layout we can convert, and
+// in that case the default mask for the number of channels is used.
+func caf_layout_to_mask(chan_chunk []int) uint {
+	var tag uint = caf_be_uint(chan_chunk[0:4])
+	var mask uint = 0
+
+	if tag == CAF_LAYOUT_USE_BITMAP {
+		mask = caf_be_uint(chan_chunk[4:8])
+	} else if tag == CAF_LAYOUT_USE_DESCRIPTIONS {
+		var num_descriptions int = int(caf_be_uint(chan_chunk[8:12]))
+
+		// each description is 20 bytes, starting with the channel label
+		for i := 0; (i < num_descriptions) && ((12 + (i * 20) + 20) <= len(chan_chunk)); i++ {
+			var label uint = caf_be_uint(chan_chunk[12+(i*20) : 16+(i*20)])
+
+			if (label >= 1) && (label <= 18) {
+				mask |= 1 << (label - 1)
+			}
+		}
+	} else if tag == CAF_LAYOUT_MONO {
+		mask = 0x4
+	} else if (tag == CAF_LAYOUT_STEREO) || (tag == CAF_LAYOUT_STEREO_HEADPHONES) {
+		mask = 0x3
+	}
 
-	if errc != nil {
-		if result == wvencode.NO_ERROR {
-			result = wvencode.SOFT_ERROR
+	return mask
+}
+
+// This function returns the big-endian 32-bit value stored in the first 4
+// values of "buffer" (as returned by DoReadFile()).
+func caf_be_uint(buffer []int) uint {
+	return uint(((buffer[0] & 0xFF) << 24) + ((buffer[1] & 0xFF) << 16) +
+		((buffer[2] & 0xFF) << 8) + (buffer[3] & 0xFF))
+}
+
+// This function parses the header of a Sony DSF file, up to the start of the
+// audio data in the data chunk, and fills in the "config" structure with the
+// format details. The 12 bytes already read into "dsf_header" are the start
+// of the 28-byte "DSD " chunk. All values in DSF files are little-endian and
+// the sizes are 64-bit. The DSD data is stored in blocks of 4096 bytes for
+// each channel in turn, and usually with the bits of each byte in LSB first
+// order. The three chunk headers are stored as the "wrapper" of the WavPack
+// file, while anything after the audio data (like an ID3v2 tag) is stored as
+// the trailer by pack_dsd_audio(). The number of samples (bytes of 8 DSD bits
+// per channel) is returned along with the result.
+func parse_dsf_header(din *os.File, infilename string, dsf_header []int, loc_config *wvencode.WavpackConfig, wpc *wvencode.WavpackContext) (int64, int) {
+	var total_samples uint64
+	var bcount int
+	var header []byte
+	var num_channels uint
+	var channel_type uint
+	var sample_rate uint
+	var bits_per_sample uint
+	var data_size uint64
+	var num_blocks uint64
+	var extension string = "dsf"
+	dsd_chunk := make([]int, 28)
+	fmt_chunk := make([]int, 52)
+	data_chunk := make([]int, 12)
+
+	copy(dsd_chunk, dsf_header[0:12])
+	bcount = DoReadFile(din, dsd_chunk[12:28], 16)
+
+	if (bcount != 16) || (get_le_value(dsd_chunk[4:12]) != 28) {
+		fmt.Printf("%s is not a valid .DSF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	bcount = DoReadFile(din, fmt_chunk, 52)
+
+	// ASCII values f = 102, m = 109, t = 116 ('fmt ')
+	if (bcount != 52) || (fmt_chunk[0] != 102) || (fmt_chunk[1] != 109) || (fmt_chunk[2] != 116) ||
+		(fmt_chunk[3] != 32) || (get_le_value(fmt_chunk[4:12]) != 52) {
+		fmt.Printf("%s is not a valid .DSF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	bcount = DoReadFile(din, data_chunk, 12)
+
+	// ASCII values d = 100, a = 97, t = 116 ('data')
+	if (bcount != 12) || (data_chunk[0] != 100) || (data_chunk[1] != 97) || (data_chunk[2] != 116) ||
+		(data_chunk[3] != 97) {
+		fmt.Printf("%s is not a valid .DSF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	header = append_header_bytes(header, dsd_chunk, 28)
+	header = append_header_bytes(header, fmt_chunk, 52)
+	header = append_header_bytes(header, data_chunk, 12)
+
+	channel_type = uint(get_le_value(fmt_chunk[20:24]))
+	num_channels = uint(get_le_value(fmt_chunk[24:28]))
+	sample_rate = uint(get_le_value(fmt_chunk[28:32]))
+	bits_per_sample = uint(get_le_value(fmt_chunk[32:36]))
+	total_samples = (get_le_value(fmt_chunk[36:44]) + 7) / 8
+	data_size = get_le_value(data_chunk[4:12]) - 12
+
+	// the format version must be 1 and the format id 0 (raw DSD), and
+	// the block size is always 4096 bytes
+	if (get_le_value(fmt_chunk[12:16]) != 1) || (get_le_value(fmt_chunk[16:20]) != 0) ||
+		(get_le_value(fmt_chunk[44:48]) != 4096) || ((bits_per_sample != 1) && (bits_per_sample != 8)) ||
+		(num_channels == 0) || (num_channels > 2) || (channel_type != num_channels) ||
+		(sample_rate < 8) || ((sample_rate % 8) != 0) {
+		fmt.Printf("%s is an unsupported .DSF format!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	num_blocks = (total_samples + 4095) / 4096
+
+	if (get_le_value(data_chunk[4:12]) < 12) || (data_size < num_blocks*4096*uint64(num_channels)) {
+		fmt.Printf("%s is not a valid .DSF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if total_samples >= uint64(wvencode.MAX_WAVPACK_SAMPLES) {
+		fmt.Printf("%s is too big!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if bits_per_sample == 1 {
+		loc_config.Qmode = wvencode.QMODE_DSD_LSB_FIRST | wvencode.QMODE_DSD_IN_BLOCKS
+	} else {
+		loc_config.Qmode = wvencode.QMODE_DSD_MSB_FIRST | wvencode.QMODE_DSD_IN_BLOCKS
+	}
+
+	loc_config.Bits_per_sample = 8
+	loc_config.Bytes_per_sample = 1
+	loc_config.Num_channels = num_channels
+	loc_config.Sample_rate = sample_rate / 8
+
+	if len(filepath.Ext(infilename)) > 1 {
+		extension = strings.ToLower(filepath.Ext(infilename)[1:])
+	}
+
+	wvencode.WavpackSetFileInformation(wpc, extension, wvencode.WP_FORMAT_DSF)
+
+	if wvencode.WavpackAddWrapper(wpc, header) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+		return 0, wvencode.HARD_ERROR
+	}
+
+	return int64(total_samples), wvencode.NO_ERROR
+}
+
+// This function parses the header of a Philips DSDIFF file, up to the start
+// of the audio data in the "DSD " chunk, and fills in the "config" structure
+// with the format details. The 12 bytes already read into "frm8_header" are
+// the FRM8 chunk header. All values in DSDIFF files are big-endian, the chunk
+// sizes are 64-bit and chunks are padded to even lengths. The format is in
+// the "SND " property chunk, and the DSD data is interleaved with the bits
+// of each byte in MSB first order. Compressed (DST) files are not supported.
+// Everything read here is stored as the "wrapper" of the WavPack file, while
+// any chunks following the audio data are stored as the trailer by
+// pack_dsd_audio(). The number of samples (bytes of 8 DSD bits per channel)
+// is returned along with the result.
+func parse_dff_header(din *os.File, infilename string, frm8_header []int, loc_config *wvencode.WavpackConfig, wpc *wvencode.WavpackContext) (int64, int) {
+	var total_samples uint64 = 0
+	var bcount int
+	var header []byte
+	var num_channels uint = 0
+	var sample_rate uint = 0
+	var channel_mask uint = 0
+	var compression string = "DSD "
+	var extension string = "dff"
+	form_type := make([]int, 4)
+	chunk_header := make([]int, 12)
+
+	bcount = DoReadFile(din, form_type, 4)
+
+	// ASCII values D = 68, S = 83 ('DSD ')
+	if (bcount != 4) || (form_type[0] != 68) || (form_type[1] != 83) || (form_type[2] != 68) ||
+		(form_type[3] != 32) {
+		fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	header = append_header_bytes(header, frm8_header, 12)
+	header = append_header_bytes(header, form_type, 4)
+
+	// loop through all the chunks of the FRM8 (until the "DSD " chunk)
+	for {
+		var chunk_id string
+		var chunkSize uint64
+		var buff []int
+
+		bcount = DoReadFile(din, chunk_header, 12)
+
+		if bcount != 12 {
+			fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		header = append_header_bytes(header, chunk_header, 12)
+
+		chunk_id = string([]byte{byte(chunk_header[0]), byte(chunk_header[1]), byte(chunk_header[2]),
+			byte(chunk_header[3])})
+		chunkSize = get_be_value(chunk_header[4:12])
+
+		if chunk_id == "DSD " {
+			if num_channels == 0 {
+				fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+				return 0, wvencode.SOFT_ERROR
+			}
+
+			total_samples = chunkSize / uint64(num_channels)
+			break
+		}
+
+		if chunk_id == "DST " {
+			fmt.Printf("%s is an unsupported .DFF format!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		if chunkSize > 0x100000 {
+			fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		buff = make([]int, (chunkSize+1) & ^uint64(1))
+		bcount = DoReadFile(din, buff, len(buff))
+
+		if bcount != len(buff) {
+			fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+			return 0, wvencode.SOFT_ERROR
+		}
+
+		header = append_header_bytes(header, buff, len(buff))
+
+		// ASCII values S = 83, N = 78, D = 68 ('SND ')
+		if (chunk_id == "PROP") && (chunkSize >= 4) && (buff[0] == 83) && (buff[1] == 78) &&
+			(buff[2] == 68) && (buff[3] == 32) {
+			var index uint64 = 4
+
+			// the property chunk is made up of chunks itself
+			for index+12 <= chunkSize {
+				var prop_id string = string([]byte{byte(buff[index]), byte(buff[index+1]),
+					byte(buff[index+2]), byte(buff[index+3])})
+				var prop_size uint64 = get_be_value(buff[index+4 : index+12])
+				var prop []int
+
+				index += 12
+
+				if index+prop_size > chunkSize {
+					fmt.Printf("%s is not a valid .DFF file!\n", infilename)
+
+					return 0, wvencode.SOFT_ERROR
+				}
+
+				prop = buff[index : index+prop_size]
+				index += (prop_size + 1) & ^uint64(1)
+
+				if (prop_id == "FS  ") && (prop_size >= 4) {
+					sample_rate = caf_be_uint(prop[0:4])
+				} else if (prop_id == "CHNL") && (prop_size >= 2) {
+					num_channels = uint(get_be_value(prop[0:2]))
+
+					if prop_size >= 2+uint64(num_channels)*4 {
+						channel_mask = dff_channels_to_mask(prop[2:], num_channels)
+					}
+				} else if (prop_id == "CMPR") && (prop_size >= 4) {
+					compression = string([]byte{byte(prop[0]), byte(prop[1]), byte(prop[2]), byte(prop[3])})
+				}
+			}
 		}
 	}
 
-	// if there were any errors then return the error
-	if result != wvencode.NO_ERROR {
-		return result
+	if (compression != "DSD ") || (num_channels == 0) || (num_channels > 2) ||
+		(sample_rate < 8) || ((sample_rate % 8) != 0) {
+		fmt.Printf("%s is an unsupported .DFF format!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if total_samples >= uint64(wvencode.MAX_WAVPACK_SAMPLES) {
+		fmt.Printf("%s is too big!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	loc_config.Qmode = wvencode.QMODE_DSD_MSB_FIRST
+	loc_config.Bits_per_sample = 8
+	loc_config.Bytes_per_sample = 1
+	loc_config.Num_channels = num_channels
+	loc_config.Sample_rate = sample_rate / 8
+	loc_config.Channel_mask = channel_mask
+
+	if len(filepath.Ext(infilename)) > 1 {
+		extension = strings.ToLower(filepath.Ext(infilename)[1:])
+	}
+
+	wvencode.WavpackSetFileInformation(wpc, extension, wvencode.WP_FORMAT_DFF)
+
+	if wvencode.WavpackAddWrapper(wpc, header) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+		return 0, wvencode.HARD_ERROR
+	}
+
+	return int64(total_samples), wvencode.NO_ERROR
+}
+
+// This function converts the channel IDs of a DSDIFF CHNL chunk into a
+// Microsoft channel mask. Zero is returned if an ID is not recognized (or is
+// repeated), and in that case the default mask for the number of channels is
+// used.
+func dff_channels_to_mask(channel_ids []int, num_channels uint) uint {
+	var mask uint = 0
+
+	for i := 0; i < int(num_channels); i++ {
+		var bit uint
+
+		switch string([]byte{byte(channel_ids[i*4]), byte(channel_ids[i*4+1]), byte(channel_ids[i*4+2]),
+			byte(channel_ids[i*4+3])}) {
+		case "SLFT", "MLFT":
+			bit = 0x1
+		case "SRGT", "MRGT":
+			bit = 0x2
+		case "C   ":
+			bit = 0x4
+		case "LFE ":
+			bit = 0x8
+		case "LS  ":
+			bit = 0x10
+		case "RS  ":
+			bit = 0x20
+		default:
+			return 0
+		}
+
+		if (mask & bit) != 0 {
+			return 0
+		}
+
+		mask |= bit
+	}
+
+	return mask
+}
+
+// This function returns the little-endian value stored in "buffer" (as
+// returned by DoReadFile()), which can be up to 8 bytes long.
+func get_le_value(buffer []int) uint64 {
+	var value uint64 = 0
+
+	for i := len(buffer) - 1; i >= 0; i-- {
+		value = (value << 8) | uint64(buffer[i]&0xFF)
+	}
+
+	return value
+}
+
+// This function returns the big-endian value stored in "buffer" (as returned
+// by DoReadFile()), which can be up to 8 bytes long.
+func get_be_value(buffer []int) uint64 {
+	var value uint64 = 0
+
+	for i := 0; i < len(buffer); i++ {
+		value = (value << 8) | uint64(buffer[i]&0xFF)
+	}
+
+	return value
+}
+
+// This function opens a FLAC file with the FLAC decoder and fills in the
+// "config" structure from its STREAMINFO. The 12 bytes already read into
+// "flac_header" are handed back to the decoder ahead of the rest of the file.
+// There is no header to store as a wrapper because the unpacked file can only
+// be a WAV file. If the FLAC file has an MD5 signature then one is stored in
+// the WavPack file too, so that it can be checked. The number of samples is
+// returned along with the result and is -1 (unknown) if the FLAC
+// file doesn't say.
+func parse_flac_header(din *os.File, infilename string, flac_header []int, loc_config *wvencode.WavpackConfig, flc *flac.FlacContext) (int64, int) {
+	var total_samples int64 = -1
+	var header []byte
+
+	header = append_header_bytes(header, flac_header, 12)
+
+	if flac.FlacOpenInput(flc, io.MultiReader(bytes.NewReader(header), din)) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, flac.FlacGetErrorMessage(flc))
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if flac.FlacGetNumChannels(flc) > 2 {
+		fmt.Printf("%s is an unsupported .FLAC format!\n", infilename)
+
+		return 0, wvencode.SOFT_ERROR
+	}
+
+	if flac.FlacGetNumSamples(flc) != 0 {
+		total_samples = int64(flac.FlacGetNumSamples(flc))
+	}
+
+	loc_config.Sample_rate = uint(flac.FlacGetSampleRate(flc))
+	loc_config.Num_channels = uint(flac.FlacGetNumChannels(flc))
+	loc_config.Bits_per_sample = flac.FlacGetBitsPerSample(flc)
+	loc_config.Bytes_per_sample = (loc_config.Bits_per_sample + 7) / 8
+
+	if !bytes.Equal(flac.FlacGetMD5Sum(flc), make([]byte, 16)) {
+		loc_config.Flags |= wvencode.CONFIG_MD5_CHECKSUM
+	}
+
+	return total_samples, wvencode.NO_ERROR
+}
+
+// This function carries the Vorbis comments of a FLAC file over to the APEv2
+// tag of the WavPack file. The common field names are converted to their
+// usual APEv2 item names and the rest are used unchanged. Fields that occur
+// more than once become a single item holding a list of values. Any field
+// whose name can't be used in an APEv2 tag is skipped with a warning.
+func write_flac_tags(wpc *wvencode.WavpackContext, flc *flac.FlacContext) int {
+	var items []string
+	var values []string
+
+	for i := 0; i < flac.FlacGetNumTagItems(flc); i++ {
+		var found int = wvencode.FALSE
+		name, value := flac.FlacGetTagItem(flc, i)
+
+		if ape_name, ok := vorbis_to_ape_names[strings.ToUpper(name)]; ok {
+			name = ape_name
+		}
+
+		for j := 0; j < len(items); j++ {
+			if strings.EqualFold(items[j], name) {
+				// APEv2 lists are separated by NULs
+				values[j] = values[j] + "\x00" + value
+				found = wvencode.TRUE
+				break
+			}
+		}
+
+		if found == wvencode.FALSE {
+			items = append(items, name)
+			values = append(values, value)
+		}
+	}
+
+	for i := 0; i < len(items); i++ {
+		if wvencode.WavpackAppendTagItem(wpc, items[i], values[i]) == wvencode.FALSE {
+			fmt.Printf("warning: tag \"%s\" not copied: %s\n", items[i], wvencode.WavpackGetErrorMessage(wpc))
+		}
+	}
+
+	if wvencode.WavpackWriteTag(wpc) == wvencode.FALSE {
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+		return wvencode.SOFT_ERROR
 	}
 
 	return wvencode.NO_ERROR
 }
 
+// the Vorbis comment field names that have different names in APEv2 tags
+var vorbis_to_ape_names = map[string]string{
+	"TITLE":        "Title",
+	"ARTIST":       "Artist",
+	"ALBUM":        "Album",
+	"ALBUMARTIST":  "Album Artist",
+	"ALBUM ARTIST": "Album Artist",
+	"DATE":         "Year",
+	"TRACKNUMBER":  "Track",
+	"DISCNUMBER":   "Disc",
+	"GENRE":        "Genre",
+	"COMMENT":      "Comment",
+	"DESCRIPTION":  "Comment",
+	"COMPOSER":     "Composer",
+	"CONDUCTOR":    "Conductor",
+	"COPYRIGHT":    "Copyright",
+	"PUBLISHER":    "Publisher",
+}
+
 // This function handles the actual audio data compression. It assumes that the
 // input file is positioned at the beginning of the audio data and that the
-// WavPack configuration has been set. This is where the conversion from RIFF
-// little-endian standard the executing processor's format is done.
-func pack_audio(wpc *wvencode.WavpackContext, din *os.File) int {
-	var samples_remaining int
+// WavPack configuration has been set. The audio is read as bytes and then
+// unpacked into samples by WavpackUnpackPCM(), from RIFF little-endian
+// standard by default. Other layouts (such as the big-endian and signed 8-bit data found in AIFF files)
+// are indicated by the qualify mode flags. Floating point samples are passed
+// on unchanged as their 32-bit patterns. If the number of samples is not
+// known then the data is read until the end of the file. If "md5_context"
+// is not nil then the raw audio data is also added to the MD5 signature.
+func pack_audio(wpc *wvencode.WavpackContext, din *os.File, md5_context hash.Hash) int {
+	var samples_remaining int64
 	var bytes_per_sample int
+	var qmode int = wvencode.WavpackGetQualifyMode(wpc)
 
 	wvencode.WavpackPackInit(wpc)
 
 	bytes_per_sample = wvencode.WavpackGetBytesPerSample(wpc) * wvencode.WavpackGetNumChannels(wpc)
 
-	samples_remaining = wvencode.WavpackGetNumSamples(wpc)
-
-	var input_buffer []int
-	var sample_buffer []int
+	samples_remaining = wvencode.WavpackGetNumSamples64(wpc)
 
-	var temp int = 0
+	// the buffers are allocated once for the largest chunk and resliced
+	var input_buffer []byte = make([]byte, wvencode.INPUT_SAMPLES*bytes_per_sample)
+	var sample_buffer []int = make([]int, wvencode.INPUT_SAMPLES*wvencode.WavpackGetNumChannels(wpc))
 
 	for {
 		var sample_count uint
 		var bytes_read int = 0
 		var bytes_to_read int
 
-		temp = temp + 1
-
-		if samples_remaining > wvencode.INPUT_SAMPLES {
+		if (samples_remaining == -1) || (samples_remaining > int64(wvencode.INPUT_SAMPLES)) {
 			bytes_to_read = wvencode.INPUT_SAMPLES * bytes_per_sample
 		} else {
-			bytes_to_read = (samples_remaining * bytes_per_sample)
+			bytes_to_read = (int(samples_remaining) * bytes_per_sample)
 		}
 
-		samples_remaining -= int(math.Floor(float64(bytes_to_read / bytes_per_sample)))
+		if samples_remaining != -1 {
+			samples_remaining -= int64(bytes_to_read / bytes_per_sample)
+		}
 
-		input_buffer = make([]int, bytes_to_read)
-		bytes_read = DoReadFile(din, input_buffer, bytes_to_read)
+		bytes_read = DoReadBytes(din, input_buffer[0:bytes_to_read])
 
-		sample_count = uint(math.Floor(float64(bytes_read / bytes_per_sample)))
+		sample_count = uint(bytes_read / bytes_per_sample)
 
 		if sample_count == 0 {
 			break
 		}
 
-		if sample_count > 0 {
-			var cnt int = (int(sample_count) * wvencode.WavpackGetNumChannels(wpc))
+		// the MD5 signature is of the audio data exactly as it is in the file
+		if md5_context != nil {
+			md5_context.Write(input_buffer[0 : int(sample_count)*bytes_per_sample])
+		}
+
+		wvencode.WavpackUnpackPCM(sample_buffer, input_buffer[0:int(sample_count)*bytes_per_sample],
+			wvencode.WavpackGetBytesPerSample(wpc), qmode)
 
-			sptr := input_buffer[0:len(input_buffer)]
+		wpc.Byte_idx = 0 // new WAV buffer data so reset the buffer index to zero
 
-			var loopBps int = 0
+		if wvencode.WavpackPackSamples(wpc, sample_buffer, sample_count) == 0 {
+			fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
 
-			loopBps = wvencode.WavpackGetBytesPerSample(wpc)
+			return wvencode.HARD_ERROR
+		}
 
-			if loopBps == 1 {
+	}
 
-				var internalCount int = 0
+	if wvencode.WavpackFlushSamples(wpc) == 0 {
 
-				sample_buffer = make([]int, cnt)
+		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
 
-				sample_buffer[cnt-1] = 0 // initialize array
-				for cnt > 0 {
-					sample_buffer[internalCount] = (sptr[internalCount] & 0xff) - 128
-					internalCount++
-					cnt--
-				}
-			} else if loopBps == 2 {
+		return wvencode.HARD_ERROR
+	}
 
-				var dcounter int = 0
-				var scounter int = 0
+	return wvencode.NO_ERROR
+}
 
-				sample_buffer = make([]int, cnt)
-				sample_buffer[cnt-1] = 0 // initialize array
-				for cnt > 0 {
-					sample_buffer[dcounter] = (sptr[scounter] & 0xff) | (sptr[scounter+1] << 8)
+// This function handles the audio data compression for DSD files (DSF and
+// DSDIFF). The DSD data is passed to WavpackPackSamples() as interleaved
+// bytes with the bits in MSB first order, so DSF files (which have the data
+// for each channel in blocks of 4096 bytes, usually LSB first) are converted
+// as they are read. The MD5 signature (if "md5_context" is not nil) is of the
+// audio in this converted form. Anything in the file after the audio data is
+// stored as the trailer of the WavPack file so that the original can be
+// restored. The samples are not flushed here, which leaves the trailer to be
+// written along with the MD5 signature in the block at the end of the file.
+func pack_dsd_audio(wpc *wvencode.WavpackContext, din *os.File, md5_context hash.Hash) int {
+	var num_channels int = wvencode.WavpackGetNumChannels(wpc)
+	var qmode int = wvencode.WavpackGetQualifyMode(wpc)
+	var samples_remaining int64 = wvencode.WavpackGetNumSamples64(wpc)
+	var block_size int = wvencode.INPUT_SAMPLES
+	var input_buffer []byte
+	var sample_buffer []int
 
-					scounter = scounter + 2
-					dcounter++
-					cnt--
-				}
-			} else if loopBps == 3 {
-
-				var dcounter int = 0
-				var scounter int = 0
-
-				sample_buffer = make([]int, cnt)
-				sample_buffer[cnt-1] = 0 // initialize array
-				for cnt > 0 {
-					sample_buffer[dcounter] = (sptr[scounter] & 0xff) |
-						((sptr[scounter+1] & 0xff) << 8) | (sptr[scounter+2] << 16)
-					scounter = scounter + 3
-					dcounter++
-					cnt--
+	wvencode.WavpackPackInit(wpc)
+
+	if (qmode & wvencode.QMODE_DSD_IN_BLOCKS) != 0 {
+		block_size = 4096
+	}
+
+	input_buffer = make([]byte, block_size*num_channels)
+	sample_buffer = make([]int, block_size*num_channels)
+
+	for samples_remaining > 0 {
+		var sample_count int = block_size
+		var bytes_read int
+
+		if samples_remaining < int64(sample_count) {
+			sample_count = int(samples_remaining)
+		}
+
+		// DSF files always have whole blocks, with the last one padded
+		if (qmode & wvencode.QMODE_DSD_IN_BLOCKS) != 0 {
+			bytes_read = DoReadBytes(din, input_buffer)
+
+			if bytes_read != block_size*num_channels {
+				break
+			}
+
+			for i := 0; i < sample_count; i++ {
+				for ch := 0; ch < num_channels; ch++ {
+					sample_buffer[i*num_channels+ch] = int(input_buffer[ch*block_size+i])
 				}
 			}
+		} else {
+			bytes_read = DoReadBytes(din, input_buffer[0:sample_count*num_channels])
+			sample_count = bytes_read / num_channels
+
+			if sample_count == 0 {
+				break
+			}
+
+			for i := 0; i < sample_count*num_channels; i++ {
+				sample_buffer[i] = int(input_buffer[i])
+			}
 		}
 
-		wpc.Byte_idx = 0 // new WAV buffer data so reset the buffer index to zero
+		if (qmode & wvencode.QMODE_DSD_LSB_FIRST) != 0 {
+			for i := 0; i < sample_count*num_channels; i++ {
+				sample_buffer[i] = int(bits.Reverse8(byte(sample_buffer[i])))
+			}
+		}
 
-		if wvencode.WavpackPackSamples(wpc, sample_buffer, sample_count) == 0 {
+		if md5_context != nil {
+			md5_buffer := make([]byte, sample_count*num_channels)
+
+			for i := range md5_buffer {
+				md5_buffer[i] = byte(sample_buffer[i])
+			}
+
+			md5_context.Write(md5_buffer)
+		}
+
+		samples_remaining -= int64(sample_count)
+		wpc.Byte_idx = 0
+
+		if wvencode.WavpackPackSamples(wpc, sample_buffer, uint(sample_count)) == 0 {
 			fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
 
 			return wvencode.HARD_ERROR
 		}
+	}
+
+	// whatever follows the audio data (like the ID3v2 tag of a DSF file or
+	// the comment chunks of a DSDIFF file) goes into the trailer
+	if samples_remaining == 0 {
+		trailer, err := io.ReadAll(din)
 
+		if (err == nil) && (len(trailer) > 0) {
+			wvencode.WavpackAddWrapper(wpc, trailer)
+		}
 	}
 
-	if wvencode.WavpackFlushSamples(wpc) == 0 {
+	return wvencode.NO_ERROR
+}
 
-		fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+// This function handles the audio data compression for FLAC files, taking the
+// samples from the FLAC decoder rather than reading the file directly. The
+// decoder returns right-justified samples, so these are shifted up to fill
+// the bytes of each sample as they would in a WAV file (and as they are
+// expected by WavpackPackSamples()). The MD5 signature stored in the WavPack
+// file is of the audio as it would be in a WAV file, but if "flac_md5_context"
+// is not nil then the signature of the audio as FLAC defines it is also
+// calculated so that it can be checked against the one from the FLAC file.
+func pack_flac_audio(wpc *wvencode.WavpackContext, flc *flac.FlacContext, md5_context hash.Hash, flac_md5_context hash.Hash) int {
+	var num_channels int = wvencode.WavpackGetNumChannels(wpc)
+	var bytes_per_sample int = wvencode.WavpackGetBytesPerSample(wpc)
+	var shift uint = uint(bytes_per_sample*8 - flac.FlacGetBitsPerSample(flc))
+
+	wvencode.WavpackPackInit(wpc)
+
+	sample_buffer := make([]int, wvencode.INPUT_SAMPLES*num_channels)
+
+	for {
+		var sample_count uint
+		var cnt int
+
+		sample_count = flac.FlacUnpackSamples(flc, sample_buffer, uint(wvencode.INPUT_SAMPLES))
+		cnt = int(sample_count) * num_channels
+
+		if sample_count == 0 {
+			break
+		}
+
+		if flac_md5_context != nil {
+			update_md5(flac_md5_context, sample_buffer[0:cnt], bytes_per_sample, wvencode.FALSE)
+		}
+
+		for i := 0; i < cnt; i++ {
+			sample_buffer[i] <<= shift
+		}
+
+		if md5_context != nil {
+			update_md5(md5_context, sample_buffer[0:cnt], bytes_per_sample, wvencode.TRUE)
+		}
+
+		wpc.Byte_idx = 0
+
+		if wvencode.WavpackPackSamples(wpc, sample_buffer, sample_count) == 0 {
+			fmt.Printf("%s\n", wvencode.WavpackGetErrorMessage(wpc))
+
+			return wvencode.HARD_ERROR
+		}
+	}
+
+	if len(flac.FlacGetErrorMessage(flc)) > 0 {
+		fmt.Printf("%s\n", flac.FlacGetErrorMessage(flc))
 
 		return wvencode.HARD_ERROR
 	}
@@ -669,7 +2263,52 @@ func pack_audio(wpc *wvencode.WavpackContext, din *os.File) int {
 	return wvencode.NO_ERROR
 }
 
+// This function adds the samples in "samples" to an MD5 signature as
+// little-endian values of "bytes_per_sample" bytes. If "unsigned_bytes" is
+// TRUE then 8-bit samples are converted to unsigned (as in WAV files).
+func update_md5(md5_context hash.Hash, samples []int, bytes_per_sample int, unsigned_bytes int) {
+	md5_buffer := make([]byte, len(samples)*bytes_per_sample)
+	var idx int = 0
+
+	for i := 0; i < len(samples); i++ {
+		var value int = samples[i]
+
+		if (bytes_per_sample == 1) && (unsigned_bytes == wvencode.TRUE) {
+			value += 128
+		}
+
+		for j := 0; j < bytes_per_sample; j++ {
+			md5_buffer[idx] = byte(value >> uint(j*8))
+			idx++
+		}
+	}
+
+	md5_context.Write(md5_buffer)
+}
+
 //////////////////////////// File I/O Wrapper ////////////////////////////////
+// Read up to len(buffer) bytes (fewer only at the end of the file) and
+// return the number of bytes read.
+func DoReadBytes(hFile *os.File, buffer []byte) int {
+	var bytes_read int = 0
+
+	for bytes_read < len(buffer) {
+		bcount, inErr := hFile.Read(buffer[bytes_read:])
+
+		if (inErr != nil) && (inErr != io.EOF) {
+			fmt.Printf("Error encountered\n")
+		}
+
+		if bcount <= 0 {
+			break
+		}
+
+		bytes_read += bcount
+	}
+
+	return bytes_read
+}
+
 func DoReadFile(hFile *os.File, lpBuffer []int, nNumberOfBytesToRead int) int {
 	tempBufferAsBytes := make([]byte, nNumberOfBytesToRead)
 
@@ -677,15 +2316,14 @@ func DoReadFile(hFile *os.File, lpBuffer []int, nNumberOfBytesToRead int) int {
 	var tempI int = 0
 
 	for nNumberOfBytesToRead > 0 {
-		bcount, inErr := hFile.Read(tempBufferAsBytes)
+		bcount, inErr := hFile.Read(tempBufferAsBytes[lpNumberOfBytesRead:])
 
-		if inErr != nil {
+		if (inErr != nil) && (inErr != io.EOF) {
 			fmt.Printf("Error encountered\n")
 		}
 
 		if bcount > 0 {
-			lpBuffer[bcount-1] = 0
-			for i := 0; i < bcount; i++ {
+			for i := lpNumberOfBytesRead; i < lpNumberOfBytesRead+bcount; i++ {
 				tempI = int(tempBufferAsBytes[i])
 				// the following is a very inelegant way to convert unsigned to signed bytes
 				// must be a better way in go!
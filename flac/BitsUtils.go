@@ -0,0 +1,133 @@
+package flac
+
+/*
+** BitsUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+var crc8_table [256]uint
+var crc16_table [256]uint
+
+func init() {
+	for i := 0; i < 256; i++ {
+		var crc8 uint = uint(i)
+		var crc16 uint = uint(i) << 8
+
+		for j := 0; j < 8; j++ {
+			if (crc8 & 0x80) != 0 {
+				crc8 = ((crc8 << 1) ^ 0x07) & 0xff
+			} else {
+				crc8 = (crc8 << 1) & 0xff
+			}
+
+			if (crc16 & 0x8000) != 0 {
+				crc16 = ((crc16 << 1) ^ 0x8005) & 0xffff
+			} else {
+				crc16 = (crc16 << 1) & 0xffff
+			}
+		}
+
+		crc8_table[i] = crc8
+		crc16_table[i] = crc16
+	}
+}
+
+// Update both frame crcs with the next byte of the stream. The crc-8 covers
+// the frame header and the crc-16 covers the whole frame.
+func update_crcs(ctx *FlacContext, b uint) {
+	ctx.crc8 = crc8_table[ctx.crc8^b]
+	ctx.crc16 = ((ctx.crc16 << 8) ^ crc16_table[(ctx.crc16>>8)^b]) & 0xffff
+}
+
+// Read the next byte from the file into the bit cache. At the end of the
+// file zeros are returned and the eof flag is set, so callers need only
+// check it once they are done with the frame.
+func fill_cache(ctx *FlacContext) {
+	b, err := ctx.infile.ReadByte()
+
+	if err != nil {
+		ctx.eof = TRUE
+		b = 0
+	}
+
+	update_crcs(ctx, uint(b))
+	ctx.cache = (ctx.cache << 8) | uint64(b)
+	ctx.cache_bits += 8
+}
+
+// Read "nbits" (up to 32) from the stream, msb first.
+func read_bits(ctx *FlacContext, nbits uint) uint {
+	if nbits == 0 {
+		return 0
+	}
+
+	for ctx.cache_bits < nbits {
+		fill_cache(ctx)
+	}
+
+	ctx.cache_bits -= nbits
+
+	return uint(ctx.cache>>ctx.cache_bits) & ((1 << nbits) - 1)
+}
+
+// Read "nbits" from the stream as a two's complement signed value.
+func read_signed_bits(ctx *FlacContext, nbits uint) int {
+	var value uint = read_bits(ctx, nbits)
+
+	if (nbits != 0) && (value&(1<<(nbits-1))) != 0 {
+		return int(value) - (1 << nbits)
+	}
+
+	return int(value)
+}
+
+// Read a unary coded value, which is the count of zeros before the next one.
+func read_unary(ctx *FlacContext) uint {
+	var count uint = 0
+
+	for {
+		if ctx.cache_bits == 0 {
+			fill_cache(ctx)
+
+			if ctx.eof == TRUE {
+				return count
+			}
+		}
+
+		if (ctx.cache & ((1 << ctx.cache_bits) - 1)) == 0 {
+			count += ctx.cache_bits
+			ctx.cache_bits = 0
+			continue
+		}
+
+		for ctx.cache_bits > 0 {
+			ctx.cache_bits--
+
+			if ((ctx.cache >> ctx.cache_bits) & 1) != 0 {
+				return count
+			}
+
+			count++
+		}
+	}
+}
+
+// Read a Rice coded signed value with the parameter "k".
+func read_rice(ctx *FlacContext, k uint) int {
+	var value uint = read_unary(ctx) << k
+
+	value |= read_bits(ctx, k)
+
+	return int(value>>1) ^ -int(value&1)
+}
+
+// Discard any bits left over from the current byte.
+func align_to_byte(ctx *FlacContext) {
+	ctx.cache_bits -= ctx.cache_bits & 7
+}
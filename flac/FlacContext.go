@@ -0,0 +1,63 @@
+package flac
+
+/*
+** FlacContext.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bufio"
+)
+
+const FALSE int = 0
+const TRUE int = 1
+
+const FLAC_MAX_CHANNELS int = 8
+const FLAC_MAX_BLOCK_SIZE int = 65535
+
+// metadata block types
+const FLAC_STREAMINFO int = 0
+const FLAC_PADDING int = 1
+const FLAC_APPLICATION int = 2
+const FLAC_SEEKTABLE int = 3
+const FLAC_VORBIS_COMMENT int = 4
+const FLAC_CUESHEET int = 5
+const FLAC_PICTURE int = 6
+
+// channel assignments of a frame (0 - 7 are independent channels)
+const FLAC_LEFT_SIDE int = 8
+const FLAC_SIDE_RIGHT int = 9
+const FLAC_MID_SIDE int = 10
+
+type FlacContext struct {
+	infile          *bufio.Reader
+	error_message   string
+	min_block_size  int
+	max_block_size  int
+	sample_rate     int
+	num_channels    int
+	bits_per_sample int
+	total_samples   uint // 0 if unknown
+	md5_sum         [16]byte
+	vendor_string   string
+	comments        []string // the Vorbis comments as "NAME=value"
+
+	// bit reader state, bits are consumed msb first
+	cache      uint64 // the low "cache_bits" bits are valid
+	cache_bits uint
+	crc8       uint
+	crc16      uint
+	eof        int
+
+	// the frame currently being returned to the caller
+	samples      [FLAC_MAX_CHANNELS][]int
+	block_size   int
+	block_pos    int
+	sample_index uint
+}
@@ -0,0 +1,327 @@
+package flac
+
+/*
+** FlacUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bufio"
+	"io"
+)
+
+// This is a decoder for native FLAC streams, written so that FLAC files can
+// be transcoded to WavPack without an intermediate WAV file. It handles
+// everything in the FLAC format except for bit depths over 24 (which
+// WavPack can't store as integers anyway) and streams where the bit depth
+// changes. Ogg FLAC is not handled.
+
+// Return the last error reported by the decoder.
+func FlacGetErrorMessage(ctx *FlacContext) string {
+	return ctx.error_message
+}
+
+// Open the FLAC stream in "infile" and read its metadata, leaving the
+// decoder positioned at the first audio frame. An ID3v2 tag ahead of the
+// stream is skipped. A return of FALSE indicates an error and the reason
+// can be retrieved with FlacGetErrorMessage().
+func FlacOpenInput(ctx *FlacContext, infile io.Reader) int {
+	var header [4]byte
+	var last_block int = FALSE
+	var got_streaminfo int = FALSE
+
+	ctx.infile = bufio.NewReaderSize(infile, 65536)
+
+	if read_bytes(ctx, header[0:4]) == FALSE {
+		ctx.error_message = "not a valid FLAC file!"
+
+		return FALSE
+	}
+
+	if string(header[0:3]) == "ID3" {
+		if skip_id3v2_tag(ctx, header[3]) == FALSE || read_bytes(ctx, header[0:4]) == FALSE {
+			ctx.error_message = "not a valid FLAC file!"
+
+			return FALSE
+		}
+	}
+
+	if string(header[0:4]) != "fLaC" {
+		ctx.error_message = "not a valid FLAC file!"
+
+		return FALSE
+	}
+
+	for last_block == FALSE {
+		var block_header [4]byte
+		var block_type int
+		var block_length int
+
+		if read_bytes(ctx, block_header[0:4]) == FALSE {
+			ctx.error_message = "FLAC file is truncated!"
+
+			return FALSE
+		}
+
+		last_block = int(block_header[0]>>7) & 1
+		block_type = int(block_header[0] & 0x7f)
+		block_length = (int(block_header[1]) << 16) | (int(block_header[2]) << 8) | int(block_header[3])
+
+		if block_type == FLAC_STREAMINFO {
+			if block_length != 34 || got_streaminfo == TRUE || read_streaminfo(ctx) == FALSE {
+				if ctx.error_message == "" {
+					ctx.error_message = "invalid FLAC STREAMINFO block!"
+				}
+
+				return FALSE
+			}
+
+			got_streaminfo = TRUE
+		} else if block_type == FLAC_VORBIS_COMMENT {
+			var data []byte = make([]byte, block_length)
+
+			if read_bytes(ctx, data) == FALSE || read_vorbis_comment(ctx, data) == FALSE {
+				ctx.error_message = "invalid FLAC VORBIS_COMMENT block!"
+
+				return FALSE
+			}
+		} else if block_type == 127 {
+			ctx.error_message = "invalid FLAC metadata block!"
+
+			return FALSE
+		} else if skip_bytes(ctx, block_length) == FALSE {
+			ctx.error_message = "FLAC file is truncated!"
+
+			return FALSE
+		}
+	}
+
+	if got_streaminfo == FALSE {
+		ctx.error_message = "FLAC file has no STREAMINFO block!"
+
+		return FALSE
+	}
+
+	for i := 0; i < ctx.num_channels; i++ {
+		ctx.samples[i] = make([]int, ctx.max_block_size)
+	}
+
+	return TRUE
+}
+
+// Read the STREAMINFO block (which must come first in the file). The
+// bit depth is limited here to what can be stored as WavPack integers.
+func read_streaminfo(ctx *FlacContext) int {
+	ctx.min_block_size = int(read_bits(ctx, 16))
+	ctx.max_block_size = int(read_bits(ctx, 16))
+	read_bits(ctx, 24) // minimum frame size
+	read_bits(ctx, 24) // maximum frame size
+	ctx.sample_rate = int(read_bits(ctx, 20))
+	ctx.num_channels = int(read_bits(ctx, 3)) + 1
+	ctx.bits_per_sample = int(read_bits(ctx, 5)) + 1
+	ctx.total_samples = read_bits(ctx, 4) << 32
+	ctx.total_samples |= read_bits(ctx, 32)
+
+	if read_bytes(ctx, ctx.md5_sum[0:16]) == FALSE || ctx.eof == TRUE {
+		return FALSE
+	}
+
+	if ctx.max_block_size < 16 || ctx.sample_rate == 0 {
+		return FALSE
+	}
+
+	if ctx.bits_per_sample < 4 || ctx.bits_per_sample > 24 {
+		ctx.error_message = "FLAC files over 24 bits per sample are not supported!"
+
+		return FALSE
+	}
+
+	return TRUE
+}
+
+// Parse the Vorbis comment block, which (unlike the rest of FLAC) uses
+// little-endian lengths. Only the comments themselves are kept.
+func read_vorbis_comment(ctx *FlacContext, data []byte) int {
+	var idx int = 0
+	var length int
+	var count int
+
+	if length = get_le_length(data, idx); length < 0 || idx+4+length > len(data) {
+		return FALSE
+	}
+
+	ctx.vendor_string = string(data[idx+4 : idx+4+length])
+	idx += 4 + length
+
+	if count = get_le_length(data, idx); count < 0 {
+		return FALSE
+	}
+
+	idx += 4
+
+	for i := 0; i < count; i++ {
+		if length = get_le_length(data, idx); length < 0 || idx+4+length > len(data) {
+			return FALSE
+		}
+
+		ctx.comments = append(ctx.comments, string(data[idx+4:idx+4+length]))
+		idx += 4 + length
+	}
+
+	return TRUE
+}
+
+func get_le_length(data []byte, idx int) int {
+	if idx+4 > len(data) {
+		return -1
+	}
+
+	return int(data[idx]) | (int(data[idx+1]) << 8) | (int(data[idx+2]) << 16) | (int(data[idx+3]) << 24)
+}
+
+// Skip an ID3v2 tag (some programs put these in front of FLAC files). The
+// "ID3" has already been read, and "version" is the byte that followed it.
+func skip_id3v2_tag(ctx *FlacContext, version byte) int {
+	var header [6]byte
+	var tag_size int
+
+	if read_bytes(ctx, header[0:6]) == FALSE {
+		return FALSE
+	}
+
+	// the size is "syncsafe" (7 bits per byte) and doesn't include the
+	// 10-byte header or the optional 10-byte footer
+
+	tag_size = (int(header[2]&0x7f) << 21) | (int(header[3]&0x7f) << 14) | (int(header[4]&0x7f) << 7) | int(header[5]&0x7f)
+
+	if version >= 4 && (header[1]&0x10) != 0 {
+		tag_size += 10
+	}
+
+	return skip_bytes(ctx, tag_size)
+}
+
+func read_bytes(ctx *FlacContext, buffer []byte) int {
+	if _, err := io.ReadFull(ctx.infile, buffer); err != nil {
+		return FALSE
+	}
+
+	return TRUE
+}
+
+func skip_bytes(ctx *FlacContext, count int) int {
+	if discarded, _ := ctx.infile.Discard(count); discarded != count {
+		return FALSE
+	}
+
+	return TRUE
+}
+
+// Unpack the specified number of samples from the current file position.
+// Note that "samples" here refers to "complete" samples, which would be
+// 2 ints for stereo files. The samples are returned right-justified in the
+// ints of "buffer" (so they range from -(2^(bits-1)) to 2^(bits-1)-1) and
+// the number of samples actually unpacked is returned, which is less than
+// requested only at the end of the stream or on an error (which may be
+// checked with FlacGetErrorMessage()).
+func FlacUnpackSamples(ctx *FlacContext, buffer []int, samples uint) uint {
+	var samples_unpacked uint = 0
+	var buf_idx int = 0
+
+	for samples_unpacked < samples {
+		var samples_to_copy uint
+
+		if ctx.block_pos == ctx.block_size {
+			if ctx.total_samples != 0 && ctx.sample_index >= ctx.total_samples {
+				break
+			}
+
+			if read_frame(ctx) == FALSE {
+				break
+			}
+		}
+
+		samples_to_copy = uint(ctx.block_size - ctx.block_pos)
+
+		if samples_to_copy > samples-samples_unpacked {
+			samples_to_copy = samples - samples_unpacked
+		}
+
+		if ctx.total_samples != 0 && ctx.sample_index+samples_to_copy > ctx.total_samples {
+			samples_to_copy = ctx.total_samples - ctx.sample_index
+		}
+
+		for i := 0; i < int(samples_to_copy); i++ {
+			for ch := 0; ch < ctx.num_channels; ch++ {
+				buffer[buf_idx] = ctx.samples[ch][ctx.block_pos]
+				buf_idx++
+			}
+
+			ctx.block_pos++
+		}
+
+		ctx.sample_index += samples_to_copy
+		samples_unpacked += samples_to_copy
+
+		if samples_to_copy == 0 {
+			break
+		}
+	}
+
+	return samples_unpacked
+}
+
+// Get total number of samples contained in the FLAC file, or 0 if unknown.
+func FlacGetNumSamples(ctx *FlacContext) uint {
+	return ctx.total_samples
+}
+
+// Get the number of samples unpacked so far.
+func FlacGetSampleIndex(ctx *FlacContext) uint {
+	return ctx.sample_index
+}
+
+func FlacGetSampleRate(ctx *FlacContext) int {
+	return ctx.sample_rate
+}
+
+func FlacGetNumChannels(ctx *FlacContext) int {
+	return ctx.num_channels
+}
+
+func FlacGetBitsPerSample(ctx *FlacContext) int {
+	return ctx.bits_per_sample
+}
+
+// Get the MD5 signature of the unencoded audio from the STREAMINFO block.
+// The signature covers the samples as signed little-endian values in the
+// smallest whole number of bytes. All zeros means the encoder didn't
+// compute one.
+func FlacGetMD5Sum(ctx *FlacContext) []byte {
+	return ctx.md5_sum[0:16]
+}
+
+// Get the number of Vorbis comments in the file.
+func FlacGetNumTagItems(ctx *FlacContext) int {
+	return len(ctx.comments)
+}
+
+// Get the Vorbis comment at "index" split into its field name and value.
+// Comments without an "=" are returned with an empty name.
+func FlacGetTagItem(ctx *FlacContext, index int) (string, string) {
+	var comment string = ctx.comments[index]
+
+	for i := 0; i < len(comment); i++ {
+		if comment[i] == '=' {
+			return comment[0:i], comment[i+1:]
+		}
+	}
+
+	return "", comment
+}
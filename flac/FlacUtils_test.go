@@ -0,0 +1,350 @@
+package flac
+
+/*
+** FlacUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// Writes a stream of bits, msb first, as the decoder reads them.
+type bit_writer struct {
+	data  []byte
+	cache uint64
+	bits  uint
+}
+
+func (w *bit_writer) write_bits(value uint64, nbits uint) {
+	for i := int(nbits) - 1; i >= 0; i-- {
+		w.cache = (w.cache << 1) | ((value >> uint(i)) & 1)
+		w.bits++
+
+		if w.bits == 8 {
+			w.data = append(w.data, byte(w.cache))
+			w.cache = 0
+			w.bits = 0
+		}
+	}
+}
+
+func (w *bit_writer) write_signed_bits(value int, nbits uint) {
+	w.write_bits(uint64(value)&((1<<nbits)-1), nbits)
+}
+
+func (w *bit_writer) write_rice(value int, k uint) {
+	var folded uint64 = uint64(value) << 1
+
+	if value < 0 {
+		folded = (uint64(-value) << 1) - 1
+	}
+
+	for q := folded >> k; q > 0; q-- {
+		w.write_bits(0, 1)
+	}
+
+	w.write_bits(1, 1)
+	w.write_bits(folded, k)
+}
+
+func (w *bit_writer) align() {
+	for w.bits != 0 {
+		w.write_bits(0, 1)
+	}
+}
+
+// The crcs of FLAC frames, worked out bit by bit rather than with the
+// decoder's tables.
+func frame_crc(data []byte, poly uint, width uint) uint {
+	var crc uint = 0
+	var top uint = 1 << (width - 1)
+	var mask uint = (1 << width) - 1
+
+	for _, b := range data {
+		crc ^= uint(b) << (width - 8)
+
+		for i := 0; i < 8; i++ {
+			if (crc & top) != 0 {
+				crc = ((crc << 1) ^ poly) & mask
+			} else {
+				crc = (crc << 1) & mask
+			}
+		}
+	}
+
+	return crc
+}
+
+// Write a frame of "block_size" samples with the given channel assignment,
+// with "subframes" writing the subframes.
+func write_frame(stream *bytes.Buffer, frame_number int, block_size int, channel_assignment int,
+	subframes func(w *bit_writer)) {
+	var w bit_writer
+
+	w.write_bits(0xfff8, 16)
+	w.write_bits(7, 4) // 16-bit block size after the header
+	w.write_bits(0, 4) // sample rate from the STREAMINFO
+	w.write_bits(uint64(channel_assignment), 4)
+	w.write_bits(4, 3) // 16 bits per sample
+	w.write_bits(0, 1)
+	w.write_bits(uint64(frame_number), 8)
+	w.write_bits(uint64(block_size-1), 16)
+	w.write_bits(uint64(frame_crc(w.data, 0x07, 8)), 8)
+
+	subframes(&w)
+	w.align()
+	w.write_bits(uint64(frame_crc(w.data, 0x8005, 16)), 16)
+
+	stream.Write(w.data)
+}
+
+func write_verbatim(w *bit_writer, samples []int, bps uint) {
+	w.write_bits(1<<1, 8) // type 1, no wasted bits
+
+	for _, sample := range samples {
+		w.write_signed_bits(sample, bps)
+	}
+}
+
+// Write a subframe with the fixed predictor of order 2, with the residual
+// in two partitions: the first Rice coded and the second escaped.
+func write_fixed(w *bit_writer, samples []int, bps uint) {
+	var half int = len(samples) / 2
+
+	w.write_bits(10<<1, 8) // type 8 + order, no wasted bits
+	w.write_signed_bits(samples[0], bps)
+	w.write_signed_bits(samples[1], bps)
+	w.write_bits(0, 2) // 4-bit Rice parameters
+	w.write_bits(1, 4) // partition order
+
+	w.write_bits(6, 4)
+
+	for i := 2; i < half; i++ {
+		w.write_rice(samples[i]-2*samples[i-1]+samples[i-2], 6)
+	}
+
+	w.write_bits(15, 4) // escape
+	w.write_bits(uint64(bps+2), 5)
+
+	for i := half; i < len(samples); i++ {
+		w.write_signed_bits(samples[i]-2*samples[i-1]+samples[i-2], bps+2)
+	}
+}
+
+// Write a subframe with the linear predictor 2 * s[i-1] - s[i-2] (with a
+// shift of one), Rice coded with 5-bit parameters.
+func write_lpc(w *bit_writer, samples []int, bps uint) {
+	w.write_bits((32+1)<<1, 8) // type 32 + order - 1, no wasted bits
+	w.write_signed_bits(samples[0], bps)
+	w.write_signed_bits(samples[1], bps)
+	w.write_bits(5-1, 4) // precision of the coefficients
+	w.write_signed_bits(1, 5)
+	w.write_signed_bits(4, 5)
+	w.write_signed_bits(-2, 5)
+	w.write_bits(1, 2) // 5-bit Rice parameters
+	w.write_bits(0, 4)
+	w.write_bits(7, 5)
+
+	for i := 2; i < len(samples); i++ {
+		w.write_rice(samples[i]-((4*samples[i-1]-2*samples[i-2])>>1), 7)
+	}
+}
+
+// Make a stereo FLAC stream (behind an ID3v2 tag) whose frames use every
+// kind of subframe and stereo decorrelation, and return it with the samples
+// it holds. The last frame is longer than the total in the STREAMINFO.
+func make_flac_stream(t *testing.T) ([]byte, []int, []byte) {
+	var stream bytes.Buffer
+	var frame_size int = 300
+	var num_samples int = frame_size*4 - 50
+	var left []int = make([]int, frame_size*4)
+	var right []int = make([]int, frame_size*4)
+	var samples []int
+	var md5_sum []byte = []byte("0123456789abcdef")
+	var seed uint32 = 5
+
+	for i := range left {
+		seed = seed*1664525 + 1013904223
+		left[i] = int(math.Sin(float64(i)*0.05)*20000) + int(seed>>28) - 8
+		right[i] = int(math.Sin(float64(i)*0.03)*12000) - int(seed>>29)
+	}
+
+	// the second channel of the first frame is constant
+	for i := 0; i < frame_size; i++ {
+		right[i] = -1234
+	}
+
+	for i := 0; i < num_samples; i++ {
+		samples = append(samples, left[i], right[i])
+	}
+
+	stream.Write([]byte{'I', 'D', '3', 4, 0, 0, 0, 0, 0, 5, 1, 2, 3, 4, 5})
+	stream.WriteString("fLaC")
+
+	var info bit_writer
+
+	info.write_bits(0x00000022, 32) // STREAMINFO, 34 bytes
+	info.write_bits(uint64(frame_size), 16)
+	info.write_bits(uint64(frame_size), 16)
+	info.write_bits(0, 24)
+	info.write_bits(0, 24)
+	info.write_bits(44100, 20)
+	info.write_bits(1, 3)
+	info.write_bits(15, 5)
+	info.write_bits(uint64(num_samples), 36)
+	stream.Write(info.data)
+	stream.Write(md5_sum)
+
+	var comments bytes.Buffer
+
+	for _, text := range []string{"test vendor", "TITLE=A Test", "ARTIST=Someone", "NOEQUALS"} {
+		binary.Write(&comments, binary.LittleEndian, uint32(len(text)))
+		comments.WriteString(text)
+
+		// the count of comments follows the vendor string
+		if text == "test vendor" {
+			binary.Write(&comments, binary.LittleEndian, uint32(3))
+		}
+	}
+
+	stream.Write([]byte{0x80 | byte(FLAC_VORBIS_COMMENT), 0, byte(comments.Len() >> 8), byte(comments.Len())})
+	stream.Write(comments.Bytes())
+
+	frame := func(n int) ([]int, []int) {
+		return left[n*frame_size : (n+1)*frame_size], right[n*frame_size : (n+1)*frame_size]
+	}
+
+	write_frame(&stream, 0, frame_size, 1, func(w *bit_writer) {
+		l, _ := frame(0)
+		write_verbatim(w, l, 16)
+		w.write_bits(0, 8) // constant
+		w.write_signed_bits(-1234, 16)
+	})
+
+	write_frame(&stream, 1, frame_size, FLAC_LEFT_SIDE, func(w *bit_writer) {
+		var side []int
+		l, r := frame(1)
+
+		for i := range l {
+			side = append(side, l[i]-r[i])
+		}
+
+		write_fixed(w, l, 16)
+		write_verbatim(w, side, 17)
+	})
+
+	write_frame(&stream, 2, frame_size, FLAC_MID_SIDE, func(w *bit_writer) {
+		var mid, side []int
+		l, r := frame(2)
+
+		for i := range l {
+			mid = append(mid, (l[i]+r[i])>>1)
+			side = append(side, l[i]-r[i])
+		}
+
+		write_lpc(w, mid, 16)
+		write_fixed(w, side, 17)
+	})
+
+	write_frame(&stream, 3, frame_size, FLAC_SIDE_RIGHT, func(w *bit_writer) {
+		var side []int
+		l, r := frame(3)
+
+		for i := range l {
+			side = append(side, l[i]-r[i])
+		}
+
+		write_verbatim(w, side, 17)
+		write_lpc(w, r, 16)
+	})
+
+	// junk at the end (like an ID3v1 tag) is skipped
+	stream.WriteString("TAG and some junk")
+
+	return stream.Bytes(), samples, md5_sum
+}
+
+func TestFlacUnpackSamples(t *testing.T) {
+	var ctx FlacContext
+	var buffer []int = make([]int, 2*200)
+	var unpacked []int
+
+	stream, samples, md5_sum := make_flac_stream(t)
+
+	if FlacOpenInput(&ctx, bytes.NewReader(stream)) == FALSE {
+		t.Fatalf("can't open the stream: %s", FlacGetErrorMessage(&ctx))
+	}
+
+	if (FlacGetNumChannels(&ctx) != 2) || (FlacGetBitsPerSample(&ctx) != 16) || (FlacGetSampleRate(&ctx) != 44100) ||
+		(int(FlacGetNumSamples(&ctx)) != len(samples)/2) || !bytes.Equal(FlacGetMD5Sum(&ctx), md5_sum) {
+		t.Errorf("STREAMINFO read as %d channels, %d bits, %d Hz, %d samples", FlacGetNumChannels(&ctx),
+			FlacGetBitsPerSample(&ctx), FlacGetSampleRate(&ctx), FlacGetNumSamples(&ctx))
+	}
+
+	if FlacGetNumTagItems(&ctx) != 3 {
+		t.Fatalf("%d Vorbis comments read instead of 3", FlacGetNumTagItems(&ctx))
+	}
+
+	for i, expected := range [][2]string{{"TITLE", "A Test"}, {"ARTIST", "Someone"}, {"", "NOEQUALS"}} {
+		if name, value := FlacGetTagItem(&ctx, i); (name != expected[0]) || (value != expected[1]) {
+			t.Errorf("comment %d read as %q = %q", i, name, value)
+		}
+	}
+
+	for {
+		var samples_unpacked uint = FlacUnpackSamples(&ctx, buffer, 200)
+
+		if samples_unpacked == 0 {
+			break
+		}
+
+		unpacked = append(unpacked, buffer[0:samples_unpacked*2]...)
+	}
+
+	if FlacGetErrorMessage(&ctx) != "" {
+		t.Errorf("unpacking failed: %s", FlacGetErrorMessage(&ctx))
+	}
+
+	if len(unpacked) != len(samples) {
+		t.Fatalf("%d samples unpacked instead of %d", len(unpacked)/2, len(samples)/2)
+	}
+
+	for i := range samples {
+		if unpacked[i] != samples[i] {
+			t.Fatalf("value %d unpacked as %d instead of %d", i, unpacked[i], samples[i])
+		}
+	}
+}
+
+// A frame that fails its crc stops the decode with an error.
+func TestFlacFrameCrc(t *testing.T) {
+	var ctx FlacContext
+	var buffer []int = make([]int, 2*2000)
+
+	stream, _, _ := make_flac_stream(t)
+
+	// damage the last frame (ahead of the junk at the end)
+	stream[len(stream)-40] ^= 0x10
+
+	if FlacOpenInput(&ctx, bytes.NewReader(stream)) == FALSE {
+		t.Fatalf("can't open the stream: %s", FlacGetErrorMessage(&ctx))
+	}
+
+	if samples_unpacked := FlacUnpackSamples(&ctx, buffer, 2000); samples_unpacked != 900 {
+		t.Errorf("%d samples unpacked from the good frames instead of 900", samples_unpacked)
+	}
+
+	if FlacGetErrorMessage(&ctx) != "crc error in FLAC frame!" {
+		t.Errorf("error given as %q", FlacGetErrorMessage(&ctx))
+	}
+}
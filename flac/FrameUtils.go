@@ -0,0 +1,411 @@
+package flac
+
+/*
+** FrameUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+var sample_size_table = [8]int{0, 8, 12, 0, 16, 20, 24, 32}
+
+// Find and decode the next frame of the stream into ctx.samples. Any junk
+// between frames (like an ID3v1 tag at the end) is skipped while looking
+// for the sync code. FALSE is returned at the end of the stream or on an
+// error, in which case the error message will be set.
+func read_frame(ctx *FlacContext) int {
+	var block_size_code uint
+	var sample_rate_code uint
+	var channel_assignment int
+	var bits_per_sample int
+	var num_channels int
+	var first_byte uint
+	var crc uint
+
+	if find_sync(ctx) == FALSE {
+		return FALSE
+	}
+
+	block_size_code = read_bits(ctx, 4)
+	sample_rate_code = read_bits(ctx, 4)
+	channel_assignment = int(read_bits(ctx, 4))
+	bits_per_sample = sample_size_table[read_bits(ctx, 3)]
+	read_bits(ctx, 1)
+
+	// the frame (or sample) number is coded like UTF-8, we only need to skip it
+
+	first_byte = read_bits(ctx, 8)
+
+	for mask := uint(0x40); (first_byte&0x80) != 0 && (first_byte&mask) != 0 && mask > 1; mask >>= 1 {
+		read_bits(ctx, 8)
+	}
+
+	switch {
+	case block_size_code == 0:
+		ctx.error_message = "invalid FLAC frame header!"
+
+		return FALSE
+
+	case block_size_code == 1:
+		ctx.block_size = 192
+
+	case block_size_code <= 5:
+		ctx.block_size = 576 << (block_size_code - 2)
+
+	case block_size_code == 6:
+		ctx.block_size = int(read_bits(ctx, 8)) + 1
+
+	case block_size_code == 7:
+		ctx.block_size = int(read_bits(ctx, 16)) + 1
+
+	default:
+		ctx.block_size = 256 << (block_size_code - 8)
+	}
+
+	// the sample rate from the STREAMINFO is always used
+
+	if sample_rate_code == 12 {
+		read_bits(ctx, 8)
+	} else if sample_rate_code == 13 || sample_rate_code == 14 {
+		read_bits(ctx, 16)
+	}
+
+	crc = ctx.crc8
+
+	if read_bits(ctx, 8) != crc {
+		if ctx.eof == FALSE {
+			ctx.error_message = "crc error in FLAC frame header!"
+		}
+
+		return FALSE
+	}
+
+	if bits_per_sample == 0 {
+		bits_per_sample = ctx.bits_per_sample
+	}
+
+	if channel_assignment < FLAC_MAX_CHANNELS {
+		num_channels = channel_assignment + 1
+	} else if channel_assignment <= FLAC_MID_SIDE {
+		num_channels = 2
+	} else {
+		ctx.error_message = "invalid FLAC frame header!"
+
+		return FALSE
+	}
+
+	if num_channels != ctx.num_channels || bits_per_sample != ctx.bits_per_sample ||
+		ctx.block_size > ctx.max_block_size {
+		ctx.error_message = "FLAC frame doesn't match the STREAMINFO!"
+
+		return FALSE
+	}
+
+	for ch := 0; ch < num_channels; ch++ {
+		var bps uint = uint(bits_per_sample)
+
+		// the side channel needs an extra bit
+
+		if (channel_assignment == FLAC_LEFT_SIDE && ch == 1) ||
+			(channel_assignment == FLAC_SIDE_RIGHT && ch == 0) ||
+			(channel_assignment == FLAC_MID_SIDE && ch == 1) {
+			bps++
+		}
+
+		if read_subframe(ctx, ctx.samples[ch][0:ctx.block_size], bps) == FALSE {
+			return FALSE
+		}
+	}
+
+	align_to_byte(ctx)
+	crc = ctx.crc16
+
+	if read_bits(ctx, 16) != crc || ctx.eof == TRUE {
+		if ctx.eof == TRUE {
+			ctx.error_message = "FLAC file is truncated!"
+		} else {
+			ctx.error_message = "crc error in FLAC frame!"
+		}
+
+		return FALSE
+	}
+
+	decorrelate_channels(ctx, channel_assignment)
+	ctx.block_pos = 0
+
+	return TRUE
+}
+
+// Search for the 14-bit frame sync code (which always starts on a byte
+// boundary) and reset the crcs to cover the frame from there. FALSE is
+// returned at the end of the file.
+func find_sync(ctx *FlacContext) int {
+	var last_byte byte = 0
+
+	ctx.cache_bits = 0
+
+	for {
+		b, err := ctx.infile.ReadByte()
+
+		if err != nil {
+			ctx.eof = TRUE
+
+			return FALSE
+		}
+
+		if last_byte == 0xff && (b&0xfe) == 0xf8 {
+			ctx.crc8 = 0
+			ctx.crc16 = 0
+			update_crcs(ctx, 0xff)
+			update_crcs(ctx, uint(b))
+
+			return TRUE
+		}
+
+		last_byte = b
+	}
+}
+
+// Decode one subframe of "bps" bits into "samples".
+func read_subframe(ctx *FlacContext, samples []int, bps uint) int {
+	var subframe_type uint
+	var wasted_bits uint = 0
+
+	if read_bits(ctx, 1) != 0 {
+		ctx.error_message = "invalid FLAC subframe!"
+
+		return FALSE
+	}
+
+	subframe_type = read_bits(ctx, 6)
+
+	if read_bits(ctx, 1) != 0 {
+		wasted_bits = read_unary(ctx) + 1
+
+		if wasted_bits >= bps {
+			ctx.error_message = "invalid FLAC subframe!"
+
+			return FALSE
+		}
+
+		bps -= wasted_bits
+	}
+
+	if subframe_type == 0 {
+		var value int = read_signed_bits(ctx, bps)
+
+		for i := range samples {
+			samples[i] = value
+		}
+	} else if subframe_type == 1 {
+		for i := range samples {
+			samples[i] = read_signed_bits(ctx, bps)
+		}
+	} else if subframe_type >= 8 && subframe_type <= 12 {
+		var order int = int(subframe_type - 8)
+
+		if order > len(samples) {
+			ctx.error_message = "invalid FLAC subframe!"
+
+			return FALSE
+		}
+
+		for i := 0; i < order; i++ {
+			samples[i] = read_signed_bits(ctx, bps)
+		}
+
+		if read_residual(ctx, samples, order) == FALSE {
+			return FALSE
+		}
+
+		restore_fixed(samples, order)
+	} else if subframe_type >= 32 {
+		var order int = int(subframe_type-32) + 1
+		var coefs [32]int
+		var precision uint
+		var shift int
+
+		if order > len(samples) {
+			ctx.error_message = "invalid FLAC subframe!"
+
+			return FALSE
+		}
+
+		for i := 0; i < order; i++ {
+			samples[i] = read_signed_bits(ctx, bps)
+		}
+
+		if precision = read_bits(ctx, 4) + 1; precision == 16 {
+			ctx.error_message = "invalid FLAC subframe!"
+
+			return FALSE
+		}
+
+		if shift = read_signed_bits(ctx, 5); shift < 0 {
+			ctx.error_message = "invalid FLAC subframe!"
+
+			return FALSE
+		}
+
+		for i := 0; i < order; i++ {
+			coefs[i] = read_signed_bits(ctx, precision)
+		}
+
+		if read_residual(ctx, samples, order) == FALSE {
+			return FALSE
+		}
+
+		restore_lpc(samples, order, coefs[0:order], uint(shift))
+	} else {
+		ctx.error_message = "invalid FLAC subframe!"
+
+		return FALSE
+	}
+
+	if wasted_bits != 0 {
+		for i := range samples {
+			samples[i] <<= wasted_bits
+		}
+	}
+
+	return TRUE
+}
+
+// Read the Rice coded residual into "samples" after the "order" warm-up
+// samples. The block is divided into 2^partition_order partitions that
+// each have their own Rice parameter, or an escape code followed by the
+// bit width of the values stored verbatim.
+func read_residual(ctx *FlacContext, samples []int, order int) int {
+	var param_bits uint = 4
+	var escape_code uint = 15
+	var partition_order uint
+	var partition_samples int
+	var idx int = order
+
+	switch read_bits(ctx, 2) {
+	case 0:
+	case 1:
+		param_bits = 5
+		escape_code = 31
+	default:
+		ctx.error_message = "invalid FLAC residual coding method!"
+
+		return FALSE
+	}
+
+	partition_order = read_bits(ctx, 4)
+	partition_samples = len(samples) >> partition_order
+
+	if (partition_samples<<partition_order) != len(samples) || partition_samples < order {
+		ctx.error_message = "invalid FLAC residual partition order!"
+
+		return FALSE
+	}
+
+	for partition := 0; partition < (1 << partition_order); partition++ {
+		var count int = partition_samples
+		var param uint = read_bits(ctx, param_bits)
+
+		if partition == 0 {
+			count -= order
+		}
+
+		if param == escape_code {
+			var nbits uint = read_bits(ctx, 5)
+
+			for i := 0; i < count; i++ {
+				samples[idx] = read_signed_bits(ctx, nbits)
+				idx++
+			}
+		} else {
+			for i := 0; i < count; i++ {
+				samples[idx] = read_rice(ctx, param)
+				idx++
+			}
+		}
+
+		if ctx.eof == TRUE {
+			ctx.error_message = "FLAC file is truncated!"
+
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Undo one of the fixed polynomial predictors (orders 0 - 4).
+func restore_fixed(samples []int, order int) {
+	switch order {
+	case 1:
+		for i := 1; i < len(samples); i++ {
+			samples[i] += samples[i-1]
+		}
+
+	case 2:
+		for i := 2; i < len(samples); i++ {
+			samples[i] += 2*samples[i-1] - samples[i-2]
+		}
+
+	case 3:
+		for i := 3; i < len(samples); i++ {
+			samples[i] += 3*samples[i-1] - 3*samples[i-2] + samples[i-3]
+		}
+
+	case 4:
+		for i := 4; i < len(samples); i++ {
+			samples[i] += 4*samples[i-1] - 6*samples[i-2] + 4*samples[i-3] - samples[i-4]
+		}
+	}
+}
+
+// Undo the linear predictor with the given quantized coefficients.
+func restore_lpc(samples []int, order int, coefs []int, shift uint) {
+	for i := order; i < len(samples); i++ {
+		var sum int = 0
+
+		for j := 0; j < order; j++ {
+			sum += coefs[j] * samples[i-1-j]
+		}
+
+		samples[i] += sum >> shift
+	}
+}
+
+// Convert the stereo decorrelated channels back to left and right.
+func decorrelate_channels(ctx *FlacContext, channel_assignment int) {
+	var left []int
+	var right []int
+
+	if channel_assignment < FLAC_MAX_CHANNELS {
+		return
+	}
+
+	left = ctx.samples[0][0:ctx.block_size]
+	right = ctx.samples[1][0:ctx.block_size]
+
+	switch channel_assignment {
+	case FLAC_LEFT_SIDE:
+		for i := range left {
+			right[i] = left[i] - right[i]
+		}
+
+	case FLAC_SIDE_RIGHT:
+		for i := range left {
+			left[i] += right[i]
+		}
+
+	case FLAC_MID_SIDE:
+		for i := range left {
+			var mid int = (left[i] << 1) | (right[i] & 1)
+			var side int = right[i]
+
+			left[i] = (mid + side) >> 1
+			right[i] = (mid - side) >> 1
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package wvencode
+
+/*
+** ApeTagItem.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+type ApeTagItem struct {
+	item  string
+	value string
+}
@@ -11,11 +11,16 @@ package wvencode
 **
  */
 
+import (
+	"encoding/binary"
+)
+
 ////////////////////////// Bitstream functions ////////////////////////////////
-// Open the specified BitStream using the specified buffer pointers. It is
-// assumed that enough buffer space has been allocated for all data that will
-// be written, otherwise an error will be generated.
-func bs_open_write(bs *Bitstream, buffer_start int, buffer_end int) {
+// Open the specified BitStream to write into "buffer" between the specified
+// indexes. It is assumed that enough buffer space has been allocated for all
+// data that will be written, otherwise an error will be generated.
+func bs_open_write(bs *Bitstream, buffer []byte, buffer_start int, buffer_end int) {
+	bs.buf = buffer
 	bs.error = 0
 	bs.sr = 0
 	bs.bc = 0
@@ -25,8 +30,58 @@ func bs_open_write(bs *Bitstream, buffer_start int, buffer_end int) {
 	bs.active = 1 // indicates that the bitstream is being used
 }
 
-// This function is only called from the putbit() and putbits() when
-// the buffer is full, which is now flagged as an error.
+// Append the low "nbits" bits of "value" to the bitstream, first bit first.
+// The bits are collected in the 64-bit accumulator, which is only written
+// out (as whole bytes) when it can't hold them, so "nbits" can be up to 56.
+func bs_putbits(bs *Bitstream, value uint, nbits uint) {
+	if bs.bc+nbits > 64 {
+		bs_flush_bytes(bs)
+	}
+
+	bs.sr |= uint64(value) << bs.bc
+	bs.bc += nbits
+}
+
+// Append "count" one bits to the bitstream (for the unary codes).
+func bs_putones(bs *Bitstream, count uint) {
+	for count > 32 {
+		bs_putbits(bs, 0xffffffff, 32)
+		count -= 32
+	}
+
+	bs_putbits(bs, (uint(1)<<count)-1, count)
+}
+
+// Write the whole bytes held in the accumulator to the buffer, leaving fewer
+// than 8 bits. This is normally done with a single 8-byte store, but near the
+// end of the buffer the bytes are written one at a time so that running out
+// of room is detected (and flagged as an error by bs_wrap()) just as before.
+func bs_flush_bytes(bs *Bitstream) {
+	var bytes int = int(bs.bc >> 3)
+
+	if bs.buf_index+8 < bs.end {
+		binary.LittleEndian.PutUint64(bs.buf[bs.buf_index:], bs.sr)
+		bs.buf_index += bytes
+		bs.sr >>= uint(bytes * 8)
+		bs.bc -= uint(bytes * 8)
+
+		return
+	}
+
+	for bs.bc >= 8 {
+		bs.buf[bs.buf_index] = byte(bs.sr)
+		bs.buf_index++
+		bs.sr >>= 8
+		bs.bc -= 8
+
+		if bs.buf_index >= bs.end {
+			bs_wrap(bs) // error
+		}
+	}
+}
+
+// This function is only called when the buffer is full, which is now flagged
+// as an error.
 func bs_wrap(bs *Bitstream) {
 	bs.buf_index = bs.start_index
 	bs.error = 1
@@ -34,51 +89,86 @@ func bs_wrap(bs *Bitstream) {
 
 // This function calculates the approximate number of bytes remaining in the
 // bitstream buffer and can be used as an early-warning of an impending overflow.
-func bs_remain_write(bs Bitstream) int {
+// The whole bytes still in the accumulator count as written.
+func bs_remain_write(bs *Bitstream) int {
 
 	if bs.error > 0 {
 		return (-1)
 	}
 
-	return bs.end - bs.buf_index
+	return bs.end - bs.buf_index - int(bs.bc>>3)
 }
 
-// This function forces a flushing write of the standard BitStream, and
-// returns the total number of bytes written into the buffer.
-func bs_close_write(wps *WavpackStream) int {
-	var bs Bitstream = wps.wvbits
-	var bytes_written int = 0
+// This function forces a flushing write of the specified BitStream, which is
+// padded with ones to a whole number of 16-bit words, and returns the total
+// number of bytes written into the buffer.
+func bs_close_write(bs *Bitstream) int {
+	var pad uint = (8 - (bs.bc & 7)) & 7
 
 	if bs.error != 0 {
 		return -1
 	}
 
-	for (bs.bc != 0) || (((bs.buf_index - bs.start_index) & 1) != 0) {
-		putbit_1(wps)
-		bs = wps.wvbits // as putbit_1 makes changes
+	bs_putones(bs, pad)
+
+	if ((bs.buf_index - bs.start_index + int(bs.bc>>3)) & 1) != 0 {
+		bs_putones(bs, 8)
 	}
 
-	bytes_written = bs.buf_index - bs.start_index
+	bs_flush_bytes(bs)
 
-	return bytes_written
+	return bs.buf_index - bs.start_index
 }
 
-// This function forces a flushing write of the correction BitStream, and
-// returns the total number of bytes written into the buffer.
-func bs_close_correction_write(wps *WavpackStream) int {
-	var bs Bitstream = wps.wvcbits
-	var bytes_written int = 0
+// Open the specified BitStream for reading the bits in "buffer" between the
+// specified indexes.
+func bs_open_read(bs *Bitstream, buffer []byte, buffer_start int, buffer_end int) {
+	bs_open_write(bs, buffer, buffer_start, buffer_end)
+}
 
-	if bs.error != 0 {
-		return -1
+// Read a single bit from the BitStream. Reading past the end of the buffer
+// returns zero bits and flags an error.
+func getbit(bs *Bitstream) uint {
+	var bit uint
+
+	if bs.bc == 0 {
+		if bs.buf_index >= bs.end {
+			bs.error = 1
+			bs.sr = 0
+		} else {
+			bs.sr = uint64(bs.buf[bs.buf_index])
+			bs.buf_index++
+		}
+
+		bs.bc = 8
 	}
 
-	for (bs.bc != 0) || (((bs.buf_index - bs.start_index) & 1) != 0) {
-		putbit_correction_1(wps)
-		bs = wps.wvcbits // as putbit_correction_1 makes changes
+	bit = uint(bs.sr & 1)
+	bs.sr >>= 1
+	bs.bc--
+
+	return bit
+}
+
+// Read "nbits" bits (up to 32) from the BitStream. The value is NOT masked,
+// so the caller must mask off any unwanted upper bits.
+func getbits(nbits uint, bs *Bitstream) uint {
+	var value uint
+
+	for nbits > bs.bc {
+		if bs.buf_index >= bs.end {
+			bs.error = 1
+		} else {
+			bs.sr |= uint64(bs.buf[bs.buf_index]) << bs.bc
+			bs.buf_index++
+		}
+
+		bs.bc += 8
 	}
 
-	bytes_written = bs.buf_index - bs.start_index
+	value = uint(bs.sr)
+	bs.sr >>= nbits
+	bs.bc -= nbits
 
-	return bytes_written
+	return value
 }
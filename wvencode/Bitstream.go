@@ -12,8 +12,9 @@ package wvencode
  */
 
 type Bitstream struct {
-	end         int // was uchar in c
-	sr          uint
+	buf         []byte // the buffer being written, from bs_open_write()
+	end         int    // was uchar in c
+	sr          uint64 // accumulator for the bits not yet written to buf
 	error       int
 	bc          uint
 	buf_index   int
@@ -0,0 +1,43 @@
+package wvencode
+
+/*
+** BlockReader.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+)
+
+// A metadata item (sub-block) of a WavPack block. The ID_LARGE and
+// ID_ODD_SIZE bits are removed from the id (ID_OPTIONAL_DATA is kept) and
+// the size is the actual number of bytes of data.
+type WavpackSubBlock struct {
+	Id     int
+	Size   int
+	Data   []byte // refers into the Data of the block
+	Offset int    // position of the sub-block (its id byte) in the block
+}
+
+type WavpackBlock struct {
+	Header        WavpackHeader
+	Data          []byte // the whole block, starting with the header
+	Offset        int64  // position of the block in the stream
+	Bytes_skipped int    // garbage skipped between the previous block and this one
+	Sub_blocks    []WavpackSubBlock
+}
+
+type BlockReader struct {
+	infile         io.Reader
+	pending        []byte // bytes read from infile but not yet returned
+	position       int64  // position in the stream of pending[0]
+	eof            int
+	trailing_bytes int64 // bytes after the last block
+	error_message  string
+}
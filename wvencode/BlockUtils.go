@@ -0,0 +1,166 @@
+package wvencode
+
+/*
+** BlockUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+)
+
+// This reads the WavPack blocks of a stream one at a time, as written by
+// pack_start_block() and pack_finish_block(), for code that has to look at
+// the blocks themselves rather than the audio. Each block comes with its
+// parsed header and metadata items. Anything between the blocks that isn't
+// a valid block (including a block whose metadata items don't exactly fill
+// it) is skipped, with the reader resyncing on the next "wvpk".
+
+// Return the last error reported by the block reader.
+func WavpackGetBlockReaderError(br *BlockReader) string {
+	return br.error_message
+}
+
+// Returns the number of bytes after the last block, once WavpackReadBlock()
+// has returned FALSE. This is normally an APEv2 or ID3v1 tag, if anything.
+func WavpackGetTrailingBytes(br *BlockReader) int64 {
+	return br.trailing_bytes
+}
+
+// Start reading blocks from "infile", which should be positioned at the
+// start of the WavPack stream (offsets of blocks are relative to this).
+func WavpackOpenBlockReader(br *BlockReader, infile io.Reader) {
+	br.infile = infile
+	br.pending = nil
+	br.position = 0
+	br.eof = FALSE
+	br.trailing_bytes = 0
+	br.error_message = ""
+}
+
+// Read the next block into "block". A return of FALSE indicates that there
+// are no more blocks, which is normally the end of the stream (otherwise
+// WavpackGetBlockReaderError() returns the read error).
+func WavpackReadBlock(br *BlockReader, block *WavpackBlock) int {
+	var bytes_skipped int = 0
+
+	for {
+		var skip int = 1
+
+		if fill_pending(br, WAVPACK_HEADER_SIZE) == FALSE {
+			br.trailing_bytes = int64(bytes_skipped + len(br.pending))
+
+			return FALSE
+		}
+
+		if valid_header(br.pending) == TRUE {
+			var block_size int = int(br.pending[4]) + (int(br.pending[5]) << 8) +
+				(int(br.pending[6]) << 16) + (int(br.pending[7]) << 24) + 8
+
+			if fill_pending(br, block_size) == TRUE {
+				var data []byte = make([]byte, block_size)
+
+				copy(data, br.pending)
+
+				if sub_blocks := parse_sub_blocks(data); sub_blocks != nil {
+					block.Data = data
+					read_header(data, &block.Header)
+					block.Offset = br.position
+					block.Bytes_skipped = bytes_skipped
+					block.Sub_blocks = sub_blocks
+					consume_pending(br, block_size)
+
+					return TRUE
+				}
+			}
+		}
+
+		// not a block, so skip to the next possible one
+		for (skip < len(br.pending)) && (br.pending[skip] != 'w') {
+			skip++
+		}
+
+		consume_pending(br, skip)
+		bytes_skipped += skip
+	}
+}
+
+// Parse the metadata items of the block in "blockbuff". If they don't fill
+// the block exactly then it isn't a valid block and nil is returned.
+func parse_sub_blocks(blockbuff []byte) []WavpackSubBlock {
+	var sub_blocks []WavpackSubBlock = make([]WavpackSubBlock, 0, 8)
+	var wpmd WavpackMetadata
+	var index int = WAVPACK_HEADER_SIZE
+
+	for index < len(blockbuff) {
+		var offset int = index
+
+		if index = read_metadata_buff(&wpmd, blockbuff, index); index < 0 {
+			return nil
+		}
+
+		sub_blocks = append(sub_blocks, WavpackSubBlock{wpmd.id, wpmd.byte_length, wpmd.data, offset})
+	}
+
+	return sub_blocks
+}
+
+// Check whether the 32 bytes at "buffer" could be the header of a block
+// that we can decode.
+func valid_header(buffer []byte) int {
+	if (buffer[0] == 'w') && (buffer[1] == 'v') && (buffer[2] == 'p') && (buffer[3] == 'k') &&
+		((buffer[4] & 1) == 0) && (buffer[6] < 16) && (buffer[7] == 0) && (buffer[9] == 4) &&
+		(int(buffer[8]) >= (MIN_STREAM_VERS & 0xff)) && (int(buffer[8]) <= (MAX_STREAM_VERS & 0xff)) &&
+		((int(buffer[4]) + (int(buffer[5]) << 8) + (int(buffer[6]) << 16)) >= WAVPACK_HEADER_SIZE-8) {
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Make sure that there are at least "count" bytes pending, reading more from
+// the stream if necessary. FALSE is returned if the stream ends first.
+func fill_pending(br *BlockReader, count int) int {
+	for (len(br.pending) < count) && (br.eof == FALSE) {
+		var have int = len(br.pending)
+		var want int = count - have
+
+		if want < 65536 {
+			want = 65536
+		}
+
+		if cap(br.pending) < have+want {
+			buffer := make([]byte, have, have+want)
+			copy(buffer, br.pending)
+			br.pending = buffer
+		}
+
+		n, err := br.infile.Read(br.pending[have : have+want])
+		br.pending = br.pending[0 : have+n]
+
+		if err != nil {
+			if err != io.EOF {
+				br.error_message = "can't read the WavPack stream!"
+			}
+
+			br.eof = TRUE
+		}
+	}
+
+	if len(br.pending) < count {
+		return FALSE
+	}
+
+	return TRUE
+}
+
+func consume_pending(br *BlockReader, count int) {
+	br.pending = br.pending[count:]
+	br.position += int64(count)
+}
@@ -0,0 +1,146 @@
+package wvencode
+
+/*
+** ConcealUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"io"
+)
+
+// Enable (or with "conceal" FALSE, disable) the concealment of damaged
+// blocks when unpacking. With concealment on, every block is unpacked and
+// checked before any of its samples are returned. A block that fails its
+// crc (or whose metadata is malformed) is returned as silence, except that
+// if only its correction block is damaged the lossy result is returned
+// instead. A malformed block is searched for the next "wvpk" header, so the
+// decode picks up at the next good block, and samples missing at the end
+// of the stream are returned as silence too. Each concealed region is
+// passed to "callback" (if it isn't nil) as it is found, and is also
+// returned by WavpackGetDamagedBlocks(). This should be called before any
+// samples are unpacked.
+func WavpackSetErrorConcealment(wpc *WavpackContext, conceal int, callback func(WavpackDamagedBlock)) {
+	if nil != wpc {
+		wpc.conceal_errors = conceal
+		wpc.conceal_callback = callback
+	}
+}
+
+// Record a damaged (or missing) region of the stream, and pass it on to the
+// concealment callback if there is one.
+func report_damage(wpc *WavpackContext, region WavpackDamagedBlock) {
+	wpc.damaged_blocks = append(wpc.damaged_blocks, region)
+
+	if wpc.conceal_callback != nil {
+		wpc.conceal_callback(region)
+	}
+}
+
+// Read the next block of the main stream into wps.wphdr and wps.blockbuff,
+// returning FALSE if there are no more. When errors are concealed, a block
+// that is truncated or whose metadata items don't exactly fill it is
+// dropped, and the search for the next block starts again just after its
+// "wvpk" (using the bytes already read before reading any more).
+func read_main_block(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var header []byte = make([]byte, WAVPACK_HEADER_SIZE)
+
+	if wpc.conceal_errors == FALSE {
+		return read_block(wpc, wpc.infile, &wps.wphdr, &wps.blockbuff)
+	}
+
+	for {
+		var infile io.Reader = wpc.infile
+		var resync *bytes.Reader = bytes.NewReader(wpc.resync_bytes)
+		var new_hdr WavpackHeader
+
+		if len(wpc.resync_bytes) != 0 {
+			infile = io.MultiReader(resync, wpc.infile)
+		}
+
+		if read_next_header(infile, header) < 0 {
+			wpc.resync_bytes = nil
+
+			return FALSE
+		}
+
+		read_header(header, &new_hdr)
+
+		var buffer []byte = make([]byte, new_hdr.ckSize+8)
+		copy(buffer, header)
+
+		n, _ := io.ReadFull(infile, buffer[WAVPACK_HEADER_SIZE:])
+		wpc.resync_bytes = wpc.resync_bytes[len(wpc.resync_bytes)-resync.Len():]
+
+		if (n == new_hdr.ckSize-24) && (parse_sub_blocks(buffer) != nil) {
+			wps.wphdr = new_hdr
+			wps.blockbuff = buffer
+
+			return TRUE
+		}
+
+		wpc.resync_bytes = append(append([]byte(nil), buffer[1:WAVPACK_HEADER_SIZE+n]...), wpc.resync_bytes...)
+	}
+}
+
+// Unpack the whole of the current block (which must have just been through
+// unpack_init()) into wps.unpacked and check it, replacing it with the lossy
+// result or with silence if it's damaged (and reporting the damage).
+func unpack_block(wpc *WavpackContext) {
+	var wps *WavpackStream = &wpc.stream
+	var region WavpackDamagedBlock = WavpackDamagedBlock{get_block_index(&wps.wphdr), int64(wps.wphdr.block_samples), FALSE, FALSE}
+	var num_values int = int(wps.wphdr.block_samples) * int(wpc.config.Num_channels)
+
+	if cap(wps.unpacked) < num_values {
+		wps.unpacked = make([]int, num_values)
+	}
+
+	wps.unpacked = wps.unpacked[0:num_values]
+	unpack_samples(wpc, wps.unpacked, uint(wps.wphdr.block_samples))
+
+	if (wps.mute_error == FALSE) && (check_crc_error(wpc) == 0) {
+		if (wpc.wvc_flag != 0) && ((wps.wphdr.flags & HYBRID_FLAG) != 0) && (len(wps.block2buff) == 0) {
+			region.Correction = TRUE
+			report_damage(wpc, region)
+		}
+
+		wps.block_unpacked = TRUE
+
+		return
+	}
+
+	// if there was a correction block, try again without it
+	if len(wps.block2buff) != 0 {
+		wps.block2buff = nil
+
+		if unpack_init(wpc) == FALSE {
+			wps.mute_error = TRUE
+		}
+
+		unpack_samples(wpc, wps.unpacked, uint(wps.wphdr.block_samples))
+
+		if (wps.mute_error == FALSE) && (check_crc_error(wpc) == 0) {
+			region.Correction = TRUE
+			report_damage(wpc, region)
+			wps.block_unpacked = TRUE
+
+			return
+		}
+	}
+
+	for i := range wps.unpacked {
+		wps.unpacked[i] = silent_sample(wpc)
+	}
+
+	wpc.crc_errors++
+	report_damage(wpc, region)
+	wps.block_unpacked = TRUE
+}
@@ -0,0 +1,104 @@
+package wvencode
+
+/*
+** ConcealUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"testing"
+)
+
+// With concealment a block that fails its crc is unpacked as silence, the
+// rest of the audio is unchanged and the block is reported, both to the
+// callback and by WavpackGetDamagedBlocks(). Verification finds the same
+// block.
+func TestConcealDamagedBlock(t *testing.T) {
+	var reported []WavpackDamagedBlock
+	var verify_result WavpackVerifyResult
+
+	samples, wv := pack_repair_audio(t, 100000)
+	offsets, headers := test_blocks(t, wv)
+	var damaged_start int = int(get_block_index(&headers[1]))
+	var damaged_end int = int(block_end_index(&headers[1]))
+
+	for i := offsets[1] + 100; i < offsets[1]+110; i++ {
+		wv[i] ^= 0x55
+	}
+
+	wpc := open_test_audio(t, wv, nil)
+	WavpackSetErrorConcealment(wpc, TRUE, func(damaged WavpackDamagedBlock) {
+		reported = append(reported, damaged)
+	})
+
+	unpacked := unpack_test_audio(wpc)
+	expected := append([]int(nil), samples...)
+
+	for i := damaged_start * 2; i < damaged_end*2; i++ {
+		expected[i] = 0
+	}
+
+	compare_test_audio(t, "concealed", expected, unpacked)
+
+	if (len(reported) != 1) || (reported[0].Block_index != int64(damaged_start)) ||
+		(reported[0].Block_samples != int64(damaged_end-damaged_start)) || (len(WavpackGetDamagedBlocks(wpc)) != 1) {
+		t.Errorf("damaged blocks reported as %v", reported)
+	}
+
+	wpc = open_test_audio(t, wv, nil)
+
+	if (WavpackVerifyFile(wpc, &verify_result) == TRUE) || (len(verify_result.Damaged_blocks) != 1) ||
+		(verify_result.Damaged_blocks[0].Block_index != int64(damaged_start)) {
+		t.Errorf("verification found %v", verify_result.Damaged_blocks)
+	}
+}
+
+// If only a correction block is damaged then the lossy audio of the block is
+// returned, and only the correction block is reported.
+func TestConcealDamagedCorrection(t *testing.T) {
+	var samples []int = make_test_audio(100000)
+	var config WavpackConfig
+	var verify_result WavpackVerifyResult
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Block_samples = 20000
+	config.Flags = CONFIG_HYBRID_FLAG | CONFIG_CREATE_WVC
+	config.Bitrate = 3 * 256
+
+	wv, wvc := pack_test_audio(t, config, samples)
+	lossy := unpack_test_audio(open_test_audio(t, wv, nil))
+	offsets, headers := test_blocks(t, wvc)
+	var damaged_start int = int(get_block_index(&headers[2]))
+	var damaged_end int = int(block_end_index(&headers[2]))
+
+	for i := offsets[2] + 60; i < offsets[2]+70; i++ {
+		wvc[i] ^= 0x55
+	}
+
+	wpc := open_test_audio(t, wv, wvc)
+	WavpackSetErrorConcealment(wpc, TRUE, nil)
+
+	expected := append([]int(nil), samples...)
+	copy(expected[damaged_start*2:damaged_end*2], lossy[damaged_start*2:damaged_end*2])
+	compare_test_audio(t, "concealed correction", expected, unpack_test_audio(wpc))
+
+	if damaged := WavpackGetDamagedBlocks(wpc); (len(damaged) != 1) || (damaged[0].Correction == FALSE) {
+		t.Errorf("damaged blocks reported as %v", damaged)
+	}
+
+	wpc = open_test_audio(t, wv, wvc)
+
+	if (WavpackVerifyFile(wpc, &verify_result) == TRUE) || (len(verify_result.Damaged_blocks) != 1) ||
+		(verify_result.Damaged_blocks[0].Correction == FALSE) {
+		t.Errorf("verification found %v", verify_result.Damaged_blocks)
+	}
+}
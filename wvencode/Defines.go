@@ -11,16 +11,14 @@ package wvencode
 **
  */
 
-const BIT_BUFFER_SIZE = 65536 // This should be carefully chosen for the
-// application and platform. Larger buffers are
-// somewhat more efficient, but the code will
-// allow smaller buffers and simply terminate
-// blocks early. If the hybrid lossless mode
-// (2 file) is not needed then the wvc_buffer
-// can be made very small.
 // or-values for "flags"
 const INPUT_SAMPLES int = 65536
+const ADAPTIVE_MIN_BLOCK uint = 8            // shortest adaptive block, as a fraction of the longest
+const ADAPTIVE_SPLIT_COST int = 128          // most bytes each extra block at a transient may add
+const ADAPTIVE_TRANSIENT int = 2             // halves of a block differing in bytes by this factor are a transient
+const BLOCK_BUFFER_EXTRA int = 4096          // room in a block buffer for the metadata
 const BYTES_STORED uint = 3                  // 1-4 bytes/sample
+const CONFIG_ADAPTIVE_BLOCKS uint = 0x200000 // block lengths chosen by trial packing
 const CONFIG_AUTO_SHAPING uint = 0x4000      // automatic noise shaping
 const CONFIG_BITRATE_KBPS uint = 0x2000      // bitrate is kbps, not bits / sample
 const CONFIG_BYTES_STORED uint = 3           // 1-4 bytes/sample
@@ -30,10 +28,12 @@ const CONFIG_CREATE_WVC uint = 0x80000       // create correction file
 const CONFIG_CROSS_DECORR uint = 0x20        // no-delay cross decorrelation
 const CONFIG_EXTRA_MODE uint = 0x2000000     // extra processing mode
 const CONFIG_FAST_FLAG uint = 0x200          // fast mode
+const CONFIG_FIXED_ERROR uint = 0x400        // hybrid mode with a fixed error limit
 const CONFIG_FLOAT_DATA uint = 0x80          // ieee 32-bit floating point data
 const CONFIG_HIGH_FLAG uint = 0x800          // high quality mode
 const CONFIG_HYBRID_FLAG uint = 8            // hybrid mode
 const CONFIG_HYBRID_SHAPE uint = 0x40        // noise shape (hybrid mode only)
+const CONFIG_JOINT_AUTO uint = 0x100         // joint stereo selected for each block
 const CONFIG_JOINT_OVERRIDE uint = 0x10000   // joint-stereo mode specified
 const CONFIG_JOINT_STEREO uint = 0x10        // joint stereo
 const CONFIG_LOSSY_MODE uint = 0x1000000     // obsolete (for information)
@@ -46,12 +46,14 @@ const CONFIG_SKIP_WVX uint = 0x4000000       // no wvx stream w/ floats & big in
 const CONFIG_VERY_HIGH_FLAG uint = 0x1000    // very high
 const CROSS_DECORR uint = 0x20               // no-delay cross decorrelation
 const CUR_STREAM_VERS int = 0x405            // stream version we are writing now
+const DSD_FLAG uint = 0x80000000             // encoded DSD audio (1-bit samples packed in bytes)
+const DSD_STREAM_VERS int = 0x410            // stream version written for DSD audio
 
 // encountered
 const FALSE int = 0
 const FALSE_STEREO uint = 0x40000000 // block is stereo, but data is mono
 const FINAL_BLOCK uint = 0x1000      // final block of multichannel segment
-const FLOAT_DATA int = 0x80          // ieee 32-bit floating point data
+const FLOAT_DATA uint = 0x80         // ieee 32-bit floating point data
 const FLOAT_EXCEPTIONS int = 0x20    // contains exceptions (inf, nan, etc.)
 const FLOAT_NEG_ZEROS int = 0x10     // contains negative zeros
 const FLOAT_SHIFT_ONES int = 1       // bits left-shifted into float = '1'
@@ -63,12 +65,16 @@ const HYBRID_BALANCE uint = 0x400 // balance noise (hybrid stereo mode only)
 const HYBRID_BITRATE uint = 0x200 // bitrate noise (hybrid mode only)
 const HYBRID_FLAG uint = 8        // hybrid mode
 const HYBRID_SHAPE uint = 0x40    // noise shape (hybrid mode only)
+const ID_ALT_EXTENSION int = 0x28
+const ID_ALT_HEADER int = 0x23
+const ID_ALT_TRAILER int = 0x24
 const ID_CHANNEL_INFO uint = 0xd
 const ID_CONFIG_BLOCK int = 0x25
 const ID_CUESHEET uint = 0x24
 const ID_DECORR_SAMPLES int = 0x4
 const ID_DECORR_TERMS int = 0x2
 const ID_DECORR_WEIGHTS int = 0x3
+const ID_DSD_BLOCK int = 0xe
 const ID_DUMMY uint = 0x0
 const ID_ENCODER_INFO uint = 0x1
 const ID_ENTROPY_VARS int = 0x5
@@ -77,6 +83,7 @@ const ID_HYBRID_PROFILE int = 0x6
 const ID_INT32_INFO uint = 0x9
 const ID_LARGE int = 0x80
 const ID_MD5_CHECKSUM uint = 0x26
+const ID_NEW_CONFIG_BLOCK int = 0x2a
 const ID_ODD_SIZE int = 0x40
 const ID_OPTIONAL_DATA uint = 0x20
 const ID_REPLAY_GAIN uint = 0x23
@@ -92,21 +99,34 @@ const INITIAL_BLOCK uint = 0x800     // initial block of multichannel segment
 const INT32_DATA int = 0x100         // special extended int handling
 const JOINT_STEREO uint = 0x10       // joint stereo
 const MAG_LSB uint = 18
+const MAX_BLOCK_BITSTREAM int = 1 << 24 // bitstream bytes in a block (the limit is 2^25)
 const MAX_NTERMS int = 16
-const MAX_STREAM_VERS int = 0x410 // highest stream version we'll decode
+const MAX_WAVPACK_SAMPLES int64 = (1 << 40) - 256 // total samples must fit in 40 bits
+const MAX_STREAM_VERS int = 0x410                 // highest stream version we'll decode
+const MAX_TARGET_BITS int64 = 24 << 8             // highest bitrate (bits/sample * 256) for a target size
 const MAX_TERM = 8
 
 const MIN_STREAM_VERS int = 0x402 // lowest stream version we'll decode
+const MODE_EXTRA int = 0x80
 const MODE_FAST int = 0x40
 const MODE_FLOAT int = 0x8
 const MODE_HIGH int = 0x20
 const MODE_HYBRID int = 0x4
 const MODE_LOSSLESS int = 0x2
+const MODE_MD5 int = 0x800
 const MODE_VALID_TAG int = 0x10
+const MODE_VERY_HIGH int = 0x400
 const MODE_WVC int = 0x1
 const MONO_FLAG uint = 4            // not stereo
 const NEW_SHAPING uint = 0x20000000 // use IIR filter for negative shaping
 const NO_ERROR int = 0
+const QMODE_BIG_ENDIAN int = 0x1     // big-endian data format (opposite of WAV format)
+const QMODE_SIGNED_BYTES int = 0x2   // 8-bit audio data is signed (opposite of WAV format)
+const QMODE_UNSIGNED_WORDS int = 0x4 // audio data (other than 8-bit) is unsigned (opposite of WAV format)
+const QMODE_DSD_LSB_FIRST int = 0x10 // DSD bytes, LSB first (most Sony .dsf files)
+const QMODE_DSD_MSB_FIRST int = 0x20 // DSD bytes, MSB first (Philips .dff files)
+const QMODE_DSD_IN_BLOCKS int = 0x40 // DSD data is in blocks of 4096 bytes per channel, not interleaved
+const QMODE_DSD_AUDIO int = 0x30     // if either of the above is set then the data is DSD
 
 // Change the following value to an even number to reflect the maximum number of samples to be processed
 // per call to WavPackUtils.WavpackUnpackSamples
@@ -115,8 +135,13 @@ const SHIFT_LSB uint = 13
 const SOFT_ERROR int = 1
 const SRATE_LSB uint = 23
 const TRUE int = 1
-const UNKNOWN_FLAGS uint = 0x80000000 // also reserved, but refuse decode if
+const UNKNOWN_FLAGS uint = 0x00000000 // no more spares, but refuse decode if
 const WAVPACK_HEADER_SIZE int = 32
+const WP_FORMAT_AIF int = 5 // Apple AIFF (or AIFF-C) file
+const WP_FORMAT_CAF int = 2 // Apple Core Audio file
+const WP_FORMAT_DFF int = 3 // Philips DSDIFF file
+const WP_FORMAT_DSF int = 4 // Sony DSD stream file
+const WP_FORMAT_WAV int = 0 // Microsoft RIFF, including BWF and RF64 variants
 const SRATE_MASK uint = (0xf << SRATE_LSB)
 const SHIFT_MASK uint = (0x1f << SHIFT_LSB)
 const MAG_MASK uint = (0x1f << MAG_LSB)
@@ -0,0 +1,28 @@
+package wvencode
+
+/*
+** DsdData.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+type DsdData struct {
+	data                 []byte // coded data of the DSD block being unpacked
+	index                int    // position of the next byte in data
+	mode                 byte
+	ready                int
+	history_bins         int
+	p0                   int
+	p1                   int
+	probabilities        [][256]byte
+	summed_probabilities [][256]int
+	lookup               [][]byte
+	low                  uint32
+	high                 uint32
+	value                uint32
+}
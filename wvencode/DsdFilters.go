@@ -0,0 +1,24 @@
+package wvencode
+
+/*
+** DsdFilters.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+type DsdFilters struct {
+	filter0 int32
+	filter1 int32
+	filter2 int32
+	filter3 int32
+	filter4 int32
+	filter5 int32
+	filter6 int32
+	factor  int32
+	value   int32
+}
@@ -0,0 +1,551 @@
+package wvencode
+
+/*
+** DsdUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+// DSD audio is packed as bytes of 8 DSD bits (MSB first) and each block is
+// coded in one of two modes, both of which use a range coder. In "fast" mode
+// whole bytes are coded with probabilities taken from histograms of the block
+// (which are sent with it), where the context is the previous byte of the
+// same channel. In "high" mode each bit is coded with a probability that is
+// looked up (and adapted) from the output of a filter that follows the audio
+// that the bits represent. If neither helps then the bytes are just stored.
+
+const DSD_MODE_RAW byte = 0
+const DSD_MODE_FAST byte = 1
+const DSD_MODE_HIGH byte = 3
+
+const MAX_HISTORY_BITS uint = 5
+const MAX_PROBABILITY int = 0xa0   // set to 0xff to disable RLE encoding of the probability tables
+const MAX_BYTES_PER_BIN int = 1280 // decoders refuse larger probability tables
+
+const PTABLE_BITS uint = 8
+const PTABLE_BINS int = (1 << PTABLE_BITS)
+const PTABLE_MASK int32 = int32(PTABLE_BINS - 1)
+
+const UP int32 = 0x010000fe
+const DOWN int32 = 0x00010000
+const DECAY uint = 8
+
+const PRECISION uint = 20
+const VALUE_ONE int32 = (1 << PRECISION)
+const PRECISION_USE uint = 12
+
+const RATE_S int = 20
+const INITIAL_TERM int = (1536 / PTABLE_BINS)
+
+// Pack one block of DSD audio from the samples accumulated by
+// buffer_samples(). The block is coded in "high" mode, or in "fast" mode if
+// CONFIG_FAST_FLAG is set, into a single ID_DSD_BLOCK metadata item that
+// starts with the power of two of the DSD rate multiplier and the mode. If
+// both channels are identical then only one is coded (FALSE_STEREO) and the
+// header crc covers just the bytes that are coded. A return of FALSE
+// indicates an error.
+func pack_dsd_block(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var num_channels uint = wpc.config.Num_channels
+	var sample_count uint = uint(len(wpc.block_buffer)) / num_channels
+	var flags uint = wps.wphdr.flags
+	var crc uint = 0xffffffff
+	var dsd_power byte = 0
+	var mode byte
+	var num_values int
+	var values []byte
+	var encoded []byte
+	var wpmd WavpackMetadata
+	var chunkSize int
+	var copyRetVal int
+
+	if sample_count > wpc.block_samples {
+		sample_count = wpc.block_samples
+	}
+
+	num_values = int(sample_count * num_channels)
+	values = make([]byte, num_values)
+
+	for i := 0; i < num_values; i++ {
+		values[i] = byte(wpc.block_buffer[i])
+	}
+
+	wpc.block_buffer = wpc.block_buffer[num_values:]
+
+	// if the two channels are identical then only one is coded
+	if num_channels == 2 {
+		flags |= FALSE_STEREO
+
+		for i := 0; i < num_values; i += 2 {
+			if values[i] != values[i+1] {
+				flags &= ^FALSE_STEREO
+				break
+			}
+		}
+
+		if (flags & FALSE_STEREO) != 0 {
+			for i := 0; i < int(sample_count); i++ {
+				values[i] = values[i*2]
+			}
+
+			num_values = int(sample_count)
+			values = values[0:num_values]
+		}
+	}
+
+	for (uint(1) << dsd_power) < wpc.dsd_multiplier {
+		dsd_power++
+	}
+
+	wps.wphdr.flags = flags
+	set_block_index(&wps.wphdr, wps.sample_index)
+
+	if pack_start_block(wpc) == FALSE {
+		wpc.error_message = "output buffer overflowed!"
+
+		return FALSE
+	}
+
+	if (wpc.config.Flags & CONFIG_FAST_FLAG) != 0 {
+		mode = DSD_MODE_FAST
+		encoded = encode_buffer_fast(values, (flags&(MONO_FLAG|FALSE_STEREO)) == 0)
+	} else {
+		mode = DSD_MODE_HIGH
+		encoded = encode_buffer_high(wps, values, (flags&(MONO_FLAG|FALSE_STEREO)) == 0)
+	}
+
+	if encoded == nil {
+		mode = DSD_MODE_RAW
+		encoded = values
+	}
+
+	// the extra byte allocated is for the padding that copy_metadata() adds
+	// to odd lengths
+	wpmd.data = make([]byte, len(encoded)+3)
+	wpmd.data[0] = dsd_power
+	wpmd.data[1] = mode
+	copy(wpmd.data[2:], encoded)
+	wpmd.byte_length = len(encoded) + 2
+	wpmd.id = ID_DSD_BLOCK
+
+	chunkSize = (int(wps.blockbuff[4]) & 0xff) + ((int(wps.blockbuff[5]) & 0xff) << 8) +
+		((int(wps.blockbuff[6]) & 0xff) << 16) + ((int(wps.blockbuff[7]) & 0xff) << 24)
+
+	if (chunkSize + wpmd.byte_length + 16) > len(wps.blockbuff) {
+		wps.blockbuff = append(wps.blockbuff, make([]byte, chunkSize+wpmd.byte_length+16-len(wps.blockbuff))...)
+	}
+
+	copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, len(wps.blockbuff))
+
+	if copyRetVal == FALSE {
+		wpc.error_message = "output buffer overflowed!"
+
+		return FALSE
+	}
+
+	for i := 0; i < num_values; i++ {
+		crc += (crc << 1) + uint(values[i])
+	}
+
+	wps.wphdr.block_samples = int(sample_count)
+	wps.wphdr.crc = crc & 0xffffffff
+
+	wps.blockbuff[20] = byte(sample_count)
+	wps.blockbuff[21] = byte(sample_count >> 8)
+	wps.blockbuff[22] = byte(sample_count >> 16)
+	wps.blockbuff[23] = byte(sample_count >> 24)
+	wps.blockbuff[28] = byte(crc)
+	wps.blockbuff[29] = byte(crc >> 8)
+	wps.blockbuff[30] = byte(crc >> 16)
+	wps.blockbuff[31] = byte(crc >> 24)
+
+	wps.sample_index += int64(sample_count)
+
+	return write_block(wpc)
+}
+
+// Encode the DSD bytes in "values" in "fast" mode. The context of each byte
+// is the previous byte of the same channel masked to "history_bits" bits,
+// and there is a histogram of the block for each context from which the
+// probabilities are calculated. These are sent first (run-length coded)
+// followed by the range coded bytes. The result is nil if the block is too
+// short to be worth it or wouldn't be any smaller than the raw bytes.
+func encode_buffer_fast(values []byte, stereo bool) []byte {
+	var num_values int = len(values)
+	var history_bits uint
+	var history_bins int
+	var history_mask int
+	var total_summed_probabilities int = 0
+	var histogram [][256]int
+	var probabilities [][256]byte
+	var summed_probabilities [][256]int
+	var low uint32 = 0
+	var high uint32 = 0xffffffff
+	var p0 int = 0
+	var p1 int = 0
+	var out []byte
+
+	if num_values < 280 {
+		return nil
+	} else if num_values < 560 {
+		history_bits = 0
+	} else if num_values < 1725 {
+		history_bits = 1
+	} else if num_values < 5000 {
+		history_bits = 2
+	} else if num_values < 14000 {
+		history_bits = 3
+	} else if num_values < 28000 {
+		history_bits = 4
+	} else {
+		history_bits = MAX_HISTORY_BITS
+	}
+
+	history_bins = 1 << history_bits
+	history_mask = history_bins - 1
+
+	histogram = make([][256]int, history_bins)
+	probabilities = make([][256]byte, history_bins)
+	summed_probabilities = make([][256]int, history_bins)
+
+	for i := 0; i < num_values; i++ {
+		histogram[p0][values[i]]++
+
+		if stereo {
+			p0 = p1
+			p1 = int(values[i]) & history_mask
+		} else {
+			p0 = int(values[i]) & history_mask
+		}
+	}
+
+	for p := 0; p < history_bins; p++ {
+		calculate_probabilities(histogram[p][:], probabilities[p][:], summed_probabilities[p][:])
+		total_summed_probabilities += summed_probabilities[p][255]
+	}
+
+	// decoders build lookup tables as large as the sums of the
+	// probabilities, so if these are too big then the largest bins are
+	// reduced (which keeps all the non-zero probabilities non-zero)
+	for total_summed_probabilities > history_bins*MAX_BYTES_PER_BIN {
+		var max_sum int = 0
+		var largest_bin int = 0
+		var sum_values int = 0
+
+		for p := 0; p < history_bins; p++ {
+			if summed_probabilities[p][255] > max_sum {
+				max_sum = summed_probabilities[p][255]
+				largest_bin = p
+			}
+		}
+
+		total_summed_probabilities -= max_sum
+
+		for i := 0; i < 256; i++ {
+			probabilities[largest_bin][i] = byte((int(probabilities[largest_bin][i]) + 1) >> 1)
+			sum_values += int(probabilities[largest_bin][i])
+			summed_probabilities[largest_bin][i] = sum_values
+		}
+
+		total_summed_probabilities += sum_values
+	}
+
+	out = make([]byte, 0, num_values+16)
+	out = append(out, byte(history_bits), byte(MAX_PROBABILITY))
+
+	// probabilities are 1 to MAX_PROBABILITY, so values above that are runs
+	// of zeros and the table is terminated with a zero
+	for i := 0; i < history_bins*256; {
+		if probabilities[i>>8][i&0xff] != 0 {
+			out = append(out, probabilities[i>>8][i&0xff])
+			i++
+		} else {
+			var zcount int = 0
+
+			for (i < history_bins*256) && (probabilities[i>>8][i&0xff] == 0) && (zcount < 255-MAX_PROBABILITY) {
+				zcount++
+				i++
+			}
+
+			out = append(out, byte(zcount+MAX_PROBABILITY))
+		}
+	}
+
+	out = append(out, 0)
+	p0 = 0
+	p1 = 0
+
+	for i := 0; i < num_values; i++ {
+		var code int = int(values[i])
+		var mult uint32 = (high - low) / uint32(summed_probabilities[p0][255])
+
+		// if the range has become too small to code with, flush it out
+		// completely and start again (as the decoder will)
+		if mult == 0 {
+			high = low
+
+			for (high >> 24) == (low >> 24) {
+				out = append(out, byte(high>>24))
+				high = (high << 8) | 0xff
+				low <<= 8
+			}
+
+			mult = (high - low) / uint32(summed_probabilities[p0][255])
+		}
+
+		if code != 0 {
+			low += uint32(summed_probabilities[p0][code-1]) * mult
+		}
+
+		high = low + uint32(probabilities[p0][code])*mult - 1
+
+		if stereo {
+			p0 = p1
+			p1 = code & history_mask
+		} else {
+			p0 = code & history_mask
+		}
+
+		for (high >> 24) == (low >> 24) {
+			out = append(out, byte(high>>24))
+			high = (high << 8) | 0xff
+			low <<= 8
+		}
+
+		if len(out) >= num_values {
+			return nil
+		}
+	}
+
+	high = low
+
+	for (high >> 24) == (low >> 24) {
+		out = append(out, byte(high>>24))
+		high = (high << 8) | 0xff
+		low <<= 8
+	}
+
+	if len(out) >= num_values {
+		return nil
+	}
+
+	return out
+}
+
+// Convert the histogram of a context into probabilities, which are scaled
+// down (if required) to be no more than MAX_PROBABILITY, with every byte
+// value that occurs at all keeping a probability of at least 1. The running
+// sums of the probabilities are also calculated.
+func calculate_probabilities(hist []int, probs []byte, prob_sums []int) {
+	var max_hits int = 0
+	var divisor int = 0
+
+	for i := 0; i < 256; i++ {
+		if hist[i] > max_hits {
+			max_hits = hist[i]
+		}
+	}
+
+	if max_hits > MAX_PROBABILITY {
+		divisor = ((max_hits << 8) + (MAX_PROBABILITY >> 1)) / MAX_PROBABILITY
+	}
+
+	for {
+		var max_value int = 0
+		var sum_values int = 0
+
+		for i := 0; i < 256; i++ {
+			var value int = hist[i]
+
+			if (value != 0) && (divisor != 0) {
+				if value = ((hist[i] << 8) + (divisor >> 1)) / divisor; value == 0 {
+					value = 1
+				}
+			}
+
+			if value > max_value {
+				max_value = value
+			}
+
+			sum_values += value
+			probs[i] = byte(value)
+			prob_sums[i] = sum_values
+		}
+
+		// rounding can leave the largest value just over the limit
+		if max_value <= MAX_PROBABILITY {
+			break
+		}
+
+		divisor++
+	}
+}
+
+// Encode the DSD bytes in "values" in "high" mode. Each channel has a set
+// of filters (kept from block to block) and the bits are coded with a
+// probability from the table entry selected by the filter output, which is
+// then adapted toward the bit that was coded. The filter states are sent at
+// reduced precision at the start of the block (with the encoder continuing
+// from the same reduced values) so that each block can be decoded on its
+// own. The result is nil if it wouldn't be any smaller than the raw bytes.
+func encode_buffer_high(wps *WavpackStream, values []byte, stereo bool) []byte {
+	var num_values int = len(values)
+	var num_channels int = 1
+	var low uint32 = 0
+	var high uint32 = 0xffffffff
+	var channel int = 0
+	var out []byte
+
+	if stereo {
+		num_channels = 2
+	}
+
+	if wps.sample_index == 0 {
+		for i := 0; i < 2; i++ {
+			var sp *DsdFilters = &wps.dsd_filters[i]
+
+			sp.filter1 = VALUE_ONE / 2
+			sp.filter2 = VALUE_ONE / 2
+			sp.filter3 = VALUE_ONE / 2
+			sp.filter4 = VALUE_ONE / 2
+			sp.filter5 = VALUE_ONE / 2
+			sp.filter6 = 0
+			sp.factor = 0
+		}
+	}
+
+	if len(wps.dsd_ptable) != PTABLE_BINS {
+		wps.dsd_ptable = make([]int32, PTABLE_BINS)
+	}
+
+	init_ptable(wps.dsd_ptable, INITIAL_TERM, RATE_S)
+
+	out = make([]byte, 0, num_values+16)
+	out = append(out, byte(INITIAL_TERM), byte(RATE_S))
+
+	for i := 0; i < num_channels; i++ {
+		var sp *DsdFilters = &wps.dsd_filters[i]
+
+		out = append(out, dsd_filter_byte(&sp.filter1), dsd_filter_byte(&sp.filter2),
+			dsd_filter_byte(&sp.filter3), dsd_filter_byte(&sp.filter4), dsd_filter_byte(&sp.filter5))
+
+		if sp.factor > 32767 {
+			sp.factor = 32767
+		} else if sp.factor < -32768 {
+			sp.factor = -32768
+		}
+
+		out = append(out, byte(sp.factor), byte(sp.factor>>8))
+		sp.filter6 = 0
+	}
+
+	for i := 0; i < num_values; i++ {
+		var sp *DsdFilters = &wps.dsd_filters[channel]
+		var code int32 = int32(values[i])
+
+		sp.value = sp.filter1 - sp.filter5 + ((sp.filter6 * sp.factor) >> 2)
+
+		for bitcount := uint(8); bitcount > 0; bitcount-- {
+			var pp *int32 = &wps.dsd_ptable[(sp.value>>(PRECISION-PRECISION_USE))&PTABLE_MASK]
+			var split uint32 = low + ((high-low)>>8)*uint32(*pp>>16)
+
+			if ((code >> (bitcount - 1)) & 1) != 0 {
+				high = split
+				*pp += (UP - *pp) >> DECAY
+				sp.filter0 = -1
+			} else {
+				low = split + 1
+				*pp += (DOWN - *pp) >> DECAY
+				sp.filter0 = 0
+			}
+
+			for (high >> 24) == (low >> 24) {
+				out = append(out, byte(high>>24))
+				high = (high << 8) | 0xff
+				low <<= 8
+			}
+
+			sp.value += sp.filter6 * 8
+			sp.factor += (((sp.value ^ sp.filter0) >> 31) | 1) & ((sp.value ^ (sp.value - (sp.filter6 * 16))) >> 31)
+			sp.filter1 += ((sp.filter0 & VALUE_ONE) - sp.filter1) >> 6
+			sp.filter2 += ((sp.filter0 & VALUE_ONE) - sp.filter2) >> 4
+			sp.filter3 += (sp.filter2 - sp.filter3) >> 4
+			sp.filter4 += (sp.filter3 - sp.filter4) >> 4
+			sp.value = (sp.filter4 - sp.filter5) >> 4
+			sp.filter5 += sp.value
+			sp.filter6 += (sp.value - sp.filter6) >> 3
+			sp.value = sp.filter1 - sp.filter5 + ((sp.filter6 * sp.factor) >> 2)
+		}
+
+		sp.factor -= (sp.factor + 512) >> 10
+
+		if stereo {
+			channel ^= 1
+		}
+
+		if len(out) >= num_values {
+			return nil
+		}
+	}
+
+	high = low
+
+	for (high >> 24) == (low >> 24) {
+		out = append(out, byte(high>>24))
+		high = (high << 8) | 0xff
+		low <<= 8
+	}
+
+	if len(out) >= num_values {
+		return nil
+	}
+
+	return out
+}
+
+// Reduce a filter value to the 8 bits it is sent with and continue from the
+// value that the decoder will have.
+func dsd_filter_byte(filter *int32) byte {
+	var value int32 = *filter >> (PRECISION - 8)
+
+	if value > 255 {
+		value = 255
+	} else if value < 0 {
+		value = 0
+	}
+
+	*filter = value << (PRECISION - 8)
+
+	return byte(value)
+}
+
+// Initialize the probability table used in "high" mode. The two halves of
+// the table mirror each other and the "rate_i" and "rate_s" parameters
+// (which are sent with each block) control how quickly the probabilities
+// move away from the center of the table.
+func init_ptable(table []int32, rate_i int, rate_s int) {
+	var value int32 = 0x808000
+	var rate int = rate_i << 8
+
+	for c := (rate + 128) >> 8; c > 0; c-- {
+		value += (DOWN - value) >> DECAY
+	}
+
+	for i := 0; i < PTABLE_BINS/2; i++ {
+		table[i] = value
+		table[PTABLE_BINS-1-i] = 0x100ffff - value
+
+		if value > 0x010000 {
+			rate += (rate*rate_s + 128) >> 8
+
+			for c := (rate + 64) >> 7; c > 0; c-- {
+				value += (DOWN - value) >> DECAY
+			}
+		}
+	}
+}
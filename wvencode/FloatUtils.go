@@ -0,0 +1,332 @@
+package wvencode
+
+/*
+** FloatUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+// The 32-bit IEEE floats are passed around stored in ints, so these return
+// the fields of the float held in "f".
+func get_mantissa(f int) uint {
+	return uint(f) & 0x7fffff
+}
+
+func get_exponent(f int) int {
+	return (f >> 23) & 0xff
+}
+
+func get_sign(f int) uint {
+	return (uint(f) >> 31) & 1
+}
+
+// Allocate room for and copy the float information into the specified
+// metadata structure. This is the information required to convert the
+// packed integers back into floats and is written into every block.
+func write_float_info(wps *WavpackStream, wpmd *WavpackMetadata) {
+	var byteptr []byte
+	var byte_idx int = 0
+
+	wpmd.data = wpmd.temp_data[0:len(wpmd.temp_data)]
+	byteptr = wpmd.data
+
+	wpmd.id = int(ID_FLOAT_INFO)
+
+	byteptr[byte_idx] = byte(wps.float_flags)
+	byte_idx++
+	byteptr[byte_idx] = byte(wps.float_shift)
+	byte_idx++
+	byteptr[byte_idx] = byte(wps.float_max_exp)
+	byte_idx++
+	byteptr[byte_idx] = byte(wps.float_norm_exp)
+	byte_idx++
+
+	wpmd.byte_length = byte_idx
+	wpmd.data = byteptr
+}
+
+// Scan the provided buffer of floating-point values and convert them to
+// integers (in place) that can be packed in the regular way. All the
+// values are scaled to the largest exponent found, so the integers are at
+// most 24 bits plus sign. The float_xxx fields of the stream are set to
+// describe the conversion and the magnitude in the header flags is updated.
+// The return value is non-zero if any information was lost in the
+// conversion, meaning that the "wvx" bitstream must be sent (with
+// send_float_data()) for the data to be restored exactly.
+func scan_float_data(wps *WavpackStream, values []int, num_values int) int {
+	var shifted_ones int = 0
+	var shifted_zeros int = 0
+	var shifted_both int = 0
+	var false_zeros int = 0
+	var neg_zeros int = 0
+	var ordata uint = 0
+	var max_exp int = 0
+	var value uint
+	var shift_count int
+
+	wps.float_shift = 0
+	wps.float_flags = 0
+
+	for i := 0; i < num_values; i++ {
+		if (get_exponent(values[i]) > max_exp) && (get_exponent(values[i]) < 255) {
+			max_exp = get_exponent(values[i])
+		}
+	}
+
+	for i := 0; i < num_values; i++ {
+		var f int = values[i]
+
+		if get_exponent(f) == 255 {
+			wps.float_flags |= FLOAT_EXCEPTIONS
+			value = 0x1000000
+			shift_count = 0
+		} else if get_exponent(f) != 0 {
+			shift_count = max_exp - get_exponent(f)
+			value = 0x800000 + get_mantissa(f)
+		} else {
+			if max_exp != 0 {
+				shift_count = max_exp - 1
+			} else {
+				shift_count = 0
+			}
+
+			value = get_mantissa(f)
+		}
+
+		if shift_count < 25 {
+			value >>= uint(shift_count)
+		} else {
+			value = 0
+		}
+
+		if value == 0 {
+			if (get_exponent(f) != 0) || (get_mantissa(f) != 0) {
+				false_zeros++
+			} else if get_sign(f) != 0 {
+				neg_zeros++
+			}
+		} else if shift_count != 0 {
+			var mask uint = (1 << uint(shift_count)) - 1
+
+			if (get_mantissa(f) & mask) == 0 {
+				shifted_zeros++
+			} else if (get_mantissa(f) & mask) == mask {
+				shifted_ones++
+			} else {
+				shifted_both++
+			}
+		}
+
+		ordata |= value
+
+		if get_sign(f) != 0 {
+			values[i] = -int(value)
+		} else {
+			values[i] = int(value)
+		}
+	}
+
+	wps.float_max_exp = max_exp
+
+	if shifted_both != 0 {
+		wps.float_flags |= FLOAT_SHIFT_SENT
+	} else if (shifted_ones != 0) && (shifted_zeros == 0) {
+		wps.float_flags |= FLOAT_SHIFT_ONES
+	} else if (shifted_ones != 0) && (shifted_zeros != 0) {
+		wps.float_flags |= FLOAT_SHIFT_SAME
+	} else if (ordata != 0) && ((ordata & 1) == 0) {
+		for (ordata & 1) == 0 {
+			wps.float_shift++
+			ordata >>= 1
+		}
+
+		for i := 0; i < num_values; i++ {
+			values[i] >>= uint(wps.float_shift)
+		}
+	}
+
+	wps.wphdr.flags &= ^MAG_MASK
+
+	for ordata != 0 {
+		wps.wphdr.flags += (1 << MAG_LSB)
+		ordata >>= 1
+	}
+
+	if (false_zeros != 0) || (neg_zeros != 0) {
+		wps.float_flags |= FLOAT_ZEROS_SENT
+	}
+
+	if neg_zeros != 0 {
+		wps.float_flags |= FLOAT_NEG_ZEROS
+	}
+
+	return wps.float_flags & (FLOAT_EXCEPTIONS | FLOAT_ZEROS_SENT | FLOAT_SHIFT_SENT | FLOAT_SHIFT_SAME)
+}
+
+// Send the information lost by scan_float_data() for the first "num_values"
+// of the original floats to the "wvx" bitstream. The crc of the floats is
+// also calculated here because it must cover exactly the values that were
+// packed into the block, and this is stored ahead of the wvx data.
+func send_float_data(wps *WavpackStream, values []int, num_values int) {
+	var max_exp int = wps.float_max_exp
+	var crc uint = 0xffffffff
+	var value uint
+	var shift_count int
+
+	for i := 0; i < num_values; i++ {
+		var f int = values[i]
+
+		crc = uint(uint32((crc * 27) + (get_mantissa(f) * 9) + (uint(get_exponent(f)) * 3) + get_sign(f)))
+
+		if get_exponent(f) == 255 {
+			if get_mantissa(f) != 0 {
+				bs_putbits(&wps.wvxbits, 1, 1)
+				bs_putbits(&wps.wvxbits, get_mantissa(f), 23)
+			} else {
+				bs_putbits(&wps.wvxbits, 0, 1)
+			}
+
+			value = 0x1000000
+			shift_count = 0
+		} else if get_exponent(f) != 0 {
+			shift_count = max_exp - get_exponent(f)
+			value = 0x800000 + get_mantissa(f)
+		} else {
+			if max_exp != 0 {
+				shift_count = max_exp - 1
+			} else {
+				shift_count = 0
+			}
+
+			value = get_mantissa(f)
+		}
+
+		if shift_count < 25 {
+			value >>= uint(shift_count)
+		} else {
+			value = 0
+		}
+
+		if value == 0 {
+			if (wps.float_flags & FLOAT_ZEROS_SENT) != 0 {
+				if (get_exponent(f) != 0) || (get_mantissa(f) != 0) {
+					bs_putbits(&wps.wvxbits, 1, 1)
+					bs_putbits(&wps.wvxbits, get_mantissa(f), 23)
+
+					if max_exp >= 25 {
+						bs_putbits(&wps.wvxbits, uint(get_exponent(f)), 8)
+					}
+
+					bs_putbits(&wps.wvxbits, get_sign(f), 1)
+				} else {
+					bs_putbits(&wps.wvxbits, 0, 1)
+
+					if (wps.float_flags & FLOAT_NEG_ZEROS) != 0 {
+						bs_putbits(&wps.wvxbits, get_sign(f), 1)
+					}
+				}
+			}
+		} else if shift_count != 0 {
+			if (wps.float_flags & FLOAT_SHIFT_SENT) != 0 {
+				var data uint = get_mantissa(f) & ((1 << uint(shift_count)) - 1)
+
+				bs_putbits(&wps.wvxbits, data, uint(shift_count))
+			} else if (wps.float_flags & FLOAT_SHIFT_SAME) != 0 {
+				bs_putbits(&wps.wvxbits, get_mantissa(f)&1, 1)
+			}
+		}
+	}
+
+	wps.crc_x = crc
+}
+
+// Convert the integer values unpacked from the main bitstream back into
+// 32-bit IEEE floats (stored in the ints of the buffer) using the float
+// information from the block and the extended data from the "wvx"
+// bitstream. The crc of the final values is accumulated in crc_wvx.
+func float_values(wps *WavpackStream, values []int, num_values uint) {
+	var crc uint = wps.crc_wvx
+	var count int = int(num_values)
+
+	if (wps.wphdr.flags & MONO_FLAG) == 0 {
+		count *= 2
+	}
+
+	for i := 0; i < count; i++ {
+		var shift_count int = 0
+		var exp int = wps.float_max_exp
+		var value int = values[i]
+		var mantissa uint = 0
+		var exponent uint = 0
+		var sign uint = 0
+
+		if value == 0 {
+			if (wps.float_flags & FLOAT_ZEROS_SENT) != 0 {
+				if wps.wvxbits.active != 0 && getbit(&wps.wvxbits) != 0 {
+					mantissa = getbits(23, &wps.wvxbits) & 0x7fffff
+
+					if exp >= 25 {
+						exponent = getbits(8, &wps.wvxbits) & 0xff
+					}
+
+					sign = getbit(&wps.wvxbits)
+				} else if (wps.float_flags&FLOAT_NEG_ZEROS) != 0 && wps.wvxbits.active != 0 {
+					sign = getbit(&wps.wvxbits)
+				}
+			}
+		} else {
+			value <<= uint(wps.float_shift)
+
+			if value < 0 {
+				value = -value
+				sign = 1
+			}
+
+			if value == 0x1000000 {
+				if wps.wvxbits.active != 0 && getbit(&wps.wvxbits) != 0 {
+					mantissa = getbits(23, &wps.wvxbits) & 0x7fffff
+				}
+
+				exponent = 255
+			} else {
+				if exp != 0 {
+					for (value&0x800000) == 0 {
+						exp--
+
+						if exp == 0 {
+							break
+						}
+
+						shift_count++
+						value <<= 1
+					}
+				}
+
+				if shift_count != 0 {
+					if (wps.float_flags & FLOAT_SHIFT_ONES) != 0 {
+						value |= (1 << uint(shift_count)) - 1
+					} else if (wps.float_flags&FLOAT_SHIFT_SAME) != 0 && wps.wvxbits.active != 0 {
+						if getbit(&wps.wvxbits) != 0 {
+							value |= (1 << uint(shift_count)) - 1
+						}
+					} else if (wps.float_flags&FLOAT_SHIFT_SENT) != 0 && wps.wvxbits.active != 0 {
+						value |= int(getbits(uint(shift_count), &wps.wvxbits)) & ((1 << uint(shift_count)) - 1)
+					}
+				}
+
+				mantissa = uint(value) & 0x7fffff
+				exponent = uint(exp)
+			}
+		}
+
+		crc = crc*27 + mantissa*9 + exponent*3 + sign
+		values[i] = int(int32(uint32((sign << 31) | (exponent << 23) | mantissa)))
+	}
+
+	wps.crc_wvx = crc
+}
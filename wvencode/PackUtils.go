@@ -27,7 +27,7 @@ var fast_terms = [3]int{18, 17, 0}
 // This function initializes everything required to pack WavPack bitstreams
 // and must be called BEFORE any other function in this module.
 func pack_init(wpc *WavpackContext) {
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 	var flags uint = wps.wphdr.flags
 	var term_string []int
 	var dpp_idx int = 0
@@ -81,16 +81,37 @@ func pack_init(wpc *WavpackContext) {
 
 	wps.w.bitrate_acc = make([]uint, 2) // initialise before first use
 
-	init_words(&wps)
-
-	wpc.stream = wps
+	init_words(wps)
 }
 
 
+// Return the size of the buffers for a block, which is room for the samples
+// of a full block at a byte more than they take in the source (because the
+// entropy coder can expand audio that doesn't compress, like white noise)
+// and for the metadata, including the wrapper in the first block. The room
+// for the samples is limited to what the length of the bitstream in the
+// block can describe, and a block that still doesn't fit is terminated
+// early by pack_samples().
+func block_buffer_size(wpc *WavpackContext) int {
+	var size int = int(wpc.block_samples*wpc.config.Num_channels) * (wpc.config.Bytes_per_sample + 1)
+
+	if size > MAX_BLOCK_BITSTREAM {
+		size = MAX_BLOCK_BITSTREAM
+	}
+
+	size += BLOCK_BUFFER_EXTRA
+
+	if wpc.stream.sample_index == 0 {
+		size += len(wpc.wrapper_data)
+	}
+
+	return size
+}
+
 // Allocate room for and copy the decorrelation terms from the decorr_passes
 // array into the specified metadata structure. Both the actual term id and
 // the delta are packed into single characters.
-func write_decorr_terms(wps WavpackStream, wpmd *WavpackMetadata) {
+func write_decorr_terms(wps *WavpackStream, wpmd *WavpackMetadata) {
 	var tcount int
 	var byteptr []byte
 	var byte_idx int = 0
@@ -364,6 +385,83 @@ func write_config_info(wpc *WavpackContext, wpmd *WavpackMetadata) {
 	wpmd.data = byteptr
 }
 
+// Allocate room for and copy the extended configuration information into
+// the specified metadata structure. The first byte is the format of the
+// source file (WP_FORMAT_xxx) and the second is the lower byte of the
+// qualify mode (QMODE_xxx), which describes how the samples were stored in
+// that file. This is only written into the first block and only when
+// something other than a standard RIFF file is being packed.
+func write_new_config_info(wpc *WavpackContext, wpmd *WavpackMetadata) {
+	var byteptr []byte
+	var byte_idx int = 0
+
+	wpmd.data = wpmd.temp_data[0:len(wpmd.temp_data)]
+	byteptr = wpmd.data
+
+	wpmd.id = ID_NEW_CONFIG_BLOCK
+
+	byteptr[byte_idx] = byte(wpc.file_format)
+	byte_idx++
+	byteptr[byte_idx] = byte(wpc.config.Qmode)
+	byte_idx++
+
+	wpmd.byte_length = byte_idx
+	wpmd.data = byteptr
+}
+
+// Allocate room for and copy the header of the original file (the "wrapper")
+// into the specified metadata structure. RIFF headers are stored as
+// ID_RIFF_HEADER and headers of all other formats as ID_ALT_HEADER. The extra
+// byte allocated is for the padding that copy_metadata() adds to odd lengths.
+func write_wrapper_info(wpc *WavpackContext, wpmd *WavpackMetadata) {
+	wpmd.data = make([]byte, len(wpc.wrapper_data)+1)
+	copy(wpmd.data, wpc.wrapper_data)
+
+	if wpc.file_format == WP_FORMAT_WAV {
+		wpmd.id = int(ID_RIFF_HEADER)
+	} else {
+		wpmd.id = ID_ALT_HEADER
+	}
+
+	wpmd.byte_length = len(wpc.wrapper_data)
+}
+
+// Allocate room for and copy the file extension of the original file (without
+// the period) into the specified metadata structure.
+func write_file_extension(wpc *WavpackContext, wpmd *WavpackMetadata) {
+	wpmd.data = wpmd.temp_data[0:len(wpmd.temp_data)]
+	wpmd.id = ID_ALT_EXTENSION
+	wpmd.byte_length = copy(wpmd.data[0:len(wpmd.data)-1], wpc.file_extension)
+}
+
+// Allocate room for and copy the channel information into the specified
+// metadata structure. The first byte is the number of channels and the
+// channel mask follows in as many bytes as it needs (none if it is zero).
+// This is written only if the mask is not the default for the number of
+// channels (front center for mono, front left and right for stereo).
+func write_channel_info(wpc *WavpackContext, wpmd *WavpackMetadata) {
+	var mask uint = wpc.config.Channel_mask
+	var byteptr []byte
+	var byte_idx int = 0
+
+	wpmd.data = wpmd.temp_data[0:len(wpmd.temp_data)]
+	byteptr = wpmd.data
+
+	wpmd.id = int(ID_CHANNEL_INFO)
+
+	byteptr[byte_idx] = byte(wpc.config.Num_channels)
+	byte_idx++
+
+	for mask != 0 {
+		byteptr[byte_idx] = byte(mask)
+		byte_idx++
+		mask >>= 8
+	}
+
+	wpmd.byte_length = byte_idx
+	wpmd.data = byteptr
+}
+
 // Allocate room for and copy the non-standard sampling rateinto the specified
 // metadata structure. We just store the lower 3 bytes of the sampling rate.
 // Note that this would only be used when the sampling rate was not included
@@ -388,7 +486,7 @@ func write_sample_rate(wpc *WavpackContext, wpmd *WavpackMetadata) {
 
 
 func pack_start_block(wpc *WavpackContext) int {
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 	var flags = wps.wphdr.flags
 	var wpmd WavpackMetadata
 	var chunkSize uint
@@ -399,8 +497,15 @@ func pack_start_block(wpc *WavpackContext) int {
 	wps.wphdr.block_samples = 0
 	wps.wphdr.ckSize = WAVPACK_HEADER_SIZE - 8
 
-	wps.blockbuff = make([]byte, BIT_BUFFER_SIZE)
-	wps.block2buff = make([]byte, BIT_BUFFER_SIZE)
+	// the buffers are kept from one block to the next, and only replaced if
+	// a block needs more room (like the first, which holds the wrapper)
+	if len(wps.blockbuff) < block_buffer_size(wpc) {
+		wps.blockbuff = make([]byte, block_buffer_size(wpc))
+		wps.block2buff = make([]byte, block_buffer_size(wpc))
+	}
+
+	wps.blockend = len(wps.blockbuff)
+	wps.block2end = len(wps.block2buff)
 
 	wps.blockbuff[0] = byte(wps.wphdr.ckID[0])
 	wps.blockbuff[1] = byte(wps.wphdr.ckID[1])
@@ -412,8 +517,8 @@ func pack_start_block(wpc *WavpackContext) int {
 	wps.blockbuff[7] = byte(wps.wphdr.ckSize >> 24)
 	wps.blockbuff[8] = byte(wps.wphdr.version)
 	wps.blockbuff[9] = byte(wps.wphdr.version >> 8)
-	wps.blockbuff[10] = byte(wps.wphdr.track_no)
-	wps.blockbuff[11] = byte(wps.wphdr.index_no)
+	wps.blockbuff[10] = byte(wps.wphdr.block_index_u8)
+	wps.blockbuff[11] = byte(wps.wphdr.total_samples_u8)
 	wps.blockbuff[12] = byte(wps.wphdr.total_samples)
 	wps.blockbuff[13] = byte(wps.wphdr.total_samples >> 8)
 	wps.blockbuff[14] = byte(wps.wphdr.total_samples >> 16)
@@ -435,32 +540,35 @@ func pack_start_block(wpc *WavpackContext) int {
 	wps.blockbuff[30] = byte(wps.wphdr.crc >> 16)
 	wps.blockbuff[31] = byte(wps.wphdr.crc >> 24)
 
-	write_decorr_terms(wps, &wpmd)
-	copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+	// DSD blocks have none of the decorrelation or entropy coder information
+	if (flags & DSD_FLAG) == 0 {
+		write_decorr_terms(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
 
-	if copyRetVal == FALSE {
-		return FALSE
-	}
+		if copyRetVal == FALSE {
+			return FALSE
+		}
 
-	write_decorr_weights(&wps, &wpmd)
-	copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+		write_decorr_weights(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
 
-	if copyRetVal == FALSE {
-		return FALSE
-	}
+		if copyRetVal == FALSE {
+			return FALSE
+		}
 
-	write_decorr_samples(&wps, &wpmd)
-	copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+		write_decorr_samples(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
 
-	if copyRetVal == FALSE {
-		return FALSE
-	}
+		if copyRetVal == FALSE {
+			return FALSE
+		}
 
-	write_entropy_vars(&wps, &wpmd)
-	copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+		write_entropy_vars(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
 
-	if copyRetVal == FALSE {
-		return FALSE
+		if copyRetVal == FALSE {
+			return FALSE
+		}
 	}
 
 	if ((flags & SRATE_MASK) == SRATE_MASK) &&
@@ -475,7 +583,25 @@ func pack_start_block(wpc *WavpackContext) int {
 	}
 
 	if (flags & HYBRID_FLAG) != 0 {
-		write_hybrid_profile(&wps, &wpmd)
+		write_hybrid_profile(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+
+		if copyRetVal == FALSE {
+			return FALSE
+		}
+	}
+
+	if (flags & FLOAT_DATA) != 0 {
+		write_float_info(wps, &wpmd)
+		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+
+		if copyRetVal == FALSE {
+			return FALSE
+		}
+	}
+
+	if wpc.config.Channel_mask != 0x5-wpc.config.Num_channels {
+		write_channel_info(wpc, &wpmd)
 		copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
 
 		if copyRetVal == FALSE {
@@ -490,12 +616,39 @@ func pack_start_block(wpc *WavpackContext) int {
 		if copyRetVal == FALSE {
 			return FALSE
 		}
+
+		if (wpc.file_format != WP_FORMAT_WAV) || ((wpc.config.Qmode & 0xff) != 0) {
+			write_new_config_info(wpc, &wpmd)
+			copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+
+			if copyRetVal == FALSE {
+				return FALSE
+			}
+		}
+
+		if len(wpc.wrapper_data) > 0 {
+			write_wrapper_info(wpc, &wpmd)
+			copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+
+			if copyRetVal == FALSE {
+				return FALSE
+			}
+		}
+
+		if len(wpc.file_extension) > 0 {
+			write_file_extension(wpc, &wpmd)
+			copyRetVal, wps.blockbuff = copy_metadata(wpmd, wps.blockbuff, wps.blockend)
+
+			if copyRetVal == FALSE {
+				return FALSE
+			}
+		}
 	}
 
 	chunkSize = uint((int(wps.blockbuff[4]) & 0xff) + ((int(wps.blockbuff[5]) & 0xff) << 8) +
 		((int(wps.blockbuff[6]) & 0xff) << 16) + ((int(wps.blockbuff[7]) & 0xff) << 24))
 
-	bs_open_write(&wps.wvbits, int(chunkSize+12), wps.blockend)
+	bs_open_write(&wps.wvbits, wps.blockbuff, int(chunkSize+12), wps.blockend)
 
 	if wpc.wvc_flag != 0 {
 		wps.block2buff[0] = byte(wps.wphdr.ckID[0])
@@ -508,8 +661,8 @@ func pack_start_block(wpc *WavpackContext) int {
 		wps.block2buff[7] = byte(wps.wphdr.ckSize >> 24)
 		wps.block2buff[8] = byte(wps.wphdr.version)
 		wps.block2buff[9] = byte(wps.wphdr.version >> 8)
-		wps.block2buff[10] = byte(wps.wphdr.track_no)
-		wps.block2buff[11] = byte(wps.wphdr.index_no)
+		wps.block2buff[10] = byte(wps.wphdr.block_index_u8)
+		wps.block2buff[11] = byte(wps.wphdr.total_samples_u8)
 		wps.block2buff[12] = byte(wps.wphdr.total_samples)
 		wps.block2buff[13] = byte(wps.wphdr.total_samples >> 8)
 		wps.block2buff[14] = byte(wps.wphdr.total_samples >> 16)
@@ -532,7 +685,7 @@ func pack_start_block(wpc *WavpackContext) int {
 		wps.block2buff[31] = byte(wps.wphdr.crc >> 24)
 
 		if (flags & HYBRID_SHAPE) != 0 {
-			write_shaping_info(&wps, &wpmd)
+			write_shaping_info(wps, &wpmd)
 			copyRetVal, wps.block2buff = copy_metadata(wpmd, wps.block2buff, wps.block2end)
 
 			if copyRetVal == FALSE {
@@ -543,24 +696,117 @@ func pack_start_block(wpc *WavpackContext) int {
 		chunkSize = uint((int(wps.block2buff[4]) & 0xff) + ((int(wps.block2buff[5]) & 0xff) << 8) +
 			((int(wps.block2buff[6]) & 0xff) << 16) + ((int(wps.block2buff[7]) & 0xff) << 24))
 
-		bs_open_write(&wps.wvcbits, (int)(chunkSize+12), wps.block2end)
+		bs_open_write(&wps.wvcbits, wps.block2buff, (int)(chunkSize+12), wps.block2end)
 	} else {
 		wps.block2buff[0] = 0
 	}
 
-	wpc.stream = wps
-
 	return TRUE
 }
 
 
+// Look for low-order bits that are zero in every one of the given samples
+// (like 16-bit audio that has been padded to 24 bits) and shift them out,
+// adding them to the shift of the source format in the block header so that
+// the decoder can restore them. The magnitude in the header is reduced to
+// match. The decorrelation history carries over from the previous block, so
+// it is rescaled if the total shift changes from one block to the next.
+// Decoders take the bits per sample from the shift of the first block, so
+// that block only gets the shift of the source format.
+func scan_wasted_bits(wpc *WavpackContext, wps *WavpackStream, values []int) {
+	var flags uint = wps.wphdr.flags
+	var prev_shift uint = (flags & SHIFT_MASK) >> SHIFT_LSB
+	var shift uint = uint((wpc.config.Bytes_per_sample * 8) - wpc.config.Bits_per_sample)
+	var mag uint = ((flags & BYTES_STORED) * 8) + 7
+	var ordata int = 0
+	var wasted uint = 0
+
+	if wps.sample_index != 0 {
+		for i := 0; i < len(values) && (ordata&1) == 0; i++ {
+			ordata |= values[i]
+		}
+	}
+
+	if ordata != 0 {
+		for (ordata & 1) == 0 {
+			ordata >>= 1
+			wasted++
+		}
+
+		for i := range values {
+			values[i] >>= wasted
+		}
+	}
+
+	shift += wasted
+
+	if wasted > mag {
+		mag = 0
+	} else {
+		mag -= wasted
+	}
+
+	flags &= ^(SHIFT_MASK | MAG_MASK)
+	flags |= (shift << SHIFT_LSB) | (mag << MAG_LSB)
+	wps.wphdr.flags = flags
+
+	if shift != prev_shift {
+		for tcount := 0; tcount < wps.num_terms; tcount++ {
+			dpp := &wps.decorr_passes[tcount]
+
+			for m := 0; m < MAX_TERM; m++ {
+				if shift > prev_shift {
+					dpp.samples_A[m] >>= (shift - prev_shift)
+					dpp.samples_B[m] >>= (shift - prev_shift)
+				} else {
+					dpp.samples_A[m] <<= (prev_shift - shift)
+					dpp.samples_B[m] <<= (prev_shift - shift)
+				}
+			}
+		}
+	}
+}
+
+// If joint stereo is automatic (CONFIG_JOINT_AUTO) then find out whether the
+// given stereo samples pack better as left/right or as mid/side and set
+// JOINT_STEREO in the block header to match. The block is trial-packed both
+// ways by trial_joint_stereo(), since no simple estimate of the residuals
+// matches what the decorrelation passes and the entropy coder will make of
+// them (unrelated channels, such as white noise, look better as mid/side to
+// a second-order predictor but pack better as left/right). The trials change
+// the context's stream, so the stream state in "wps" is put back afterwards.
+func select_joint_stereo(wpc *WavpackContext, wps *WavpackStream, values []int) {
+	var saved WavpackStream
+
+	if joint_stereo_auto(wpc, wps) == FALSE {
+		return
+	}
+
+	saved = *wps
+	_, trial, _ := trial_joint_stereo(wpc, saved, values)
+	*wps = saved
+	wps.wphdr.flags = (wps.wphdr.flags & ^JOINT_STEREO) | (trial.wphdr.flags & JOINT_STEREO)
+}
+
+// Joint stereo is chosen for each block if it's automatic, except for mono
+// and hybrid blocks. Hybrid blocks are packed as the samples arrive, so they
+// just keep the joint stereo setting from the configuration.
+func joint_stereo_auto(wpc *WavpackContext, wps *WavpackStream) int {
+	if ((wpc.config.Flags & CONFIG_JOINT_AUTO) == 0) ||
+		((wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO | HYBRID_FLAG)) != 0) {
+		return FALSE
+	}
+
+	return TRUE
+}
+
 // Pack the given samples into the block currently being assembled. This function
 // checks the available space each sample so that it can return prematurely to
 // indicate that the blocks must be terminated. The return value is the number
 // of actual samples packed and will be the same as the provided sample_count
 // in no error occurs.
 func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 
 	var flags = wps.wphdr.flags
 
@@ -611,7 +857,7 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 		for i = 0; i < sample_count; i++ {
 			var code uint
 
-			if bs_remain_write(wps.wvbits) < 64 {
+			if bs_remain_write(&wps.wvbits) < 64 {
 				break
 			}
 
@@ -622,36 +868,33 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			dpp_idx = 0
 
 			for tcount = wps.num_terms; tcount > 0; tcount-- {
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
 				var sam int
 
-				if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-					if (wps.decorr_passes[dpp_idx].term & 1) != 0 {
-						sam = (2 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]
+				if dpp.term > MAX_TERM {
+					if (dpp.term & 1) != 0 {
+						sam = (2 * dpp.samples_A[0]) - dpp.samples_A[1]
 					} else {
-						sam = ((3 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]) >> 1
+						sam = ((3 * dpp.samples_A[0]) - dpp.samples_A[1]) >> 1
 					}
 
-					wps.decorr_passes[dpp_idx].samples_A[1] = wps.decorr_passes[dpp_idx].samples_A[0]
-					wps.decorr_passes[dpp_idx].samples_A[0] = int(code)
+					dpp.samples_A[1] = dpp.samples_A[0]
+					dpp.samples_A[0] = int(code)
 				} else {
-					sam = wps.decorr_passes[dpp_idx].samples_A[m]
+					sam = dpp.samples_A[m]
 
-					wps.decorr_passes[dpp_idx].samples_A[(m+wps.decorr_passes[dpp_idx].term)&
-						(MAX_TERM-1)] = int(code)
+					dpp.samples_A[(m+dpp.term)&(MAX_TERM-1)] = int(code)
 				}
 
-				code -= uint(apply_weight(wps.decorr_passes[dpp_idx].weight_A, sam))
-				wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-					wps.decorr_passes[dpp_idx].delta, sam, int(code))
+				code -= uint(apply_weight(dpp.weight_A, sam))
+				dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, sam, int(code))
 
 				dpp_idx++
 			}
 
 			m = (m + 1) & (MAX_TERM - 1)
 
-			send_word_lossless(&wps, int(code), 0)
+			send_word_lossless(wps, int(code), 0)
 		}
 
 		wpc.Byte_idx = byte_idx
@@ -666,7 +909,7 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			var sam_A int
 			var sam_B int
 
-			if bs_remain_write(wps.wvbits) < 128 {
+			if bs_remain_write(&wps.wvbits) < 128 {
 				break
 			}
 
@@ -683,68 +926,62 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			dpp_idx = 0
 
 			for tcount = wps.num_terms; tcount > 0; tcount-- {
-				if wps.decorr_passes[dpp_idx].term > 0 {
-					if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-						if (wps.decorr_passes[dpp_idx].term & 1) != 0 {
-							sam_A = (2 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-								wps.decorr_passes[dpp_idx].samples_A[1]
-							sam_B = (2 * wps.decorr_passes[dpp_idx].samples_B[0]) -
-								wps.decorr_passes[dpp_idx].samples_B[1]
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > 0 {
+					if dpp.term > MAX_TERM {
+						if (dpp.term & 1) != 0 {
+							sam_A = (2 * dpp.samples_A[0]) - dpp.samples_A[1]
+							sam_B = (2 * dpp.samples_B[0]) - dpp.samples_B[1]
 						} else {
-							sam_A = ((3 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-								wps.decorr_passes[dpp_idx].samples_A[1]) >> 1
-							sam_B = ((3 * wps.decorr_passes[dpp_idx].samples_B[0]) -
-								wps.decorr_passes[dpp_idx].samples_B[1]) >> 1
+							sam_A = ((3 * dpp.samples_A[0]) - dpp.samples_A[1]) >> 1
+							sam_B = ((3 * dpp.samples_B[0]) - dpp.samples_B[1]) >> 1
 						}
 
-						wps.decorr_passes[dpp_idx].samples_A[1] = wps.decorr_passes[dpp_idx].samples_A[0]
-						wps.decorr_passes[dpp_idx].samples_B[1] = wps.decorr_passes[dpp_idx].samples_B[0]
-						wps.decorr_passes[dpp_idx].samples_A[0] = left
-						wps.decorr_passes[dpp_idx].samples_B[0] = right
+						dpp.samples_A[1] = dpp.samples_A[0]
+						dpp.samples_B[1] = dpp.samples_B[0]
+						dpp.samples_A[0] = left
+						dpp.samples_B[0] = right
 					} else {
-						var k int = (m + wps.decorr_passes[dpp_idx].term) & (MAX_TERM - 1)
+						var k int = (m + dpp.term) & (MAX_TERM - 1)
 
-						sam_A = wps.decorr_passes[dpp_idx].samples_A[m]
-						sam_B = wps.decorr_passes[dpp_idx].samples_B[m]
-						wps.decorr_passes[dpp_idx].samples_A[k] = left
-						wps.decorr_passes[dpp_idx].samples_B[k] = right
+						sam_A = dpp.samples_A[m]
+						sam_B = dpp.samples_B[m]
+						dpp.samples_A[k] = left
+						dpp.samples_B[k] = right
 					}
 
-					left -= apply_weight(wps.decorr_passes[dpp_idx].weight_A, sam_A)
-					right -= apply_weight(wps.decorr_passes[dpp_idx].weight_B, sam_B)
-					wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta, sam_A, left)
-					wps.decorr_passes[dpp_idx].weight_B = update_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].delta, sam_B, right)
+					left -= apply_weight(dpp.weight_A, sam_A)
+					right -= apply_weight(dpp.weight_B, sam_B)
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, sam_A, left)
+					dpp.weight_B = update_weight(dpp.weight_B, dpp.delta, sam_B, right)
 				} else {
-					if wps.decorr_passes[dpp_idx].term == -2 {
+					if dpp.term == -2 {
 						sam_A = right
 					} else {
-						sam_A = wps.decorr_passes[dpp_idx].samples_A[0]
+						sam_A = dpp.samples_A[0]
 					}
 
-					if wps.decorr_passes[dpp_idx].term == -1 {
+					if dpp.term == -1 {
 						sam_B = left
 					} else {
-						sam_B = wps.decorr_passes[dpp_idx].samples_B[0]
+						sam_B = dpp.samples_B[0]
 					}
 
-					wps.decorr_passes[dpp_idx].samples_A[0] = right
-					wps.decorr_passes[dpp_idx].samples_B[0] = left
-					left -= apply_weight(wps.decorr_passes[dpp_idx].weight_A, sam_A)
-					right -= apply_weight(wps.decorr_passes[dpp_idx].weight_B, sam_B)
-					wps.decorr_passes[dpp_idx].weight_A = update_weight_clip(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta, sam_A, uint(left))
-					wps.decorr_passes[dpp_idx].weight_B = update_weight_clip(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].delta, sam_B, uint(right))
+					dpp.samples_A[0] = right
+					dpp.samples_B[0] = left
+					left -= apply_weight(dpp.weight_A, sam_A)
+					right -= apply_weight(dpp.weight_B, sam_B)
+					dpp.weight_A = update_weight_clip(dpp.weight_A, dpp.delta, sam_A, uint(left))
+					dpp.weight_B = update_weight_clip(dpp.weight_B, dpp.delta, sam_B, uint(right))
 				}
 
 				dpp_idx++
 			}
 
 			m = (m + 1) & (MAX_TERM - 1)
-			send_word_lossless(&wps, left, 0)
-			send_word_lossless(&wps, right, 1)
+			send_word_lossless(wps, left, 0)
+			send_word_lossless(wps, right, 1)
 
 			byte_idx += 2
 		}
@@ -759,8 +996,8 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			var code int
 			var temp int
 
-			if (bs_remain_write(wps.wvbits) < 64) ||
-				((wpc.wvc_flag != 0) && (bs_remain_write(wps.wvcbits) < 64)) {
+			if (bs_remain_write(&wps.wvbits) < 64) ||
+				((wpc.wvc_flag != 0) && (bs_remain_write(&wps.wvcbits) < 64)) {
 				break
 			}
 
@@ -794,48 +1031,44 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			dpp_idx = 0
 
 			for tcount = wps.num_terms; tcount > 0; tcount-- {
-				if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-					if (wps.decorr_passes[dpp_idx].term & 1) != 0 {
-						wps.decorr_passes[dpp_idx].samples_A[2] = (2 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > MAX_TERM {
+					if (dpp.term & 1) != 0 {
+						dpp.samples_A[2] = (2 * dpp.samples_A[0]) - dpp.samples_A[1]
 					} else {
-						wps.decorr_passes[dpp_idx].samples_A[2] = ((3 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]) >> 1
+						dpp.samples_A[2] = ((3 * dpp.samples_A[0]) - dpp.samples_A[1]) >> 1
 					}
 
-					wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].samples_A[2])
-					code -= (wps.decorr_passes[dpp_idx].aweight_A)
+					dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[2])
+					code -= (dpp.aweight_A)
 				} else {
-					wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].samples_A[m])
-					code -= (wps.decorr_passes[dpp_idx].aweight_A)
+					dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[m])
+					code -= (dpp.aweight_A)
 				}
 
 				dpp_idx++
 			}
 
-			code = send_word(&wps, code, 0)
+			code = send_word(wps, code, 0)
 
 			dpp_idx--
 
 			for dpp_idx >= 0 {
-				if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-					wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_A[2], code)
-					wps.decorr_passes[dpp_idx].samples_A[1] = wps.decorr_passes[dpp_idx].samples_A[0]
-					code += wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].samples_A[0] = code
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > MAX_TERM {
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, dpp.samples_A[2], code)
+					dpp.samples_A[1] = dpp.samples_A[0]
+					code += dpp.aweight_A
+					dpp.samples_A[0] = code
 				} else {
-					var sam int = wps.decorr_passes[dpp_idx].samples_A[m]
+					var sam int = dpp.samples_A[m]
 
-					wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta, sam, code)
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, sam, code)
 
-					code += wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].samples_A[(m+wps.decorr_passes[dpp_idx].term)&
-						(MAX_TERM-1)] = code
+					code += dpp.aweight_A
+					dpp.samples_A[(m+dpp.term)&(MAX_TERM-1)] = code
 				}
 
 				dpp_idx--
@@ -862,8 +1095,8 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			var temp int
 			var shaping_weight int
 
-			if (bs_remain_write(wps.wvbits) < 128) ||
-				((wpc.wvc_flag != 0) && (bs_remain_write(wps.wvcbits) < 128)) {
+			if (bs_remain_write(&wps.wvbits) < 128) ||
+				((wpc.wvc_flag != 0) && (bs_remain_write(&wps.wvcbits) < 128)) {
 				break
 			}
 
@@ -925,108 +1158,90 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 			dpp_idx = 0
 
 			for tcount = wps.num_terms; tcount > 0; tcount-- {
-				if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-					if (wps.decorr_passes[dpp_idx].term & 1) != 0 {
-						wps.decorr_passes[dpp_idx].samples_A[2] = (2 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]
-						wps.decorr_passes[dpp_idx].samples_B[2] = (2 * wps.decorr_passes[dpp_idx].samples_B[0]) -
-							wps.decorr_passes[dpp_idx].samples_B[1]
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > MAX_TERM {
+					if (dpp.term & 1) != 0 {
+						dpp.samples_A[2] = (2 * dpp.samples_A[0]) - dpp.samples_A[1]
+						dpp.samples_B[2] = (2 * dpp.samples_B[0]) - dpp.samples_B[1]
 					} else {
-						wps.decorr_passes[dpp_idx].samples_A[2] = ((3 * wps.decorr_passes[dpp_idx].samples_A[0]) -
-							wps.decorr_passes[dpp_idx].samples_A[1]) >> 1
-						wps.decorr_passes[dpp_idx].samples_B[2] = ((3 * wps.decorr_passes[dpp_idx].samples_B[0]) -
-							wps.decorr_passes[dpp_idx].samples_B[1]) >> 1
+						dpp.samples_A[2] = ((3 * dpp.samples_A[0]) - dpp.samples_A[1]) >> 1
+						dpp.samples_B[2] = ((3 * dpp.samples_B[0]) - dpp.samples_B[1]) >> 1
 					}
-					wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].samples_A[2])
-					left -= (wps.decorr_passes[dpp_idx].aweight_A)
-					wps.decorr_passes[dpp_idx].aweight_B = apply_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].samples_B[2])
-					right -= (wps.decorr_passes[dpp_idx].aweight_B)
-				} else if wps.decorr_passes[dpp_idx].term > 0 {
-					wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].samples_A[m])
-					left -= (wps.decorr_passes[dpp_idx].aweight_A)
-
-					wps.decorr_passes[dpp_idx].aweight_B = apply_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].samples_B[m])
-					right -= (wps.decorr_passes[dpp_idx].aweight_B)
+					dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[2])
+					left -= (dpp.aweight_A)
+					dpp.aweight_B = apply_weight(dpp.weight_B, dpp.samples_B[2])
+					right -= (dpp.aweight_B)
+				} else if dpp.term > 0 {
+					dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[m])
+					left -= (dpp.aweight_A)
+
+					dpp.aweight_B = apply_weight(dpp.weight_B, dpp.samples_B[m])
+					right -= (dpp.aweight_B)
 				} else {
-					if wps.decorr_passes[dpp_idx].term == -1 {
-						wps.decorr_passes[dpp_idx].samples_B[0] = left
-					} else if wps.decorr_passes[dpp_idx].term == -2 {
-						wps.decorr_passes[dpp_idx].samples_A[0] = right
+					if dpp.term == -1 {
+						dpp.samples_B[0] = left
+					} else if dpp.term == -2 {
+						dpp.samples_A[0] = right
 					}
 
-					wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].samples_A[0])
-					left -= wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].aweight_B = apply_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].samples_B[0])
-					right -= wps.decorr_passes[dpp_idx].aweight_B
+					dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[0])
+					left -= dpp.aweight_A
+					dpp.aweight_B = apply_weight(dpp.weight_B, dpp.samples_B[0])
+					right -= dpp.aweight_B
 				}
 
 				dpp_idx++
 			}
 
-			left = send_word(&wps, left, 0)
-			right = send_word(&wps, right, 1)
+			left = send_word(wps, left, 0)
+			right = send_word(wps, right, 1)
 
 			dpp_idx--
 
 			for dpp_idx >= 0 {
-				if wps.decorr_passes[dpp_idx].term > MAX_TERM {
-					wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_A[2], left)
-					wps.decorr_passes[dpp_idx].weight_B = update_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_B[2], right)
-
-					wps.decorr_passes[dpp_idx].samples_A[1] = wps.decorr_passes[dpp_idx].samples_A[0]
-					wps.decorr_passes[dpp_idx].samples_B[1] = wps.decorr_passes[dpp_idx].samples_B[0]
-
-					left += wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].samples_A[0] = left
-					right += wps.decorr_passes[dpp_idx].aweight_B
-					wps.decorr_passes[dpp_idx].samples_B[0] = right
-				} else if wps.decorr_passes[dpp_idx].term > 0 {
-					var k int = (m + wps.decorr_passes[dpp_idx].term) & (MAX_TERM - 1)
-
-					wps.decorr_passes[dpp_idx].weight_A = update_weight(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_A[m], left)
-					left += wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].samples_A[k] = left
-
-					wps.decorr_passes[dpp_idx].weight_B = update_weight(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_B[m], right)
-					right += wps.decorr_passes[dpp_idx].aweight_B
-					wps.decorr_passes[dpp_idx].samples_B[k] = right
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > MAX_TERM {
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, dpp.samples_A[2], left)
+					dpp.weight_B = update_weight(dpp.weight_B, dpp.delta, dpp.samples_B[2], right)
+
+					dpp.samples_A[1] = dpp.samples_A[0]
+					dpp.samples_B[1] = dpp.samples_B[0]
+
+					left += dpp.aweight_A
+					dpp.samples_A[0] = left
+					right += dpp.aweight_B
+					dpp.samples_B[0] = right
+				} else if dpp.term > 0 {
+					var k int = (m + dpp.term) & (MAX_TERM - 1)
+
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, dpp.samples_A[m], left)
+					left += dpp.aweight_A
+					dpp.samples_A[k] = left
+
+					dpp.weight_B = update_weight(dpp.weight_B, dpp.delta, dpp.samples_B[m], right)
+					right += dpp.aweight_B
+					dpp.samples_B[k] = right
 				} else {
-					if wps.decorr_passes[dpp_idx].term == -1 {
-						wps.decorr_passes[dpp_idx].samples_B[0] = left +
-							wps.decorr_passes[dpp_idx].aweight_A
-						wps.decorr_passes[dpp_idx].aweight_B = apply_weight(wps.decorr_passes[dpp_idx].weight_B,
-							wps.decorr_passes[dpp_idx].samples_B[0])
-					} else if wps.decorr_passes[dpp_idx].term == -2 {
-						wps.decorr_passes[dpp_idx].samples_A[0] = right +
-							wps.decorr_passes[dpp_idx].aweight_B
-						wps.decorr_passes[dpp_idx].aweight_A = apply_weight(wps.decorr_passes[dpp_idx].weight_A,
-							wps.decorr_passes[dpp_idx].samples_A[0])
+					if dpp.term == -1 {
+						dpp.samples_B[0] = left +
+							dpp.aweight_A
+						dpp.aweight_B = apply_weight(dpp.weight_B, dpp.samples_B[0])
+					} else if dpp.term == -2 {
+						dpp.samples_A[0] = right +
+							dpp.aweight_B
+						dpp.aweight_A = apply_weight(dpp.weight_A, dpp.samples_A[0])
 					}
 
-					wps.decorr_passes[dpp_idx].weight_A = update_weight_clip(wps.decorr_passes[dpp_idx].weight_A,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_A[0], uint(left))
-					wps.decorr_passes[dpp_idx].weight_B = update_weight_clip(wps.decorr_passes[dpp_idx].weight_B,
-						wps.decorr_passes[dpp_idx].delta,
-						wps.decorr_passes[dpp_idx].samples_B[0], uint(right))
-					left += wps.decorr_passes[dpp_idx].aweight_A
-					wps.decorr_passes[dpp_idx].samples_B[0] = left
-					right += wps.decorr_passes[dpp_idx].aweight_B
-					wps.decorr_passes[dpp_idx].samples_A[0] = right
+					dpp.weight_A = update_weight_clip(dpp.weight_A, dpp.delta,
+						dpp.samples_A[0], uint(left))
+					dpp.weight_B = update_weight_clip(dpp.weight_B, dpp.delta,
+						dpp.samples_B[0], uint(right))
+					left += dpp.aweight_A
+					dpp.samples_B[0] = left
+					right += dpp.aweight_B
+					dpp.samples_A[0] = right
 				}
 
 				dpp_idx--
@@ -1090,9 +1305,7 @@ func pack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
 		wps.lossy_block = TRUE
 	}
 
-	wps.sample_index += int(i)
-
-	wpc.stream = wps
+	wps.sample_index += int64(i)
 
 	return i
 }
@@ -1132,7 +1345,7 @@ func update_weight_clip(weight int, delta int, source int, result uint) int {
 // means just closing the bitstreams because the block_samples and crc fields
 // of the WavpackHeader are updated during packing.
 func pack_finish_block(wpc *WavpackContext) int {
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 	var lossy int = wps.lossy_block
 	var tcount int
 	var m int
@@ -1170,9 +1383,9 @@ func pack_finish_block(wpc *WavpackContext) int {
 		}
 	}
 
-	flush_word(&wps)
+	flush_word(wps)
 
-	data_count = bs_close_write(&wps)
+	data_count = bs_close_write(&wps.wvbits)
 
 	if data_count != 0 {
 		if data_count != -1 {
@@ -1203,8 +1416,57 @@ func pack_finish_block(wpc *WavpackContext) int {
 		}
 	}
 
+	// the extended float data follows the regular bitstream, preceded by the
+	// crc of the original floats (the block buffer is grown to fit)
+	if wps.wvxbits.active != 0 {
+		data_count = bs_close_write(&wps.wvxbits)
+
+		if data_count == -1 {
+			return FALSE
+		}
+
+		if data_count != 0 {
+			var cptr_idx int = 0
+
+			chunkSize = (int(wps.blockbuff[4]) & 0xff) + ((int(wps.blockbuff[5]) & 0xff) << 8) +
+				((int(wps.blockbuff[6]) & 0xff) << 16) + ((int(wps.blockbuff[7]) & 0xff) << 24)
+
+			cptr_idx = chunkSize + 8
+
+			if (cptr_idx + data_count + 8) > len(wps.blockbuff) {
+				wps.blockbuff = append(wps.blockbuff, make([]byte, cptr_idx+data_count+8-len(wps.blockbuff))...)
+			}
+
+			wps.blockbuff[cptr_idx] = byte(int(ID_WVX_BITSTREAM) | ID_LARGE)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte((data_count + 4) >> 1)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte((data_count + 4) >> 9)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte((data_count + 4) >> 17)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte(wps.crc_x)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte(wps.crc_x >> 8)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte(wps.crc_x >> 16)
+			cptr_idx++
+			wps.blockbuff[cptr_idx] = byte(wps.crc_x >> 24)
+			cptr_idx++
+
+			copy(wps.blockbuff[cptr_idx:], wps.wvxbuff[0:data_count])
+
+			chunkSize = chunkSize + data_count + 8
+
+			wps.blockbuff[4] = byte(chunkSize)
+			wps.blockbuff[5] = byte(chunkSize >> 8)
+			wps.blockbuff[6] = byte(chunkSize >> 16)
+			wps.blockbuff[7] = byte(chunkSize >> 24)
+		}
+	}
+
 	if wpc.wvc_flag != 0 {
-		data_count = bs_close_correction_write(&wps)
+		data_count = bs_close_write(&wps.wvcbits)
 
 		if (data_count != 0) && (lossy != 0) {
 			if data_count != -1 {
@@ -1236,8 +1498,6 @@ func pack_finish_block(wpc *WavpackContext) int {
 		wpc.lossy_blocks = TRUE
 	}
 
-	wpc.stream = wps
-
 	return TRUE
 }
 
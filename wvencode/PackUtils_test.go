@@ -0,0 +1,50 @@
+package wvencode
+
+/*
+** PackUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"testing"
+)
+
+// Automatic joint stereo (-j2) has to come out no bigger than the better
+// of left/right and mid/side, both for unrelated channels (white noise,
+// where left/right wins) and for a tone common to both (where mid/side
+// wins), and the audio has to be restored exactly.
+func TestJointStereoAuto(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		samples []int
+	}{{"noise", make_noise_audio(200000)}, {"tone", make_test_audio(200000)}} {
+		var sizes [3]int
+
+		for i, joint := range []uint{CONFIG_JOINT_OVERRIDE, CONFIG_JOINT_OVERRIDE | CONFIG_JOINT_STEREO, CONFIG_JOINT_AUTO} {
+			var config WavpackConfig
+
+			config.Bits_per_sample = 16
+			config.Bytes_per_sample = 2
+			config.Num_channels = 2
+			config.Sample_rate = 44100
+			config.Flags = joint
+
+			wv, _ := pack_test_audio(t, config, test.samples)
+			compare_test_audio(t, test.name, test.samples, unpack_test_audio(open_test_audio(t, wv, nil)))
+			sizes[i] = len(wv)
+		}
+
+		t.Logf("%s: left/right %d bytes, mid/side %d, auto %d", test.name, sizes[0], sizes[1], sizes[2])
+
+		if (sizes[2] > sizes[0]) || (sizes[2] > sizes[1]) {
+			t.Errorf("%s: auto joint stereo takes %d bytes, but left/right takes %d and mid/side %d",
+				test.name, sizes[2], sizes[0], sizes[1])
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package wvencode
+
+/*
+** PcmUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+// Unpack the PCM audio bytes in "pcm" (interleaved, as they are in a WAV file)
+// into the 32-bit sample values that WavpackPackSamples() takes, one value in
+// "sample_buffer" for every "bytes_per_sample" (1 to 4) bytes. By default the
+// bytes are in little-endian order, 8-bit samples are unsigned and all others
+// are signed, as in WAV files. This can be changed with the QMODE_BIG_ENDIAN,
+// QMODE_SIGNED_BYTES and QMODE_UNSIGNED_WORDS bits of "qmode" (the 4-byte
+// samples, which can also be 32-bit floats, are taken as they are). The return
+// value is the number of values unpacked, which is limited by the length of
+// both buffers.
+func WavpackUnpackPCM(sample_buffer []int, pcm []byte, bytes_per_sample int, qmode int) int {
+	var num_values int = len(pcm) / bytes_per_sample
+
+	if num_values > len(sample_buffer) {
+		num_values = len(sample_buffer)
+	}
+
+	switch bytes_per_sample {
+	case 1:
+		if (qmode & QMODE_SIGNED_BYTES) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int8(pcm[i]))
+			}
+		} else {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(pcm[i]) - 128
+			}
+		}
+
+	case 2:
+		var src []byte = pcm[0 : num_values*2]
+
+		if (qmode & QMODE_BIG_ENDIAN) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int16(uint16(src[i*2])<<8 | uint16(src[i*2+1])))
+			}
+		} else {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int16(uint16(src[i*2]) | uint16(src[i*2+1])<<8))
+			}
+		}
+
+		if (qmode & QMODE_UNSIGNED_WORDS) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = (sample_buffer[i] & 0xffff) - 0x8000
+			}
+		}
+
+	case 3:
+		var src []byte = pcm[0 : num_values*3]
+
+		if (qmode & QMODE_BIG_ENDIAN) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int32(uint32(src[i*3])<<24|uint32(src[i*3+1])<<16|uint32(src[i*3+2])<<8) >> 8)
+			}
+		} else {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int32(uint32(src[i*3])<<8|uint32(src[i*3+1])<<16|uint32(src[i*3+2])<<24) >> 8)
+			}
+		}
+
+		if (qmode & QMODE_UNSIGNED_WORDS) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = (sample_buffer[i] & 0xffffff) - 0x800000
+			}
+		}
+
+	case 4:
+		var src []byte = pcm[0 : num_values*4]
+
+		if (qmode & QMODE_BIG_ENDIAN) != 0 {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int32(uint32(src[i*4])<<24 | uint32(src[i*4+1])<<16 |
+					uint32(src[i*4+2])<<8 | uint32(src[i*4+3])))
+			}
+		} else {
+			for i := 0; i < num_values; i++ {
+				sample_buffer[i] = int(int32(uint32(src[i*4]) | uint32(src[i*4+1])<<8 |
+					uint32(src[i*4+2])<<16 | uint32(src[i*4+3])<<24))
+			}
+		}
+
+	default:
+		return 0
+	}
+
+	return num_values
+}
+
+// The reverse of WavpackUnpackPCM(), this formats the 32-bit sample values
+// from WavpackUnpackSamples() in "sample_buffer" as PCM audio bytes in "pcm",
+// "bytes_per_sample" (1 to 4) bytes for each value. The qmode bits select the
+// byte order and signedness as they do for WavpackUnpackPCM(), so with a qmode
+// of zero the bytes are as they would be in a WAV file. The return value is
+// the number of values formatted, which is limited by the length of both
+// buffers.
+func WavpackFormatPCM(pcm []byte, sample_buffer []int, bytes_per_sample int, qmode int) int {
+	var num_values int = len(pcm) / bytes_per_sample
+	var offset int = 0
+
+	if num_values > len(sample_buffer) {
+		num_values = len(sample_buffer)
+	}
+
+	if (qmode & QMODE_UNSIGNED_WORDS) != 0 {
+		if bytes_per_sample == 2 {
+			offset = 0x8000
+		} else if bytes_per_sample == 3 {
+			offset = 0x800000
+		}
+	}
+
+	switch bytes_per_sample {
+	case 1:
+		if (qmode & QMODE_SIGNED_BYTES) != 0 {
+			for i := 0; i < num_values; i++ {
+				pcm[i] = byte(sample_buffer[i])
+			}
+		} else {
+			for i := 0; i < num_values; i++ {
+				pcm[i] = byte(sample_buffer[i] + 128)
+			}
+		}
+
+	case 2, 3, 4:
+		var dst []byte = pcm[0 : num_values*bytes_per_sample]
+
+		for i := 0; i < num_values; i++ {
+			var value uint32 = uint32(sample_buffer[i] + offset)
+
+			for j := 0; j < bytes_per_sample; j++ {
+				if (qmode & QMODE_BIG_ENDIAN) != 0 {
+					dst[i*bytes_per_sample+j] = byte(value >> uint((bytes_per_sample-1-j)*8))
+				} else {
+					dst[i*bytes_per_sample+j] = byte(value >> uint(j*8))
+				}
+			}
+		}
+
+	default:
+		return 0
+	}
+
+	return num_values
+}
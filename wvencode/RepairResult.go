@@ -0,0 +1,24 @@
+package wvencode
+
+/*
+** RepairResult.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+type WavpackRepairResult struct {
+	Blocks_kept       int
+	Blocks_dropped    int                   // complete blocks that failed their crc or were out of sequence
+	Damaged_blocks    []WavpackDamagedBlock // the audio blocks that were dropped for failing their crc
+	Gaps              []WavpackDamagedBlock // samples (numbered as in the damaged file) with no block kept, closed up in the repaired file
+	Bytes_skipped     int64                 // bytes that weren't part of any block (including truncated blocks)
+	Old_total_samples int64                 // the total_samples of the first block, -1 if unknown
+	Total_samples     int64                 // the total_samples written to the first block
+	Tag_bytes         int                   // size of the APEv2 tag that was kept, 0 if there wasn't one
+	Md5_dropped       int                   // TRUE if the MD5 signature was dropped because audio was lost
+}
@@ -0,0 +1,278 @@
+package wvencode
+
+/*
+** RepairUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"io"
+)
+
+// Rebuild a playable WavPack file from the damaged WavPack file "infile"
+// (typically one that was cut off, say by the encoder being killed) and
+// write it to "outfile". The blocks are read with the block reader, so
+// garbage and truncated blocks are skipped, and every audio block is
+// unpacked on its own and dropped if it fails its crc (or if it overlaps
+// the audio of the blocks before it). The audio blocks kept get new block
+// indexes so that their audio runs on without a gap wherever blocks were
+// lost (including at the start), and the total_samples of the first block
+// is rewritten to match the audio that was recovered. If any audio was lost
+// then the MD5 signature can't match what's left, so it's dropped (and the
+// configuration no longer says that there's one). An APEv2 tag at the end
+// of the file is kept if it can still be read. The blocks are otherwise
+// written as they were. What was done is returned in "result", and a return
+// of FALSE indicates an error (see WavpackGetErrorMessage()), including
+// there being no audio to recover. Correction files are not repaired.
+func WavpackRepairFile(wpc *WavpackContext, infile io.ReadSeeker, outfile io.Writer, result *WavpackRepairResult) int {
+	var footer []byte = make([]byte, APE_TAG_HEADER_SIZE)
+	var tag []byte
+	var tag_start int64
+	var stream_end int64
+	var br BlockReader
+	var block WavpackBlock
+	var blocks [][]byte
+	var first_audio int = -1
+	var next_index int64 = -1
+	var new_index int64 = 0
+
+	*result = WavpackRepairResult{}
+	result.Old_total_samples = -1
+
+	stream_end, err := infile.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		wpc.error_message = "can't seek in the WavPack file!"
+
+		return FALSE
+	}
+
+	// the blocks are only searched for ahead of the tag
+	if tag, tag_start = read_ape_tag(infile, footer); tag != nil {
+		stream_end = tag_start
+		result.Tag_bytes = len(tag)
+	}
+
+	if _, err = infile.Seek(0, io.SeekStart); err != nil {
+		wpc.error_message = "can't seek in the WavPack file!"
+
+		return FALSE
+	}
+
+	WavpackOpenBlockReader(&br, io.LimitReader(infile, stream_end))
+
+	for WavpackReadBlock(&br, &block) == TRUE {
+		result.Bytes_skipped += int64(block.Bytes_skipped)
+
+		if block.Header.block_samples != 0 {
+			if (next_index >= 0) && (get_block_index(&block.Header) < next_index) {
+				result.Blocks_dropped++
+				continue
+			}
+
+			if unpack_whole_block(block.Data, nil) == nil {
+				result.Damaged_blocks = append(result.Damaged_blocks, WavpackDamagedBlock{get_block_index(&block.Header),
+					int64(block.Header.block_samples), FALSE, FALSE})
+				result.Blocks_dropped++
+				continue
+			}
+
+			if first_audio < 0 {
+				first_audio = len(blocks)
+				result.Old_total_samples = get_total_samples(&block.Header)
+				next_index = 0
+			}
+
+			if get_block_index(&block.Header) > next_index {
+				result.Gaps = append(result.Gaps, WavpackDamagedBlock{next_index,
+					get_block_index(&block.Header) - next_index, TRUE, FALSE})
+			}
+
+			// the audio of the blocks kept is numbered from the start again
+			next_index = block_end_index(&block.Header)
+			write_block_index(block.Data, new_index)
+			new_index += int64(block.Header.block_samples)
+		}
+
+		blocks = append(blocks, block.Data)
+	}
+
+	if message := WavpackGetBlockReaderError(&br); message != "" {
+		wpc.error_message = message
+
+		return FALSE
+	}
+
+	result.Bytes_skipped += WavpackGetTrailingBytes(&br)
+	result.Blocks_kept = len(blocks)
+
+	if first_audio < 0 {
+		wpc.error_message = "no audio could be recovered from the file!"
+
+		return FALSE
+	}
+
+	// the total is read from the first block with audio, but any blocks
+	// ahead of it are given the total too
+	result.Total_samples = new_index
+
+	for i := 0; i <= first_audio; i++ {
+		write_total_samples(blocks[i], result.Total_samples)
+	}
+
+	if (len(result.Damaged_blocks) != 0) || (len(result.Gaps) != 0) ||
+		((result.Old_total_samples >= 0) && (result.Old_total_samples != result.Total_samples)) {
+		for i := range blocks {
+			var data []byte = drop_md5_checksum(blocks[i])
+
+			if len(data) != len(blocks[i]) {
+				result.Md5_dropped = TRUE
+			}
+
+			blocks[i] = data
+		}
+	}
+
+	for _, data := range blocks {
+		if written, err := outfile.Write(data); (written != len(data)) || (err != nil) {
+			wpc.error_message = "can't write WavPack data, disk probably full!"
+
+			return FALSE
+		}
+	}
+
+	if tag != nil {
+		if written, err := outfile.Write(tag); (written != len(tag)) || (err != nil) {
+			wpc.error_message = "can't write WavPack data, disk probably full!"
+
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Return the block in "blockbuff" without its MD5 signature, and with
+// CONFIG_MD5_CHECKSUM cleared in its configuration. The block is returned
+// as it is if it has neither.
+func drop_md5_checksum(blockbuff []byte) []byte {
+	var sub_blocks []WavpackSubBlock = parse_sub_blocks(blockbuff)
+	var block []byte = make([]byte, WAVPACK_HEADER_SIZE, len(blockbuff))
+	var ckSize int
+
+	copy(block, blockbuff[0:WAVPACK_HEADER_SIZE])
+
+	for i, sub_block := range sub_blocks {
+		var end int = len(blockbuff)
+
+		if i+1 < len(sub_blocks) {
+			end = sub_blocks[i+1].Offset
+		}
+
+		if sub_block.Id == int(ID_MD5_CHECKSUM) {
+			continue
+		}
+
+		block = append(block, blockbuff[sub_block.Offset:end]...)
+
+		if (sub_block.Id == ID_CONFIG_BLOCK) && (sub_block.Size >= 3) {
+			clear_md5_flag(block[len(block)-(end-sub_block.Offset):])
+		}
+	}
+
+	ckSize = len(block) - 8
+	block[4] = byte(ckSize)
+	block[5] = byte(ckSize >> 8)
+	block[6] = byte(ckSize >> 16)
+	block[7] = byte(ckSize >> 24)
+
+	return block
+}
+
+// Unpack the audio block in "blockbuff" on its own (with its correction
+// block, if "wvc_blockbuff" isn't nil) and return its samples, or nil if it
+// (or the correction block) can't be unpacked or fails its crc. Every block holds all that's needed to
+// unpack it, except for the format details that only the first block of a
+// file has, and those don't change the samples.
+func unpack_whole_block(blockbuff []byte, wvc_blockbuff []byte) []int {
+	var wpc *WavpackContext = new(WavpackContext)
+	var wvc_infile io.Reader = nil
+	var block_samples uint
+
+	if wvc_blockbuff != nil {
+		wvc_infile = bytes.NewReader(wvc_blockbuff)
+	}
+
+	if WavpackOpenFileInput(wpc, bytes.NewReader(blockbuff), wvc_infile) == FALSE {
+		return nil
+	}
+
+	// the total in the header may be wrong (or not apply to this block), and
+	// the samples before the block aren't a gap, just in other blocks
+	wpc.total_samples = -1
+	wpc.stream.sample_index = get_block_index(&wpc.stream.wphdr)
+	block_samples = uint(wpc.stream.wphdr.block_samples)
+
+	var buffer []int = make([]int, int(block_samples)*WavpackGetNumChannels(wpc))
+
+	if (WavpackUnpackSamples(wpc, buffer, block_samples) != block_samples) || (WavpackGetNumErrors(wpc) != 0) {
+		return nil
+	}
+
+	// a damaged correction block would have been quietly left out
+	if (wvc_blockbuff != nil) && (WavpackLossyBlocks(wpc) == TRUE) {
+		return nil
+	}
+
+	return buffer
+}
+
+// Read the whole of the APEv2 tag at the end of "infile" (see find_ape_tag()),
+// including its header if it has one, and return it with its position. If
+// the header is missing then the tag is returned without it (and the footer
+// is changed to say so). A nil tag means that there's no valid tag.
+func read_ape_tag(infile io.ReadSeeker, footer []byte) ([]byte, int64) {
+	var tag_end int64
+	var tag_start int64
+	var flags uint
+	var tag []byte
+
+	if tag_end = find_ape_tag(infile, footer); tag_end < 0 {
+		return nil, -1
+	}
+
+	tag_start = tag_end - int64(int(footer[12])+(int(footer[13])<<8)+(int(footer[14])<<16)+(int(footer[15])<<24))
+	flags = uint(footer[20]) + (uint(footer[21]) << 8) + (uint(footer[22]) << 16) + (uint(footer[23]) << 24)
+
+	if ((flags & APE_TAG_CONTAINS_HEADER) != 0) && (tag_start >= int64(APE_TAG_HEADER_SIZE)) {
+		var header []byte = make([]byte, APE_TAG_HEADER_SIZE)
+
+		infile.Seek(tag_start-int64(APE_TAG_HEADER_SIZE), io.SeekStart)
+
+		if n, _ := io.ReadFull(infile, header); (n == APE_TAG_HEADER_SIZE) && (string(header[0:8]) == "APETAGEX") {
+			tag_start -= int64(APE_TAG_HEADER_SIZE)
+		} else {
+			flags &= ^APE_TAG_CONTAINS_HEADER
+		}
+	} else {
+		flags &= ^APE_TAG_CONTAINS_HEADER
+	}
+
+	tag = make([]byte, tag_end-tag_start)
+	infile.Seek(tag_start, io.SeekStart)
+
+	if n, _ := io.ReadFull(infile, tag); n != len(tag) {
+		return nil, -1
+	}
+
+	tag[len(tag)-APE_TAG_HEADER_SIZE+23] = byte(flags >> 24)
+
+	return tag, tag_start
+}
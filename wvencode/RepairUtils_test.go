@@ -0,0 +1,129 @@
+package wvencode
+
+/*
+** RepairUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Pack "num_samples" of the test audio, with an MD5 signature, in blocks
+// that are 20000 samples long.
+func pack_repair_audio(t *testing.T, num_samples int) ([]int, []byte) {
+	var samples []int = make_test_audio(num_samples)
+	var config WavpackConfig
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Block_samples = 20000
+	config.Flags = CONFIG_MD5_CHECKSUM
+
+	wv, _ := pack_test_audio(t, config, samples)
+
+	return samples, wv
+}
+
+// Return the offset and header of each block of the WavPack file "wv".
+func test_blocks(t *testing.T, wv []byte) ([]int, []WavpackHeader) {
+	var br BlockReader
+	var block WavpackBlock
+	var offsets []int
+	var headers []WavpackHeader
+	var offset int = 0
+
+	WavpackOpenBlockReader(&br, bytes.NewReader(wv))
+
+	for WavpackReadBlock(&br, &block) == TRUE {
+		offset += block.Bytes_skipped
+		offsets = append(offsets, offset)
+		headers = append(headers, block.Header)
+		offset += len(block.Data)
+	}
+
+	if len(offsets) < 4 {
+		t.Fatalf("only %d blocks packed", len(offsets))
+	}
+
+	return offsets, headers
+}
+
+func repair_test_audio(t *testing.T, wv []byte, result *WavpackRepairResult) []byte {
+	var output bytes.Buffer
+	var wpc WavpackContext
+
+	if WavpackRepairFile(&wpc, bytes.NewReader(wv), &output, result) == FALSE {
+		t.Fatalf("can't repair the file: %s", WavpackGetErrorMessage(&wpc))
+	}
+
+	return output.Bytes()
+}
+
+// A file with a damaged block in the middle passes verification once it's
+// repaired, since the MD5 signature that no longer matches is dropped, and
+// the audio is what's left of the original with the lost samples closed up.
+func TestRepairThenVerify(t *testing.T) {
+	var repair_result WavpackRepairResult
+	var verify_result WavpackVerifyResult
+
+	samples, wv := pack_repair_audio(t, 100000)
+	offsets, headers := test_blocks(t, wv)
+	var lost_start int = int(get_block_index(&headers[2]))
+	var lost_end int = int(block_end_index(&headers[2]))
+
+	// damage the audio of the third block (after its header and first items)
+	for i := offsets[2] + 100; i < offsets[2]+110; i++ {
+		wv[i] ^= 0x55
+	}
+
+	repaired := repair_test_audio(t, wv, &repair_result)
+
+	if (len(repair_result.Damaged_blocks) != 1) || (repair_result.Md5_dropped == FALSE) {
+		t.Fatalf("%d blocks found damaged, MD5 dropped is %d", len(repair_result.Damaged_blocks), repair_result.Md5_dropped)
+	}
+
+	wpc := open_test_audio(t, repaired, nil)
+
+	if WavpackVerifyFile(wpc, &verify_result) == FALSE {
+		t.Fatalf("the repaired file fails verification: %s", WavpackGetErrorMessage(wpc))
+	}
+
+	if verify_result.Md5_stored == TRUE {
+		t.Errorf("the repaired file still has an MD5 signature")
+	}
+
+	var expected []int = append(append([]int(nil), samples[0:lost_start*2]...), samples[lost_end*2:]...)
+
+	compare_test_audio(t, "repaired", expected, unpack_test_audio(open_test_audio(t, repaired, nil)))
+}
+
+// When no audio is lost the MD5 signature is kept, and still matches.
+func TestRepairKeepsMD5(t *testing.T) {
+	var repair_result WavpackRepairResult
+	var verify_result WavpackVerifyResult
+
+	_, wv := pack_repair_audio(t, 100000)
+
+	// garbage ahead of the file loses no audio
+	repaired := repair_test_audio(t, append([]byte("garbage"), wv...), &repair_result)
+
+	if repair_result.Md5_dropped == TRUE {
+		t.Errorf("the MD5 signature was dropped with no audio lost")
+	}
+
+	wpc := open_test_audio(t, repaired, nil)
+
+	if (WavpackVerifyFile(wpc, &verify_result) == FALSE) || (verify_result.Md5_match == FALSE) {
+		t.Errorf("the repaired file fails verification: %s", WavpackGetErrorMessage(wpc))
+	}
+}
@@ -0,0 +1,249 @@
+package wvencode
+
+/*
+** SeekUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+)
+
+// When the binary search for a block has narrowed the range down to this
+// many bytes it becomes a simple scan through the blocks.
+const SEEK_SCAN_BYTES int64 = 1 << 16
+
+// Seek to the specified sample index, returning TRUE on success. Blocks can
+// only be unpacked from their start, so the block containing the sample is
+// found and the samples ahead of it in the block are unpacked and discarded.
+// The samples unpacked after a seek are the same as they would be if the
+// file had been unpacked from the start. Within the current block a seek is
+// always possible, otherwise a stream that isn't seekable (such as a pipe)
+// can only seek forward, by reading through the blocks in between. On a
+// FALSE return the position is undefined (and the reason can be retrieved
+// with WavpackGetErrorMessage()).
+func WavpackSeekSample64(wpc *WavpackContext, sample int64) int {
+	var wps *WavpackStream = &wpc.stream
+
+	if (sample < 0) || ((wpc.total_samples >= 0) && (sample >= wpc.total_samples)) {
+		wpc.error_message = "can't seek past the end of the file!"
+
+		return FALSE
+	}
+
+	// if the sample isn't in the block we have, find the block that it is in
+	if (wps.wphdr.block_samples == 0) || (sample < get_block_index(&wps.wphdr)) ||
+		(sample >= block_end_index(&wps.wphdr)) {
+		if infile, ok := wpc.infile.(io.ReadSeeker); ok {
+			if find_block(infile, sample, &wps.wphdr, &wps.blockbuff) == FALSE {
+				return seek_past_end(wpc, sample)
+			}
+
+			wps.block2buff = nil
+			wps.wvc_pending = nil
+			wpc.resync_bytes = nil
+
+			if wvc_infile, ok := wpc.wvc_infile.(io.ReadSeeker); ok {
+				var wvc_hdr WavpackHeader
+
+				// the correction block found is held as if it had been read ahead
+				if find_block(wvc_infile, get_block_index(&wps.wphdr), &wvc_hdr, &wps.wvc_pending) == TRUE {
+					read_wvc_block(wpc)
+				} else {
+					wps.wvc_pending = nil
+				}
+			} else {
+				wpc.wvc_infile = nil
+			}
+		} else {
+			if sample < wps.sample_index {
+				wpc.error_message = "can't seek backwards in a stream that isn't seekable!"
+
+				return FALSE
+			}
+
+			for {
+				if read_main_block(wpc) == FALSE {
+					return seek_past_end(wpc, sample)
+				}
+
+				wps.block2buff = nil
+
+				if wps.wphdr.block_samples != 0 {
+					if wpc.wvc_flag != 0 {
+						read_wvc_block(wpc)
+					}
+
+					if block_end_index(&wps.wphdr) > sample {
+						break
+					}
+				}
+			}
+		}
+
+		if unpack_init(wpc) == FALSE {
+			wps.mute_error = TRUE
+		}
+	} else if (sample < wps.sample_index) && (wps.block_unpacked == FALSE) {
+		// back to the start of the block we have
+		if unpack_init(wpc) == FALSE {
+			wps.mute_error = TRUE
+		}
+	}
+
+	wps.end_of_stream = FALSE
+
+	// the sample may be in a gap between blocks, which unpacks as silence
+	if sample < get_block_index(&wps.wphdr) {
+		wps.sample_index = sample
+
+		return TRUE
+	}
+
+	// when concealing errors the block is unpacked whole, so there's nothing
+	// to skip (and if it has been unpacked already it's used as it is)
+	if (wpc.conceal_errors != FALSE) || (wps.block_unpacked != FALSE) {
+		wps.sample_index = sample
+
+		return TRUE
+	}
+
+	skip_samples(wpc, sample)
+
+	return TRUE
+}
+
+// Seek to the specified sample index (see WavpackSeekSample64()).
+func WavpackSeekSample(wpc *WavpackContext, sample uint) int {
+	return WavpackSeekSample64(wpc, int64(sample))
+}
+
+// Handle a seek to a sample that no block was found for. Normally that's a
+// failure, but when errors are concealed and the stream has ended early,
+// the rest of it is silence (and is reported as missing).
+func seek_past_end(wpc *WavpackContext, sample int64) int {
+	var wps *WavpackStream = &wpc.stream
+
+	if (wpc.conceal_errors == FALSE) || (wpc.total_samples < 0) {
+		wpc.error_message = "can't find the block for the sample!"
+
+		return FALSE
+	}
+
+	report_damage(wpc, WavpackDamagedBlock{sample, wpc.total_samples - sample, TRUE, FALSE})
+	wps.wphdr.block_samples = 0
+	wps.block2buff = nil
+	wps.wvc_pending = nil
+	wps.block_unpacked = FALSE
+	wps.end_of_stream = TRUE
+	wps.sample_index = sample
+
+	return TRUE
+}
+
+// Unpack and discard the samples of the current block up to (but not
+// including) the specified sample index.
+func skip_samples(wpc *WavpackContext, sample int64) {
+	var wps *WavpackStream = &wpc.stream
+	var discard []int
+
+	for sample > wps.sample_index {
+		var samples_to_unpack int64 = sample - wps.sample_index
+
+		if samples_to_unpack > int64(SAMPLE_BUFFER_SIZE) {
+			samples_to_unpack = int64(SAMPLE_BUFFER_SIZE)
+		}
+
+		if discard == nil {
+			discard = make([]int, SAMPLE_BUFFER_SIZE*2)
+		}
+
+		unpack_samples(wpc, discard, uint(samples_to_unpack))
+	}
+}
+
+// Find the block containing "sample" in the seekable stream "infile" and
+// read it into the specified buffer, leaving the stream positioned after it.
+// The search is a binary search on the position in the stream (using the
+// block_index of the first block with audio after each position tried) down
+// to SEEK_SCAN_BYTES, and then a scan through the blocks from there. If the
+// sample is in a gap between blocks, the block after the gap is returned. A
+// return of FALSE means that no such block was found.
+func find_block(infile io.ReadSeeker, sample int64, wphdr *WavpackHeader, blockbuff *[]byte) int {
+	var low int64 = 0
+	var high int64
+
+	high, err := infile.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return FALSE
+	}
+
+	for high-low > SEEK_SCAN_BYTES {
+		var middle int64 = low + (high-low)/2
+		var pos int64
+
+		if pos = next_audio_header(infile, middle, wphdr); (pos < 0) || (pos >= high) ||
+			(get_block_index(wphdr) > sample) {
+			high = middle
+		} else {
+			low = pos
+
+			if block_end_index(wphdr) > sample {
+				break
+			}
+		}
+	}
+
+	if _, err = infile.Seek(low, io.SeekStart); err != nil {
+		return FALSE
+	}
+
+	for {
+		if read_block(nil, infile, wphdr, blockbuff) == FALSE {
+			return FALSE
+		}
+
+		if (wphdr.block_samples != 0) && (block_end_index(wphdr) > sample) {
+			return TRUE
+		}
+	}
+}
+
+// Find the first block with audio at or after "pos" in "infile" and read its
+// header into "wphdr", returning the position of the block (or -1 if there
+// isn't one). Blocks without audio are jumped over using their ckSize.
+func next_audio_header(infile io.ReadSeeker, pos int64, wphdr *WavpackHeader) int64 {
+	var header []byte = make([]byte, WAVPACK_HEADER_SIZE)
+
+	if _, err := infile.Seek(pos, io.SeekStart); err != nil {
+		return -1
+	}
+
+	for {
+		var bytes_skipped int = read_next_header(infile, header)
+
+		if bytes_skipped < 0 {
+			return -1
+		}
+
+		pos += int64(bytes_skipped)
+		read_header(header, wphdr)
+
+		if wphdr.block_samples != 0 {
+			return pos
+		}
+
+		pos += int64(wphdr.ckSize + 8)
+
+		if _, err := infile.Seek(pos, io.SeekStart); err != nil {
+			return -1
+		}
+	}
+}
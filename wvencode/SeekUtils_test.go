@@ -0,0 +1,108 @@
+package wvencode
+
+/*
+** SeekUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"testing"
+)
+
+// Make "num_samples" of stereo DSD audio (as bytes of 8 bits, MSB first),
+// a slowly changing pattern with some noise so that it neither packs to
+// nothing nor fails to pack at all.
+func make_dsd_audio(num_samples int) []int {
+	var samples []int = make([]int, num_samples*2)
+	var seed uint32 = 99
+
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] = (0x69 ^ (i / 2000)) ^ int((seed>>29)&1)
+	}
+
+	return samples
+}
+
+// Seek to the sample "position" of "wpc" and check that what is unpacked
+// from there is the same as "expected" (the whole of the audio unpacked
+// from the start) from that point.
+func check_seek(t *testing.T, name string, wpc *WavpackContext, expected []int, position int64) {
+	var num_channels int = WavpackGetNumChannels(wpc)
+	var count int = len(expected)/num_channels - int(position)
+	var buffer []int
+
+	if count > 5000 {
+		count = 5000
+	}
+
+	if WavpackSeekSample64(wpc, position) == FALSE {
+		t.Fatalf("%s: can't seek to sample %d: %s", name, position, WavpackGetErrorMessage(wpc))
+	}
+
+	buffer = make([]int, count*num_channels)
+
+	if WavpackUnpackSamples(wpc, buffer, uint(count)) != uint(count) {
+		t.Fatalf("%s: can't unpack %d samples at sample %d", name, count, position)
+	}
+
+	compare_test_audio(t, name, expected[int(position)*num_channels:(int(position)+count)*num_channels], buffer)
+}
+
+// Unpacking after a seek (forwards, backwards, within a block, to the start
+// or the end of a block and to the last sample) gives the same samples as
+// unpacking from the start, for lossless, lossy and DSD audio.
+func TestSeekMatchesLinear(t *testing.T) {
+	var num_samples int = 200000
+	var positions []int64 = []int64{100000, 1, 0, 22049, 22050, 150001, 44100, 3, int64(num_samples) - 1, 77777}
+
+	for _, test := range []struct {
+		name    string
+		flags   uint
+		qmode   int
+		samples []int
+	}{
+		{"lossless", 0, 0, make_test_audio(num_samples)},
+		{"high", CONFIG_HIGH_FLAG, 0, make_test_audio(num_samples)},
+		{"hybrid", CONFIG_HYBRID_FLAG, 0, make_test_audio(num_samples)},
+		{"hybrid with correction", CONFIG_HYBRID_FLAG | CONFIG_CREATE_WVC, 0, make_test_audio(num_samples)},
+		{"dsd", 0, QMODE_DSD_MSB_FIRST, make_dsd_audio(num_samples)},
+		{"dsd high", CONFIG_HIGH_FLAG, QMODE_DSD_MSB_FIRST, make_dsd_audio(num_samples)},
+	} {
+		var config WavpackConfig
+
+		config.Bits_per_sample = 16
+		config.Bytes_per_sample = 2
+		config.Num_channels = 2
+		config.Sample_rate = 44100
+		config.Flags = test.flags
+		config.Qmode = test.qmode
+		config.Bitrate = 3 * 256
+
+		if test.qmode != 0 {
+			config.Bits_per_sample = 8
+			config.Bytes_per_sample = 1
+			config.Sample_rate = 352800
+		}
+
+		wv, wvc := pack_test_audio(t, config, test.samples)
+		expected := unpack_test_audio(open_test_audio(t, wv, wvc))
+
+		// the audio is only as it went in if it's lossless
+		if ((test.flags & CONFIG_HYBRID_FLAG) == 0) || (wvc != nil) {
+			compare_test_audio(t, test.name, test.samples, expected)
+		}
+
+		wpc := open_test_audio(t, wv, wvc)
+
+		for _, position := range positions {
+			check_seek(t, test.name, wpc, expected, position)
+		}
+	}
+}
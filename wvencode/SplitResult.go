@@ -0,0 +1,18 @@
+package wvencode
+
+/*
+** SplitResult.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+type WavpackSplitResult struct {
+	Split_points  []int64 // where the file was split (after any were moved to block boundaries)
+	Blocks_copied int     // blocks copied as they were (apart from their headers)
+	Blocks_packed int     // blocks packed from the audio of the blocks that straddled split points
+}
@@ -0,0 +1,476 @@
+package wvencode
+
+/*
+** SplitUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"io"
+)
+
+// Split the WavPack stream "infile" (and its correction stream "wvc_infile",
+// if it isn't nil and "wvc_outfiles" isn't nil either) at the sample indexes
+// in "split_points", which must be in increasing order, writing the pieces
+// to "outfiles" (and "wvc_outfiles"), of which there must be one more than
+// there are split points. The blocks that fall entirely within a piece are
+// copied as they are, with only the block_index (so that each piece starts
+// at zero) and total_samples in their headers changed. A block straddling a
+// split point is unpacked and its audio either side of the split is packed
+// again, losslessly and in the same compression mode (a hybrid block packed
+// without its correction block is packed from the lossy audio, so nothing
+// more is lost). If "block_boundaries" is TRUE then each split point is
+// instead moved to the nearest block boundary, so nothing is packed again,
+// and this is the only way DSD audio (which isn't packed again) can be split.
+//
+// The first block of each piece gets the format information of the original
+// file, but not its header or trailer (the wrapper) or its MD5 signature,
+// which are for the whole file. The split points used and the number of
+// blocks copied and packed are returned in "result". A return of FALSE
+// indicates an error (see WavpackGetErrorMessage()), which might have come
+// after some of the pieces were written.
+func WavpackSplitFile(wpc *WavpackContext, infile io.Reader, wvc_infile io.Reader, split_points []int64,
+	block_boundaries int, outfiles []io.Writer, wvc_outfiles []io.Writer, result *WavpackSplitResult) int {
+	var br BlockReader
+	var wvc_br BlockReader
+	var block WavpackBlock
+	var wvc_block WavpackBlock
+	var wvc_pending int = FALSE
+	var format *WavpackContext = nil
+	var format_items []byte
+	var blocks [][]byte
+	var wvc_blocks [][]byte
+	var piece int = 0
+	var audio_end int64 = 0
+
+	*result = WavpackSplitResult{}
+	result.Split_points = append([]int64(nil), split_points...)
+
+	var cuts []int64 = result.Split_points
+
+	if len(outfiles) != len(cuts)+1 {
+		wpc.error_message = "there must be one more output than there are split points!"
+
+		return FALSE
+	}
+
+	for i := 0; i < len(cuts); i++ {
+		if cuts[i] <= 0 {
+			wpc.error_message = "a split point at the start of the file would leave a piece empty!"
+
+			return FALSE
+		}
+
+		if (i > 0) && (cuts[i] <= cuts[i-1]) {
+			wpc.error_message = "the split points must be in increasing order!"
+
+			return FALSE
+		}
+	}
+
+	if wvc_outfiles == nil {
+		wvc_infile = nil
+	}
+
+	WavpackOpenBlockReader(&br, infile)
+
+	if wvc_infile != nil {
+		WavpackOpenBlockReader(&wvc_br, wvc_infile)
+	}
+
+	for WavpackReadBlock(&br, &block) == TRUE {
+		var block_start int64 = get_block_index(&block.Header)
+		var block_end int64 = block_end_index(&block.Header)
+		var wvc_data []byte = nil
+
+		// blocks without audio hold the trailer and MD5 signature of the
+		// whole file, so they're left out
+		if block.Header.block_samples == 0 {
+			continue
+		}
+
+		if format == nil {
+			format = new(WavpackContext)
+
+			if WavpackOpenFileInput(format, bytes.NewReader(block.Data), nil) == FALSE {
+				wpc.error_message = "not compatible with this version of WavPack file!"
+
+				return FALSE
+			}
+
+			if (len(cuts) > 0) && (get_total_samples(&block.Header) >= 0) &&
+				(cuts[len(cuts)-1] >= get_total_samples(&block.Header)) {
+				wpc.error_message = "can't split past the end of the file!"
+
+				return FALSE
+			}
+
+			format_items = file_format_items(&block)
+		}
+
+		// the correction block is matched by its index, as when unpacking
+		for wvc_infile != nil {
+			if wvc_pending == FALSE {
+				if WavpackReadBlock(&wvc_br, &wvc_block) == FALSE {
+					break
+				}
+
+				wvc_pending = TRUE
+			}
+
+			if get_block_index(&wvc_block.Header) > block_start {
+				break
+			}
+
+			wvc_pending = FALSE
+
+			if (get_block_index(&wvc_block.Header) == block_start) &&
+				(wvc_block.Header.block_samples == block.Header.block_samples) {
+				wvc_data = wvc_block.Data
+				break
+			}
+		}
+
+		if block_boundaries == TRUE {
+			for i := piece; (i < len(cuts)) && (cuts[i] < block_end); i++ {
+				if cuts[i] <= block_start {
+					continue
+				}
+
+				if cuts[i]-block_start <= block_end-cuts[i] {
+					cuts[i] = block_start
+				} else {
+					cuts[i] = block_end
+				}
+
+				if (cuts[i] == 0) || ((i > 0) && (cuts[i] <= cuts[i-1])) {
+					wpc.error_message = "a split point can't be moved to a block boundary without leaving a piece empty!"
+
+					return FALSE
+				}
+			}
+		}
+
+		// a block at (or after a gap past) a split point starts the next piece
+		for (piece < len(cuts)) && (block_start >= cuts[piece]) {
+			if write_split_piece(wpc, blocks, wvc_blocks, piece, cuts, audio_end, format_items, outfiles, wvc_outfiles) == FALSE {
+				return FALSE
+			}
+
+			blocks = nil
+			wvc_blocks = nil
+			piece++
+		}
+
+		if (piece == len(cuts)) || (block_end <= cuts[piece]) {
+			blocks = append(blocks, block.Data)
+
+			if wvc_data != nil {
+				wvc_blocks = append(wvc_blocks, wvc_data)
+			}
+
+			result.Blocks_copied++
+			audio_end = block_end
+			continue
+		}
+
+		// the block straddles a split point, so its audio is packed again
+		if (block.Header.flags & DSD_FLAG) != 0 {
+			wpc.error_message = "DSD audio can only be split at block boundaries!"
+
+			return FALSE
+		}
+
+		var samples []int = unpack_whole_block(block.Data, wvc_data)
+		var num_channels int = WavpackGetNumChannels(format)
+
+		if samples == nil {
+			wpc.error_message = "a block that has to be split is damaged!"
+
+			return FALSE
+		}
+
+		for start := block_start; start < block_end; {
+			var end int64 = block_end
+
+			if (piece < len(cuts)) && (cuts[piece] < end) {
+				end = cuts[piece]
+			}
+
+			packed := pack_split_samples(wpc, format, samples[int(start-block_start)*num_channels:int(end-block_start)*num_channels],
+				uint(end-start))
+
+			if packed == nil {
+				return FALSE
+			}
+
+			for _, data := range packed {
+				var wphdr WavpackHeader
+
+				read_header(data, &wphdr)
+				write_block_index(data, get_block_index(&wphdr)+start)
+			}
+
+			blocks = append(blocks, packed...)
+			result.Blocks_packed += len(packed)
+			audio_end = end
+
+			if end < block_end {
+				if write_split_piece(wpc, blocks, wvc_blocks, piece, cuts, audio_end, format_items, outfiles, wvc_outfiles) == FALSE {
+					return FALSE
+				}
+
+				blocks = nil
+				wvc_blocks = nil
+				piece++
+			}
+
+			start = end
+		}
+	}
+
+	if message := WavpackGetBlockReaderError(&br); message != "" {
+		wpc.error_message = message
+
+		return FALSE
+	}
+
+	if format == nil {
+		wpc.error_message = "not compatible with this version of WavPack file!"
+
+		return FALSE
+	}
+
+	if piece < len(cuts) {
+		wpc.error_message = "can't split past the end of the file!"
+
+		return FALSE
+	}
+
+	return write_split_piece(wpc, blocks, wvc_blocks, piece, cuts, audio_end, format_items, outfiles, wvc_outfiles)
+}
+
+// Write the blocks of the piece "piece" of a split file (see
+// WavpackSplitFile()), which runs from the split point before it (or the
+// start) to the one after it (or "audio_end" for the last piece). The block
+// indexes of the blocks are changed to start at zero and the total samples
+// of every block is set to the length of the piece.
+func write_split_piece(wpc *WavpackContext, blocks [][]byte, wvc_blocks [][]byte, piece int, cuts []int64,
+	audio_end int64, format_items []byte, outfiles []io.Writer, wvc_outfiles []io.Writer) int {
+	var start int64 = 0
+	var end int64 = audio_end
+
+	if piece > 0 {
+		start = cuts[piece-1]
+	}
+
+	if piece < len(cuts) {
+		end = cuts[piece]
+	}
+
+	for i, data := range blocks {
+		var items []byte = nil
+
+		if i == 0 {
+			items = format_items
+		}
+
+		data = split_block(data, start, end-start, items)
+
+		if written, err := outfiles[piece].Write(data); (written != len(data)) || (err != nil) {
+			wpc.error_message = "can't write WavPack data, disk probably full!"
+
+			return FALSE
+		}
+	}
+
+	for _, data := range wvc_blocks {
+		data = split_block(data, start, end-start, nil)
+
+		if written, err := wvc_outfiles[piece].Write(data); (written != len(data)) || (err != nil) {
+			wpc.error_message = "can't write WavPack data, disk probably full!"
+
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Returns TRUE for the metadata items that describe the whole file rather
+// than the block that they're in. These are only in the first block.
+func file_item(id int) int {
+	switch id {
+	case ID_CONFIG_BLOCK, ID_NEW_CONFIG_BLOCK, ID_ALT_EXTENSION, ID_ALT_HEADER, ID_ALT_TRAILER,
+		int(ID_RIFF_HEADER), int(ID_RIFF_TRAILER), int(ID_MD5_CHECKSUM):
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Return the raw metadata items of the first block of a file that describe
+// the format of the file, which are all of its file items (see file_item())
+// except for the wrapper and MD5 signature. The configuration no longer
+// says that there's an MD5 signature.
+func file_format_items(block *WavpackBlock) []byte {
+	var items []byte
+
+	for i, sub_block := range block.Sub_blocks {
+		var end int = len(block.Data)
+
+		if i+1 < len(block.Sub_blocks) {
+			end = block.Sub_blocks[i+1].Offset
+		}
+
+		if (sub_block.Id != ID_CONFIG_BLOCK) && (sub_block.Id != ID_NEW_CONFIG_BLOCK) && (sub_block.Id != ID_ALT_EXTENSION) {
+			continue
+		}
+
+		var raw []byte = append([]byte(nil), block.Data[sub_block.Offset:end]...)
+
+		if (sub_block.Id == ID_CONFIG_BLOCK) && (sub_block.Size >= 3) {
+			clear_md5_flag(raw)
+		}
+
+		items = append(items, raw...)
+	}
+
+	return items
+}
+
+// Clear CONFIG_MD5_CHECKSUM in the raw ID_CONFIG_BLOCK item "raw" (which
+// must hold at least the 3 bytes of flags).
+func clear_md5_flag(raw []byte) {
+	var data []byte = raw[2:]
+
+	if (int(raw[0]) & ID_LARGE) != 0 {
+		data = raw[4:]
+	}
+
+	var flags uint = (uint(data[0]) << 8) + (uint(data[1]) << 16) + (uint(data[2]) << 24)
+
+	flags &= ^CONFIG_MD5_CHECKSUM
+	data[0] = byte(flags >> 8)
+	data[1] = byte(flags >> 16)
+	data[2] = byte(flags >> 24)
+}
+
+// Return a copy of the block in "blockbuff" for a piece of a split file that
+// starts at sample "start" of the original and is "total_samples" long. The
+// file items (see file_item()) are left out, and "items" (raw metadata
+// items) are put in ahead of the audio instead.
+func split_block(blockbuff []byte, start int64, total_samples int64, items []byte) []byte {
+	var sub_blocks []WavpackSubBlock = parse_sub_blocks(blockbuff)
+	var block []byte = make([]byte, WAVPACK_HEADER_SIZE, len(blockbuff)+len(items))
+	var wphdr WavpackHeader
+	var ckSize int
+
+	copy(block, blockbuff[0:WAVPACK_HEADER_SIZE])
+
+	for i, sub_block := range sub_blocks {
+		var end int = len(blockbuff)
+
+		if i+1 < len(sub_blocks) {
+			end = sub_blocks[i+1].Offset
+		}
+
+		if (items != nil) && ((sub_block.Id == ID_WV_BITSTREAM) || (sub_block.Id == ID_WVC_BITSTREAM) ||
+			(sub_block.Id == int(ID_WVX_BITSTREAM)) || (sub_block.Id == ID_DSD_BLOCK)) {
+			block = append(block, items...)
+			items = nil
+		}
+
+		if file_item(sub_block.Id) == FALSE {
+			block = append(block, blockbuff[sub_block.Offset:end]...)
+		}
+	}
+
+	block = append(block, items...)
+
+	ckSize = len(block) - 8
+	block[4] = byte(ckSize)
+	block[5] = byte(ckSize >> 8)
+	block[6] = byte(ckSize >> 16)
+	block[7] = byte(ckSize >> 24)
+
+	read_header(block, &wphdr)
+	write_block_index(block, get_block_index(&wphdr)-start)
+	write_total_samples(block, total_samples)
+
+	return block
+}
+
+// Write "index" into the header of the block in "block" as its block_index.
+func write_block_index(block []byte, index int64) {
+	var hdr WavpackHeader
+
+	set_block_index(&hdr, index)
+
+	block[10] = byte(hdr.block_index_u8)
+	block[16] = byte(hdr.block_index)
+	block[17] = byte(hdr.block_index >> 8)
+	block[18] = byte(hdr.block_index >> 16)
+	block[19] = byte(hdr.block_index >> 24)
+}
+
+// Pack "sample_count" samples losslessly in the format (and compression
+// mode) of the file opened in "format", returning the blocks, with block
+// indexes starting at zero. A return of nil indicates an error (which is
+// left in "wpc").
+func pack_split_samples(wpc *WavpackContext, format *WavpackContext, samples []int, sample_count uint) [][]byte {
+	var packer *WavpackContext = new(WavpackContext)
+	var config WavpackConfig
+	var mode int = WavpackGetMode(format)
+	var packed bytes.Buffer
+	var br BlockReader
+	var block WavpackBlock
+	var blocks [][]byte
+
+	config.Bits_per_sample = WavpackGetBitsPerSample(format)
+	config.Bytes_per_sample = WavpackGetBytesPerSample(format)
+	config.Num_channels = uint(WavpackGetNumChannels(format))
+	config.Sample_rate = WavpackGetSampleRate(format)
+	config.Channel_mask = WavpackGetChannelMask(format)
+
+	if (mode & MODE_FLOAT) != 0 {
+		config.Flags |= CONFIG_FLOAT_DATA
+	}
+
+	if (mode & MODE_FAST) != 0 {
+		config.Flags |= CONFIG_FAST_FLAG
+	}
+
+	if (mode & MODE_HIGH) != 0 {
+		config.Flags |= CONFIG_HIGH_FLAG
+	}
+
+	if (mode & MODE_VERY_HIGH) != 0 {
+		config.Flags |= CONFIG_VERY_HIGH_FLAG
+	}
+
+	packer.Outfile = &packed
+
+	if (WavpackSetConfiguration64(packer, &config, int64(sample_count)) == FALSE) ||
+		(WavpackPackInit(packer) == FALSE) || (WavpackPackSamples(packer, samples, sample_count) == FALSE) ||
+		(WavpackFlushSamples(packer) == FALSE) {
+		wpc.error_message = WavpackGetErrorMessage(packer)
+
+		return nil
+	}
+
+	WavpackOpenBlockReader(&br, &packed)
+
+	for WavpackReadBlock(&br, &block) == TRUE {
+		blocks = append(blocks, block.Data)
+	}
+
+	return blocks
+}
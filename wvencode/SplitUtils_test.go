@@ -0,0 +1,141 @@
+package wvencode
+
+/*
+** SplitUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// Split "wv" (with "wvc", if it isn't nil) at "split_points" and return the
+// pieces and the correction files of the pieces.
+func split_test_audio(t *testing.T, wv []byte, wvc []byte, split_points []int64, block_boundaries int,
+	result *WavpackSplitResult) ([][]byte, [][]byte) {
+	var outputs []bytes.Buffer = make([]bytes.Buffer, len(split_points)+1)
+	var wvc_outputs []bytes.Buffer = make([]bytes.Buffer, len(split_points)+1)
+	var outfiles []io.Writer
+	var wvc_outfiles []io.Writer
+	var wvc_infile io.Reader = nil
+	var pieces, wvc_pieces [][]byte
+	var wpc WavpackContext
+
+	for i := range outputs {
+		outfiles = append(outfiles, &outputs[i])
+
+		if wvc != nil {
+			wvc_outfiles = append(wvc_outfiles, &wvc_outputs[i])
+		}
+	}
+
+	if wvc != nil {
+		wvc_infile = bytes.NewReader(wvc)
+	}
+
+	if WavpackSplitFile(&wpc, bytes.NewReader(wv), wvc_infile, split_points, block_boundaries, outfiles,
+		wvc_outfiles, result) == FALSE {
+		t.Fatalf("can't split the file: %s", WavpackGetErrorMessage(&wpc))
+	}
+
+	for i := range outputs {
+		pieces = append(pieces, outputs[i].Bytes())
+
+		if wvc != nil {
+			wvc_pieces = append(wvc_pieces, wvc_outputs[i].Bytes())
+		} else {
+			wvc_pieces = append(wvc_pieces, nil)
+		}
+	}
+
+	return pieces, wvc_pieces
+}
+
+// The pieces of a split file, unpacked one after the other, give back the
+// audio of the whole file, and each piece is as long as it should be. The
+// split points fall within blocks, on a block boundary and next to each
+// other, and with a correction file the pieces are lossless too.
+func TestSplitThenConcatenate(t *testing.T) {
+	var samples []int = make_test_audio(200000)
+	var split_points []int64 = []int64{30000, 44100, 44101, 150001}
+
+	for _, flags := range []uint{0, CONFIG_HIGH_FLAG, CONFIG_HYBRID_FLAG | CONFIG_CREATE_WVC} {
+		var config WavpackConfig
+		var result WavpackSplitResult
+		var joined []int
+
+		config.Bits_per_sample = 16
+		config.Bytes_per_sample = 2
+		config.Num_channels = 2
+		config.Sample_rate = 44100
+		config.Flags = flags
+		config.Bitrate = 3 * 256
+
+		wv, wvc := pack_test_audio(t, config, samples)
+		pieces, wvc_pieces := split_test_audio(t, wv, wvc, split_points, FALSE, &result)
+
+		for i := range pieces {
+			var start int64 = 0
+			var end int64 = int64(len(samples) / 2)
+
+			if i > 0 {
+				start = split_points[i-1]
+			}
+
+			if i < len(split_points) {
+				end = split_points[i]
+			}
+
+			wpc := open_test_audio(t, pieces[i], wvc_pieces[i])
+
+			if WavpackGetNumSamples64(wpc) != end-start {
+				t.Errorf("flags 0x%x: piece %d is %d samples instead of %d", flags, i, WavpackGetNumSamples64(wpc), end-start)
+			}
+
+			joined = append(joined, unpack_test_audio(wpc)...)
+		}
+
+		if result.Blocks_packed == 0 {
+			t.Errorf("flags 0x%x: no blocks were packed again", flags)
+		}
+
+		compare_test_audio(t, "split", samples, joined)
+	}
+}
+
+// Split at block boundaries nothing is packed again, the split points are
+// moved to the nearest boundaries, and the pieces still join up exactly.
+func TestSplitAtBlockBoundaries(t *testing.T) {
+	var samples []int = make_test_audio(200000)
+	var config WavpackConfig
+	var result WavpackSplitResult
+	var joined []int
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Block_samples = 20000
+
+	wv, _ := pack_test_audio(t, config, samples)
+	pieces, _ := split_test_audio(t, wv, nil, []int64{29000, 111111}, TRUE, &result)
+
+	if (result.Blocks_packed != 0) || (len(result.Split_points) != 2) ||
+		(result.Split_points[0] != 20000) || (result.Split_points[1] != 120000) {
+		t.Errorf("split at %v with %d blocks packed", result.Split_points, result.Blocks_packed)
+	}
+
+	for i := range pieces {
+		joined = append(joined, unpack_test_audio(open_test_audio(t, pieces[i], nil))...)
+	}
+
+	compare_test_audio(t, "split at block boundaries", samples, joined)
+}
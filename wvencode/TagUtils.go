@@ -0,0 +1,254 @@
+package wvencode
+
+/*
+** TagUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+	"strings"
+)
+
+const APE_TAG_VERSION uint = 2000
+const APE_TAG_CONTAINS_HEADER uint = 0x80000000
+const APE_TAG_THIS_IS_HEADER uint = 0x20000000
+const APE_TAG_HEADER_SIZE int = 32
+
+// Add the specified item and value to the APEv2 tag that will be written to
+// the end of the WavPack file with WavpackWriteTag(). The value is a UTF-8
+// string (multiple values are separated by NUL characters) and any existing
+// item with the same name is replaced (item names are not case sensitive).
+// Item names must be 2 to 255 printable ASCII characters and some names are
+// reserved by the format. A return of FALSE indicates an error.
+func WavpackAppendTagItem(wpc *WavpackContext, item string, value string) int {
+	if len(item) < 2 || len(item) > 255 {
+		wpc.error_message = "APEv2 tag item name must be 2 to 255 characters!"
+
+		return FALSE
+	}
+
+	for i := 0; i < len(item); i++ {
+		if item[i] < 0x20 || item[i] > 0x7e {
+			wpc.error_message = "APEv2 tag item name must be printable ASCII!"
+
+			return FALSE
+		}
+	}
+
+	if strings.EqualFold(item, "ID3") || strings.EqualFold(item, "TAG") ||
+		strings.EqualFold(item, "OggS") || strings.EqualFold(item, "MP+") {
+		wpc.error_message = "APEv2 tag item name is reserved!"
+
+		return FALSE
+	}
+
+	for i := 0; i < len(wpc.ape_tag_items); i++ {
+		if strings.EqualFold(wpc.ape_tag_items[i].item, item) {
+			wpc.ape_tag_items = append(wpc.ape_tag_items[:i], wpc.ape_tag_items[i+1:]...)
+			break
+		}
+	}
+
+	wpc.ape_tag_items = append(wpc.ape_tag_items, ApeTagItem{item, value})
+
+	return TRUE
+}
+
+// Write the APEv2 tag (with both a header and a footer) to the current
+// position of the WavPack file, which should be after the last block. This
+// does nothing if no items have been added. A return of FALSE indicates an
+// error.
+func WavpackWriteTag(wpc *WavpackContext) int {
+	var tag_size int = APE_TAG_HEADER_SIZE
+	var tag_buff []byte
+	var idx int
+
+	if len(wpc.ape_tag_items) == 0 {
+		return TRUE
+	}
+
+	for i := 0; i < len(wpc.ape_tag_items); i++ {
+		tag_size += 8 + len(wpc.ape_tag_items[i].item) + 1 + len(wpc.ape_tag_items[i].value)
+	}
+
+	tag_buff = make([]byte, APE_TAG_HEADER_SIZE+tag_size)
+	write_ape_tag_header(tag_buff[0:APE_TAG_HEADER_SIZE], tag_size, len(wpc.ape_tag_items),
+		APE_TAG_CONTAINS_HEADER|APE_TAG_THIS_IS_HEADER)
+	idx = APE_TAG_HEADER_SIZE
+
+	for i := 0; i < len(wpc.ape_tag_items); i++ {
+		var value_size uint = uint(len(wpc.ape_tag_items[i].value))
+
+		tag_buff[idx] = byte(value_size)
+		tag_buff[idx+1] = byte(value_size >> 8)
+		tag_buff[idx+2] = byte(value_size >> 16)
+		tag_buff[idx+3] = byte(value_size >> 24)
+		idx += 8 // item flags are zero for UTF-8 text
+		idx += copy(tag_buff[idx:], wpc.ape_tag_items[i].item) + 1
+		idx += copy(tag_buff[idx:], wpc.ape_tag_items[i].value)
+	}
+
+	write_ape_tag_header(tag_buff[idx:idx+APE_TAG_HEADER_SIZE], tag_size, len(wpc.ape_tag_items),
+		APE_TAG_CONTAINS_HEADER)
+
+	if written, err := wpc.Outfile.Write(tag_buff); (written != len(tag_buff)) || (err != nil) {
+		wpc.error_message = "can't write WavPack data, disk probably full!"
+
+		return FALSE
+	}
+
+	wpc.filelen += uint(len(tag_buff))
+
+	return TRUE
+}
+
+// Fill in an APEv2 tag header or footer. The tag size includes the items
+// and the footer, but not the header.
+func write_ape_tag_header(buffer []byte, tag_size int, item_count int, flags uint) {
+	var version uint = APE_TAG_VERSION
+
+	copy(buffer[0:8], "APETAGEX")
+	buffer[8] = byte(version)
+	buffer[9] = byte(version >> 8)
+	buffer[12] = byte(tag_size)
+	buffer[13] = byte(tag_size >> 8)
+	buffer[14] = byte(tag_size >> 16)
+	buffer[15] = byte(tag_size >> 24)
+	buffer[16] = byte(item_count)
+	buffer[17] = byte(item_count >> 8)
+	buffer[18] = byte(item_count >> 16)
+	buffer[19] = byte(item_count >> 24)
+	buffer[20] = byte(flags)
+	buffer[21] = byte(flags >> 8)
+	buffer[22] = byte(flags >> 16)
+	buffer[23] = byte(flags >> 24)
+}
+
+// Read the APEv2 tag at the end of the WavPack file "infile" (ahead of an
+// ID3v1 tag, if there is one) so that its items can be retrieved with
+// WavpackGetTagItem(). Only text items are kept. A return of FALSE means
+// that there is no valid tag. The file position is left at the end.
+func WavpackReadTag(wpc *WavpackContext, infile io.ReadSeeker) int {
+	var footer []byte = make([]byte, APE_TAG_HEADER_SIZE)
+	var tag_end int64
+	var tag_size int
+	var item_count int
+	var tag_buff []byte
+	var idx int = 0
+
+	wpc.ape_tag_items = nil
+
+	if tag_end = find_ape_tag(infile, footer); tag_end < 0 {
+		infile.Seek(0, io.SeekEnd)
+		return FALSE
+	}
+
+	tag_size = int(footer[12]) + (int(footer[13]) << 8) + (int(footer[14]) << 16) + (int(footer[15]) << 24)
+	item_count = int(footer[16]) + (int(footer[17]) << 8) + (int(footer[18]) << 16) + (int(footer[19]) << 24)
+
+	tag_buff = make([]byte, tag_size-APE_TAG_HEADER_SIZE)
+	infile.Seek(tag_end-int64(tag_size), io.SeekStart)
+
+	if n, _ := io.ReadFull(infile, tag_buff); n != len(tag_buff) {
+		infile.Seek(0, io.SeekEnd)
+		return FALSE
+	}
+
+	infile.Seek(0, io.SeekEnd)
+
+	for i := 0; i < item_count; i++ {
+		var value_size int
+		var flags int
+		var name_end int
+
+		if idx+8 > len(tag_buff) {
+			break
+		}
+
+		value_size = int(tag_buff[idx]) + (int(tag_buff[idx+1]) << 8) + (int(tag_buff[idx+2]) << 16) + (int(tag_buff[idx+3]) << 24)
+		flags = int(tag_buff[idx+4])
+		idx += 8
+		name_end = idx
+
+		for (name_end < len(tag_buff)) && (tag_buff[name_end] != 0) {
+			name_end++
+		}
+
+		if (name_end == len(tag_buff)) || (value_size < 0) || (name_end+1+value_size > len(tag_buff)) {
+			break
+		}
+
+		// bits 1 and 2 of the flags are the type of item, with 0 being text
+		if (flags & 6) == 0 {
+			wpc.ape_tag_items = append(wpc.ape_tag_items, ApeTagItem{string(tag_buff[idx:name_end]),
+				string(tag_buff[name_end+1 : name_end+1+value_size])})
+		}
+
+		idx = name_end + 1 + value_size
+	}
+
+	return TRUE
+}
+
+// Find the APEv2 tag at the end of "infile" (ahead of an ID3v1 tag, if there
+// is one) and read its footer into "footer". The position of the end of the
+// tag is returned, or -1 if there is no valid tag.
+func find_ape_tag(infile io.ReadSeeker, footer []byte) int64 {
+	var tag_end int64
+	var tag_size int
+
+	file_size, err := infile.Seek(0, io.SeekEnd)
+
+	if err != nil {
+		return -1
+	}
+
+	tag_end = file_size
+
+	// skip an ID3v1 tag, which is always 128 bytes starting with "TAG"
+	if file_size >= 128 {
+		var id3 []byte = make([]byte, 3)
+
+		infile.Seek(file_size-128, io.SeekStart)
+
+		if n, _ := io.ReadFull(infile, id3); (n == 3) && (string(id3) == "TAG") {
+			tag_end -= 128
+		}
+	}
+
+	if tag_end < int64(APE_TAG_HEADER_SIZE) {
+		return -1
+	}
+
+	infile.Seek(tag_end-int64(APE_TAG_HEADER_SIZE), io.SeekStart)
+
+	if n, _ := io.ReadFull(infile, footer); (n != APE_TAG_HEADER_SIZE) || (string(footer[0:8]) != "APETAGEX") {
+		return -1
+	}
+
+	tag_size = int(footer[12]) + (int(footer[13]) << 8) + (int(footer[14]) << 16) + (int(footer[15]) << 24)
+
+	if (tag_size < APE_TAG_HEADER_SIZE) || (int64(tag_size) > tag_end) {
+		return -1
+	}
+
+	return tag_end
+}
+
+// Get the number of items in the APEv2 tag (either read with WavpackReadTag()
+// or added with WavpackAppendTagItem()).
+func WavpackGetNumTagItems(wpc *WavpackContext) int {
+	return len(wpc.ape_tag_items)
+}
+
+// Get the tag item at "index" as its name and value.
+func WavpackGetTagItem(wpc *WavpackContext, index int) (string, string) {
+	return wpc.ape_tag_items[index].item, wpc.ape_tag_items[index].value
+}
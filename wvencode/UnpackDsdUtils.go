@@ -0,0 +1,438 @@
+package wvencode
+
+/*
+** UnpackDsdUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+// DSD audio is unpacked to bytes of 8 DSD bits (MSB first), one for each
+// channel of each sample, returned in the ints of the buffer. See DsdUtils.go
+// for the "fast" and "high" modes that the blocks are coded in.
+
+// Initialize the decoder for the ID_DSD_BLOCK metadata item of the block in
+// wps.wphdr. The first byte is the power of two of the DSD rate multiplier
+// and the second is the mode, and what follows depends on the mode. A return
+// of FALSE means that the item is corrupt (or uses a mode we don't know).
+func init_dsd_block(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	var wps *WavpackStream = &wpc.stream
+	var dsd *DsdData = &wps.dsd
+	var stereo bool = (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0
+
+	if wpmd.byte_length < 2 {
+		return FALSE
+	}
+
+	wpc.dsd_multiplier = uint(1) << (wpmd.data[0] & 0x1f)
+	dsd.mode = wpmd.data[1]
+	dsd.data = wpmd.data[2:wpmd.byte_length]
+	dsd.index = 0
+
+	switch dsd.mode {
+	case DSD_MODE_RAW:
+		var num_values int = wps.wphdr.block_samples
+
+		if stereo {
+			num_values *= 2
+		}
+
+		if len(dsd.data) != num_values {
+			return FALSE
+		}
+
+	case DSD_MODE_FAST:
+		if init_dsd_block_fast(dsd) == FALSE {
+			return FALSE
+		}
+
+	case DSD_MODE_HIGH:
+		if init_dsd_block_high(wps, stereo) == FALSE {
+			return FALSE
+		}
+
+	default:
+		return FALSE
+	}
+
+	dsd.ready = TRUE
+
+	return TRUE
+}
+
+// Read the probability tables of a "fast" mode block (run-length coded, as
+// encode_buffer_fast() writes them, unless the maximum probability is 0xff)
+// and build the lookup tables from which the bytes are decoded, then start
+// the range decoder.
+func init_dsd_block_fast(dsd *DsdData) int {
+	var history_bits uint
+	var max_probability int
+	var total_summed_probabilities int = 0
+	var num_probabilities int
+
+	if len(dsd.data) < 2 {
+		return FALSE
+	}
+
+	history_bits = uint(dsd.data[0])
+
+	if history_bits > MAX_HISTORY_BITS {
+		return FALSE
+	}
+
+	dsd.history_bins = 1 << history_bits
+	max_probability = int(dsd.data[1])
+	dsd.index = 2
+	num_probabilities = dsd.history_bins * 256
+
+	dsd.probabilities = make([][256]byte, dsd.history_bins)
+	dsd.summed_probabilities = make([][256]int, dsd.history_bins)
+	dsd.lookup = make([][]byte, dsd.history_bins)
+
+	if max_probability < 0xff {
+		var count int = 0
+
+		for (count < num_probabilities) && (dsd.index < len(dsd.data)) {
+			var code int = int(dsd.data[dsd.index])
+
+			dsd.index++
+
+			if code > max_probability {
+				for zcount := code - max_probability; (count < num_probabilities) && (zcount > 0); zcount-- {
+					dsd.probabilities[count>>8][count&0xff] = 0
+					count++
+				}
+			} else if code != 0 {
+				dsd.probabilities[count>>8][count&0xff] = byte(code)
+				count++
+			} else {
+				break
+			}
+		}
+
+		// the table is terminated with a zero
+		if count < num_probabilities {
+			return FALSE
+		}
+
+		if dsd.index < len(dsd.data) {
+			if dsd.data[dsd.index] != 0 {
+				return FALSE
+			}
+
+			dsd.index++
+		}
+	} else if len(dsd.data)-dsd.index > num_probabilities {
+		for i := 0; i < num_probabilities; i++ {
+			dsd.probabilities[i>>8][i&0xff] = dsd.data[dsd.index]
+			dsd.index++
+		}
+	} else {
+		return FALSE
+	}
+
+	for p := 0; p < dsd.history_bins; p++ {
+		var sum_values int = 0
+
+		for i := 0; i < 256; i++ {
+			sum_values += int(dsd.probabilities[p][i])
+			dsd.summed_probabilities[p][i] = sum_values
+		}
+
+		if sum_values != 0 {
+			total_summed_probabilities += sum_values
+
+			if total_summed_probabilities > dsd.history_bins*MAX_BYTES_PER_BIN {
+				return FALSE
+			}
+
+			dsd.lookup[p] = make([]byte, 0, sum_values)
+
+			for i := 0; i < 256; i++ {
+				for c := int(dsd.probabilities[p][i]); c > 0; c-- {
+					dsd.lookup[p] = append(dsd.lookup[p], byte(i))
+				}
+			}
+		}
+	}
+
+	if len(dsd.data)-dsd.index < 4 {
+		return FALSE
+	}
+
+	dsd_start_decoder(dsd)
+	dsd.p0 = 0
+	dsd.p1 = 0
+
+	return TRUE
+}
+
+// Read the probability table parameters and the (reduced precision) filter
+// states of a "high" mode block, as encode_buffer_high() writes them, and
+// start the range decoder.
+func init_dsd_block_high(wps *WavpackStream, stereo bool) int {
+	var dsd *DsdData = &wps.dsd
+	var num_channels int = 1
+	var rate_i int
+	var rate_s int
+
+	if stereo {
+		num_channels = 2
+	}
+
+	if len(dsd.data) < 2+num_channels*7+4 {
+		return FALSE
+	}
+
+	rate_i = int(dsd.data[0])
+	rate_s = int(dsd.data[1])
+	dsd.index = 2
+
+	if rate_s != RATE_S {
+		return FALSE
+	}
+
+	if len(wps.dsd_ptable) != PTABLE_BINS {
+		wps.dsd_ptable = make([]int32, PTABLE_BINS)
+	}
+
+	init_ptable(wps.dsd_ptable, rate_i, rate_s)
+
+	for i := 0; i < num_channels; i++ {
+		var sp *DsdFilters = &wps.dsd_filters[i]
+		var data []byte = dsd.data[dsd.index : dsd.index+7]
+
+		sp.filter1 = int32(data[0]) << (PRECISION - 8)
+		sp.filter2 = int32(data[1]) << (PRECISION - 8)
+		sp.filter3 = int32(data[2]) << (PRECISION - 8)
+		sp.filter4 = int32(data[3]) << (PRECISION - 8)
+		sp.filter5 = int32(data[4]) << (PRECISION - 8)
+		sp.filter6 = 0
+		sp.factor = int32(int16(uint16(data[5]) | (uint16(data[6]) << 8)))
+		dsd.index += 7
+	}
+
+	dsd_start_decoder(dsd)
+
+	return TRUE
+}
+
+// Start the range decoder on the next 4 bytes of the block.
+func dsd_start_decoder(dsd *DsdData) {
+	dsd.low = 0
+	dsd.high = 0xffffffff
+	dsd.value = 0
+
+	for i := 0; i < 4; i++ {
+		dsd.value = (dsd.value << 8) | uint32(dsd.data[dsd.index])
+		dsd.index++
+	}
+}
+
+// Shift out the top byte of the range while it's settled, shifting in the
+// next byte of the block (until there are no more).
+func dsd_next_bytes(dsd *DsdData) {
+	for ((dsd.high >> 24) == (dsd.low >> 24)) && (dsd.index < len(dsd.data)) {
+		dsd.value = (dsd.value << 8) | uint32(dsd.data[dsd.index])
+		dsd.index++
+		dsd.high = (dsd.high << 8) | 0xff
+		dsd.low <<= 8
+	}
+}
+
+// Unpack the specified number of samples of DSD audio from the current block
+// (which must have been through unpack_init()) into "buffer", one byte for
+// each channel of each sample, and update the crc with the bytes that were
+// coded. This is the DSD counterpart of unpack_samples(), which calls it for
+// DSD blocks. If the block turns out to be corrupt the rest of it is
+// returned as DSD silence (0x55) and its crc won't match.
+func unpack_dsd_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
+	var wps *WavpackStream = &wpc.stream
+	var dsd *DsdData = &wps.dsd
+	var flags uint = wps.wphdr.flags
+	var stereo bool = (flags & (MONO_FLAG | FALSE_STEREO)) == 0
+	var num_values int = int(sample_count)
+
+	if wps.sample_index+int64(sample_count) > block_end_index(&wps.wphdr) {
+		sample_count = uint(block_end_index(&wps.wphdr) - wps.sample_index)
+		num_values = int(sample_count)
+	}
+
+	if stereo {
+		num_values *= 2
+	}
+
+	if (wps.mute_error == FALSE) && (dsd.ready == FALSE) {
+		wps.mute_error = TRUE
+	}
+
+	if wps.mute_error == FALSE {
+		switch dsd.mode {
+		case DSD_MODE_RAW:
+			for i := 0; i < num_values; i++ {
+				buffer[i] = int(dsd.data[dsd.index])
+				wps.crc += (wps.crc << 1) + uint(dsd.data[dsd.index])
+				dsd.index++
+			}
+
+		case DSD_MODE_FAST:
+			if decode_fast(wps, buffer[0:num_values], stereo) == FALSE {
+				wps.mute_error = TRUE
+			}
+
+		default:
+			decode_high(wps, buffer[0:num_values], stereo)
+		}
+	}
+
+	if wps.mute_error != FALSE {
+		var count int = int(sample_count)
+
+		if (flags & MONO_FLAG) == 0 {
+			count *= 2
+		}
+
+		for i := 0; i < count; i++ {
+			buffer[i] = 0x55
+		}
+
+		wps.sample_index += int64(sample_count)
+
+		return sample_count
+	}
+
+	if (flags & FALSE_STEREO) != 0 {
+		for i := int(sample_count) - 1; i >= 0; i-- {
+			buffer[i*2+1] = buffer[i]
+			buffer[i*2] = buffer[i]
+		}
+	}
+
+	wps.sample_index += int64(sample_count)
+
+	return sample_count
+}
+
+// Decode the bytes of a "fast" mode block into "output", the reverse of
+// encode_buffer_fast(). A return of FALSE means that the data is corrupt.
+func decode_fast(wps *WavpackStream, output []int, stereo bool) int {
+	var dsd *DsdData = &wps.dsd
+	var history_mask int = dsd.history_bins - 1
+
+	for i := range output {
+		var total int = dsd.summed_probabilities[dsd.p0][255]
+		var mult uint32
+		var index uint32
+		var code int
+
+		if total == 0 {
+			return FALSE
+		}
+
+		mult = (dsd.high - dsd.low) / uint32(total)
+
+		// the encoder flushed the range out and started again
+		if mult == 0 {
+			if len(dsd.data)-dsd.index >= 4 {
+				dsd_start_decoder(dsd)
+			} else {
+				dsd.low = 0
+				dsd.high = 0xffffffff
+			}
+
+			mult = dsd.high / uint32(total)
+		}
+
+		if index = (dsd.value - dsd.low) / mult; index >= uint32(total) {
+			return FALSE
+		}
+
+		code = int(dsd.lookup[dsd.p0][index])
+		output[i] = code
+
+		if code != 0 {
+			dsd.low += uint32(dsd.summed_probabilities[dsd.p0][code-1]) * mult
+		}
+
+		dsd.high = dsd.low + uint32(dsd.probabilities[dsd.p0][code])*mult - 1
+		wps.crc += (wps.crc << 1) + uint(code)
+
+		if stereo {
+			dsd.p0 = dsd.p1
+			dsd.p1 = code & history_mask
+		} else {
+			dsd.p0 = code & history_mask
+		}
+
+		dsd_next_bytes(dsd)
+	}
+
+	return TRUE
+}
+
+// Decode the bytes of a "high" mode block into "output", the reverse of
+// encode_buffer_high(). Each bit is decoded with the probability selected
+// by the filter output, and the filters and probabilities are then updated
+// exactly as the encoder updated them.
+func decode_high(wps *WavpackStream, output []int, stereo bool) {
+	var dsd *DsdData = &wps.dsd
+	var channel int = 0
+
+	for i := range output {
+		var sp *DsdFilters = &wps.dsd_filters[channel]
+		var code int32 = 0
+
+		sp.value = sp.filter1 - sp.filter5 + ((sp.filter6 * sp.factor) >> 2)
+
+		for bitcount := 8; bitcount > 0; bitcount-- {
+			var pp *int32 = &wps.dsd_ptable[(sp.value>>(PRECISION-PRECISION_USE))&PTABLE_MASK]
+			var split uint32 = dsd.low + ((dsd.high-dsd.low)>>8)*uint32(*pp>>16)
+
+			if dsd.value <= split {
+				dsd.high = split
+				*pp += (UP - *pp) >> DECAY
+				sp.filter0 = -1
+			} else {
+				dsd.low = split + 1
+				*pp += (DOWN - *pp) >> DECAY
+				sp.filter0 = 0
+			}
+
+			dsd_next_bytes(dsd)
+
+			sp.value += sp.filter6 * 8
+			code = (code << 1) | (sp.filter0 & 1)
+			sp.factor += (((sp.value ^ sp.filter0) >> 31) | 1) & ((sp.value ^ (sp.value - (sp.filter6 * 16))) >> 31)
+			sp.filter1 += ((sp.filter0 & VALUE_ONE) - sp.filter1) >> 6
+			sp.filter2 += ((sp.filter0 & VALUE_ONE) - sp.filter2) >> 4
+			sp.filter3 += (sp.filter2 - sp.filter3) >> 4
+			sp.filter4 += (sp.filter3 - sp.filter4) >> 4
+			sp.value = (sp.filter4 - sp.filter5) >> 4
+			sp.filter5 += sp.value
+			sp.filter6 += (sp.value - sp.filter6) >> 3
+			sp.value = sp.filter1 - sp.filter5 + ((sp.filter6 * sp.factor) >> 2)
+		}
+
+		sp.factor -= (sp.factor + 512) >> 10
+		output[i] = int(code)
+		wps.crc += (wps.crc << 1) + uint(code)
+
+		if stereo {
+			channel ^= 1
+		}
+	}
+}
+
+// Return the value of a sample of silence, which for DSD audio is 0x55
+// (alternating bits) rather than zero.
+func silent_sample(wpc *WavpackContext) int {
+	if (wpc.stream.wphdr.flags & DSD_FLAG) != 0 {
+		return 0x55
+	}
+
+	return 0
+}
@@ -0,0 +1,1577 @@
+package wvencode
+
+/*
+** UnpackUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+)
+
+const WORD_EOF int = (1 << 31) // returned by get_word() at end of bitstream
+
+// Open the WavPack stream "infile" for decoding. If a correction stream is
+// available it should be passed as "wvc_infile" (otherwise nil) and the
+// decode will be lossless even for hybrid files. The first block is read to
+// determine the stream format. A return of FALSE indicates an error (see
+// WavpackGetErrorMessage()).
+func WavpackOpenFileInput(wpc *WavpackContext, infile io.Reader, wvc_infile io.Reader) int {
+	var wps *WavpackStream = &wpc.stream
+	var num_blocks int = 0
+
+	wpc.infile = infile
+	wpc.wvc_infile = wvc_infile
+	wpc.total_samples = -1
+
+	if wvc_infile != nil {
+		wpc.wvc_flag = TRUE
+	}
+
+	for wps.wphdr.block_samples == 0 {
+		if read_block(wpc, infile, &wps.wphdr, &wps.blockbuff) == FALSE {
+			wpc.error_message = "not compatible with this version of WavPack file!"
+
+			return FALSE
+		}
+
+		num_blocks++
+
+		if (wps.wphdr.block_samples == 0) && (num_blocks > 16) {
+			wpc.error_message = "not compatible with this version of WavPack file!"
+
+			return FALSE
+		}
+
+		wps.block2buff = nil
+
+		if (wps.wphdr.block_samples != 0) && (wpc.wvc_flag != 0) {
+			read_wvc_block(wpc)
+		}
+
+		if unpack_init(wpc) == FALSE {
+			wpc.error_message = "not compatible with this version of WavPack file!"
+
+			return FALSE
+		}
+	}
+
+	// If the first block was too damaged to be read, the block we have starts
+	// later, and the samples before it are unpacked as a gap (silence).
+	wps.sample_index = 0
+
+	wpc.total_samples = get_total_samples(&wps.wphdr)
+	wpc.config.Flags &= ^uint(0xff)
+	wpc.config.Flags |= wps.wphdr.flags & 0xff
+	wpc.config.Bytes_per_sample = int(wps.wphdr.flags&BYTES_STORED) + 1
+	wpc.config.Bits_per_sample = (wpc.config.Bytes_per_sample * 8) -
+		int((wps.wphdr.flags&SHIFT_MASK)>>SHIFT_LSB)
+
+	if wpc.config.Sample_rate == 0 {
+		if (wps.wphdr.flags & SRATE_MASK) == SRATE_MASK {
+			wpc.config.Sample_rate = 44100
+		} else {
+			wpc.config.Sample_rate = sample_rates[(wps.wphdr.flags&SRATE_MASK)>>SRATE_LSB]
+		}
+	}
+
+	if wpc.config.Num_channels == 0 {
+		if (wps.wphdr.flags & MONO_FLAG) != 0 {
+			wpc.config.Num_channels = 1
+		} else {
+			wpc.config.Num_channels = 2
+		}
+
+		wpc.config.Channel_mask = 0x5 - wpc.config.Num_channels
+	}
+
+	return TRUE
+}
+
+// Read the rest of the WavPack file once all the audio has been unpacked to
+// pick up the trailer of the original file (if one was stored), which is
+// then returned by WavpackGetWrapperData(). Only blocks without audio are
+// expected here, and their metadata is otherwise ignored.
+func WavpackSeekTrailingWrapper(wpc *WavpackContext) {
+	var wps *WavpackStream = &wpc.stream
+
+	for read_main_block(wpc) == TRUE {
+		if wps.wphdr.block_samples == 0 {
+			process_metadata_block(wpc, wps.blockbuff)
+		}
+	}
+}
+
+// Read the next WavPack block from "infile" into the specified buffer,
+// skipping over any garbage preceding it, and parse its header into
+// "wphdr". A return of FALSE indicates that no more blocks could be read
+// (and "wphdr" is left as it was, so a truncated block isn't mistaken for
+// the block that was read before it).
+func read_block(wpc *WavpackContext, infile io.Reader, wphdr *WavpackHeader, blockbuff *[]byte) int {
+	var header []byte = make([]byte, WAVPACK_HEADER_SIZE)
+	var new_hdr WavpackHeader
+
+	if read_next_header(infile, header) < 0 {
+		return FALSE
+	}
+
+	read_header(header, &new_hdr)
+
+	var buffer []byte = make([]byte, new_hdr.ckSize+8)
+	copy(buffer, header)
+
+	if n, _ := io.ReadFull(infile, buffer[WAVPACK_HEADER_SIZE:]); n != new_hdr.ckSize-24 {
+		return FALSE
+	}
+
+	*wphdr = new_hdr
+	*blockbuff = buffer
+
+	return TRUE
+}
+
+// Read the correction block matching the block just read from the main
+// stream into wps.block2buff (or leave it nil if there isn't one). Blocks
+// are paired by their block_index: a correction block for a later block is
+// held until that block is read, and any for earlier blocks (whose blocks
+// are missing from the main stream) are skipped. If the correction stream
+// ends early then the decode simply continues lossy (but the stream is kept,
+// as a seek may take us back to blocks that it does have).
+func read_wvc_block(wpc *WavpackContext) {
+	var wps *WavpackStream = &wpc.stream
+	var wvc_hdr WavpackHeader
+
+	wps.block2buff = nil
+
+	for wpc.wvc_infile != nil {
+		if wps.wvc_pending == nil {
+			if read_block(wpc, wpc.wvc_infile, &wvc_hdr, &wps.wvc_pending) == FALSE {
+				wps.wvc_pending = nil
+
+				return
+			}
+		} else {
+			read_header(wps.wvc_pending, &wvc_hdr)
+		}
+
+		if get_block_index(&wvc_hdr) > get_block_index(&wps.wphdr) {
+			return
+		}
+
+		if get_block_index(&wvc_hdr) == get_block_index(&wps.wphdr) {
+			if wvc_hdr.block_samples == wps.wphdr.block_samples {
+				wps.block2buff = wps.wvc_pending
+			}
+
+			wps.wvc_pending = nil
+
+			return
+		}
+
+		wps.wvc_pending = nil
+	}
+}
+
+// Find the next WavPack block header in "infile" and read it into "buffer".
+// The number of bytes skipped to find it is returned, or -1 if no header
+// could be found (within 1 MB).
+func read_next_header(infile io.Reader, buffer []byte) int {
+	var bytes_skipped int = 0
+	var bleft int = 0
+
+	for {
+		if n, _ := io.ReadFull(infile, buffer[bleft:WAVPACK_HEADER_SIZE]); n != WAVPACK_HEADER_SIZE-bleft {
+			return -1
+		}
+
+		if valid_header(buffer) == TRUE {
+			return bytes_skipped
+		}
+
+		var sp int = 1
+
+		for (sp < WAVPACK_HEADER_SIZE) && (buffer[sp] != 'w') {
+			sp++
+		}
+
+		bytes_skipped += sp
+
+		if bytes_skipped > 1024*1024 {
+			return -1
+		}
+
+		bleft = copy(buffer, buffer[sp:WAVPACK_HEADER_SIZE])
+	}
+}
+
+// Unpack the specified number of samples from the current file position.
+// Note that "samples" here refers to "complete" samples, which would be
+// 2 ints for stereo files. The audio data is returned right-justified in
+// 32-bit ints in the endian mode native to the executing processor. So,
+// if the original data was 16-bit, then the values returned would be
+// +/-32k. Floating point data will be returned as 32-bit IEEE floats (stored
+// in the ints). The actual number of samples unpacked is returned, which
+// should be equal to the number requested unless the end of file is
+// encountered or an error occurs. DSD audio is returned as bytes of 8 DSD
+// bits (MSB first), one for each channel of each sample.
+func WavpackUnpackSamples(wpc *WavpackContext, buffer []int, samples uint) uint {
+	var wps *WavpackStream = &wpc.stream
+	var samples_unpacked uint = 0
+	var samples_to_unpack uint
+	var num_channels int = int(wpc.config.Num_channels)
+	var buf_idx int = 0
+
+	for samples > 0 {
+		if (wps.end_of_stream == FALSE) && ((wps.wphdr.block_samples == 0) || ((wps.wphdr.flags & INITIAL_BLOCK) == 0) ||
+			(wps.sample_index >= block_end_index(&wps.wphdr))) {
+			if read_main_block(wpc) == FALSE {
+				// when concealing errors, the missing end of the stream is silence
+				if (wpc.conceal_errors == FALSE) || (wpc.total_samples < 0) || (wps.sample_index >= wpc.total_samples) {
+					break
+				}
+
+				report_damage(wpc, WavpackDamagedBlock{wps.sample_index, wpc.total_samples - wps.sample_index, TRUE, FALSE})
+				wps.end_of_stream = TRUE
+				continue
+			}
+
+			wps.block2buff = nil
+
+			if (wps.wphdr.block_samples != 0) && (wpc.wvc_flag != 0) {
+				read_wvc_block(wpc)
+			}
+
+			var sample_index int64 = wps.sample_index
+
+			if unpack_init(wpc) == FALSE {
+				wps.mute_error = TRUE
+			}
+
+			if wps.wphdr.block_samples == 0 {
+				wps.sample_index = sample_index
+				continue
+			}
+
+			wps.sample_index = sample_index
+
+			// samples with no block, because it was too damaged to be read
+			if get_block_index(&wps.wphdr) > sample_index {
+				report_damage(wpc, WavpackDamagedBlock{sample_index, get_block_index(&wps.wphdr) - sample_index, TRUE, FALSE})
+			}
+		}
+
+		if (wps.end_of_stream != FALSE) || (wps.sample_index < get_block_index(&wps.wphdr)) {
+			// a gap at the very start is reported here, because the block
+			// after it was read when the file was opened
+			if (wps.end_of_stream == FALSE) && (wps.sample_index == 0) {
+				report_damage(wpc, WavpackDamagedBlock{0, get_block_index(&wps.wphdr), TRUE, FALSE})
+			}
+
+			if wps.end_of_stream != FALSE {
+				if wps.sample_index >= wpc.total_samples {
+					break
+				}
+
+				samples_to_unpack = uint(wpc.total_samples - wps.sample_index)
+			} else {
+				samples_to_unpack = uint(get_block_index(&wps.wphdr) - wps.sample_index)
+			}
+
+			if samples_to_unpack > samples {
+				samples_to_unpack = samples
+			}
+
+			wps.sample_index += int64(samples_to_unpack)
+			samples_unpacked += samples_to_unpack
+			samples -= samples_to_unpack
+
+			for i := 0; i < int(samples_to_unpack)*num_channels; i++ {
+				buffer[buf_idx] = silent_sample(wpc)
+				buf_idx++
+			}
+
+			continue
+		}
+
+		samples_to_unpack = uint(block_end_index(&wps.wphdr) - wps.sample_index)
+
+		if samples_to_unpack > samples {
+			samples_to_unpack = samples
+		}
+
+		// when concealing errors, the whole block is unpacked (and checked)
+		// before any of it is returned
+		if (wpc.conceal_errors != FALSE) && (wps.block_unpacked == FALSE) {
+			var sample_index int64 = wps.sample_index
+
+			if (sample_index != get_block_index(&wps.wphdr)) && (unpack_init(wpc) == FALSE) {
+				wps.mute_error = TRUE
+			}
+
+			unpack_block(wpc)
+			wps.sample_index = sample_index
+		}
+
+		if wps.block_unpacked != FALSE {
+			var offset int = int(wps.sample_index-get_block_index(&wps.wphdr)) * num_channels
+			var num_values int = int(samples_to_unpack) * num_channels
+
+			copy(buffer[buf_idx:buf_idx+num_values], wps.unpacked[offset:offset+num_values])
+			wps.sample_index += int64(samples_to_unpack)
+			buf_idx += num_values
+			samples_unpacked += samples_to_unpack
+			samples -= samples_to_unpack
+
+			if wps.sample_index == wpc.total_samples {
+				break
+			}
+
+			continue
+		}
+
+		var sample_index int64 = wps.sample_index
+
+		unpack_samples(wpc, buffer[buf_idx:], samples_to_unpack)
+
+		// If the correction data turns out to be damaged, the samples are
+		// unpacked again without it (from the start of the block, because
+		// the lossy stream can't be picked up from where we are).
+		if (wps.wvcbits.active != 0) && ((wps.mute_error != FALSE) || (wps.wvcbits.error != 0)) {
+			wps.block2buff = nil
+
+			if unpack_init(wpc) == FALSE {
+				wps.mute_error = TRUE
+			}
+
+			skip_samples(wpc, sample_index)
+			unpack_samples(wpc, buffer[buf_idx:], samples_to_unpack)
+		}
+
+		buf_idx += int(samples_to_unpack) * num_channels
+		samples_unpacked += samples_to_unpack
+		samples -= samples_to_unpack
+
+		if wps.sample_index == block_end_index(&wps.wphdr) {
+			if check_crc_error(wpc) != 0 {
+				wpc.crc_errors++
+				report_damage(wpc, WavpackDamagedBlock{get_block_index(&wps.wphdr), int64(wps.wphdr.block_samples), FALSE, FALSE})
+			} else if (wpc.wvc_flag != 0) && ((wps.wphdr.flags & HYBRID_FLAG) != 0) && (len(wps.block2buff) == 0) {
+				// the block is fine, but was unpacked lossy without its correction block
+				report_damage(wpc, WavpackDamagedBlock{get_block_index(&wps.wphdr), int64(wps.wphdr.block_samples), FALSE, TRUE})
+			}
+		}
+
+		if wps.sample_index == wpc.total_samples {
+			break
+		}
+	}
+
+	return samples_unpacked
+}
+
+// Read the WavpackHeader fields from the first 32 bytes of the specified
+// buffer (which are stored little-endian) into the header structure.
+func read_header(buffer []byte, wphdr *WavpackHeader) {
+	wphdr.ckID[0] = int(buffer[0])
+	wphdr.ckID[1] = int(buffer[1])
+	wphdr.ckID[2] = int(buffer[2])
+	wphdr.ckID[3] = int(buffer[3])
+	wphdr.ckSize = int(buffer[4]) + (int(buffer[5]) << 8) + (int(buffer[6]) << 16) + (int(buffer[7]) << 24)
+	wphdr.version = int(buffer[8]) + (int(buffer[9]) << 8)
+	wphdr.block_index_u8 = int(buffer[10])
+	wphdr.total_samples_u8 = int(buffer[11])
+	wphdr.total_samples = uint(buffer[12]) + (uint(buffer[13]) << 8) + (uint(buffer[14]) << 16) + (uint(buffer[15]) << 24)
+	wphdr.block_index = uint(buffer[16]) + (uint(buffer[17]) << 8) + (uint(buffer[18]) << 16) + (uint(buffer[19]) << 24)
+	wphdr.block_samples = int(buffer[20]) + (int(buffer[21]) << 8) + (int(buffer[22]) << 16) + (int(buffer[23]) << 24)
+	wphdr.flags = uint(buffer[24]) + (uint(buffer[25]) << 8) + (uint(buffer[26]) << 16) + (uint(buffer[27]) << 24)
+	wphdr.crc = uint(buffer[28]) + (uint(buffer[29]) << 8) + (uint(buffer[30]) << 16) + (uint(buffer[31]) << 24)
+}
+
+// Return the index of the sample following the block with the specified
+// header (up to 40 bits).
+func block_end_index(wphdr *WavpackHeader) int64 {
+	return get_block_index(wphdr) + int64(wphdr.block_samples)
+}
+
+// Read the next metadata item from the block at "blockbuff", starting at
+// "index", into the specified metadata structure. The data field of the
+// metadata structure refers directly into the block. The return value is
+// the index of the following metadata item, or -1 if the item is corrupt
+// (that is, it extends past the end of the block).
+func read_metadata_buff(wpmd *WavpackMetadata, blockbuff []byte, index int) int {
+	var block_end int = (int(blockbuff[4]) + (int(blockbuff[5]) << 8) +
+		(int(blockbuff[6]) << 16) + (int(blockbuff[7]) << 24)) + 8
+
+	if block_end > len(blockbuff) || (index+2) > block_end {
+		return -1
+	}
+
+	wpmd.id = int(blockbuff[index])
+	wpmd.byte_length = int(blockbuff[index+1]) << 1
+	index += 2
+
+	if (wpmd.id & ID_LARGE) != 0 {
+		wpmd.id &= ^ID_LARGE
+
+		if (index + 2) > block_end {
+			return -1
+		}
+
+		wpmd.byte_length += (int(blockbuff[index]) << 9) + (int(blockbuff[index+1]) << 17)
+		index += 2
+	}
+
+	if (index + wpmd.byte_length) > block_end {
+		return -1
+	}
+
+	wpmd.data = blockbuff[index : index+wpmd.byte_length]
+	index += wpmd.byte_length
+
+	if (wpmd.id & ID_ODD_SIZE) != 0 {
+		wpmd.id &= ^ID_ODD_SIZE
+
+		if wpmd.byte_length == 0 {
+			return -1
+		}
+
+		wpmd.byte_length--
+		wpmd.data = wpmd.data[0:wpmd.byte_length]
+	}
+
+	return index
+}
+
+// Process all the metadata items in the block at "blockbuff" (which may be a
+// standard block or a correction block) and return FALSE if any of them are
+// corrupt or unknown (and not marked as optional).
+func process_metadata_block(wpc *WavpackContext, blockbuff []byte) int {
+	var wpmd WavpackMetadata
+	var index int = WAVPACK_HEADER_SIZE
+	var block_end int = (int(blockbuff[4]) + (int(blockbuff[5]) << 8) +
+		(int(blockbuff[6]) << 16) + (int(blockbuff[7]) << 24)) + 8
+
+	for index < block_end {
+		index = read_metadata_buff(&wpmd, blockbuff, index)
+
+		if index < 0 || process_metadata(wpc, &wpmd) == FALSE {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Process the specified metadata item. Bitstreams are opened directly on the
+// data of the item (which refers into the block buffer). Unknown items are
+// ignored if they are flagged as optional, otherwise FALSE is returned.
+func process_metadata(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	var wps *WavpackStream = &wpc.stream
+
+	switch wpmd.id {
+	case int(ID_DUMMY):
+		return TRUE
+
+	case ID_DECORR_TERMS:
+		return read_decorr_terms(wps, wpmd)
+
+	case ID_DECORR_WEIGHTS:
+		return read_decorr_weights(wps, wpmd)
+
+	case ID_DECORR_SAMPLES:
+		return read_decorr_samples(wps, wpmd)
+
+	case ID_ENTROPY_VARS:
+		return read_entropy_vars(wps, wpmd)
+
+	case ID_HYBRID_PROFILE:
+		return read_hybrid_profile(wps, wpmd)
+
+	case ID_SHAPING_WEIGHTS:
+		return read_shaping_info(wps, wpmd)
+
+	case int(ID_FLOAT_INFO):
+		return read_float_info(wps, wpmd)
+
+	case int(ID_CHANNEL_INFO):
+		return read_channel_info(wpc, wpmd)
+
+	case ID_CONFIG_BLOCK:
+		return read_config_info(wpc, wpmd)
+
+	case ID_NEW_CONFIG_BLOCK:
+		return read_new_config_info(wpc, wpmd)
+
+	case ID_SAMPLE_RATE:
+		return read_sample_rate(wpc, wpmd)
+
+	case ID_WV_BITSTREAM:
+		if wpmd.byte_length == 0 {
+			return FALSE
+		}
+
+		bs_open_read(&wps.wvbits, wpmd.data, 0, wpmd.byte_length)
+
+		return TRUE
+
+	case ID_WVC_BITSTREAM:
+		if wpmd.byte_length == 0 {
+			return FALSE
+		}
+
+		bs_open_read(&wps.wvcbits, wpmd.data, 0, wpmd.byte_length)
+
+		return TRUE
+
+	case int(ID_WVX_BITSTREAM):
+		if wpmd.byte_length <= 4 {
+			return FALSE
+		}
+
+		wps.crc_x = uint(wpmd.data[0]) + (uint(wpmd.data[1]) << 8) +
+			(uint(wpmd.data[2]) << 16) + (uint(wpmd.data[3]) << 24)
+
+		bs_open_read(&wps.wvxbits, wpmd.data, 4, wpmd.byte_length)
+
+		return TRUE
+
+	case int(ID_RIFF_HEADER), ID_ALT_HEADER:
+		if wps.sample_index == 0 && get_block_index(&wps.wphdr) == 0 {
+			wpc.wrapper_data = append(wpc.wrapper_data, wpmd.data...)
+		}
+
+		return TRUE
+
+	case int(ID_RIFF_TRAILER), ID_ALT_TRAILER:
+		wpc.wrapper_data = append(wpc.wrapper_data, wpmd.data...)
+
+		return TRUE
+
+	case ID_ALT_EXTENSION:
+		wpc.file_extension = string(wpmd.data)
+
+		return TRUE
+
+	case ID_DSD_BLOCK:
+		return init_dsd_block(wpc, wpmd)
+
+	case int(ID_MD5_CHECKSUM):
+		if wpmd.byte_length == 16 {
+			copy(wpc.md5_checksum[0:16], wpmd.data)
+			wpc.md5_read = TRUE
+		}
+
+		return TRUE
+	}
+
+	if (wpmd.id & int(ID_OPTIONAL_DATA)) != 0 {
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Read decorrelation terms from specified metadata block into the
+// decorr_passes array. The terms range from -3 to 8, plus 17 & 18;
+// other values are reserved and generate errors for now. The delta
+// ranges from 0 to 7 with all values valid. Note that the terms are
+// stored in the opposite order in the decorr_passes array compared
+// to packing.
+func read_decorr_terms(wps *WavpackStream, wpmd *WavpackMetadata) int {
+	var termcnt int = wpmd.byte_length
+	var dpp_idx int
+
+	if termcnt > MAX_NTERMS {
+		return FALSE
+	}
+
+	wps.num_terms = termcnt
+
+	for dpp_idx = termcnt - 1; dpp_idx >= 0; dpp_idx-- {
+		var b int = int(wpmd.data[termcnt-1-dpp_idx])
+
+		wps.decorr_passes[dpp_idx].term = (b & 0x1f) - 5
+		wps.decorr_passes[dpp_idx].delta = (b >> 5) & 0x7
+
+		if (wps.decorr_passes[dpp_idx].term == 0) || (wps.decorr_passes[dpp_idx].term < -3) ||
+			((wps.decorr_passes[dpp_idx].term > MAX_TERM) && (wps.decorr_passes[dpp_idx].term < 17)) ||
+			(wps.decorr_passes[dpp_idx].term > 18) {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Read decorrelation weights from specified metadata block into the
+// decorr_passes array. The weights range +/-1024, but are rounded and
+// truncated to fit in signed chars for metadata storage. Weights are
+// separate for the two channels and are specified from the "last" term
+// (first during encode). Unspecified weights are set to zero.
+func read_decorr_weights(wps *WavpackStream, wpmd *WavpackMetadata) int {
+	var termcnt int = wpmd.byte_length
+	var byte_idx int = 0
+	var dpp_idx int
+
+	if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0 {
+		termcnt /= 2
+	}
+
+	if termcnt > wps.num_terms {
+		return FALSE
+	}
+
+	for dpp_idx = 0; dpp_idx < wps.num_terms; dpp_idx++ {
+		wps.decorr_passes[dpp_idx].weight_A = 0
+		wps.decorr_passes[dpp_idx].weight_B = 0
+	}
+
+	for dpp_idx = wps.num_terms - 1; dpp_idx >= 0 && termcnt > 0; dpp_idx-- {
+		wps.decorr_passes[dpp_idx].weight_A = restore_weight(wpmd.data[byte_idx])
+		byte_idx++
+
+		if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0 {
+			wps.decorr_passes[dpp_idx].weight_B = restore_weight(wpmd.data[byte_idx])
+			byte_idx++
+		}
+
+		termcnt--
+	}
+
+	return TRUE
+}
+
+// Read decorrelation samples from specified metadata block into the
+// decorr_passes array. The samples are signed 32-bit values, but are
+// converted to signed log2 values for storage in metadata. Values are
+// stored for both channels and are specified from the "last" term (first
+// during encode) with unspecified samples set to zero. The number of
+// samples stored varies with the actual term value, so those must
+// obviously come first in the metadata.
+func read_decorr_samples(wps *WavpackStream, wpmd *WavpackMetadata) int {
+	var byteptr []byte = wpmd.data
+	var byte_idx int = 0
+	var dpp_idx int
+
+	for dpp_idx = 0; dpp_idx < wps.num_terms; dpp_idx++ {
+		for k := 0; k < MAX_TERM; k++ {
+			wps.decorr_passes[dpp_idx].samples_A[k] = 0
+			wps.decorr_passes[dpp_idx].samples_B[k] = 0
+		}
+	}
+
+	for dpp_idx = wps.num_terms - 1; dpp_idx >= 0 && byte_idx < wpmd.byte_length; dpp_idx-- {
+		if wps.decorr_passes[dpp_idx].term > MAX_TERM {
+			if (byte_idx + 4) > wpmd.byte_length {
+				return FALSE
+			}
+
+			wps.decorr_passes[dpp_idx].samples_A[0] = exp2s(read_signed_short(byteptr, byte_idx))
+			wps.decorr_passes[dpp_idx].samples_A[1] = exp2s(read_signed_short(byteptr, byte_idx+2))
+			byte_idx += 4
+
+			if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0 {
+				if (byte_idx + 4) > wpmd.byte_length {
+					return FALSE
+				}
+
+				wps.decorr_passes[dpp_idx].samples_B[0] = exp2s(read_signed_short(byteptr, byte_idx))
+				wps.decorr_passes[dpp_idx].samples_B[1] = exp2s(read_signed_short(byteptr, byte_idx+2))
+				byte_idx += 4
+			}
+		} else if wps.decorr_passes[dpp_idx].term < 0 {
+			if (byte_idx + 4) > wpmd.byte_length {
+				return FALSE
+			}
+
+			wps.decorr_passes[dpp_idx].samples_A[0] = exp2s(read_signed_short(byteptr, byte_idx))
+			wps.decorr_passes[dpp_idx].samples_B[0] = exp2s(read_signed_short(byteptr, byte_idx+2))
+			byte_idx += 4
+		} else {
+			var m int = 0
+			var cnt int = wps.decorr_passes[dpp_idx].term
+
+			for cnt > 0 {
+				if (byte_idx + 2) > wpmd.byte_length {
+					return FALSE
+				}
+
+				wps.decorr_passes[dpp_idx].samples_A[m] = exp2s(read_signed_short(byteptr, byte_idx))
+				byte_idx += 2
+
+				if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0 {
+					if (byte_idx + 2) > wpmd.byte_length {
+						return FALSE
+					}
+
+					wps.decorr_passes[dpp_idx].samples_B[m] = exp2s(read_signed_short(byteptr, byte_idx))
+					byte_idx += 2
+				}
+
+				m++
+				cnt--
+			}
+		}
+	}
+
+	if byte_idx != wpmd.byte_length {
+		return FALSE
+	}
+
+	return TRUE
+}
+
+// Read the shaping weights from specified metadata block into the
+// WavpackStream structure. Note that there must be two values (even
+// for mono streams) and that the values are stored in the same
+// manner as decorrelation weights. These would normally be read from
+// the "correction" file and are used for lossless reconstruction of
+// hybrid data.
+func read_shaping_info(wps *WavpackStream, wpmd *WavpackMetadata) int {
+	var byteptr []byte = wpmd.data
+	var mono int = FALSE
+
+	if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) != 0 {
+		mono = TRUE
+	}
+
+	if wpmd.byte_length == 2 {
+		wps.dc.shaping_acc[0] = restore_weight(byteptr[0]) << 16
+		wps.dc.shaping_acc[1] = restore_weight(byteptr[1]) << 16
+
+		return TRUE
+	} else if (wpmd.byte_length >= 8) || ((mono == TRUE) && (wpmd.byte_length >= 4)) {
+		var byte_idx int = 0
+
+		wps.dc.error[0] = exp2s(read_signed_short(byteptr, byte_idx))
+		wps.dc.shaping_acc[0] = exp2s(read_signed_short(byteptr, byte_idx+2))
+		byte_idx += 4
+
+		if mono == FALSE {
+			wps.dc.error[1] = exp2s(read_signed_short(byteptr, byte_idx))
+			wps.dc.shaping_acc[1] = exp2s(read_signed_short(byteptr, byte_idx+2))
+			byte_idx += 4
+		}
+
+		if (wpmd.byte_length == 12) || ((mono == TRUE) && (wpmd.byte_length == 6)) {
+			wps.dc.shaping_delta[0] = exp2s(read_signed_short(byteptr, byte_idx))
+
+			if mono == FALSE {
+				wps.dc.shaping_delta[1] = exp2s(read_signed_short(byteptr, byte_idx+2))
+			}
+		}
+
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Read the floating-point data information from the specified metadata
+// block into the WavpackStream structure.
+func read_float_info(wps *WavpackStream, wpmd *WavpackMetadata) int {
+	if wpmd.byte_length != 4 {
+		return FALSE
+	}
+
+	wps.float_flags = int(wpmd.data[0])
+	wps.float_shift = int(wpmd.data[1])
+	wps.float_max_exp = int(wpmd.data[2])
+	wps.float_norm_exp = int(wpmd.data[3])
+
+	return TRUE
+}
+
+// Read multichannel information from metadata. The first byte is the total
+// number of channels and the following bytes represent the channel_mask
+// as described for Microsoft WAVEFORMATEX.
+func read_channel_info(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	var mask uint = 0
+	var shift uint = 0
+
+	if (wpmd.byte_length == 0) || (wpmd.byte_length > 5) {
+		return FALSE
+	}
+
+	for i := 1; i < wpmd.byte_length; i++ {
+		mask |= uint(wpmd.data[i]) << shift
+		shift += 8
+	}
+
+	wpc.config.Num_channels = uint(wpmd.data[0])
+	wpc.config.Channel_mask = mask
+
+	return TRUE
+}
+
+// Read configuration information from metadata.
+func read_config_info(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	if wpmd.byte_length >= 3 {
+		wpc.config.Flags &= 0xff
+		wpc.config.Flags |= uint(wpmd.data[0]) << 8
+		wpc.config.Flags |= uint(wpmd.data[1]) << 16
+		wpc.config.Flags |= uint(wpmd.data[2]) << 24
+	}
+
+	return TRUE
+}
+
+// Read the extended configuration information (the source file format
+// and qualify mode) from metadata.
+func read_new_config_info(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	wpc.file_format = 0
+	wpc.config.Qmode = 0
+
+	if wpmd.byte_length >= 1 {
+		wpc.file_format = int(wpmd.data[0])
+	}
+
+	if wpmd.byte_length >= 2 {
+		wpc.config.Qmode = int(wpmd.data[1])
+	}
+
+	return TRUE
+}
+
+// Read non-standard sampling rate from metadata.
+func read_sample_rate(wpc *WavpackContext, wpmd *WavpackMetadata) int {
+	if wpmd.byte_length == 3 {
+		wpc.config.Sample_rate = uint(wpmd.data[0]) + (uint(wpmd.data[1]) << 8) + (uint(wpmd.data[2]) << 16)
+	}
+
+	return TRUE
+}
+
+// Return the signed 16-bit value stored little-endian at "index".
+func read_signed_short(byteptr []byte, index int) int {
+	return int(int16(uint16(byteptr[index]) | (uint16(byteptr[index+1]) << 8)))
+}
+
+// This function initializes everything required to unpack a WavPack block
+// and must be called before unpack_samples() is called to obtain audio data.
+// It is assumed that the WavpackHeader has been read into wps.wphdr and
+// that the entire block has been read at wps.blockbuff. If a correction
+// block is available then it must have been read into wps.block2buff
+// (otherwise wps.block2buff must be nil). This is where all the metadata
+// blocks are scanned including those that contain bitstream data.
+func unpack_init(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+
+	wps.mute_error = FALSE
+	wps.block_unpacked = FALSE
+	wps.dsd.ready = FALSE
+	wps.crc = 0xffffffff
+	wps.crc_wvc = 0xffffffff
+	wps.crc_x = 0xffffffff
+	wps.crc_wvx = 0xffffffff
+	wps.num_terms = 0
+	wps.float_flags = 0
+	wps.float_shift = 0
+	wps.float_max_exp = 0
+	wps.float_norm_exp = 0
+	wps.wvbits.active = 0
+	wps.wvcbits.active = 0
+	wps.wvxbits.active = 0
+	wps.decorr_passes = [16]DecorrPass{}
+	wps.dc.shaping_acc = make([]int, 2)
+	wps.dc.shaping_delta = make([]int, 2)
+	wps.dc.error = make([]int, 2)
+	wps.w = WordsData{}
+	wps.w.bitrate_delta = make([]int, 2)
+	wps.w.bitrate_acc = make([]uint, 2)
+	wps.w.slow_level = make([]int, 2)
+	wps.w.error_limit = make([]int, 2)
+
+	if process_metadata_block(wpc, wps.blockbuff) == FALSE {
+		return FALSE
+	}
+
+	// a damaged correction block just means that the block is unpacked lossy
+	if len(wps.block2buff) > 0 {
+		if process_metadata_block(wpc, wps.block2buff) == FALSE {
+			wps.block2buff = nil
+
+			return unpack_init(wpc)
+		}
+	}
+
+	if (wps.wphdr.block_samples != 0) && (wps.wvbits.active == 0) && (wps.dsd.ready == FALSE) {
+		return FALSE
+	}
+
+	if ((wps.wphdr.flags & HYBRID_FLAG) != 0) && (len(wps.block2buff) == 0) {
+		wpc.lossy_blocks = TRUE
+	}
+
+	wps.sample_index = get_block_index(&wps.wphdr)
+
+	return TRUE
+}
+
+// This monster actually unpacks the WavPack bitstream(s) into the specified
+// buffer as 32-bit integers or floats (depending on orignal data). Lossy
+// samples will be clipped to their original limits (i.e. 8-bit samples are
+// clipped to -128/+127) but are still returned in ints. It is up to the
+// caller to potentially reformat this for the final output including any
+// multichannel distribution, block alignment or endian compensation. The
+// function unpack_init() must have been called and the entire WavPack block
+// must still be visible (although wps.blockbuff will not be accessed again).
+// For maximum clarity, the function is broken up into segments that handle
+// various modes. This makes for a few extra infrequent flag checks, but
+// makes the code easier to follow because the nesting does not become so
+// deep. For maximum efficiency, the conversion is isolated to tight loops
+// that handle an entire buffer. The function returns the total number of
+// samples unpacked, which can be less than the number requested if an error
+// occurs or the end of the block is reached. DSD blocks are passed on to
+// unpack_dsd_samples().
+func unpack_samples(wpc *WavpackContext, buffer []int, sample_count uint) uint {
+	var wps *WavpackStream = &wpc.stream
+	var flags uint = wps.wphdr.flags
+	var crc uint = wps.crc
+	var crc_wvc uint = wps.crc_wvc
+	var mute_limit int = (1 << ((flags & MAG_MASK) >> MAG_LSB)) + 2
+	var correction [2]int
+	var m int = 0
+	var i uint
+	var buf_idx int = 0
+	var dpp_idx int
+
+	if (flags & DSD_FLAG) != 0 {
+		return unpack_dsd_samples(wpc, buffer, sample_count)
+	}
+
+	if wps.sample_index+int64(sample_count) > block_end_index(&wps.wphdr) {
+		sample_count = uint(block_end_index(&wps.wphdr) - wps.sample_index)
+	}
+
+	if wps.mute_error != FALSE {
+		var count uint = sample_count
+
+		if (flags & MONO_FLAG) == 0 {
+			count *= 2
+		}
+
+		for i = 0; i < count; i++ {
+			buffer[i] = 0
+		}
+
+		wps.sample_index += int64(sample_count)
+
+		return sample_count
+	}
+
+	if ((flags & HYBRID_FLAG) != 0) && (wps.wvcbits.active == 0) {
+		mute_limit = (mute_limit * 2) + 128
+	}
+
+	///////////////////// handle version 4 mono data /////////////////////////
+	if (flags & (MONO_FLAG | FALSE_STEREO)) != 0 {
+		for i = 0; i < sample_count; i++ {
+			var read_word int = get_word(wps, 0, &correction[0])
+
+			if read_word == WORD_EOF {
+				break
+			}
+
+			for dpp_idx = 0; dpp_idx < wps.num_terms; dpp_idx++ {
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+				var sam int
+				var k int
+
+				if dpp.term > MAX_TERM {
+					if (dpp.term & 1) != 0 {
+						sam = 2*dpp.samples_A[0] - dpp.samples_A[1]
+					} else {
+						sam = (3*dpp.samples_A[0] - dpp.samples_A[1]) >> 1
+					}
+
+					dpp.samples_A[1] = dpp.samples_A[0]
+					k = 0
+				} else {
+					sam = dpp.samples_A[m]
+					k = (m + dpp.term) & (MAX_TERM - 1)
+				}
+
+				var temp int = apply_weight(dpp.weight_A, sam) + read_word
+				dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, sam, read_word)
+				dpp.samples_A[k] = temp
+				read_word = temp
+			}
+
+			m = (m + 1) & (MAX_TERM - 1)
+
+			crc = crc*3 + uint(read_word)
+
+			if wps.wvcbits.active != 0 {
+				if (flags & HYBRID_SHAPE) != 0 {
+					wps.dc.shaping_acc[0] += wps.dc.shaping_delta[0]
+					var shaping_weight int = wps.dc.shaping_acc[0] >> 16
+					var temp int = -apply_weight(shaping_weight, wps.dc.error[0])
+
+					if ((flags & NEW_SHAPING) != 0) && (shaping_weight < 0) && (temp != 0) {
+						if temp == wps.dc.error[0] {
+							if temp < 0 {
+								temp++
+							} else {
+								temp--
+							}
+						}
+
+						wps.dc.error[0] = temp - correction[0]
+					} else {
+						wps.dc.error[0] = -correction[0]
+					}
+
+					read_word += correction[0] - temp
+				} else {
+					read_word += correction[0]
+				}
+
+				crc_wvc = crc_wvc*3 + uint(read_word)
+			}
+
+			if (read_word > mute_limit) || (read_word < -mute_limit) {
+				break
+			}
+
+			buffer[buf_idx] = read_word
+			buf_idx++
+		}
+
+		if (flags & FALSE_STEREO) != 0 {
+			var src int = int(i) - 1
+			var dst int = int(i)*2 - 1
+
+			for src >= 0 {
+				buffer[dst] = buffer[src]
+				buffer[dst-1] = buffer[src]
+				dst -= 2
+				src--
+			}
+		}
+	} else {
+		//////////////////// handle version 4 stereo data /////////////////////////
+		for i = 0; i < sample_count; i++ {
+			var left int
+			var right int
+			var left2 int
+			var right2 int
+
+			left = get_word(wps, 0, &correction[0])
+
+			if left == WORD_EOF {
+				break
+			}
+
+			right = get_word(wps, 1, &correction[1])
+
+			if right == WORD_EOF {
+				break
+			}
+
+			for dpp_idx = 0; dpp_idx < wps.num_terms; dpp_idx++ {
+				var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+				if dpp.term > 0 {
+					var sam_A int
+					var sam_B int
+					var k int
+
+					if dpp.term > MAX_TERM {
+						if (dpp.term & 1) != 0 {
+							sam_A = 2*dpp.samples_A[0] - dpp.samples_A[1]
+							sam_B = 2*dpp.samples_B[0] - dpp.samples_B[1]
+						} else {
+							sam_A = (3*dpp.samples_A[0] - dpp.samples_A[1]) >> 1
+							sam_B = (3*dpp.samples_B[0] - dpp.samples_B[1]) >> 1
+						}
+
+						dpp.samples_A[1] = dpp.samples_A[0]
+						dpp.samples_B[1] = dpp.samples_B[0]
+						k = 0
+					} else {
+						sam_A = dpp.samples_A[m]
+						sam_B = dpp.samples_B[m]
+						k = (m + dpp.term) & (MAX_TERM - 1)
+					}
+
+					left2 = apply_weight(dpp.weight_A, sam_A) + left
+					right2 = apply_weight(dpp.weight_B, sam_B) + right
+
+					dpp.weight_A = update_weight(dpp.weight_A, dpp.delta, sam_A, left)
+					dpp.weight_B = update_weight(dpp.weight_B, dpp.delta, sam_B, right)
+
+					left = left2
+					right = right2
+					dpp.samples_A[k] = left
+					dpp.samples_B[k] = right
+				} else if dpp.term == -1 {
+					left2 = left + apply_weight(dpp.weight_A, dpp.samples_A[0])
+					dpp.weight_A = update_weight_clip(dpp.weight_A, dpp.delta, dpp.samples_A[0], uint(left))
+					left = left2
+					right2 = right + apply_weight(dpp.weight_B, left2)
+
+					// The encoder predicted the right channel from the exact
+					// left sample, which only the correction stream gives us.
+					if wps.wvcbits.active != 0 {
+						correction[1] += apply_weight(dpp.weight_B, left2+correction[0]) - apply_weight(dpp.weight_B, left2)
+					}
+
+					dpp.weight_B = update_weight_clip(dpp.weight_B, dpp.delta, left2, uint(right))
+					right = right2
+					dpp.samples_A[0] = right
+				} else {
+					right2 = right + apply_weight(dpp.weight_B, dpp.samples_B[0])
+					dpp.weight_B = update_weight_clip(dpp.weight_B, dpp.delta, dpp.samples_B[0], uint(right))
+					right = right2
+
+					if dpp.term == -3 {
+						right2 = dpp.samples_A[0]
+						dpp.samples_A[0] = right
+					} else if wps.wvcbits.active != 0 {
+						// as above, but from the exact right sample
+						correction[0] += apply_weight(dpp.weight_A, right2+correction[1]) - apply_weight(dpp.weight_A, right2)
+					}
+
+					left2 = left + apply_weight(dpp.weight_A, right2)
+					dpp.weight_A = update_weight_clip(dpp.weight_A, dpp.delta, right2, uint(left))
+					left = left2
+					dpp.samples_B[0] = left
+				}
+			}
+
+			m = (m + 1) & (MAX_TERM - 1)
+
+			if wps.wvcbits.active != 0 {
+				var left_c int = left + correction[0]
+				var right_c int = right + correction[1]
+
+				if (flags & JOINT_STEREO) != 0 {
+					right_c -= (left_c >> 1)
+					left_c += right_c
+					right -= (left >> 1)
+					left += right
+				}
+
+				crc = (crc*3+uint(left))*3 + uint(right)
+
+				if (flags & HYBRID_SHAPE) != 0 {
+					for ch := 0; ch < 2; ch++ {
+						var exact int
+						var lossy int
+
+						if ch == 0 {
+							exact = left_c
+							lossy = left
+						} else {
+							exact = right_c
+							lossy = right
+						}
+
+						correction[ch] = exact - lossy
+						wps.dc.shaping_acc[ch] += wps.dc.shaping_delta[ch]
+						var shaping_weight int = wps.dc.shaping_acc[ch] >> 16
+						var temp int = -apply_weight(shaping_weight, wps.dc.error[ch])
+
+						if ((flags & NEW_SHAPING) != 0) && (shaping_weight < 0) && (temp != 0) {
+							if temp == wps.dc.error[ch] {
+								if temp < 0 {
+									temp++
+								} else {
+									temp--
+								}
+							}
+
+							wps.dc.error[ch] = temp - correction[ch]
+						} else {
+							wps.dc.error[ch] = -correction[ch]
+						}
+
+						if ch == 0 {
+							left = exact - temp
+						} else {
+							right = exact - temp
+						}
+					}
+				} else {
+					left = left_c
+					right = right_c
+				}
+
+				crc_wvc = (crc_wvc*3+uint(left))*3 + uint(right)
+			} else {
+				if (flags & JOINT_STEREO) != 0 {
+					right -= (left >> 1)
+					left += right
+				}
+
+				crc = (crc*3+uint(left))*3 + uint(right)
+			}
+
+			if (left > mute_limit) || (left < -mute_limit) ||
+				(right > mute_limit) || (right < -mute_limit) {
+				break
+			}
+
+			buffer[buf_idx] = left
+			buffer[buf_idx+1] = right
+			buf_idx += 2
+		}
+	}
+
+	if i != sample_count {
+		var count int = int(sample_count)
+
+		if (flags & MONO_FLAG) == 0 {
+			count *= 2
+		}
+
+		for j := 0; j < count; j++ {
+			buffer[j] = 0
+		}
+
+		wps.mute_error = TRUE
+		i = sample_count
+	}
+
+	if m != 0 {
+		for dpp_idx = 0; dpp_idx < wps.num_terms; dpp_idx++ {
+			var dpp *DecorrPass = &wps.decorr_passes[dpp_idx]
+
+			if (dpp.term > 0) && (dpp.term <= MAX_TERM) {
+				var temp_A [MAX_TERM]int = dpp.samples_A
+				var temp_B [MAX_TERM]int = dpp.samples_B
+				var k int
+
+				for k = 0; k < MAX_TERM; k++ {
+					dpp.samples_A[k] = temp_A[m]
+					dpp.samples_B[k] = temp_B[m]
+					m = (m + 1) & (MAX_TERM - 1)
+				}
+			}
+		}
+	}
+
+	fixup_samples(wps, buffer, i)
+
+	if (flags & uint(FLOAT_DATA)) != 0 {
+		float_values(wps, buffer, i)
+	}
+
+	wps.sample_index += int64(i)
+	wps.crc = crc
+	wps.crc_wvc = crc_wvc
+
+	return i
+}
+
+// This is a helper function for unpack_samples() that applies the "shift"
+// indicated in the header (for data with fewer bits than bytes stored)
+// and, for lossy data, clips the samples to the original range.
+func fixup_samples(wps *WavpackStream, buffer []int, sample_count uint) {
+	var flags uint = wps.wphdr.flags
+	var shift uint = (flags & SHIFT_MASK) >> SHIFT_LSB
+	var count int = int(sample_count)
+
+	if (flags & uint(FLOAT_DATA)) != 0 {
+		return
+	}
+
+	if (flags & MONO_FLAG) == 0 {
+		count *= 2
+	}
+
+	if (flags & HYBRID_FLAG) != 0 {
+		var min_value int
+		var max_value int
+
+		switch flags & BYTES_STORED {
+		case 0:
+			min_value = -128 >> shift
+			max_value = 127 >> shift
+		case 1:
+			min_value = -32768 >> shift
+			max_value = 32767 >> shift
+		case 2:
+			min_value = -8388608 >> shift
+			max_value = 8388607 >> shift
+		default:
+			min_value = -2147483648 >> shift
+			max_value = 2147483647 >> shift
+		}
+
+		for i := 0; i < count; i++ {
+			if buffer[i] < min_value {
+				buffer[i] = min_value
+			} else if buffer[i] > max_value {
+				buffer[i] = max_value
+			}
+		}
+	}
+
+	if shift != 0 {
+		for i := 0; i < count; i++ {
+			buffer[i] <<= shift
+		}
+	}
+}
+
+// This function checks the crc value(s) for an unpacked block, returning the
+// number of actual crc errors detected for the block. The block must be
+// completely unpacked before this test is valid. For hybrid lossless (that
+// is, when a correction block is used) both the crc of the lossy samples
+// (stored in the standard block) and the crc of the final samples (stored
+// in the correction block) are checked. For floating-point data the crc of
+// the extended (wvx) data is also checked.
+func check_crc_error(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var result int = 0
+
+	if (wps.crc & 0xffffffff) != wps.wphdr.crc {
+		result++
+	}
+
+	if len(wps.block2buff) > 0 {
+		var crc2 uint = uint(wps.block2buff[28]) + (uint(wps.block2buff[29]) << 8) +
+			(uint(wps.block2buff[30]) << 16) + (uint(wps.block2buff[31]) << 24)
+
+		if wps.wvcbits.active == 0 {
+			if crc2 != wps.wphdr.crc {
+				result++
+			}
+		} else if (wps.crc_wvc & 0xffffffff) != crc2 {
+			result++
+		}
+	}
+
+	if (wps.wvxbits.active != 0) && ((wps.crc_wvx & 0xffffffff) != wps.crc_x) {
+		result++
+	}
+
+	return result
+}
+
+// Read the next word from the bitstream "wvbits" and return the value. This
+// function can be used for hybrid or lossless streams, but since an
+// optimized version is available for lossless this function would normally
+// be used for hybrid only. If a hybrid lossless stream is being read then
+// the "correction" offset is written at the specified pointer. A return value
+// of WORD_EOF indicates that the end of the bitstream was reached (all 1s) or
+// some other error occurred.
+func get_word(wps *WavpackStream, channel int, correction *int) int {
+	var ones_count uint
+	var low uint
+	var mid uint
+	var high uint
+	var sign uint
+
+	*correction = 0
+
+	if ((wps.w.median[0][0] & ^1) == 0) && (wps.w.holding_zero == 0) && (wps.w.holding_one == 0) &&
+		((wps.w.median[0][1] & ^1) == 0) {
+		if wps.w.zeros_acc != 0 {
+			wps.w.zeros_acc--
+
+			if wps.w.zeros_acc != 0 {
+				wps.w.slow_level[channel] -= (wps.w.slow_level[channel] + SLO) >> SLS
+				return 0
+			}
+		} else {
+			var cbits uint
+
+			for cbits = 0; cbits < 33 && getbit(&wps.wvbits) != 0; cbits++ {
+			}
+
+			if cbits == 33 {
+				return WORD_EOF
+			}
+
+			if cbits < 2 {
+				wps.w.zeros_acc = cbits
+			} else {
+				var mask uint = 1
+
+				wps.w.zeros_acc = 0
+
+				for cbits--; cbits > 0; cbits-- {
+					if getbit(&wps.wvbits) != 0 {
+						wps.w.zeros_acc |= mask
+					}
+
+					mask <<= 1
+				}
+
+				wps.w.zeros_acc |= mask
+			}
+
+			if wps.w.zeros_acc != 0 {
+				wps.w.slow_level[channel] -= (wps.w.slow_level[channel] + SLO) >> SLS
+				wps.w.median = [3][2]int{}
+
+				return 0
+			}
+		}
+	}
+
+	if wps.w.holding_zero != 0 {
+		ones_count = 0
+		wps.w.holding_zero = 0
+	} else {
+		for ones_count = 0; ones_count < (LIMIT_ONES+1) && getbit(&wps.wvbits) != 0; ones_count++ {
+		}
+
+		if ones_count >= LIMIT_ONES {
+			var cbits uint
+
+			if ones_count == (LIMIT_ONES + 1) {
+				return WORD_EOF
+			}
+
+			for cbits = 0; cbits < 33 && getbit(&wps.wvbits) != 0; cbits++ {
+			}
+
+			if cbits == 33 {
+				return WORD_EOF
+			}
+
+			if cbits < 2 {
+				ones_count = cbits
+			} else {
+				var mask uint = 1
+
+				ones_count = 0
+
+				for cbits--; cbits > 0; cbits-- {
+					if getbit(&wps.wvbits) != 0 {
+						ones_count |= mask
+					}
+
+					mask <<= 1
+				}
+
+				ones_count |= mask
+			}
+
+			ones_count += LIMIT_ONES
+		}
+
+		if wps.w.holding_one != 0 {
+			wps.w.holding_one = ones_count & 1
+			ones_count = (ones_count >> 1) + 1
+		} else {
+			wps.w.holding_one = ones_count & 1
+			ones_count >>= 1
+		}
+
+		wps.w.holding_zero = int(^wps.w.holding_one & 1)
+	}
+
+	if ((wps.wphdr.flags & HYBRID_FLAG) != 0) && (channel == 0) {
+		update_error_limit(wps)
+	}
+
+	if ones_count == 0 {
+		low = 0
+		high = uint(GET_MED(wps, 0, channel) - 1)
+		DEC_MED0(wps, channel)
+	} else {
+		low = uint(GET_MED(wps, 0, channel))
+		INC_MED0(wps, channel)
+
+		if ones_count == 1 {
+			high = low + uint(GET_MED(wps, 1, channel)) - 1
+			DEC_MED1(wps, channel)
+		} else {
+			low += uint(GET_MED(wps, 1, channel))
+			INC_MED1(wps, channel)
+
+			if ones_count == 2 {
+				high = low + uint(GET_MED(wps, 2, channel)) - 1
+				DEC_MED2(wps, channel)
+			} else {
+				low += (ones_count - 2) * uint(GET_MED(wps, 2, channel))
+				high = low + uint(GET_MED(wps, 2, channel)) - 1
+				INC_MED2(wps, channel)
+			}
+		}
+	}
+
+	low &= 0x7fffffff
+	high &= 0x7fffffff
+
+	if low > high {
+		high = low
+	}
+
+	mid = (high + low + 1) >> 1
+
+	if wps.w.error_limit[channel] == 0 {
+		mid = read_code(&wps.wvbits, high-low) + low
+	} else {
+		for (high - low) > uint(wps.w.error_limit[channel]) {
+			if getbit(&wps.wvbits) != 0 {
+				low = mid
+				mid = (high + low + 1) >> 1
+			} else {
+				high = mid - 1
+				mid = (high + low + 1) >> 1
+			}
+		}
+	}
+
+	sign = getbit(&wps.wvbits)
+
+	if (wps.wvcbits.active != 0) && (wps.w.error_limit[channel] != 0) {
+		var value uint = read_code(&wps.wvcbits, high-low) + low
+
+		if sign != 0 {
+			*correction = int(mid) - int(value)
+		} else {
+			*correction = int(value) - int(mid)
+		}
+	}
+
+	if (wps.wphdr.flags & HYBRID_BITRATE) != 0 {
+		wps.w.slow_level[channel] -= (wps.w.slow_level[channel] + SLO) >> SLS
+		wps.w.slow_level[channel] += mylog2(int(mid))
+	}
+
+	if sign != 0 {
+		return ^int(mid)
+	}
+
+	return int(mid)
+}
+
+// Read a single unsigned value from the specified bitstream with a value
+// from 0 to maxcode. If there are exactly a power of two number of possible
+// codes then this will read a fixed number of bits; otherwise it reads the
+// minimum number of bits and then determines whether another bit is needed
+// to define the code.
+func read_code(bs *Bitstream, maxcode uint) uint {
+	var bitcount int = count_bits(maxcode)
+	var extras uint = bitset[bitcount] - maxcode - 1
+	var code uint
+
+	if bitcount == 0 {
+		return 0
+	}
+
+	code = getbits(uint(bitcount-1), bs) & bitmask[bitcount-1]
+
+	if code >= extras {
+		code = (code << 1) - extras
+
+		if getbit(bs) != 0 {
+			code++
+		}
+	}
+
+	return code
+}
@@ -0,0 +1,34 @@
+package wvencode
+
+/*
+** VerifyResult.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+// A range of samples that failed verification, which is either a block
+// whose samples don't match its crc (or that couldn't be unpacked at all),
+// samples that no block was found for, because the blocks holding them were
+// too damaged to be read, or a hybrid block whose correction block was
+// missing or damaged (when unpacking with a correction file).
+type WavpackDamagedBlock struct {
+	Block_index   int64 // index of the first sample of the range
+	Block_samples int64
+	Missing       int // TRUE if there was no block for the samples
+	Correction    int // TRUE if only the correction block is at fault
+}
+
+type WavpackVerifyResult struct {
+	Total_samples    int64 // -1 if unknown
+	Samples_unpacked int64
+	Damaged_blocks   []WavpackDamagedBlock
+	Lossy_blocks     int // TRUE if any blocks were hybrid blocks unpacked without correction
+	Md5_stored       int // TRUE if the file has an MD5 signature
+	Md5_checked      int // TRUE if the MD5 signature was checked (the audio must be lossless)
+	Md5_match        int // TRUE if the MD5 signature matches the unpacked audio
+}
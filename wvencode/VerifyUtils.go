@@ -0,0 +1,114 @@
+package wvencode
+
+/*
+** VerifyUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+)
+
+// Returns the blocks found to be damaged so far in unpacking the file (see
+// WavpackDamagedBlock), in the order of their samples.
+func WavpackGetDamagedBlocks(wpc *WavpackContext) []WavpackDamagedBlock {
+	if nil != wpc {
+		return wpc.damaged_blocks
+	}
+
+	return nil
+}
+
+// Verify the WavPack file opened in "wpc", without needing the original
+// audio. All the audio is unpacked (from the start, so nothing must have
+// been unpacked already) and the crc of every block is checked, which with a
+// correction file is the crc of the lossless audio too. The blocks are
+// checked the way error concealment does (see WavpackSetErrorConcealment()),
+// so if a block fails with its correction block but its lossy audio passes
+// its own crc, only the correction block is reported as damaged. If the file
+// has an MD5 signature and the audio is lossless, the signature of the
+// unpacked audio (in the byte format of the original file) is checked as
+// well. What was found is returned in "result", and the return value is TRUE
+// if the file is intact, that is if there are no damaged blocks (in the file
+// or the correction file) and the MD5 signature matches. The MD5 signature
+// of DSD audio is of the bytes of DSD bits as they are unpacked.
+func WavpackVerifyFile(wpc *WavpackContext, result *WavpackVerifyResult) int {
+	var num_channels int = WavpackGetNumChannels(wpc)
+	var bytes_per_sample int = WavpackGetBytesPerSample(wpc)
+	var qmode int = WavpackGetQualifyMode(wpc)
+	var samples_per_buffer uint = 4096
+	var sample_buffer []int = make([]int, int(samples_per_buffer)*num_channels)
+	var pcm_buffer []byte = make([]byte, len(sample_buffer)*bytes_per_sample)
+	var md5_context hash.Hash = md5.New()
+
+	*result = WavpackVerifyResult{}
+	result.Total_samples = WavpackGetNumSamples64(wpc)
+
+	WavpackSetErrorConcealment(wpc, TRUE, wpc.conceal_callback)
+
+	// the DSD bytes are taken as they are
+	if (qmode & QMODE_DSD_AUDIO) != 0 {
+		qmode = QMODE_SIGNED_BYTES
+	}
+
+	for {
+		var samples_unpacked uint = WavpackUnpackSamples(wpc, sample_buffer, samples_per_buffer)
+		var num_values int = int(samples_unpacked) * num_channels
+
+		if samples_unpacked == 0 {
+			break
+		}
+
+		WavpackFormatPCM(pcm_buffer, sample_buffer[0:num_values], bytes_per_sample, qmode)
+		md5_context.Write(pcm_buffer[0 : num_values*bytes_per_sample])
+		result.Samples_unpacked += int64(samples_unpacked)
+	}
+
+	// the MD5 signature is in a block after the audio
+	WavpackSeekTrailingWrapper(wpc)
+
+	result.Damaged_blocks = wpc.damaged_blocks
+	result.Lossy_blocks = WavpackLossyBlocks(wpc)
+
+	if md5_sum := WavpackGetMD5Sum(wpc); md5_sum != nil {
+		result.Md5_stored = TRUE
+
+		if ((WavpackGetMode(wpc) & MODE_LOSSLESS) != 0) && (result.Lossy_blocks == FALSE) {
+			result.Md5_checked = TRUE
+
+			if bytes.Equal(md5_context.Sum(nil), md5_sum) {
+				result.Md5_match = TRUE
+			}
+		}
+	}
+
+	for _, damaged := range result.Damaged_blocks {
+		if damaged.Correction == FALSE {
+			wpc.error_message = "the file has damaged blocks!"
+
+			return FALSE
+		}
+	}
+
+	if len(result.Damaged_blocks) != 0 {
+		wpc.error_message = "the correction file is incomplete or damaged!"
+
+		return FALSE
+	}
+
+	if (result.Md5_checked == TRUE) && (result.Md5_match == FALSE) {
+		wpc.error_message = "the MD5 signature doesn't match the audio!"
+
+		return FALSE
+	}
+
+	return TRUE
+}
@@ -11,6 +11,10 @@ package wvencode
 **
  */
 
+import (
+	"math"
+)
+
 ///////////////////////////// local table storage ////////////////////////////
 var sample_rates = [15]uint{6000, 8000, 9600, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000, 64000, 88200, 96000, 192000}
 
@@ -22,6 +26,17 @@ func WavpackGetErrorMessage(wpc *WavpackContext) string {
 }
 
 
+// This is the original version of WavpackSetConfiguration64() for 32-bit
+// sample counts, where an unknown length is passed as 0xffffffff (which was
+// -1 as a uint32_t in C).
+func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_samples uint) int {
+	if total_samples == 0xffffffff {
+		return WavpackSetConfiguration64(wpc, config, -1)
+	}
+
+	return WavpackSetConfiguration64(wpc, config, int64(total_samples))
+}
+
 // Set configuration for writing WavPack files. This must be done before
 // sending any actual samples. The "config" structure contains the following
 // required information:
@@ -33,8 +48,13 @@ func WavpackGetErrorMessage(wpc *WavpackContext) string {
 // config->flags:
 // --------------
 // o CONFIG_HYBRID_FLAG         select hybrid mode (must set bitrate)
+// o CONFIG_FIXED_ERROR         hybrid mode with a fixed maximum error instead
+//                               of a bitrate (must set error_level), which
+//                               always codes stereo as left/right
 // o CONFIG_JOINT_STEREO        select joint stereo (must set override also)
 // o CONFIG_JOINT_OVERRIDE      override default joint stereo selection
+// o CONFIG_JOINT_AUTO          select joint stereo for each block (lossless)
+// o CONFIG_ADAPTIVE_BLOCKS    select the length of each block (lossless)
 // o CONFIG_HYBRID_SHAPE        select hybrid noise shaping (set override &
 //                                                      shaping_weight != 0)
 // o CONFIG_SHAPE_OVERRIDE      override default hybrid noise shaping
@@ -45,10 +65,24 @@ func WavpackGetErrorMessage(wpc *WavpackContext) string {
 // o CONFIG_CREATE_WVC          create correction file
 // o CONFIG_OPTIMIZE_WVC        maximize bybrid compression (-cc option)
 // config->bitrate              hybrid bitrate in bits/sample (scaled up 2^8)
+// config->error_level          hybrid maximum error in dB relative to full
+//                               scale, so negative (scaled up 2^8)
 // config->shaping_weight       hybrid noise shaping coefficient (scaled up 2^10)
 // config->block_samples        force samples per WavPack block (0 = use deflt)
+// config->qmode                QMODE_xxx flags describing the source format
+//                               (QMODE_BIG_ENDIAN, QMODE_SIGNED_BYTES,
+//                                QMODE_UNSIGNED_WORDS)
+// config->channel_mask         Microsoft standard channel mask (0 = default
+//                               for the number of channels)
+// Floating point data is indicated with CONFIG_FLOAT_DATA in config->flags
+// and must be 32-bit (4 bytes per sample); hybrid mode is not supported for
+// it.
+// DSD audio is indicated by one of the QMODE_DSD_xxx flags in config->qmode.
+// The samples are then bytes of 8 DSD bits (MSB first) and sample_rate is the
+// DSD bit rate divided by 8. Of the mode flags only CONFIG_FAST_FLAG applies
+// (the default for DSD is the "high" mode) and hybrid mode is not supported.
 // If the number of samples to be written is known then it should be passed
-// here. If the duration is not known then pass -1. In the case that the size
+// here (it may be up to 40 bits). If the duration is not known then pass -1. In the case that the size
 // is not known (or the writing is terminated early) then it is suggested that
 // the application retrieve the first block written and let the library update
 // the total samples indication. A function is provided to do this update and
@@ -57,9 +91,9 @@ func WavpackGetErrorMessage(wpc *WavpackContext) string {
 // be created, but when applications want to access that file they will have
 // to seek all the way to the end to determine the actual duration. A return of
 // FALSE indicates an error.
-func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_samples uint) int {
+func WavpackSetConfiguration64(wpc *WavpackContext, config *WavpackConfig, total_samples int64) int {
 	var flags uint = uint(config.Bytes_per_sample - 1)
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 	var bps int = 0
 	var shift uint
 	var i uint
@@ -70,9 +104,6 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 		return FALSE
 	}
 
-	wps.blockend = BIT_BUFFER_SIZE  // need to initialise this
-	wps.block2end = BIT_BUFFER_SIZE // and initialise this
-
 	wpc.total_samples = total_samples
 	wpc.config.Sample_rate = config.Sample_rate
 	wpc.config.Num_channels = config.Num_channels
@@ -80,6 +111,65 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 	wpc.config.Bytes_per_sample = config.Bytes_per_sample
 	wpc.config.Block_samples = config.Block_samples
 	wpc.config.Flags = config.Flags
+	wpc.config.Qmode = config.Qmode
+	wpc.config.Channel_mask = config.Channel_mask
+
+	if wpc.config.Channel_mask == 0 {
+		wpc.config.Channel_mask = 0x5 - config.Num_channels
+	}
+
+	if (config.Flags & CONFIG_FLOAT_DATA) != 0 {
+		if (config.Bytes_per_sample != 4) || (config.Bits_per_sample != 32) {
+			wpc.error_message = "floating point data must be 32-bit!"
+
+			return FALSE
+		}
+
+		if (config.Flags & CONFIG_HYBRID_FLAG) != 0 {
+			wpc.error_message = "hybrid mode is not supported for floating point data!"
+
+			return FALSE
+		}
+
+		wps.float_norm_exp = 127 // floats are normalized to +/-1.0
+	}
+
+	if (config.Qmode & QMODE_DSD_AUDIO) != 0 {
+		if (config.Bytes_per_sample != 1) || (config.Bits_per_sample != 8) {
+			wpc.error_message = "DSD audio must be packed into 8-bit samples!"
+
+			return FALSE
+		}
+
+		if (config.Flags & (CONFIG_HYBRID_FLAG | CONFIG_FLOAT_DATA)) != 0 {
+			wpc.error_message = "hybrid mode is not supported for DSD audio!"
+
+			return FALSE
+		}
+
+		// the DSD rate is stored as a standard rate and a power-of-two
+		// multiplier, so look for the highest standard rate that works
+		wpc.dsd_multiplier = 1
+		flags |= DSD_FLAG
+
+		for i = 15; i > 0; i-- {
+			if (config.Sample_rate % sample_rates[i-1]) == 0 {
+				var divisor uint = config.Sample_rate / sample_rates[i-1]
+
+				if (divisor & (divisor - 1)) == 0 {
+					wpc.config.Sample_rate /= divisor
+					wpc.dsd_multiplier = divisor
+					break
+				}
+			}
+		}
+
+		wpc.config.Flags &= ^(CONFIG_HIGH_FLAG | CONFIG_VERY_HIGH_FLAG)
+
+		if (config.Flags & CONFIG_FAST_FLAG) == 0 {
+			wpc.config.Flags |= CONFIG_HIGH_FLAG
+		}
+	}
 
 	if (wpc.config.Flags & CONFIG_VERY_HIGH_FLAG) > 0 {
 		wpc.config.Flags |= CONFIG_HIGH_FLAG
@@ -96,8 +186,16 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 	flags |= (i << SRATE_LSB)
 	flags |= (shift << SHIFT_LSB)
 
+	if (config.Flags & CONFIG_FLOAT_DATA) != 0 {
+		flags |= FLOAT_DATA
+	}
+
 	if (config.Flags & CONFIG_HYBRID_FLAG) != 0 {
-		flags |= (HYBRID_FLAG | HYBRID_BITRATE | HYBRID_BALANCE)
+		flags |= HYBRID_FLAG
+
+		if (config.Flags & CONFIG_FIXED_ERROR) == 0 {
+			flags |= (HYBRID_BITRATE | HYBRID_BALANCE)
+		}
 
 		if ((wpc.config.Flags & CONFIG_SHAPE_OVERRIDE) != 0) &&
 			((wpc.config.Flags & CONFIG_HYBRID_SHAPE) != 0) &&
@@ -110,13 +208,38 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 			flags |= CROSS_DECORR
 		}
 
-		bps = config.Bitrate
-	} else {
+		if (config.Flags & CONFIG_FIXED_ERROR) != 0 {
+			var max_error int = int(math.Ldexp(math.Pow(10, float64(config.Error_level)/(256*20)),
+				config.Bits_per_sample-1))
+
+			// with a fixed error limit "bits" is the log2 of the limit
+			// (scaled up 2^8) as passed to exp2s(), which returns half the
+			// power of 2. The limit is the width of the range that each
+			// sample is coded to, and a sample can be off by half of it
+			// (rounded up), so it must not be more than twice the maximum
+			// error (and 6.0206 dB is one bit). exp2s() isn't exact, so we
+			// start a little high and come down until it fits.
+			bps = ((config.Bits_per_sample - 1) << 8) + 512 + 16 + (config.Error_level * 1000 / 6021)
+
+			for (bps > 0) && (exp2s(bps) > max_error*2) {
+				bps--
+			}
+
+			if bps < 0 {
+				bps = 0
+			}
+		} else {
+			bps = config.Bitrate
+		}
+	} else if (flags & DSD_FLAG) == 0 {
 		flags |= CROSS_DECORR
 	}
 
-	if ((config.Flags & CONFIG_JOINT_OVERRIDE) == 0) ||
-		((config.Flags & CONFIG_JOINT_STEREO) != 0) {
+	// with a fixed error limit stereo is always left/right, because the
+	// errors of mid and side would add up in the left and right channels
+	if (((config.Flags & CONFIG_JOINT_OVERRIDE) == 0) ||
+		((config.Flags & CONFIG_JOINT_STEREO) != 0)) && ((flags & DSD_FLAG) == 0) &&
+		((config.Flags & CONFIG_FIXED_ERROR) == 0) {
 		flags |= JOINT_STEREO
 	}
 
@@ -124,7 +247,11 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 		wpc.wvc_flag = TRUE
 	}
 
-	wpc.stream_version = CUR_STREAM_VERS
+	if (flags & DSD_FLAG) != 0 {
+		wpc.stream_version = DSD_STREAM_VERS
+	} else {
+		wpc.stream_version = CUR_STREAM_VERS
+	}
 
 	wps.wphdr.ckID[0] = 'w'
 	wps.wphdr.ckID[1] = 'v'
@@ -133,7 +260,7 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 
 	// 32 is the size of the WavPack header
 	wps.wphdr.ckSize = 32 - 8
-	wps.wphdr.total_samples = wpc.total_samples
+	set_total_samples(&wps.wphdr, wpc.total_samples)
 	wps.wphdr.version = wpc.stream_version
 	wps.wphdr.flags = flags | INITIAL_BLOCK | FINAL_BLOCK
 	wps.bits = bps
@@ -144,18 +271,68 @@ func WavpackSetConfiguration(wpc *WavpackContext, config *WavpackConfig, total_s
 		wps.wphdr.flags |= MONO_FLAG
 	}
 
-	wpc.stream = wps
+	return TRUE
+}
+
+
+// Add wrapper to WavPack blocks. The header of the source file should be
+// added before sending any audio samples and is written into the first
+// block. For RIFF files this will be stored as ID_RIFF_HEADER, while for the
+// other formats (see WavpackSetFileInformation()) it is stored as
+// ID_ALT_HEADER so that the original file can be restored on unpacking. If
+// this is called after samples have been sent then the data is the trailer
+// of the source file (whatever follows the audio data), which is stored as
+// ID_RIFF_TRAILER or ID_ALT_TRAILER in a block at the end of the file. A
+// return of FALSE indicates an error.
+func WavpackAddWrapper(wpc *WavpackContext, data []byte) int {
+	if (wpc.stream.sample_index != 0) || (wpc.acc_samples != 0) || (len(wpc.block_buffer) != 0) {
+		if wpc.file_format == WP_FORMAT_WAV {
+			add_to_metadata(wpc, data, int(ID_RIFF_TRAILER))
+		} else {
+			add_to_metadata(wpc, data, ID_ALT_TRAILER)
+		}
+
+		return TRUE
+	}
+
+	wpc.wrapper_data = append(wpc.wrapper_data, data...)
 
 	return TRUE
 }
 
 
+// Set the "file format" and extension of the source file. This is stored in
+// the first block so that the original file type can be restored on
+// unpacking. The extension should not include the period (e.g. "aif"). The
+// default is WP_FORMAT_WAV with no extension stored.
+func WavpackSetFileInformation(wpc *WavpackContext, file_extension string, file_format int) {
+	wpc.file_extension = file_extension
+	wpc.file_format = file_format
+}
+
+
 // Prepare to actually pack samples by determining the size of the WavPack
 // blocks and initializing the stream. Call after WavpackSetConfiguration()
 // and before WavpackPackSamples(). A return of FALSE indicates an error.
 func WavpackPackInit(wpc *WavpackContext) int {
 	if wpc.config.Block_samples > 0 {
 		wpc.block_samples = wpc.config.Block_samples
+	} else if (wpc.stream.wphdr.flags & DSD_FLAG) != 0 {
+		// DSD blocks are about a second long at DSD64 in "fast" mode, and
+		// half that in "high" mode (which is much slower to decode)
+		if (wpc.config.Sample_rate % 7) != 0 {
+			wpc.block_samples = 48000
+		} else {
+			wpc.block_samples = 44100
+		}
+
+		if (wpc.config.Flags & CONFIG_HIGH_FLAG) != 0 {
+			wpc.block_samples /= 2
+		}
+
+		if wpc.config.Num_channels == 1 {
+			wpc.block_samples *= 2
+		}
 	} else {
 		if (wpc.config.Flags & CONFIG_HIGH_FLAG) > 0 {
 			wpc.block_samples = wpc.config.Sample_rate
@@ -172,6 +349,12 @@ func WavpackPackInit(wpc *WavpackContext) int {
 		for (wpc.block_samples * wpc.config.Num_channels) < 40000 {
 			wpc.block_samples *= 2
 		}
+
+		// adaptive blocks can be up to twice the usual length, but can be
+		// split down to much shorter ones (see plan_blocks())
+		if adaptive_blocks(wpc) == TRUE {
+			wpc.block_samples *= 2
+		}
 	}
 
 	pack_init(wpc)
@@ -193,11 +376,17 @@ func WavpackPackInit(wpc *WavpackContext) int {
 // function provided in the initial call to WavpackOpenFileOutput(). A
 // return of FALSE indicates an error.
 func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count uint) int {
-	var wps WavpackStream = wpc.stream
+	var wps *WavpackStream = &wpc.stream
 	var flags uint = wps.wphdr.flags
 
-	if (flags & SHIFT_MASK) != 0 {
-		shift := (uint)((flags & SHIFT_MASK) >> SHIFT_LSB)
+	if (flags & (FLOAT_DATA | DSD_FLAG)) != 0 {
+		return buffer_samples(wpc, sample_buffer, sample_count)
+	}
+
+	// the flags of the last block can include wasted bits, so the shift for
+	// the source format is taken from the configuration
+	if wpc.config.Bits_per_sample != wpc.config.Bytes_per_sample*8 {
+		shift := uint((wpc.config.Bytes_per_sample * 8) - wpc.config.Bits_per_sample)
 		ptr := sample_buffer[0:len(sample_buffer)]
 		cnt := sample_count
 		ptrIndex := 0
@@ -219,6 +408,13 @@ func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count u
 		}
 	}
 
+	// lossless blocks are accumulated so that each can be checked for zero
+	// LSBs before it is packed (in hybrid mode the bitrate is set per sample,
+	// so the samples are packed as they arrive)
+	if (flags & HYBRID_FLAG) == 0 {
+		return buffer_samples(wpc, sample_buffer, sample_count)
+	}
+
 	for sample_count > 0 {
 		var samples_to_pack uint
 		var samples_packed uint
@@ -227,10 +423,18 @@ func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count u
 			flags &= ^MAG_MASK
 			flags += ((1 << MAG_LSB) * (((flags & BYTES_STORED) * 8) + 7))
 
-			wps.wphdr.block_index = wps.sample_index
+			set_block_index(&wps.wphdr, wps.sample_index)
 			wps.wphdr.flags = flags
-			wpc.stream = wps
-			pack_start_block(wpc)
+
+			if wpc.target_size != 0 {
+				wps.bits = target_block_bitrate(wpc, wps.sample_index)
+			}
+
+			if pack_start_block(wpc) == FALSE {
+				wpc.error_message = "output buffer overflowed!"
+
+				return FALSE
+			}
 		}
 
 		if (wpc.acc_samples + sample_count) > wpc.block_samples {
@@ -240,7 +444,6 @@ func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count u
 		}
 
 		samples_packed = pack_samples(wpc, sample_buffer, samples_to_pack)
-		wps = wpc.stream
 
 		sample_count -= samples_packed
 
@@ -251,7 +454,6 @@ func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count u
 				return FALSE
 			}
 		}
-		wps = wpc.stream
 	}
 
 	return TRUE
@@ -264,16 +466,478 @@ func WavpackPackSamples(wpc *WavpackContext, sample_buffer []int, sample_count u
 // is possible to continue after this operation). A return of FALSE indicates
 // an error.
 func WavpackFlushSamples(wpc *WavpackContext) int {
+	for len(wpc.block_buffer) > 0 {
+		if pack_buffered_block(wpc) == FALSE {
+			return FALSE
+		}
+	}
+
 	if (wpc.acc_samples != 0) && (finish_block(wpc) == 0) {
 		return FALSE
 	}
 
+	if (len(wpc.metadata) != 0) && (write_metadata_block(wpc) == FALSE) {
+		return FALSE
+	}
+
+	return TRUE
+}
+
+// Accumulate the specified floating point samples (32-bit IEEE floats
+// stored in the ints of the buffer), lossless integer samples or DSD
+// samples. The float information and the wasted bits of integer data
+// written at the start of each block depend on all the samples in that
+// block, and DSD blocks are encoded in one go, so in all cases the samples
+// are just accumulated here until a complete block is available for
+// pack_buffered_block().
+func buffer_samples(wpc *WavpackContext, sample_buffer []int, sample_count uint) int {
+	var num_values int = int(sample_count * wpc.config.Num_channels)
+
+	wpc.block_buffer = append(wpc.block_buffer, sample_buffer[wpc.Byte_idx:wpc.Byte_idx+num_values]...)
+	wpc.Byte_idx += num_values
+
+	for uint(len(wpc.block_buffer)) >= (wpc.block_samples * wpc.config.Num_channels) {
+		if pack_buffered_block(wpc) == FALSE {
+			return FALSE
+		}
+	}
+
+	return TRUE
+}
+
+// Return a buffer for the copy of the "count" samples of a block that is
+// packed (which is changed by packing), reusing the one from the last block
+// whenever it is big enough.
+func block_values(wpc *WavpackContext, count uint) []int {
+	if uint(cap(wpc.block_values)) < count {
+		wpc.block_values = make([]int, count)
+	}
+
+	return wpc.block_values[0:count]
+}
+
+// Pack one block from the samples accumulated by buffer_samples().
+func pack_buffered_block(wpc *WavpackContext) int {
+	if (wpc.stream.wphdr.flags & DSD_FLAG) != 0 {
+		return pack_dsd_block(wpc)
+	}
+
+	if (wpc.stream.wphdr.flags & FLOAT_DATA) != 0 {
+		return pack_float_block(wpc)
+	}
+
+	return pack_int_block(wpc)
+}
+
+// Pack one block from the accumulated integer samples. With adaptive blocks
+// the length of the block comes from plan_blocks(). Any low-order bits that
+// are zero in every sample of the block are shifted out of a copy of the
+// samples by scan_wasted_bits(), joint stereo is selected for the block if
+// that is automatic, and the block is then packed in the regular way. If the
+// block has to be terminated early then the samples that were not packed are
+// kept for the next block. A return of FALSE indicates an error.
+func pack_int_block(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var num_channels uint = wpc.config.Num_channels
+	var sample_count uint = uint(len(wpc.block_buffer)) / num_channels
+	var byte_idx int = wpc.Byte_idx
+	var samples_packed uint
+	var values []int
+
+	if sample_count > wpc.block_samples {
+		sample_count = wpc.block_samples
+	}
+
+	// the blocks are planned for all the samples that are available and
+	// then packed one at a time
+	if adaptive_blocks(wpc) == TRUE {
+		if len(wpc.block_plan) == 0 {
+			var saved WavpackStream = wpc.stream
+
+			_, wpc.block_plan, _ = plan_blocks(wpc, saved, wpc.block_buffer[0:sample_count*num_channels])
+			wpc.stream = saved
+		}
+
+		sample_count = wpc.block_plan[0]
+		wpc.block_plan = wpc.block_plan[1:]
+	}
+
+	values = block_values(wpc, sample_count*num_channels)
+	copy(values, wpc.block_buffer)
+
+	set_block_index(&wps.wphdr, wps.sample_index)
+	scan_wasted_bits(wpc, wps, values)
+	select_joint_stereo(wpc, wps, values)
+
+	if pack_start_block(wpc) == FALSE {
+		wpc.error_message = "output buffer overflowed!"
+
+		return FALSE
+	}
+
+	wpc.Byte_idx = 0
+	samples_packed = pack_samples(wpc, values, sample_count)
+	wpc.Byte_idx = byte_idx
+
+	// a block that is cut short makes the rest of the plan useless
+	if samples_packed != sample_count {
+		wpc.block_plan = nil
+	}
+
+	wpc.acc_samples = samples_packed
+	wpc.block_buffer = wpc.block_buffer[samples_packed*num_channels:]
+
+	return finish_block(wpc)
+}
+
+// Adaptive block lengths are only for lossless integer audio, where the
+// samples of each block are buffered anyway.
+func adaptive_blocks(wpc *WavpackContext) int {
+	if ((wpc.config.Flags & CONFIG_ADAPTIVE_BLOCKS) != 0) &&
+		((wpc.stream.wphdr.flags & (HYBRID_FLAG | FLOAT_DATA | DSD_FLAG)) == 0) {
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Choose the lengths of the blocks for the samples in "values", starting
+// with the stream state "wps". The samples are trial-packed as one block and
+// as two halves (each of which is planned in the same way, down to
+// ADAPTIVE_MIN_BLOCK of the longest block). The halves win if they take
+// fewer bytes, which happens where the wasted bits or the best joint stereo
+// mode change. Otherwise splitting only adds the overhead of another block,
+// since the decorrelation and entropy coder carry on from one block to the
+// next, so the halves also win if they're either side of a transient (see
+// transient()) and cost no more than ADAPTIVE_SPLIT_COST bytes for each
+// extra block. This gives finer seeking around transients and changes
+// between silence and sound, while stationary audio is packed in longer
+// blocks with less overhead. The total bytes, the block lengths and the
+// stream state after the blocks are returned. The context's stream is
+// changed by the trials, so the caller must restore it.
+func plan_blocks(wpc *WavpackContext, wps WavpackStream, values []int) (int, []uint, WavpackStream) {
+	var num_channels uint = wpc.config.Num_channels
+	var sample_count uint = uint(len(values)) / num_channels
+	var half uint = sample_count / 2
+	var whole_bytes, first_bytes, second_bytes int
+	var whole_wps, first_wps, second_wps WavpackStream
+	var first_plan, second_plan []uint
+	var result int
+
+	whole_bytes, whole_wps, result = trial_block(wpc, wps, values)
+
+	if half < (wpc.block_samples / ADAPTIVE_MIN_BLOCK) {
+		return whole_bytes, []uint{sample_count}, whole_wps
+	}
+
+	first_bytes, first_plan, first_wps = plan_blocks(wpc, wps, values[0:half*num_channels])
+	second_bytes, second_plan, second_wps = plan_blocks(wpc, first_wps, values[half*num_channels:])
+
+	// the halves may be in several blocks each
+	var split_cost int = ADAPTIVE_SPLIT_COST * (len(first_plan) + len(second_plan) - 1)
+
+	if (result == FALSE) || ((first_bytes + second_bytes) < whole_bytes) ||
+		((transient(first_bytes, second_bytes) == TRUE) && ((first_bytes + second_bytes) <= whole_bytes+split_cost)) {
+		return first_bytes + second_bytes, append(first_plan, second_plan...), second_wps
+	}
+
+	return whole_bytes, []uint{sample_count}, whole_wps
+}
+
+// Two halves of a block are taken to be either side of a transient (or of
+// the start or end of the audio) if one takes ADAPTIVE_TRANSIENT times as
+// many bytes as the other.
+func transient(first_bytes int, second_bytes int) int {
+	if (first_bytes >= second_bytes*ADAPTIVE_TRANSIENT) || (second_bytes >= first_bytes*ADAPTIVE_TRANSIENT) {
+		return TRUE
+	}
+
+	return FALSE
+}
+
+// Pack the samples in "values" as a block starting with the stream state
+// "wps", but only to find out how many bytes it takes. The bytes and the
+// stream state after the block are returned, with a result of FALSE if the
+// block overflowed (in which case it should be split).
+func trial_block(wpc *WavpackContext, wps WavpackStream, values []int) (int, WavpackStream, int) {
+	var block []int = make([]int, len(values))
+
+	copy(block, values)
+
+	set_block_index(&wps.wphdr, wps.sample_index)
+	scan_wasted_bits(wpc, &wps, block)
+
+	if joint_stereo_auto(wpc, &wps) == TRUE {
+		return trial_joint_stereo(wpc, wps, block)
+	}
+
+	return trial_pack(wpc, wps, block)
+}
+
+// Trial-pack the stereo samples in "values" as left/right and as mid/side
+// (see trial_pack()) and return the result for whichever takes fewer bytes,
+// or for left/right if neither fits. JOINT_STEREO in the header of the
+// stream state returned says which it was.
+func trial_joint_stereo(wpc *WavpackContext, wps WavpackStream, values []int) (int, WavpackStream, int) {
+	wps.wphdr.flags &= ^JOINT_STEREO
+	lr_bytes, lr_wps, lr_result := trial_pack(wpc, wps, values)
+
+	wps.wphdr.flags |= JOINT_STEREO
+	ms_bytes, ms_wps, ms_result := trial_pack(wpc, wps, values)
+
+	if (ms_result == TRUE) && ((lr_result == FALSE) || (ms_bytes < lr_bytes)) {
+		return ms_bytes, ms_wps, ms_result
+	}
+
+	return lr_bytes, lr_wps, lr_result
+}
+
+// Pack the samples in "values" (which are left as they are) as a block
+// with the stream state "wps", which must be ready for the block (see
+// trial_block()), and return the bytes, the stream state after the block
+// and a result of FALSE if the block overflowed. The context's stream is
+// changed.
+func trial_pack(wpc *WavpackContext, wps WavpackStream, values []int) (int, WavpackStream, int) {
+	var sample_count uint = uint(len(values)) / wpc.config.Num_channels
+	var byte_idx int = wpc.Byte_idx
+	var samples_packed uint
+	var block []int = make([]int, len(values))
+
+	copy(block, values)
+	wpc.stream = wps
+
+	if pack_start_block(wpc) == FALSE {
+		return 0, wps, FALSE
+	}
+
+	wpc.Byte_idx = 0
+	samples_packed = pack_samples(wpc, block, sample_count)
+	wpc.Byte_idx = byte_idx
+
+	if (samples_packed != sample_count) || (pack_finish_block(wpc) == FALSE) {
+		return 0, wps, FALSE
+	}
+
+	wps = wpc.stream
+
+	return (int(wps.blockbuff[4]) & 0xff) + ((int(wps.blockbuff[5]) & 0xff) << 8) +
+		((int(wps.blockbuff[6]) & 0xff) << 16) + ((int(wps.blockbuff[7]) & 0xff) << 24) + 8, wps, TRUE
+}
+
+// Pack one block from the accumulated floating point samples. A copy of the
+// samples is converted to integers by scan_float_data() and packed in the
+// regular way, while anything lost in the conversion is sent to the "wvx"
+// bitstream. If the block has to be terminated early then the samples that
+// were not packed are kept for the next block. A return of FALSE indicates
+// an error.
+func pack_float_block(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var num_channels uint = wpc.config.Num_channels
+	var sample_count uint = uint(len(wpc.block_buffer)) / num_channels
+	var byte_idx int = wpc.Byte_idx
+	var samples_packed uint
+	var values []int
+
+	if sample_count > wpc.block_samples {
+		sample_count = wpc.block_samples
+	}
+
+	values = block_values(wpc, sample_count*num_channels)
+	copy(values, wpc.block_buffer)
+
+	set_block_index(&wps.wphdr, wps.sample_index)
+
+	if scan_float_data(wps, values, len(values)) != 0 {
+		wps.wvxbuff = make([]byte, (len(values)*5)+16) // worst case is 33 bits a value
+		bs_open_write(&wps.wvxbits, wps.wvxbuff, 0, len(wps.wvxbuff))
+	} else {
+		wps.wvxbits.active = 0
+	}
+
+	select_joint_stereo(wpc, wps, values)
+
+	if pack_start_block(wpc) == FALSE {
+		wpc.error_message = "output buffer overflowed!"
+
+		return FALSE
+	}
+
+	wpc.Byte_idx = 0
+	samples_packed = pack_samples(wpc, values, sample_count)
+	wpc.Byte_idx = byte_idx
+
+	if wps.wvxbits.active != 0 {
+		send_float_data(wps, wpc.block_buffer, int(samples_packed*num_channels))
+	}
+
+	wpc.acc_samples = samples_packed
+	wpc.block_buffer = wpc.block_buffer[samples_packed*num_channels:]
+
+	return finish_block(wpc)
+}
+
+// Aim for a total size in bytes for the audio blocks of a hybrid file that
+// has a bitrate (rather than a fixed error). The sizes of the blocks in
+// "block_sizes" come from an analysis pass over the same audio packed at the
+// configured bitrate (see WavpackGetBlockSizes()), and "block_samples" is the
+// number of samples in each of them. The bitrate of every block is then set
+// so that the rest of the audio fills the rest of the target, in proportion
+// to how much of the analysis it took. Call after WavpackSetConfiguration()
+// and before packing any samples. A return of FALSE indicates an error.
+func WavpackSetTargetSize(wpc *WavpackContext, target_size int64, block_sizes []int, block_samples uint) int {
+	if ((wpc.stream.wphdr.flags & HYBRID_FLAG) == 0) || ((wpc.config.Flags & CONFIG_FIXED_ERROR) != 0) {
+		wpc.error_message = "a target size is only possible in hybrid mode with a bitrate!"
+
+		return FALSE
+	}
+
+	if (target_size <= 0) || (len(block_sizes) == 0) || (block_samples == 0) {
+		wpc.error_message = "invalid target size!"
+
+		return FALSE
+	}
+
+	wpc.target_size = target_size
+	wpc.target_plan = block_sizes
+	wpc.target_samples = block_samples
+	wpc.target_bits = wpc.stream.bits
+
+	return TRUE
+}
+
+// Return the bitrate for the block that starts at "sample_index" when there
+// is a target size. The bytes still left of the target are compared with the
+// bytes that the rest of the audio took in the analysis pass (the block that
+// the index falls in is counted in part, in case the analysis blocks are a
+// different length) and the bitrate of the analysis is scaled by the ratio.
+// Any error in one block is made up for in the blocks after it.
+func target_block_bitrate(wpc *WavpackContext, sample_index int64) int {
+	var block int64 = sample_index / int64(wpc.target_samples)
+	var offset int64 = sample_index % int64(wpc.target_samples)
+	var remaining int64 = 0
+	var bits int64
+
+	for i := block; i < int64(len(wpc.target_plan)); i++ {
+		remaining += int64(wpc.target_plan[i])
+
+		if i == block {
+			remaining -= int64(wpc.target_plan[i]) * offset / int64(wpc.target_samples)
+		}
+	}
+
+	if remaining <= 0 {
+		return wpc.stream.bits
+	}
+
+	bits = int64(wpc.target_bits) * (wpc.target_size - int64(wpc.filelen)) / remaining
+
+	if bits < 0 {
+		bits = 0
+	} else if bits > MAX_TARGET_BITS {
+		bits = MAX_TARGET_BITS
+	}
+
+	return int(bits)
+}
+
+// Return the sizes in bytes of the audio blocks written to the WavPack file
+// so far and the number of samples in each block (the last block can be
+// shorter). These are what WavpackSetTargetSize() needs from an analysis pass.
+func WavpackGetBlockSizes(wpc *WavpackContext) ([]int, uint) {
+	return wpc.block_sizes, wpc.block_samples
+}
+
+// Return the number of bytes written to the WavPack file so far, including
+// any metadata block and tag
+func WavpackGetFileSize64(wpc *WavpackContext) int64 {
+	if nil != wpc {
+		return int64(wpc.filelen)
+	}
+
+	return 0
+}
+
+// Store the MD5 signature of the raw audio data (as it was stored in the
+// source file) so that a decoder can verify the restored file. This must be
+// called before the final WavpackFlushSamples(), which writes it into a
+// block of its own at the end of the file. A return of FALSE indicates an
+// error.
+func WavpackStoreMD5Sum(wpc *WavpackContext, data []byte) int {
+	if len(data) != 16 {
+		wpc.error_message = "MD5 signature must be 16 bytes!"
+
+		return FALSE
+	}
+
+	add_to_metadata(wpc, data, int(ID_MD5_CHECKSUM))
+
+	return TRUE
+}
+
+// Queue a metadata item to be written by write_metadata_block().
+func add_to_metadata(wpc *WavpackContext, data []byte, id int) {
+	var wpmd WavpackMetadata
+
+	wpmd.data = make([]byte, len(data)+1)
+	copy(wpmd.data, data)
+	wpmd.byte_length = len(data)
+	wpmd.id = id
+
+	wpc.metadata = append(wpc.metadata, wpmd)
+}
+
+// Write the queued metadata into a block that contains no audio samples
+// (decoders simply skip over these). The block goes to the WavPack file
+// only, never to the correction file.
+func write_metadata_block(wpc *WavpackContext) int {
+	var block_size int = WAVPACK_HEADER_SIZE
+	var block_buff []byte
+	var copyRetVal int
+	var hdr WavpackHeader
+
+	for i := 0; i < len(wpc.metadata); i++ {
+		block_size += wpc.metadata[i].byte_length + (wpc.metadata[i].byte_length & 1) + 4
+	}
+
+	block_buff = make([]byte, block_size+1)
+	copy(block_buff[0:4], "wvpk")
+	block_buff[4] = byte(WAVPACK_HEADER_SIZE - 8)
+	block_buff[8] = byte(wpc.stream_version)
+	block_buff[9] = byte(wpc.stream_version >> 8)
+	set_total_samples(&hdr, wpc.total_samples)
+	block_buff[11] = byte(hdr.total_samples_u8)
+	block_buff[12] = byte(hdr.total_samples)
+	block_buff[13] = byte(hdr.total_samples >> 8)
+	block_buff[14] = byte(hdr.total_samples >> 16)
+	block_buff[15] = byte(hdr.total_samples >> 24)
+
+	for i := 0; i < len(wpc.metadata); i++ {
+		copyRetVal, block_buff = copy_metadata(wpc.metadata[i], block_buff, len(block_buff))
+
+		if copyRetVal == FALSE {
+			wpc.error_message = "output buffer overflowed!"
+
+			return FALSE
+		}
+	}
+
+	wpc.metadata = nil
+
+	block_size = (int(block_buff[4]) & 0xff) + ((int(block_buff[5]) & 0xff) << 8) +
+		((int(block_buff[6]) & 0xff) << 16) + ((int(block_buff[7]) & 0xff) << 24) + 8
+
+	if written, err := wpc.Outfile.Write(block_buff[0:block_size]); (written != block_size) || (err != nil) {
+		wpc.error_message = "can't write WavPack data, disk probably full!"
+
+		return FALSE
+	}
+
+	wpc.filelen += uint(block_size)
+
 	return TRUE
 }
 
 func finish_block(wpc *WavpackContext) int {
-	var wps WavpackStream = wpc.stream
-	var bcount uint
 	var result int = 0
 
 	result = pack_finish_block(wpc)
@@ -286,6 +950,16 @@ func finish_block(wpc *WavpackContext) int {
 		return result
 	}
 
+	return write_block(wpc)
+}
+
+// Write the completed block (and the matching correction block, if there is
+// one) to the output file(s). A return of FALSE indicates an error.
+func write_block(wpc *WavpackContext) int {
+	var wps *WavpackStream = &wpc.stream
+	var bcount uint
+	var result int = TRUE
+
 	bcount = uint((int(wps.blockbuff[4]) & 0xff) + ((int(wps.blockbuff[5]) & 0xff) << 8) +
 		((int(wps.blockbuff[6]) & 0xff) << 16) + ((int(wps.blockbuff[7]) & 0xff) << 24) + 8)
 
@@ -308,6 +982,7 @@ func finish_block(wpc *WavpackContext) int {
 	}
 
 	wpc.filelen += bcount
+	wpc.block_sizes = append(wpc.block_sizes, int(bcount))
 
 	if wps.block2buff[0] == 'w' { // if starts with w then has a WavPack header i.e. it is defined 
 		bcount = uint(int(wps.block2buff[4]&0xff) + (int(wps.block2buff[5]&0xff) << 8) +
@@ -339,34 +1014,68 @@ func finish_block(wpc *WavpackContext) int {
 
 // Get total number of samples contained in the WavPack file, or -1 if unknown
 func WavpackGetNumSamples(wpc *WavpackContext) int {
+	return int(WavpackGetNumSamples64(wpc))
+}
+
+// Get total number of samples contained in the WavPack file (up to 40 bits),
+// or -1 if unknown
+func WavpackGetNumSamples64(wpc *WavpackContext) int64 {
 	if nil != wpc {
-		return int(wpc.total_samples)
+		return wpc.total_samples
 	}
 	return (-1)
 }
 
+// Given the first block written (which must be read back by the application
+// from the start of the file), update the total number of samples to the
+// number of samples actually packed. This is needed when the length was not
+// known at WavpackSetConfiguration() (and -1 was passed) and should be done
+// to the first block of the "correction" file also. Only the header of the
+// block is changed, so the block does not have to be read in full.
+func WavpackUpdateNumSamples(wpc *WavpackContext, first_block []byte) {
+	write_total_samples(first_block, WavpackGetSampleIndex64(wpc))
+}
+
+// Write "total_samples" into the header of the block in "block".
+func write_total_samples(block []byte, total_samples int64) {
+	var hdr WavpackHeader
+
+	set_total_samples(&hdr, total_samples)
+
+	block[11] = byte(hdr.total_samples_u8)
+	block[12] = byte(hdr.total_samples)
+	block[13] = byte(hdr.total_samples >> 8)
+	block[14] = byte(hdr.total_samples >> 16)
+	block[15] = byte(hdr.total_samples >> 24)
+}
+
 // Get the current sample index position, or -1 if unknown
 func WavpackGetSampleIndex(wpc *WavpackContext) int {
+	return int(WavpackGetSampleIndex64(wpc))
+}
+
+// Get the current sample index position (up to 40 bits), or -1 if unknown
+func WavpackGetSampleIndex64(wpc *WavpackContext) int64 {
 	if nil != wpc {
 		return wpc.stream.sample_index
 	}
 
 	return -1
 }
-/*
-   // Returns the sample rate of the specified WavPack file
-   static long WavpackGetSampleRate(wpc *WavpackContext)
-   {
-       if (null != wpc)
-       {
-           return (wpc.config.sample_rate);
-       }
-       else
-       {
-           return (long) 44100;
-       }
-   }
-*/
+// Returns the sample rate of the specified WavPack file. For DSD audio this
+// is the rate of the bytes (each holding 8 one-bit samples) per channel.
+func WavpackGetSampleRate(wpc *WavpackContext) uint {
+	if nil != wpc {
+		if wpc.dsd_multiplier > 1 {
+			return wpc.config.Sample_rate * wpc.dsd_multiplier
+		}
+
+		return wpc.config.Sample_rate
+	}
+
+	return 44100
+}
+
 // Returns the number of channels of the specified WavPack file.
 func WavpackGetNumChannels(wpc *WavpackContext) int {
 	if nil != wpc {
@@ -375,25 +1084,32 @@ func WavpackGetNumChannels(wpc *WavpackContext) int {
 
 	return 2
 }
-/*
-   // Returns the actual number of valid bits per sample contained in the
-   // original file from 1 to 24, and which may or may not be a multiple
-   // of 8. When this value is not a multiple of 8, then the "extra" bits
-   // are located in the LSBs of the results. That is, values are right
-   // justified when unpacked into ints, but are left justified in the
-   // number of bytes used by the original data.
-   static int WavpackGetBitsPerSample(WavpackContext wpc)
-   {
-       if (null != wpc)
-       {
-           return (wpc.config.bits_per_sample);
-       }
-       else
-       {
-           return 16;
-       }
-   }
-*/
+// Returns the actual number of valid bits per sample contained in the
+// original file from 1 to 32, and which may or may not be a multiple of 8.
+// When this value is not a multiple of 8, then the "extra" bits are located
+// in the LSBs of the results. That is, values are right justified when
+// unpacked into ints, but are left justified in the number of bytes used by
+// the original data.
+func WavpackGetBitsPerSample(wpc *WavpackContext) int {
+	if nil != wpc {
+		return wpc.config.Bits_per_sample
+	}
+
+	return 16
+}
+
+// Returns the qualify mode flags (QMODE_xxx) of the specified WavPack file.
+// These describe how the audio data was stored in the original file (for
+// example big-endian or signed 8-bit data) and must be honoured when
+// converting the raw file data into samples.
+func WavpackGetQualifyMode(wpc *WavpackContext) int {
+	if nil != wpc {
+		return wpc.config.Qmode
+	}
+
+	return 0
+}
+
 // Returns the number of bytes used for each sample (1 to 4) in the original
 // file. This is required information for the user of this module because the
 // audio data is returned in the LOWER bytes of the long buffer and must be
@@ -404,3 +1120,126 @@ func WavpackGetBytesPerSample(wpc *WavpackContext) int {
 	}
 	return 2
 }
+
+// Returns the channel mask of the specified WavPack file, which is the same
+// as the dwChannelMask of a WAVEFORMATEXTENSIBLE header (0 if unassigned).
+func WavpackGetChannelMask(wpc *WavpackContext) uint {
+	if nil != wpc {
+		return wpc.config.Channel_mask
+	}
+
+	return 0
+}
+
+// Returns the format of the file that the WavPack file was packed from
+// (WP_FORMAT_WAV etc.), as stored in the file.
+func WavpackGetFileFormat(wpc *WavpackContext) int {
+	if nil != wpc {
+		return wpc.file_format
+	}
+
+	return 0
+}
+
+// Returns the mode of the WavPack file being unpacked as the MODE_xxx bits.
+// MODE_LOSSLESS is set for lossless files and for hybrid files that are
+// being unpacked with their correction file (in which case MODE_WVC is set
+// too).
+func WavpackGetMode(wpc *WavpackContext) int {
+	var mode int = 0
+
+	if nil == wpc {
+		return mode
+	}
+
+	if (wpc.config.Flags & CONFIG_HYBRID_FLAG) != 0 {
+		mode |= MODE_HYBRID
+	} else {
+		mode |= MODE_LOSSLESS
+	}
+
+	if wpc.wvc_flag != 0 {
+		mode |= (MODE_LOSSLESS | MODE_WVC)
+	}
+
+	if (wpc.config.Flags & CONFIG_FLOAT_DATA) != 0 {
+		mode |= MODE_FLOAT
+	}
+
+	if (wpc.config.Flags & CONFIG_HIGH_FLAG) != 0 {
+		mode |= MODE_HIGH
+	}
+
+	if (wpc.config.Flags & CONFIG_FAST_FLAG) != 0 {
+		mode |= MODE_FAST
+	}
+
+	if (wpc.config.Flags & CONFIG_VERY_HIGH_FLAG) != 0 {
+		mode |= MODE_VERY_HIGH
+	}
+
+	if (wpc.config.Flags & CONFIG_EXTRA_MODE) != 0 {
+		mode |= MODE_EXTRA
+	}
+
+	if (wpc.config.Flags & CONFIG_MD5_CHECKSUM) != 0 {
+		mode |= MODE_MD5
+	}
+
+	if len(wpc.ape_tag_items) != 0 {
+		mode |= MODE_VALID_TAG
+	}
+
+	return mode
+}
+
+// Returns the header (and, once the end of the file has been reached, the
+// trailer) of the original file as stored in the WavPack file. This is
+// empty if nothing was stored.
+func WavpackGetWrapperData(wpc *WavpackContext) []byte {
+	if nil != wpc {
+		return wpc.wrapper_data
+	}
+
+	return nil
+}
+
+// Free the wrapper data returned by WavpackGetWrapperData(), normally after
+// the header has been written so that only the trailer (if any) remains to
+// be returned at the end of the file.
+func WavpackFreeWrapper(wpc *WavpackContext) {
+	wpc.wrapper_data = nil
+}
+
+// Returns the MD5 signature of the raw audio data stored in the file, or nil
+// if none has been read. The signature is in the last block of the file, so
+// it is normally only available once all the audio has been unpacked (or
+// WavpackSeekTrailingWrapper() has been called).
+func WavpackGetMD5Sum(wpc *WavpackContext) []byte {
+	if (nil != wpc) && (wpc.md5_read != 0) {
+		return wpc.md5_checksum[0:16]
+	}
+
+	return nil
+}
+
+// Returns the number of errors encountered so far in unpacking the file,
+// which are normally blocks with crc errors.
+func WavpackGetNumErrors(wpc *WavpackContext) int {
+	if nil != wpc {
+		return wpc.crc_errors
+	}
+
+	return 0
+}
+
+// Returns TRUE if any hybrid blocks have been unpacked lossy, which for a
+// file opened with its correction file means that the correction data for
+// them was missing or damaged.
+func WavpackLossyBlocks(wpc *WavpackContext) int {
+	if nil != wpc {
+		return wpc.lossy_blocks
+	}
+
+	return FALSE
+}
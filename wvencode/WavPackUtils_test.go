@@ -0,0 +1,315 @@
+package wvencode
+
+/*
+** WavPackUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"hash"
+	"io"
+	"math"
+	"testing"
+)
+
+// Pack the interleaved "samples" with "config" (which must give the format)
+// and return the WavPack file and the correction file (nil unless
+// CONFIG_CREATE_WVC is set). The samples are passed in pieces, as the
+// encoder reads them. With CONFIG_MD5_CHECKSUM the MD5 signature of the
+// samples (as signed little-endian values) is stored.
+func pack_test_audio(t *testing.T, config WavpackConfig, samples []int) ([]byte, []byte) {
+	var num_channels int = int(config.Num_channels)
+	var num_samples int = len(samples) / num_channels
+	var wv_output bytes.Buffer
+	var wvc_output bytes.Buffer
+	var buffer []int = make([]int, INPUT_SAMPLES*num_channels)
+	var md5_context hash.Hash = md5.New()
+
+	wpc := new(WavpackContext)
+	wpc.Outfile = &wv_output
+
+	if (config.Flags & CONFIG_CREATE_WVC) != 0 {
+		wpc.Correction_outfile = &wvc_output
+	}
+
+	if WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == FALSE {
+		t.Fatalf("can't configure the encoder: %s", WavpackGetErrorMessage(wpc))
+	}
+
+	WavpackPackInit(wpc)
+
+	for index := 0; index < num_samples; index += INPUT_SAMPLES {
+		var count int = num_samples - index
+
+		if count > INPUT_SAMPLES {
+			count = INPUT_SAMPLES
+		}
+
+		// the samples are changed by packing them
+		copy(buffer, samples[index*num_channels:(index+count)*num_channels])
+		wpc.Byte_idx = 0
+
+		var pcm []byte = make([]byte, count*num_channels*config.Bytes_per_sample)
+
+		WavpackFormatPCM(pcm, buffer, config.Bytes_per_sample, 0)
+		md5_context.Write(pcm)
+
+		if WavpackPackSamples(wpc, buffer, uint(count)) == FALSE {
+			t.Fatalf("can't pack the samples: %s", WavpackGetErrorMessage(wpc))
+		}
+	}
+
+	if (config.Flags & CONFIG_MD5_CHECKSUM) != 0 {
+		WavpackStoreMD5Sum(wpc, md5_context.Sum(nil))
+	}
+
+	if WavpackFlushSamples(wpc) == FALSE {
+		t.Fatalf("can't pack the samples: %s", WavpackGetErrorMessage(wpc))
+	}
+
+	if (config.Flags & CONFIG_CREATE_WVC) != 0 {
+		return wv_output.Bytes(), wvc_output.Bytes()
+	}
+
+	return wv_output.Bytes(), nil
+}
+
+// Open the WavPack file "wv" (with the correction file "wvc" if it isn't
+// nil) for unpacking.
+func open_test_audio(t *testing.T, wv []byte, wvc []byte) *WavpackContext {
+	var wvc_infile io.Reader = nil
+
+	if wvc != nil {
+		wvc_infile = bytes.NewReader(wvc)
+	}
+
+	wpc := new(WavpackContext)
+
+	if WavpackOpenFileInput(wpc, bytes.NewReader(wv), wvc_infile) == FALSE {
+		t.Fatalf("can't open the packed audio: %s", WavpackGetErrorMessage(wpc))
+	}
+
+	return wpc
+}
+
+// Unpack all the audio of "wpc" and return it, interleaved.
+func unpack_test_audio(wpc *WavpackContext) []int {
+	var num_channels int = WavpackGetNumChannels(wpc)
+	var buffer []int = make([]int, 1000*num_channels)
+	var samples []int
+
+	for {
+		var samples_unpacked uint = WavpackUnpackSamples(wpc, buffer, 1000)
+
+		if samples_unpacked == 0 {
+			break
+		}
+
+		samples = append(samples, buffer[0:int(samples_unpacked)*num_channels]...)
+	}
+
+	return samples
+}
+
+// Make "num_samples" of 16-bit stereo audio: a tone that is louder in the
+// left channel than the right, with some noise in each.
+func make_test_audio(num_samples int) []int {
+	var samples []int = make([]int, num_samples*2)
+	var seed uint32 = 1
+
+	for i := 0; i < num_samples; i++ {
+		var tone float64 = math.Sin(float64(i) * 2 * math.Pi * 440 / 44100)
+
+		seed = seed*1664525 + 1013904223
+		samples[i*2] = int(tone*12000) + int(seed>>22) - 512
+		seed = seed*1664525 + 1013904223
+		samples[i*2+1] = int(tone*7000) + int(seed>>22) - 512
+	}
+
+	return samples
+}
+
+// Make "num_samples" of 16-bit stereo white noise at nearly full scale, with
+// the channels unrelated.
+func make_noise_audio(num_samples int) []int {
+	var samples []int = make([]int, num_samples*2)
+	var seed uint32 = 12345
+
+	for i := range samples {
+		seed = seed*1664525 + 1013904223
+		samples[i] = int(int16(seed>>16)) * 7 / 8
+	}
+
+	return samples
+}
+
+func compare_test_audio(t *testing.T, name string, expected []int, samples []int) {
+	if len(samples) != len(expected) {
+		t.Fatalf("%s: %d values unpacked instead of %d", name, len(samples), len(expected))
+	}
+
+	for i := range samples {
+		if samples[i] != expected[i] {
+			t.Fatalf("%s: value %d is %d instead of %d", name, i, samples[i], expected[i])
+		}
+	}
+}
+
+// In hybrid mode with a fixed maximum error (-e) no sample may be off by
+// more than the limit, whatever was asked for in the way of joint stereo.
+func TestFixedErrorLimit(t *testing.T) {
+	for _, samples := range [][]int{make_test_audio(100000), make_noise_audio(100000)} {
+		test_fixed_error_limit(t, samples)
+	}
+}
+
+func test_fixed_error_limit(t *testing.T, samples []int) {
+	for _, joint := range []uint{0, CONFIG_JOINT_OVERRIDE, CONFIG_JOINT_OVERRIDE | CONFIG_JOINT_STEREO, CONFIG_JOINT_AUTO} {
+		for _, error_db := range []int{-30, -60, -80} {
+			var config WavpackConfig
+			var limit float64 = 32768 * math.Pow(10, float64(error_db)/20)
+			var max_error int = 0
+
+			config.Bits_per_sample = 16
+			config.Bytes_per_sample = 2
+			config.Num_channels = 2
+			config.Sample_rate = 44100
+			config.Flags = CONFIG_HYBRID_FLAG | CONFIG_FIXED_ERROR | joint
+			config.Error_level = error_db * 256
+
+			wv, _ := pack_test_audio(t, config, samples)
+			unpacked := unpack_test_audio(open_test_audio(t, wv, nil))
+
+			if len(unpacked) != len(samples) {
+				t.Fatalf("%d values unpacked instead of %d", len(unpacked), len(samples))
+			}
+
+			for i := range samples {
+				if error := int(math.Abs(float64(unpacked[i] - samples[i]))); error > max_error {
+					max_error = error
+				}
+			}
+
+			if float64(max_error) > limit {
+				t.Errorf("flags 0x%x, %d dB: error of %d with a limit of %.1f", joint, error_db, max_error, limit)
+			}
+		}
+	}
+}
+
+// The correction file still restores the exact audio with a fixed error.
+func TestFixedErrorCorrection(t *testing.T) {
+	var samples []int = make_test_audio(50000)
+	var config WavpackConfig
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Flags = CONFIG_HYBRID_FLAG | CONFIG_FIXED_ERROR | CONFIG_CREATE_WVC
+	config.Error_level = -60 * 256
+
+	wv, wvc := pack_test_audio(t, config, samples)
+	compare_test_audio(t, "fixed error with correction", samples, unpack_test_audio(open_test_audio(t, wv, wvc)))
+}
+
+// With adaptive blocks a transient (here a tone that stops) gets shorter
+// blocks around it than the steady audio either side, the audio is restored
+// exactly and the file is hardly any bigger than with fixed blocks.
+func TestAdaptiveBlocksTransient(t *testing.T) {
+	var samples []int = make_test_audio(200000)
+	var config WavpackConfig
+	var sizes [2]int
+	var transient_block, longest_block int
+
+	// the tone stops, leaving silence
+	for i := 100000 * 2; i < len(samples); i++ {
+		samples[i] = 0
+	}
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+
+	for i, flags := range []uint{0, CONFIG_ADAPTIVE_BLOCKS} {
+		config.Flags = flags
+		wv, _ := pack_test_audio(t, config, samples)
+		compare_test_audio(t, "adaptive blocks", samples, unpack_test_audio(open_test_audio(t, wv, nil)))
+		sizes[i] = len(wv)
+
+		if flags == CONFIG_ADAPTIVE_BLOCKS {
+			_, headers := test_blocks(t, wv)
+
+			for _, header := range headers {
+				if (get_block_index(&header) <= 100000) && (block_end_index(&header) > 100000) {
+					transient_block = header.block_samples
+				}
+
+				if header.block_samples > longest_block {
+					longest_block = header.block_samples
+				}
+			}
+		}
+	}
+
+	if transient_block*4 > longest_block {
+		t.Errorf("the block with the transient has %d samples, and the longest %d", transient_block, longest_block)
+	}
+
+	if sizes[1] > sizes[0]+(sizes[0]/100) {
+		t.Errorf("adaptive blocks take %d bytes, and fixed blocks %d", sizes[1], sizes[0])
+	}
+}
+
+// Audio with fewer valid bits than bytes (12 bits in 2 bytes and 20 bits in
+// 3, as well as 8 bits) comes back exactly, both lossless and from hybrid
+// with a correction file. The samples are passed (and unpacked) as values of
+// the whole container, with the unused low bits zero.
+func TestPartialBytesRoundTrip(t *testing.T) {
+	for _, format := range []struct{ bits, bytes int }{{12, 2}, {20, 3}, {8, 1}, {16, 2}, {24, 3}} {
+		for _, flags := range []uint{0, CONFIG_HYBRID_FLAG | CONFIG_CREATE_WVC} {
+			var shift uint = uint(format.bytes*8 - format.bits)
+			var samples []int = make_test_audio(50000)
+			var config WavpackConfig
+
+			for i := range samples {
+				var value int
+
+				// the test audio is 16-bit, so it's rescaled to fit
+				if format.bits >= 16 {
+					value = samples[i] << uint(format.bits-16)
+				} else {
+					value = samples[i] >> uint(16-format.bits)
+				}
+
+				samples[i] = value << shift
+			}
+
+			config.Bits_per_sample = format.bits
+			config.Bytes_per_sample = format.bytes
+			config.Num_channels = 2
+			config.Sample_rate = 44100
+			config.Flags = flags
+			config.Bitrate = 3 * 256
+
+			wv, wvc := pack_test_audio(t, config, samples)
+			wpc := open_test_audio(t, wv, wvc)
+
+			if (WavpackGetBitsPerSample(wpc) != format.bits) || (WavpackGetBytesPerSample(wpc) != format.bytes) {
+				t.Errorf("%d bits in %d bytes unpacked as %d bits in %d bytes", format.bits, format.bytes,
+					WavpackGetBitsPerSample(wpc), WavpackGetBytesPerSample(wpc))
+			}
+
+			compare_test_audio(t, "partial bytes", samples, unpack_test_audio(wpc))
+		}
+	}
+}
@@ -13,6 +13,7 @@ package wvencode
 
 type WavpackConfig struct {
 	Bitrate          int
+	Error_level      int
 	Shaping_weight   int
 	Bits_per_sample  int
 	Bytes_per_sample int
@@ -20,4 +21,6 @@ type WavpackConfig struct {
 	Block_samples    uint
 	Flags            uint
 	Sample_rate      uint
+	Qmode            int
+	Channel_mask     uint
 }
@@ -12,6 +12,7 @@ package wvencode
  */
 
 import (
+	"io"
 	"os"
 )
 
@@ -20,9 +21,9 @@ type WavpackContext struct {
 	stream             WavpackStream
 	error_message      string
 	Infile             os.File
-	Outfile            *os.File
-	Correction_outfile *os.File
-	total_samples      uint // was uint32_t in C
+	Outfile            io.Writer
+	Correction_outfile io.Writer
+	total_samples      int64 // was uint32_t in C, -1 if unknown
 	lossy_blocks       int
 	wvc_flag           int
 	block_samples      uint
@@ -30,5 +31,31 @@ type WavpackContext struct {
 	filelen            uint
 	file2len           uint
 	stream_version     int
-	Byte_idx           int // holds the current buffer position for the input WAV data
+	Byte_idx           int    // holds the current buffer position for the input WAV data
+	wrapper_data       []byte // original file header, stored in the first block
+	file_format        int
+	file_extension     string
+	block_buffer       []int             // float or DSD samples waiting to be packed into a block
+	metadata           []WavpackMetadata // written in a block of its own when flushed
+	ape_tag_items      []ApeTagItem
+	dsd_multiplier     uint      // DSD rate in bytes is sample_rate * dsd_multiplier
+	block_plan         []uint    // lengths of the next blocks, with adaptive blocks
+	block_values       []int     // reused for the copy of the samples of each block
+	block_sizes        []int     // sizes of the audio blocks written to the WavPack file
+	target_size        int64     // if not 0, the bytes that the audio blocks should total
+	target_plan        []int     // block sizes of the analysis pass for the target size
+	target_samples     uint      // samples in each block of the analysis pass
+	target_bits        int       // the bitrate of the analysis pass
+	infile             io.Reader // WavPack stream being unpacked
+	wvc_infile         io.Reader // correction stream being unpacked, or nil
+	crc_errors         int       // blocks unpacked with crc errors
+	md5_checksum       [16]byte  // MD5 signature read from the file
+	md5_read           int       // TRUE once md5_checksum has been read
+
+	// blocks that failed their crc check, and samples missing from the stream
+	damaged_blocks []WavpackDamagedBlock
+
+	conceal_errors   int                       // TRUE to conceal damaged blocks
+	conceal_callback func(WavpackDamagedBlock) // called for each concealed region
+	resync_bytes     []byte                    // bytes of a malformed block to search for the next block
 }
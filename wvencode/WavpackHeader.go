@@ -12,14 +12,88 @@ package wvencode
  */
 
 type WavpackHeader struct {
-	ckID          [4]int
-	ckSize        int // was uint32_t in C
-	version       int
-	track_no      int  // was uchar in C
-	index_no      int  // was uchar in C
-	total_samples uint // was uint32_t in C
-	block_index   int  // was uint32_t in C
-	block_samples int  // was uint32_t in C
-	flags         uint // was uint32_t in C
-	crc           uint
+	ckID             [4]int
+	ckSize           int // was uint32_t in C
+	version          int
+	block_index_u8   int  // was uchar in C (track_no in older versions)
+	total_samples_u8 int  // was uchar in C (index_no in older versions)
+	total_samples    uint // was uint32_t in C
+	block_index      uint // was uint32_t in C
+	block_samples    int  // was uint32_t in C
+	flags            uint // was uint32_t in C
+	crc              uint
+}
+
+// The block index and total samples are 40-bit values, with the upper 8 bits
+// stored in the bytes that used to be the (never used) track and index
+// numbers. For the total samples each increment of the upper byte is worth
+// 0xffffffff samples rather than 2^32 so that a lower word of 0xffffffff can
+// still mean "unknown" (which is indicated here by -1).
+
+func set_block_index(hdr *WavpackHeader, value int64) {
+	hdr.block_index = uint(uint32(value))
+	hdr.block_index_u8 = int(uint8(value >> 32))
+}
+
+func get_block_index(hdr *WavpackHeader) int64 {
+	return int64(hdr.block_index) + (int64(hdr.block_index_u8) << 32)
+}
+
+func set_total_samples(hdr *WavpackHeader, value int64) {
+	if value < 0 {
+		hdr.total_samples = 0xffffffff
+		hdr.total_samples_u8 = 0
+	} else {
+		value += value / 0xffffffff
+		hdr.total_samples = uint(uint32(value))
+		hdr.total_samples_u8 = int(uint8(value >> 32))
+	}
+}
+
+func get_total_samples(hdr *WavpackHeader) int64 {
+	if hdr.total_samples == 0xffffffff {
+		return -1
+	}
+
+	return int64(hdr.total_samples) + (int64(hdr.total_samples_u8) << 32) - int64(hdr.total_samples_u8)
+}
+
+// These return the fields of a block header (such as the one in a
+// WavpackBlock from WavpackReadBlock()) for code outside of this package.
+
+// Returns the stream version of the block (0x402 to 0x410).
+func WavpackGetHeaderVersion(wphdr *WavpackHeader) int {
+	return wphdr.version
+}
+
+// Returns the size of the whole block in bytes, including the header.
+func WavpackGetHeaderBlockSize(wphdr *WavpackHeader) int {
+	return wphdr.ckSize + 8
+}
+
+// Returns the index of the first sample in the block.
+func WavpackGetHeaderBlockIndex(wphdr *WavpackHeader) int64 {
+	return get_block_index(wphdr)
+}
+
+// Returns the number of samples in the block, which is 0 for blocks that
+// only contain metadata.
+func WavpackGetHeaderBlockSamples(wphdr *WavpackHeader) uint {
+	return uint(wphdr.block_samples)
+}
+
+// Returns the total number of samples in the file as given in the block
+// header, or -1 if it is unknown.
+func WavpackGetHeaderTotalSamples(wphdr *WavpackHeader) int64 {
+	return get_total_samples(wphdr)
+}
+
+// Returns the flags of the block (MONO_FLAG, HYBRID_FLAG etc.).
+func WavpackGetHeaderFlags(wphdr *WavpackHeader) uint {
+	return wphdr.flags
+}
+
+// Returns the crc of the unpacked audio of the block.
+func WavpackGetHeaderCRC(wphdr *WavpackHeader) uint {
+	return wphdr.crc
 }
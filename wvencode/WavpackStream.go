@@ -15,16 +15,39 @@ type WavpackStream struct {
 	wphdr        WavpackHeader
 	wvbits       Bitstream
 	wvcbits      Bitstream
+	wvxbits      Bitstream
 	dc           DeltaData
 	w            WordsData
 	blockbuff    []byte
 	blockend     int
 	block2buff   []byte
 	block2end    int
+	wvc_pending  []byte // correction block read ahead of the block it's for
+	wvxbuff      []byte // extended float data, appended to the block when finished
 	bits         int
 	lossy_block  int
 	num_terms    int
-	sample_index int // was uint32_t in C
+	sample_index int64 // was uint32_t in C
+	crc          uint  // crc of the samples of the block being unpacked
+	crc_wvc      uint  // crc of the final samples with the correction block
+	crc_x        uint
+	crc_wvx      uint // crc of the unpacked extended float data
+	mute_error   int  // if TRUE the rest of the block is unpacked as silence
+
+	float_flags    int
+	float_shift    int
+	float_max_exp  int
+	float_norm_exp int
 
 	decorr_passes [16]DecorrPass
+
+	dsd_filters [2]DsdFilters // state of the "high" mode DSD filters
+	dsd_ptable  []int32
+	dsd         DsdData // state of the DSD decoder
+
+	// When errors are concealed each block is unpacked whole before any of
+	// it is returned, so that a block that fails its crc can be replaced.
+	unpacked       []int
+	block_unpacked int // TRUE if "unpacked" holds the current block
+	end_of_stream  int // TRUE if the stream ended before all its samples
 }
@@ -168,10 +168,12 @@ func init_words(wps *WavpackStream) {
 }
 
 // Set up parameters for hybrid mode based on header flags and "bits" field.
-// This is currently only set up for the HYBRID_BITRATE mode in which the
-// allowed error varies with the residual level (from "slow_level"). The
-// simpler mode (which is not used yet) has the error level directly
-// controlled from the metadata.
+// In the HYBRID_BITRATE mode the allowed error varies with the residual level
+// (from "slow_level"). The simpler mode has the error level directly
+// controlled from the metadata, in which case "bits" is the log2 of the
+// error limit. In joint stereo the mid channel gets half the limit of the
+// side channel because its error goes to both left and right, while only
+// half of the side channel error does.
 func word_set_bitrate(wps *WavpackStream) {
 	var bitrate_0 int = 0
 	var bitrate_1 int = 0
@@ -205,8 +207,19 @@ func word_set_bitrate(wps *WavpackStream) {
 			}
 		}
 	} else {
-		bitrate_1 = 0
-		bitrate_0 = 0
+		bitrate_0 = wps.bits
+
+		if (wps.wphdr.flags & (MONO_FLAG | FALSE_STEREO)) == 0 {
+			bitrate_1 = bitrate_0
+
+			if (wps.wphdr.flags & JOINT_STEREO) != 0 {
+				if bitrate_1 < 256 {
+					bitrate_1 = 0
+				} else {
+					bitrate_1 -= 256
+				}
+			}
+		}
 	}
 
 	wps.w.bitrate_acc[0] = uint(bitrate_0 << 16)
@@ -422,9 +435,10 @@ func read_hybrid_profile(wps *WavpackStream, wpmd *WavpackMetadata) int {
 
 // This function is called during both encoding and decoding of hybrid data to
 // update the "error_limit" variable which determines the maximum sample error
-// allowed in the main bitstream. In the HYBRID_BITRATE mode (which is the only
-// currently implemented) this is calculated from the slow_level values and the
-// bitrate accumulators. Note that the bitrate accumulators can be channelging.
+// allowed in the main bitstream. In the HYBRID_BITRATE mode this is calculated
+// from the slow_level values and the bitrate accumulators, otherwise the
+// bitrate accumulators hold the log2 of the limit itself. Note that the
+// bitrate accumulators can be channelging.
 func update_error_limit(wps *WavpackStream) {
 
 	wps.w.bitrate_acc[0] += uint(wps.w.bitrate_delta[0])
@@ -519,7 +533,7 @@ func send_word(wps *WavpackStream, value int, channel int) int {
 				return 0
 			}
 		} else if value != 0 {
-			putbit_0(wps)
+			bs_putbits(&wps.wvbits, 0, 1)
 		} else {
 			wps.w.slow_level[channel] -= ((wps.w.slow_level[channel] + SLO) >> SLS)
 			wps.w.median[0][0] = 0
@@ -646,10 +660,10 @@ func send_word(wps *WavpackStream, value int, channel int) int {
 
 		if bitcount != 0 {
 			if code < extras {
-				putbits_correction(code, uint(bitcount-1), wps)
+				bs_putbits(&wps.wvcbits, code, uint(bitcount-1))
 			} else {
-				putbits_correction((code+extras)>>1, uint(bitcount-1), wps)
-				putbit_correction((code+extras)&1, wps)
+				bs_putbits(&wps.wvcbits, (code+extras)>>1, uint(bitcount-1))
+				bs_putbits(&wps.wvcbits, (code+extras)&1, 1)
 			}
 		}
 	}
@@ -693,7 +707,7 @@ func send_word_lossless(wps *WavpackStream, value int, channel int) {
 				return
 			}
 		} else if value != 0 {
-			putbit_0(wps)
+			bs_putbits(&wps.wvbits, 0, 1)
 		} else {
 			wps.w.median[0][0] = 0
 			wps.w.median[1][0] = 0
@@ -785,189 +799,22 @@ func send_word_lossless(wps *WavpackStream, value int, channel int) {
 	}
 }
 
-func putbit_0(wps *WavpackStream) {
-	var bs Bitstream = wps.wvbits
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.blockbuff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		(bs).sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvbits = bs
-}
-
-func putbit_1(wps *WavpackStream) {
-
-	var bs Bitstream = wps.wvbits
-	(bs).sr |= (1 << (bs).bc)
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.blockbuff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		(bs).sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvbits = bs
-}
-
-func putbit(bit uint, wps *WavpackStream) {
-	var bs Bitstream = wps.wvbits
-
-	if bit != 0 {
-		(bs).sr |= (1 << (bs).bc)
-	}
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.blockbuff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		(bs).sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvbits = bs
-}
-
-func putbits(value uint, nbits uint, wps *WavpackStream) {
-	var bs Bitstream = wps.wvbits
-
-	(bs).sr |= ((value) << (bs).bc)
-
-	bs.bc += nbits
-	if bs.bc >= 8 {
-		for bs.bc >= 8 {
-			wps.blockbuff[bs.buf_index] = byte(bs.sr)
-			bs.buf_index++
-			(bs).sr >>= 8
-
-			bs.bc -= 8
-			if bs.bc > 24 {
-				(bs).sr |= ((value) >> ((nbits) - (bs).bc))
-			}
-
-			if bs.buf_index >= bs.end {
-				bs_wrap(&bs) // error
-			}
-		}
-	}
-	wps.wvbits = bs
-}
-
-/* Bitstream routines for the correction file bits */
-
-func putbit_correction_0(wps *WavpackStream) {
-	var bs Bitstream = wps.wvcbits
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.block2buff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		bs.sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvcbits = bs
-}
-
-func putbit_correction_1(wps *WavpackStream) {
-	var bs Bitstream = wps.wvcbits
-	(bs).sr |= (1 << (bs).bc)
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.block2buff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		bs.sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvcbits = bs
-}
-
-func putbit_correction(bit uint, wps *WavpackStream) {
-	var bs Bitstream = wps.wvcbits
-
-	if bit != 0 {
-		(bs).sr |= (1 << (bs).bc)
-	}
-
-	bs.bc++
-	if bs.bc == 8 {
-		wps.block2buff[bs.buf_index] = byte(bs.sr)
-		bs.buf_index++
-		bs.bc = 0
-		bs.sr = 0
-
-		if bs.buf_index >= bs.end {
-			bs_wrap(&bs) // error
-		}
-	}
-	wps.wvcbits = bs
-}
-
-func putbits_correction(value uint, nbits uint, wps *WavpackStream) {
-	var bs Bitstream = wps.wvcbits
-	(bs).sr |= ((value) << (bs).bc)
-
-	bs.bc += nbits
-	if bs.bc >= 8 {
-		for bs.bc >= 8 {
-			wps.block2buff[bs.buf_index] = byte(bs.sr)
-			bs.buf_index++
-			(bs).sr >>= 8
-
-			bs.bc -= 8
-			if bs.bc > 24 {
-				(bs).sr |= ((value) >> ((nbits) - (bs).bc))
-			}
-
-			if bs.buf_index >= bs.end {
-				bs_wrap(&bs) // error
-			}
-		}
-	}
-	wps.wvcbits = bs
-}
-
-
 // Used by send_word() and send_word_lossless() to actually send most the
 // accumulated data onto the bitstream. This is also called directly from
-// clients when all words have been sent.
+// clients when all words have been sent. Counts of zeros and ones are sent
+// as a run of ones (the number of bits in the count) and a zero, followed by
+// the bits of the count below the top one, each of which is a single write.
 func flush_word(wps *WavpackStream) {
+	var bs *Bitstream = &wps.wvbits
 
 	if wps.w.zeros_acc != 0 {
 		var cbits int = count_bits(wps.w.zeros_acc)
 
-		for cbits > 0 {
-			putbit_1(wps)
-			cbits--
-		}
-
-		putbit_0(wps)
+		bs_putones(bs, uint(cbits))
+		bs_putbits(bs, 0, 1)
 
-		for wps.w.zeros_acc > 1 {
-			putbit(wps.w.zeros_acc&1, wps)
-			wps.w.zeros_acc >>= 1
+		if cbits > 1 {
+			bs_putbits(bs, wps.w.zeros_acc&((1<<uint(cbits-1))-1), uint(cbits-1))
 		}
 
 		wps.w.zeros_acc = 0
@@ -977,37 +824,32 @@ func flush_word(wps *WavpackStream) {
 		if wps.w.holding_one >= LIMIT_ONES {
 			var cbits int
 
-			putbits((1<<LIMIT_ONES)-1, LIMIT_ONES+1, wps)
+			bs_putbits(bs, (1<<LIMIT_ONES)-1, LIMIT_ONES+1)
 			wps.w.holding_one -= LIMIT_ONES
 			cbits = count_bits(wps.w.holding_one)
 
-			for cbits > 0 {
-				putbit_1(wps)
-				cbits--
-			}
-
-			putbit_0(wps)
+			bs_putones(bs, uint(cbits))
+			bs_putbits(bs, 0, 1)
 
-			for wps.w.holding_one > 1 {
-				putbit(wps.w.holding_one&1, wps)
-				wps.w.holding_one >>= 1
+			if cbits > 1 {
+				bs_putbits(bs, wps.w.holding_one&((1<<uint(cbits-1))-1), uint(cbits-1))
 			}
 
 			wps.w.holding_zero = 0
 		} else {
-			putbits(bitmask[(int)(wps.w.holding_one)], wps.w.holding_one, wps)
+			bs_putbits(bs, bitmask[(int)(wps.w.holding_one)], wps.w.holding_one)
 		}
 
 		wps.w.holding_one = 0
 	}
 
 	if wps.w.holding_zero != 0 {
-		putbit_0(wps)
+		bs_putbits(bs, 0, 1)
 		wps.w.holding_zero = 0
 	}
 
 	if wps.w.pend_count != 0 {
-		putbits(wps.w.pend_data, wps.w.pend_count, wps)
+		bs_putbits(bs, wps.w.pend_data, wps.w.pend_count)
 		wps.w.pend_count = 0
 		wps.w.pend_data = 0
 	}
@@ -0,0 +1,322 @@
+package wav
+
+/*
+** WavUtils.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// This is a writer for RIFF/WAVE files, for the audio that comes out of the
+// WavPack decoder (or anything else that produces 32-bit samples). Plain
+// PCM and IEEE float headers are written when they can describe the audio,
+// and WAVE_FORMAT_EXTENSIBLE otherwise. If the output is seekable the sizes
+// in the header are corrected when the writer is closed (and the file is
+// changed to RF64 if it has grown past 4 GB); if not, the sizes are written
+// as 0xffffffff when the length isn't known, as is usual for streamed WAV.
+
+// the SubFormat GUID of WAVE_FORMAT_EXTENSIBLE after the format tag
+var subformat_guid = []byte{0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00, 0x00, 0xaa, 0x00, 0x38, 0x9b, 0x71}
+
+// Return the last error reported by the writer.
+func WavGetErrorMessage(ww *WavWriter) string {
+	return ww.error_message
+}
+
+// Start the WAV file in "outfile" for audio of the specified format and
+// write its header. The number of samples ("complete" samples, so one for
+// each channel) should be given if it's known, otherwise -1. A return of
+// FALSE indicates an error and the reason can be retrieved with
+// WavGetErrorMessage().
+func WavOpenOutput(ww *WavWriter, outfile io.Writer, format *WavFormat, total_samples int64) int {
+	var data_bytes int64 = -1
+
+	ww.outfile = outfile
+	ww.format = *format
+	ww.total_samples = total_samples
+	ww.samples_written = 0
+	ww.data_bytes = 0
+
+	if ww.format.Bytes_per_sample == 0 {
+		ww.format.Bytes_per_sample = (ww.format.Bits_per_sample + 7) / 8
+	}
+
+	if (ww.format.Num_channels < 1) || (ww.format.Num_channels > 0xffff) ||
+		(ww.format.Bits_per_sample < 1) || (ww.format.Bytes_per_sample > 4) ||
+		(ww.format.Bits_per_sample > ww.format.Bytes_per_sample*8) ||
+		((ww.format.Float_data != FALSE) && (ww.format.Bits_per_sample != 32)) {
+		ww.error_message = "can't write this format to a WAV file!"
+
+		return FALSE
+	}
+
+	ww.fmt_chunk = make_fmt_chunk(&ww.format)
+
+	if seeker, ok := outfile.(io.Seeker); ok {
+		if pos, err := seeker.Seek(0, io.SeekCurrent); err == nil {
+			ww.seeker = seeker
+			ww.start = pos
+		}
+	}
+
+	if total_samples >= 0 {
+		data_bytes = total_samples * int64(block_align(&ww.format))
+
+		if riff_size(ww, data_bytes) > MAX_RIFF_SIZE {
+			ww.rf64 = TRUE
+		}
+	} else if ww.seeker != nil {
+		ww.reserve_ds64 = TRUE
+	}
+
+	return write_bytes(ww, make_header(ww, data_bytes))
+}
+
+// Write the specified number of samples from "buffer" to the WAV file. The
+// samples are values of the full Bytes_per_sample bytes, as
+// WavpackUnpackSamples() returns them, so when there are fewer valid bits
+// they are already at the top and the unused low bits are zero. They are
+// interleaved, so there are "samples" times the number of channels of them.
+// A return of FALSE indicates an error.
+func WavWriteSamples(ww *WavWriter, buffer []int, samples uint) int {
+	var bytes_per_sample int = ww.format.Bytes_per_sample
+	var num_values int = int(samples) * ww.format.Num_channels
+
+	if len(ww.buffer) < num_values*bytes_per_sample {
+		ww.buffer = make([]byte, num_values*bytes_per_sample)
+	}
+
+	var dst []byte = ww.buffer[0 : num_values*bytes_per_sample]
+
+	switch bytes_per_sample {
+	case 1:
+		for i := 0; i < num_values; i++ {
+			dst[i] = byte(buffer[i] + 128)
+		}
+
+	case 2:
+		for i := 0; i < num_values; i++ {
+			binary.LittleEndian.PutUint16(dst[i*2:], uint16(buffer[i]))
+		}
+
+	case 3:
+		for i := 0; i < num_values; i++ {
+			var value int = buffer[i]
+
+			dst[i*3] = byte(value)
+			dst[i*3+1] = byte(value >> 8)
+			dst[i*3+2] = byte(value >> 16)
+		}
+
+	case 4:
+		for i := 0; i < num_values; i++ {
+			binary.LittleEndian.PutUint32(dst[i*4:], uint32(buffer[i]))
+		}
+	}
+
+	if write_bytes(ww, dst) == FALSE {
+		return FALSE
+	}
+
+	ww.samples_written += int64(samples)
+	ww.data_bytes += int64(len(dst))
+
+	return TRUE
+}
+
+// Get the number of samples written so far.
+func WavGetNumSamples(ww *WavWriter) int64 {
+	return ww.samples_written
+}
+
+// Finish the WAV file, which pads the data chunk to an even length and, for
+// a seekable output, rewrites the header with the actual sizes if they
+// weren't known at the start (making it an RF64 file if they don't fit in
+// a RIFF header). The output itself is not closed. A return of FALSE
+// indicates an error, such as a non-seekable output receiving a different
+// number of samples than its header claims.
+func WavClose(ww *WavWriter) int {
+	if (ww.data_bytes & 1) != 0 {
+		if write_bytes(ww, []byte{0}) == FALSE {
+			return FALSE
+		}
+	}
+
+	if ww.samples_written == ww.total_samples {
+		return TRUE
+	}
+
+	if ww.seeker == nil {
+		if ww.total_samples >= 0 {
+			ww.error_message = "the number of samples written doesn't match the WAV header!"
+
+			return FALSE
+		}
+
+		return TRUE
+	}
+
+	if (ww.rf64 == FALSE) && (riff_size(ww, ww.data_bytes) > MAX_RIFF_SIZE) {
+		if ww.reserve_ds64 == FALSE {
+			ww.error_message = "too much audio for the WAV header!"
+
+			return FALSE
+		}
+
+		ww.rf64 = TRUE
+	}
+
+	end, err := ww.seeker.Seek(0, io.SeekCurrent)
+
+	if err == nil {
+		_, err = ww.seeker.Seek(ww.start, io.SeekStart)
+	}
+
+	if (err != nil) || (write_bytes(ww, make_header(ww, ww.data_bytes)) == FALSE) {
+		ww.error_message = "can't update the WAV header!"
+
+		return FALSE
+	}
+
+	if _, err = ww.seeker.Seek(end, io.SeekStart); err != nil {
+		ww.error_message = "can't update the WAV header!"
+
+		return FALSE
+	}
+
+	return TRUE
+}
+
+func write_bytes(ww *WavWriter, data []byte) int {
+	if _, err := ww.outfile.Write(data); err != nil {
+		ww.error_message = "can't write the WAV file!"
+
+		return FALSE
+	}
+
+	return TRUE
+}
+
+func block_align(format *WavFormat) int {
+	return format.Bytes_per_sample * format.Num_channels
+}
+
+func header_size(ww *WavWriter) int {
+	var size int = 12 + len(ww.fmt_chunk) + 8
+
+	if (ww.reserve_ds64 != FALSE) || (ww.rf64 != FALSE) {
+		size += 36
+	}
+
+	return size
+}
+
+// The value for the RIFF size field, which is everything after it.
+func riff_size(ww *WavWriter, data_bytes int64) int64 {
+	return int64(header_size(ww)-8) + data_bytes + (data_bytes & 1)
+}
+
+// Make the fmt chunk for the specified format. WAVE_FORMAT_EXTENSIBLE is
+// used for more than two channels, for a channel mask that isn't the
+// default and when the valid bits don't fill the bytes of each sample.
+func make_fmt_chunk(format *WavFormat) []byte {
+	var format_tag int = WAVE_FORMAT_PCM
+	var default_mask uint = 0x3
+	var chunk []byte
+
+	if format.Float_data != FALSE {
+		format_tag = WAVE_FORMAT_IEEE_FLOAT
+	}
+
+	if format.Num_channels == 1 {
+		default_mask = 0x4
+	}
+
+	if (format.Num_channels > 2) || (format.Bits_per_sample != format.Bytes_per_sample*8) ||
+		((format.Channel_mask != 0) && (format.Channel_mask != default_mask)) {
+		chunk = make([]byte, 8+40)
+	} else {
+		chunk = make([]byte, 8+16)
+	}
+
+	copy(chunk[0:4], "fmt ")
+	binary.LittleEndian.PutUint32(chunk[4:8], uint32(len(chunk)-8))
+
+	if len(chunk) == 8+40 {
+		binary.LittleEndian.PutUint16(chunk[8:10], uint16(WAVE_FORMAT_EXTENSIBLE))
+	} else {
+		binary.LittleEndian.PutUint16(chunk[8:10], uint16(format_tag))
+	}
+
+	binary.LittleEndian.PutUint16(chunk[10:12], uint16(format.Num_channels))
+	binary.LittleEndian.PutUint32(chunk[12:16], uint32(format.Sample_rate))
+	binary.LittleEndian.PutUint32(chunk[16:20], uint32(format.Sample_rate)*uint32(block_align(format)))
+	binary.LittleEndian.PutUint16(chunk[20:22], uint16(block_align(format)))
+	binary.LittleEndian.PutUint16(chunk[22:24], uint16(format.Bytes_per_sample*8))
+
+	if len(chunk) == 8+40 {
+		// cbSize, wValidBitsPerSample, dwChannelMask and the SubFormat GUID
+		binary.LittleEndian.PutUint16(chunk[24:26], 22)
+		binary.LittleEndian.PutUint16(chunk[26:28], uint16(format.Bits_per_sample))
+		binary.LittleEndian.PutUint32(chunk[28:32], uint32(format.Channel_mask))
+		binary.LittleEndian.PutUint16(chunk[32:34], uint16(format_tag))
+		copy(chunk[34:48], subformat_guid)
+	}
+
+	return chunk
+}
+
+// Make the header for "data_bytes" bytes of audio (-1 if unknown), which
+// is everything up to the audio itself. The size of the header only depends
+// on the format and whether there is room for a ds64 chunk, so it can be
+// rewritten in place once the sizes are known.
+func make_header(ww *WavWriter, data_bytes int64) []byte {
+	var header []byte = make([]byte, header_size(ww))
+	var riff_field int64 = MAX_RIFF_SIZE
+	var data_field int64 = MAX_RIFF_SIZE
+	var index int = 12
+
+	if (data_bytes >= 0) && (ww.rf64 == FALSE) {
+		riff_field = riff_size(ww, data_bytes)
+		data_field = data_bytes
+	}
+
+	if ww.rf64 != FALSE {
+		copy(header[0:4], "RF64")
+	} else {
+		copy(header[0:4], "RIFF")
+	}
+
+	binary.LittleEndian.PutUint32(header[4:8], uint32(riff_field))
+	copy(header[8:12], "WAVE")
+
+	if ww.rf64 != FALSE {
+		// the real sizes, and an empty table of other chunk sizes
+		copy(header[12:16], "ds64")
+		binary.LittleEndian.PutUint32(header[16:20], 28)
+		binary.LittleEndian.PutUint64(header[20:28], uint64(riff_size(ww, data_bytes)))
+		binary.LittleEndian.PutUint64(header[28:36], uint64(data_bytes))
+		binary.LittleEndian.PutUint64(header[36:44], uint64(data_bytes/int64(block_align(&ww.format))))
+		index += 36
+	} else if ww.reserve_ds64 != FALSE {
+		copy(header[12:16], "JUNK")
+		binary.LittleEndian.PutUint32(header[16:20], 28)
+		index += 36
+	}
+
+	copy(header[index:], ww.fmt_chunk)
+	index += len(ww.fmt_chunk)
+	copy(header[index:index+4], "data")
+	binary.LittleEndian.PutUint32(header[index+4:index+8], uint32(data_field))
+
+	return header
+}
@@ -0,0 +1,63 @@
+package wav
+
+/*
+** WavUtils_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// Write "samples" (mono or interleaved) in "format" and return the header and
+// the bytes of the audio.
+func write_test_samples(t *testing.T, format WavFormat, samples []int) ([]byte, []byte) {
+	var output bytes.Buffer
+	var ww WavWriter
+
+	if WavOpenOutput(&ww, &output, &format, int64(len(samples)/format.Num_channels)) == FALSE {
+		t.Fatal(WavGetErrorMessage(&ww))
+	}
+
+	if (WavWriteSamples(&ww, samples, uint(len(samples)/format.Num_channels)) == FALSE) || (WavClose(&ww) == FALSE) {
+		t.Fatal(WavGetErrorMessage(&ww))
+	}
+
+	var header_bytes int = header_size(&ww)
+
+	return output.Bytes()[0:header_bytes], output.Bytes()[header_bytes:]
+}
+
+// Samples with fewer valid bits than bytes are written as they are, because
+// the valid bits are already at the top.
+func TestWriteSamplesPartialBytes(t *testing.T) {
+	header, data := write_test_samples(t, WavFormat{Sample_rate: 44100, Num_channels: 1, Bits_per_sample: 12}, []int{304, -16})
+
+	if binary.LittleEndian.Uint16(header[20:22]) != uint16(WAVE_FORMAT_EXTENSIBLE) || binary.LittleEndian.Uint16(header[38:40]) != 12 {
+		t.Errorf("12-bit audio should have an extensible header with 12 valid bits")
+	}
+
+	if !bytes.Equal(data, []byte{0x30, 0x01, 0xf0, 0xff}) {
+		t.Errorf("12-bit samples written as % x", data)
+	}
+
+	_, data = write_test_samples(t, WavFormat{Sample_rate: 44100, Num_channels: 1, Bits_per_sample: 20}, []int{304, -16})
+
+	if !bytes.Equal(data, []byte{0x30, 0x01, 0x00, 0xf0, 0xff, 0xff}) {
+		t.Errorf("20-bit samples written as % x", data)
+	}
+
+	_, data = write_test_samples(t, WavFormat{Sample_rate: 44100, Num_channels: 1, Bits_per_sample: 8}, []int{-128, 127})
+
+	if !bytes.Equal(data, []byte{0x00, 0xff}) {
+		t.Errorf("8-bit samples written as % x", data)
+	}
+}
@@ -0,0 +1,61 @@
+package wav
+
+/*
+** WavWriter.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"io"
+)
+
+const FALSE int = 0
+const TRUE int = 1
+
+const WAVE_FORMAT_PCM int = 1
+const WAVE_FORMAT_IEEE_FLOAT int = 3
+const WAVE_FORMAT_EXTENSIBLE int = 0xfffe
+
+// the largest size that fits in the 32-bit size fields of a RIFF file, which
+// is the value written to them in streaming and RF64 files
+const MAX_RIFF_SIZE int64 = 0xffffffff
+
+// The format of the audio to be written. Bytes_per_sample may be left as 0
+// for the smallest number of bytes that hold Bits_per_sample, and a
+// Channel_mask of 0 is the default for the number of channels (mono or
+// left and right). Float_data is TRUE for 32-bit IEEE floating point
+// samples, which are passed to WavWriteSamples() as the bits of a float32.
+type WavFormat struct {
+	Sample_rate      uint
+	Num_channels     int
+	Bits_per_sample  int
+	Bytes_per_sample int
+	Float_data       int
+	Channel_mask     uint
+}
+
+type WavWriter struct {
+	outfile       io.Writer
+	seeker        io.Seeker // nil if the output isn't seekable
+	start         int64     // position of the header in a seekable output
+	error_message string
+	format        WavFormat
+	fmt_chunk     []byte
+
+	// A seekable output of unknown length has a JUNK chunk in front of the
+	// fmt chunk that can be turned into the ds64 chunk of an RF64 file, which
+	// a header written as RF64 from the start has instead.
+	reserve_ds64 int
+	rf64         int
+
+	total_samples   int64 // -1 if unknown
+	samples_written int64
+	data_bytes      int64
+	buffer          []byte
+}
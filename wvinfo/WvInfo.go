@@ -0,0 +1,490 @@
+package main
+
+/*
+** WvInfo.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"../wvencode"
+)
+
+const usage0 = "\n"
+const usage1 string = " Usage:   WvInfo [-options] infile.wv\n"
+const usage2 string = "\n"
+const usage3 string = "  Options: \n       -v     = also list every block with its flags, crc and metadata\n"
+const usage4 string = "       --json = write the information as a JSON object (for scripts)\n"
+
+// The information about a file, which is either printed or written as JSON.
+type file_info struct {
+	File              string       `json:"file"`
+	File_size         int64        `json:"file_size"`
+	Wvc_file_size     int64        `json:"wvc_file_size,omitempty"`
+	Source_format     string       `json:"source_format"`
+	Sample_rate       uint         `json:"sample_rate"`
+	Channels          int          `json:"channels"`
+	Channel_mask      uint         `json:"channel_mask"`
+	Bits_per_sample   int          `json:"bits_per_sample"`
+	Bytes_per_sample  int          `json:"bytes_per_sample"`
+	Float_data        bool         `json:"float_data"`
+	Dsd_audio         bool         `json:"dsd_audio"`
+	Total_samples     int64        `json:"total_samples"`
+	Duration          float64      `json:"duration"`
+	Mode              string       `json:"mode"`
+	Quality           string       `json:"quality"`
+	Extra             bool         `json:"extra"`
+	Stream_version    int          `json:"stream_version"`
+	Compression       float64      `json:"compression"` // percent of the original audio size
+	Average_bitrate   float64      `json:"average_bitrate"`
+	Num_blocks        int          `json:"num_blocks"`
+	Max_block_samples uint         `json:"max_block_samples"`
+	Md5               string       `json:"md5,omitempty"`
+	Trailing_bytes    int64        `json:"trailing_bytes"`
+	Tags              []tag_info   `json:"tags"`
+	Blocks            []block_info `json:"blocks,omitempty"`
+}
+
+type tag_info struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type block_info struct {
+	Offset        int64            `json:"offset"`
+	Size          int              `json:"size"`
+	Bytes_skipped int              `json:"bytes_skipped"`
+	Block_index   int64            `json:"block_index"`
+	Block_samples uint             `json:"block_samples"`
+	Flags         uint             `json:"flags"`
+	Flag_names    []string         `json:"flag_names"`
+	Crc           uint             `json:"crc"`
+	Terms         []int            `json:"decorr_terms,omitempty"`
+	Sub_blocks    []sub_block_info `json:"sub_blocks"`
+}
+
+type sub_block_info struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// the names of the metadata ids (with ID_OPTIONAL_DATA where it's set)
+var sub_block_names = map[int]string{
+	int(wvencode.ID_DUMMY):         "DUMMY",
+	int(wvencode.ID_ENCODER_INFO):  "ENCODER_INFO",
+	wvencode.ID_DECORR_TERMS:       "DECORR_TERMS",
+	wvencode.ID_DECORR_WEIGHTS:     "DECORR_WEIGHTS",
+	wvencode.ID_DECORR_SAMPLES:     "DECORR_SAMPLES",
+	wvencode.ID_ENTROPY_VARS:       "ENTROPY_VARS",
+	wvencode.ID_HYBRID_PROFILE:     "HYBRID_PROFILE",
+	wvencode.ID_SHAPING_WEIGHTS:    "SHAPING_WEIGHTS",
+	int(wvencode.ID_FLOAT_INFO):    "FLOAT_INFO",
+	int(wvencode.ID_INT32_INFO):    "INT32_INFO",
+	wvencode.ID_WV_BITSTREAM:       "WV_BITSTREAM",
+	wvencode.ID_WVC_BITSTREAM:      "WVC_BITSTREAM",
+	int(wvencode.ID_WVX_BITSTREAM): "WVX_BITSTREAM",
+	int(wvencode.ID_CHANNEL_INFO):  "CHANNEL_INFO",
+	wvencode.ID_DSD_BLOCK:          "DSD_BLOCK",
+	int(wvencode.ID_RIFF_HEADER):   "RIFF_HEADER",
+	int(wvencode.ID_RIFF_TRAILER):  "RIFF_TRAILER",
+	wvencode.ID_CONFIG_BLOCK:       "CONFIG_BLOCK",
+	int(wvencode.ID_MD5_CHECKSUM):  "MD5_CHECKSUM",
+	wvencode.ID_SAMPLE_RATE:        "SAMPLE_RATE",
+	wvencode.ID_ALT_HEADER:         "ALT_HEADER",
+	wvencode.ID_ALT_TRAILER:        "ALT_TRAILER",
+	wvencode.ID_ALT_EXTENSION:      "ALT_EXTENSION",
+	wvencode.ID_NEW_CONFIG_BLOCK:   "NEW_CONFIG_BLOCK",
+}
+
+// the names of the single bit flags of a block header
+var flag_names = []struct {
+	flag uint
+	name string
+}{
+	{wvencode.MONO_FLAG, "MONO"},
+	{wvencode.HYBRID_FLAG, "HYBRID"},
+	{wvencode.JOINT_STEREO, "JOINT_STEREO"},
+	{wvencode.CROSS_DECORR, "CROSS_DECORR"},
+	{wvencode.HYBRID_SHAPE, "HYBRID_SHAPE"},
+	{wvencode.FLOAT_DATA, "FLOAT_DATA"},
+	{uint(wvencode.INT32_DATA), "INT32_DATA"},
+	{wvencode.HYBRID_BITRATE, "HYBRID_BITRATE"},
+	{wvencode.HYBRID_BALANCE, "HYBRID_BALANCE"},
+	{wvencode.INITIAL_BLOCK, "INITIAL_BLOCK"},
+	{wvencode.FINAL_BLOCK, "FINAL_BLOCK"},
+	{wvencode.NEW_SHAPING, "NEW_SHAPING"},
+	{wvencode.FALSE_STEREO, "FALSE_STEREO"},
+	{wvencode.DSD_FLAG, "DSD"},
+}
+
+func usage() {
+	fmt.Printf(usage0)
+	fmt.Printf(usage1)
+	fmt.Printf(usage2)
+	fmt.Printf(usage3)
+	fmt.Printf(usage4)
+
+	os.Exit(1)
+}
+
+func main() {
+	// This is the main module for the WavPack file information command. It
+	// reports the format of the audio in a WavPack file and how it was
+	// packed, and optionally the details of every block, either as text or
+	// as JSON.
+
+	var infilename string = ""
+	var verbose int = wvencode.FALSE
+	var json_output int = wvencode.FALSE
+	var error_count int = 0
+
+	for arg_idx := 1; arg_idx < len(os.Args); arg_idx++ {
+		var arg string = os.Args[arg_idx]
+
+		if arg == "--json" {
+			json_output = wvencode.TRUE
+		} else if arg == "-v" || arg == "-V" {
+			verbose = wvencode.TRUE
+		} else if arg[0] == '-' && len(arg) > 1 {
+			fmt.Printf("illegal option: %s\n", arg)
+			error_count++
+		} else if len(infilename) == 0 {
+			infilename = arg
+		} else {
+			fmt.Printf("extra unknown argument: %s\n", arg)
+			error_count++
+		}
+	}
+
+	if error_count != 0 {
+		os.Exit(1)
+	}
+
+	if len(infilename) == 0 {
+		usage()
+	}
+
+	info, result := get_file_info(infilename, verbose)
+
+	if result != wvencode.NO_ERROR {
+		os.Exit(1)
+	}
+
+	if json_output == wvencode.TRUE {
+		output, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Printf("%s\n", output)
+	} else {
+		print_file_info(info)
+	}
+}
+
+// Gather the information about "infilename". The format of the audio comes
+// from opening it with the decoder, and the rest from reading all of its
+// blocks (which are only kept if "verbose" is TRUE) and its APEv2 tag.
+func get_file_info(infilename string, verbose int) (*file_info, int) {
+	var info *file_info = new(file_info)
+	var block wvencode.WavpackBlock
+	var end_index int64 = 0
+	var audio_bytes int64
+
+	wpc := new(wvencode.WavpackContext)
+	br := new(wvencode.BlockReader)
+
+	infile, err := os.Open(infilename)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file %s\n", infilename)
+		return nil, wvencode.HARD_ERROR
+	}
+
+	defer infile.Close()
+
+	if wvencode.WavpackOpenFileInput(wpc, infile, nil) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		return nil, wvencode.HARD_ERROR
+	}
+
+	info.File = infilename
+	info.Sample_rate = wvencode.WavpackGetSampleRate(wpc)
+	info.Channels = wvencode.WavpackGetNumChannels(wpc)
+	info.Channel_mask = wvencode.WavpackGetChannelMask(wpc)
+	info.Bits_per_sample = wvencode.WavpackGetBitsPerSample(wpc)
+	info.Bytes_per_sample = wvencode.WavpackGetBytesPerSample(wpc)
+	info.Float_data = (wvencode.WavpackGetMode(wpc) & wvencode.MODE_FLOAT) != 0
+	info.Extra = (wvencode.WavpackGetMode(wpc) & wvencode.MODE_EXTRA) != 0
+	info.Total_samples = wvencode.WavpackGetNumSamples64(wpc)
+	info.Tags = make([]tag_info, 0)
+
+	switch wvencode.WavpackGetFileFormat(wpc) {
+	case wvencode.WP_FORMAT_AIF:
+		info.Source_format = "AIFF"
+	case wvencode.WP_FORMAT_CAF:
+		info.Source_format = "CAF"
+	case wvencode.WP_FORMAT_DFF:
+		info.Source_format = "DSDIFF"
+	case wvencode.WP_FORMAT_DSF:
+		info.Source_format = "DSF"
+	default:
+		info.Source_format = "WAV"
+	}
+
+	// each "sample" of DSD audio is a byte holding 8 one-bit samples
+	if (wvencode.WavpackGetQualifyMode(wpc) & wvencode.QMODE_DSD_AUDIO) != 0 {
+		info.Dsd_audio = true
+		info.Bits_per_sample = 1
+	}
+
+	if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_VERY_HIGH) != 0 {
+		info.Quality = "very high"
+	} else if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_HIGH) != 0 {
+		info.Quality = "high"
+	} else if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_FAST) != 0 {
+		info.Quality = "fast"
+	} else {
+		info.Quality = "normal"
+	}
+
+	if wvc_stat, err := os.Stat(infilename + "c"); err == nil {
+		info.Wvc_file_size = wvc_stat.Size()
+	}
+
+	if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_HYBRID) == 0 {
+		info.Mode = "lossless"
+	} else if info.Wvc_file_size != 0 {
+		info.Mode = "hybrid lossless (with .wvc)"
+	} else {
+		info.Mode = "hybrid lossy"
+	}
+
+	// now go through every block from the start of the file
+	infile.Seek(0, io.SeekStart)
+	wvencode.WavpackOpenBlockReader(br, infile)
+
+	for wvencode.WavpackReadBlock(br, &block) == wvencode.TRUE {
+		var hdr *wvencode.WavpackHeader = &block.Header
+		var block_samples uint = wvencode.WavpackGetHeaderBlockSamples(hdr)
+
+		if info.Num_blocks == 0 {
+			info.Stream_version = wvencode.WavpackGetHeaderVersion(hdr)
+		}
+
+		info.Num_blocks++
+
+		if block_samples > info.Max_block_samples {
+			info.Max_block_samples = block_samples
+		}
+
+		if wvencode.WavpackGetHeaderBlockIndex(hdr)+int64(block_samples) > end_index {
+			end_index = wvencode.WavpackGetHeaderBlockIndex(hdr) + int64(block_samples)
+		}
+
+		for i := range block.Sub_blocks {
+			if (block.Sub_blocks[i].Id == int(wvencode.ID_MD5_CHECKSUM)) && (block.Sub_blocks[i].Size == 16) {
+				info.Md5 = hex.EncodeToString(block.Sub_blocks[i].Data)
+			}
+		}
+
+		if verbose == wvencode.TRUE {
+			info.Blocks = append(info.Blocks, get_block_info(&block))
+		}
+	}
+
+	info.Trailing_bytes = wvencode.WavpackGetTrailingBytes(br)
+
+	if info.Total_samples < 0 {
+		info.Total_samples = end_index
+	}
+
+	if info.Sample_rate != 0 {
+		info.Duration = float64(info.Total_samples) / float64(info.Sample_rate)
+	}
+
+	if wvencode.WavpackReadTag(wpc, infile) == wvencode.TRUE {
+		for i := 0; i < wvencode.WavpackGetNumTagItems(wpc); i++ {
+			name, value := wvencode.WavpackGetTagItem(wpc, i)
+			info.Tags = append(info.Tags, tag_info{name, value})
+		}
+	}
+
+	if file_stat, err := infile.Stat(); err == nil {
+		info.File_size = file_stat.Size()
+	}
+
+	audio_bytes = info.Total_samples * int64(info.Channels*info.Bytes_per_sample)
+
+	if audio_bytes > 0 {
+		info.Compression = float64(info.File_size) * 100.0 / float64(audio_bytes)
+	}
+
+	if info.Duration > 0 {
+		info.Average_bitrate = float64(info.File_size) * 8.0 / info.Duration / 1000.0
+	}
+
+	// DSD rates are given in bits rather than the bytes that are stored
+	if info.Dsd_audio {
+		info.Sample_rate *= 8
+	}
+
+	return info, wvencode.NO_ERROR
+}
+
+// Get the details of a single block, including the decorrelation terms if
+// it has any.
+func get_block_info(block *wvencode.WavpackBlock) block_info {
+	var hdr *wvencode.WavpackHeader = &block.Header
+	var bi block_info
+
+	bi.Offset = block.Offset
+	bi.Size = wvencode.WavpackGetHeaderBlockSize(hdr)
+	bi.Bytes_skipped = block.Bytes_skipped
+	bi.Block_index = wvencode.WavpackGetHeaderBlockIndex(hdr)
+	bi.Block_samples = wvencode.WavpackGetHeaderBlockSamples(hdr)
+	bi.Flags = wvencode.WavpackGetHeaderFlags(hdr)
+	bi.Flag_names = decode_flags(bi.Flags)
+	bi.Crc = wvencode.WavpackGetHeaderCRC(hdr)
+	bi.Sub_blocks = make([]sub_block_info, 0, len(block.Sub_blocks))
+
+	for i := range block.Sub_blocks {
+		var sub *wvencode.WavpackSubBlock = &block.Sub_blocks[i]
+		var name string = sub_block_names[sub.Id]
+
+		if len(name) == 0 {
+			name = fmt.Sprintf("UNKNOWN_0x%02x", sub.Id)
+		}
+
+		bi.Sub_blocks = append(bi.Sub_blocks, sub_block_info{sub.Id, name, sub.Size})
+
+		// each term is stored as a byte of (term + 5) and the delta << 5
+		if sub.Id == wvencode.ID_DECORR_TERMS {
+			for _, b := range sub.Data {
+				bi.Terms = append(bi.Terms, int(b&0x1f)-5)
+			}
+		}
+	}
+
+	return bi
+}
+
+// Return the names of the flags that are set in a block header. The fields
+// of more than one bit are given as NAME=value.
+func decode_flags(flags uint) []string {
+	var names []string = make([]string, 0, 8)
+
+	names = append(names, fmt.Sprintf("BYTES_STORED=%d", (flags&wvencode.BYTES_STORED)+1))
+
+	for _, f := range flag_names {
+		if (flags & f.flag) != 0 {
+			names = append(names, f.name)
+		}
+	}
+
+	if (flags & wvencode.SHIFT_MASK) != 0 {
+		names = append(names, fmt.Sprintf("SHIFT=%d", (flags&wvencode.SHIFT_MASK)>>wvencode.SHIFT_LSB))
+	}
+
+	if (flags & wvencode.MAG_MASK) != 0 {
+		names = append(names, fmt.Sprintf("MAG=%d", (flags&wvencode.MAG_MASK)>>wvencode.MAG_LSB))
+	}
+
+	names = append(names, fmt.Sprintf("SRATE=%d", (flags&wvencode.SRATE_MASK)>>wvencode.SRATE_LSB))
+
+	return names
+}
+
+// Print the information about a file as text, in the style of the regular
+// WavPack programs.
+func print_file_info(info *file_info) {
+	var source string
+	var minutes int = int(info.Duration) / 60
+
+	if info.Dsd_audio {
+		source = fmt.Sprintf("1-bit DSD, %d ch at %d Hz", info.Channels, info.Sample_rate)
+	} else if info.Float_data {
+		source = fmt.Sprintf("32-bit floats, %d ch at %d Hz", info.Channels, info.Sample_rate)
+	} else {
+		source = fmt.Sprintf("%d-bit ints, %d ch at %d Hz", info.Bits_per_sample, info.Channels, info.Sample_rate)
+	}
+
+	fmt.Printf("file name:         %s\n", info.File)
+	fmt.Printf("file size:         %d bytes\n", info.File_size)
+
+	if info.Wvc_file_size != 0 {
+		fmt.Printf("wvc file size:     %d bytes\n", info.Wvc_file_size)
+	}
+
+	fmt.Printf("source:            %s (%s)\n", source, info.Source_format)
+	fmt.Printf("channel mask:      0x%x\n", info.Channel_mask)
+	fmt.Printf("duration:          %d:%02d:%05.2f (%d samples)\n", minutes/60, minutes%60,
+		info.Duration-float64(minutes*60), info.Total_samples)
+	fmt.Printf("mode:              %s\n", info.Mode)
+
+	if info.Extra {
+		fmt.Printf("quality:           %s, extra\n", info.Quality)
+	} else {
+		fmt.Printf("quality:           %s\n", info.Quality)
+	}
+
+	fmt.Printf("compression:       %.2f%% of the original audio (%.0f kbps)\n", info.Compression, info.Average_bitrate)
+	fmt.Printf("stream version:    0x%x\n", info.Stream_version)
+	fmt.Printf("blocks:            %d (up to %d samples)\n", info.Num_blocks, info.Max_block_samples)
+
+	if len(info.Md5) != 0 {
+		fmt.Printf("MD5 signature:     %s\n", info.Md5)
+	}
+
+	if info.Trailing_bytes != 0 {
+		fmt.Printf("after the blocks:  %d bytes\n", info.Trailing_bytes)
+	}
+
+	if len(info.Tags) != 0 {
+		fmt.Printf("APEv2 tag items:   %d\n", len(info.Tags))
+
+		for _, tag := range info.Tags {
+			fmt.Printf("  %s: %s\n", tag.Name, tag.Value)
+		}
+	}
+
+	for i, bi := range info.Blocks {
+		var terms []string
+		var sub_blocks []string
+
+		if i == 0 {
+			fmt.Printf("\n")
+		}
+
+		fmt.Printf("block %d at %d: %d bytes, index %d, %d samples, crc 0x%08x\n", i, bi.Offset,
+			bi.Size, bi.Block_index, bi.Block_samples, bi.Crc)
+
+		if bi.Bytes_skipped != 0 {
+			fmt.Printf("  (after %d bytes that aren't a block)\n", bi.Bytes_skipped)
+		}
+
+		fmt.Printf("  flags 0x%08x: %s\n", bi.Flags, strings.Join(bi.Flag_names, " "))
+
+		for _, term := range bi.Terms {
+			terms = append(terms, fmt.Sprintf("%d", term))
+		}
+
+		if len(terms) != 0 {
+			fmt.Printf("  decorr terms: %s\n", strings.Join(terms, " "))
+		}
+
+		for _, sub := range bi.Sub_blocks {
+			sub_blocks = append(sub_blocks, fmt.Sprintf("%s (%d)", sub.Name, sub.Size))
+		}
+
+		fmt.Printf("  metadata: %s\n", strings.Join(sub_blocks, ", "))
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+/*
+** WvInfo_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"../wvencode"
+)
+
+// Pack "num_samples" of 16-bit stereo audio at 48 kHz in blocks of 10000
+// samples, with an MD5 signature, to the WavPack file "filename" and return
+// the signature.
+func pack_test_file(t *testing.T, filename string, num_samples int) []byte {
+	var config wvencode.WavpackConfig
+	var samples []int = make([]int, num_samples*2)
+	var pcm []byte = make([]byte, num_samples*4)
+	var output bytes.Buffer
+
+	for i := range samples {
+		samples[i] = (i*37)%65536 - 32768
+	}
+
+	wvencode.WavpackFormatPCM(pcm, samples, 2, 0)
+	md5_sum := md5.Sum(pcm)
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 48000
+	config.Block_samples = 10000
+	config.Flags = wvencode.CONFIG_MD5_CHECKSUM | wvencode.CONFIG_HIGH_FLAG
+
+	wpc := new(wvencode.WavpackContext)
+	wpc.Outfile = &output
+
+	if wvencode.WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == wvencode.FALSE {
+		t.Fatalf("can't configure the encoder: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	wvencode.WavpackPackInit(wpc)
+	wpc.Byte_idx = 0
+
+	if (wvencode.WavpackPackSamples(wpc, samples, uint(num_samples)) == wvencode.FALSE) ||
+		(wvencode.WavpackStoreMD5Sum(wpc, md5_sum[:]) == wvencode.FALSE) ||
+		(wvencode.WavpackFlushSamples(wpc) == wvencode.FALSE) {
+		t.Fatalf("can't pack the samples: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	if err := os.WriteFile(filename, output.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	return md5_sum[:]
+}
+
+func TestGetFileInfo(t *testing.T) {
+	var filename string = filepath.Join(t.TempDir(), "test.wv")
+
+	md5_sum := pack_test_file(t, filename, 45000)
+	info, result := get_file_info(filename, wvencode.TRUE)
+
+	if result != wvencode.NO_ERROR {
+		t.Fatalf("get_file_info returned %d", result)
+	}
+
+	if (info.Sample_rate != 48000) || (info.Channels != 2) || (info.Bits_per_sample != 16) ||
+		(info.Total_samples != 45000) || (info.Duration != 45000.0/48000) {
+		t.Errorf("format read as %d Hz, %d channels, %d bits, %d samples, %f seconds", info.Sample_rate,
+			info.Channels, info.Bits_per_sample, info.Total_samples, info.Duration)
+	}
+
+	if (info.Mode != "lossless") || (info.Quality != "high") || (info.Source_format != "WAV") {
+		t.Errorf("mode read as %s, %s, %s", info.Mode, info.Quality, info.Source_format)
+	}
+
+	// five blocks of audio and one holding the MD5 signature
+	if (info.Num_blocks != 6) || (len(info.Blocks) != 6) || (info.Max_block_samples != 10000) {
+		t.Errorf("%d blocks (%d listed), longest %d samples", info.Num_blocks, len(info.Blocks), info.Max_block_samples)
+	}
+
+	if info.Md5 != hex.EncodeToString(md5_sum) {
+		t.Errorf("MD5 signature read as %s", info.Md5)
+	}
+
+	if (len(info.Blocks) != 0) && ((info.Blocks[1].Block_index != 10000) || (info.Blocks[1].Block_samples != 10000)) {
+		t.Errorf("second block listed at sample %d with %d samples", info.Blocks[1].Block_index, info.Blocks[1].Block_samples)
+	}
+
+	if _, result = get_file_info(filepath.Join(t.TempDir(), "missing.wv"), wvencode.FALSE); result == wvencode.NO_ERROR {
+		t.Errorf("a missing file gave no error")
+	}
+}
+
+func TestDecodeFlags(t *testing.T) {
+	var flags uint = 1 | wvencode.JOINT_STEREO | wvencode.INITIAL_BLOCK | (3 << wvencode.SHIFT_LSB) |
+		(15 << wvencode.MAG_LSB) | (9 << wvencode.SRATE_LSB)
+	var expected []string = []string{"BYTES_STORED=2", "JOINT_STEREO", "INITIAL_BLOCK", "SHIFT=3", "MAG=15", "SRATE=9"}
+
+	if names := decode_flags(flags); !reflect.DeepEqual(names, expected) {
+		t.Errorf("flags decoded as %v", names)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+/*
+** WvRepair.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"fmt"
+	"os"
+	"../wvencode"
+)
+
+const usage0 = "\n"
+const usage1 string = " Usage:   WvRepair [-options] infile.wv outfile.wv\n"
+const usage2 string = "\n"
+const usage3 string = "  Options: \n       -y  = overwrite an existing output file\n"
+const usage4 string = "\n"
+const usage5 string = " The valid blocks of infile.wv are copied to outfile.wv, dropping garbage,\n"
+const usage6 string = " truncated blocks and blocks that fail their crc. The total samples in the\n"
+const usage7 string = " first block is set to match the audio recovered, the blocks after any lost\n"
+const usage8 string = " audio are moved up to close the gap, and an APEv2 tag is kept if it is\n"
+const usage9 string = " still readable. Correction files (.wvc) are not repaired.\n"
+
+func usage() {
+	fmt.Printf(usage0)
+	fmt.Printf(usage1)
+	fmt.Printf(usage2)
+	fmt.Printf(usage3)
+	fmt.Printf(usage4)
+	fmt.Printf(usage5)
+	fmt.Printf(usage6)
+	fmt.Printf(usage7)
+	fmt.Printf(usage8)
+	fmt.Printf(usage9)
+
+	os.Exit(1)
+}
+
+func main() {
+	// This is the main module for the WavPack file repairer. It rebuilds a
+	// playable WavPack file from one that is damaged, typically one that
+	// was cut off part way through by the encoder being killed, which will
+	// have a wrong total samples and a truncated last block.
+
+	var infilename string = ""
+	var outfilename string = ""
+	var overwrite int = wvencode.FALSE
+	var error_count int = 0
+
+	for arg_idx := 1; arg_idx < len(os.Args); arg_idx++ {
+		var arg string = os.Args[arg_idx]
+
+		if arg[0] == '-' && len(arg) > 1 {
+			if arg == "-y" || arg == "-Y" {
+				overwrite = wvencode.TRUE
+			} else {
+				fmt.Printf("illegal option: %s\n", arg)
+				error_count++
+			}
+		} else if len(infilename) == 0 {
+			infilename = arg
+		} else if len(outfilename) == 0 {
+			outfilename = arg
+		} else {
+			fmt.Printf("extra unknown argument: %s\n", arg)
+			error_count++
+		}
+	}
+
+	if error_count != 0 {
+		os.Exit(1)
+	}
+
+	if (len(infilename) == 0) || (len(outfilename) == 0) {
+		usage()
+	}
+
+	if outfilename == infilename {
+		fmt.Printf("the output file can't be the input file!\n")
+		os.Exit(1)
+	}
+
+	if overwrite == wvencode.FALSE {
+		if _, err := os.Stat(outfilename); err == nil {
+			fmt.Printf("output file %s already exists (use -y to overwrite)!\n", outfilename)
+			os.Exit(1)
+		}
+	}
+
+	if repair_file(infilename, outfilename) != wvencode.NO_ERROR {
+		os.Exit(1)
+	}
+}
+
+// Repair "infilename" to "outfilename", printing what was recovered (and
+// what had to be dropped). The output file is removed if the repair fails.
+func repair_file(infilename string, outfilename string) int {
+	var result wvencode.WavpackRepairResult
+
+	wpc := new(wvencode.WavpackContext)
+
+	wv_file, err := os.Open(infilename)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file %s\n", infilename)
+		return wvencode.HARD_ERROR
+	}
+
+	defer wv_file.Close()
+
+	out_file, err := os.OpenFile(outfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+
+	if err != nil {
+		fmt.Printf("Error creating output file %s - error code is %s\n", outfilename, err)
+		return wvencode.HARD_ERROR
+	}
+
+	fmt.Printf("repairing %s to %s\n", infilename, outfilename)
+
+	if wvencode.WavpackRepairFile(wpc, wv_file, out_file, &result) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		out_file.Close()
+		os.Remove(outfilename)
+		return wvencode.HARD_ERROR
+	}
+
+	if err = out_file.Close(); err != nil {
+		fmt.Printf("%s is not writable!\n", outfilename)
+		os.Remove(outfilename)
+		return wvencode.HARD_ERROR
+	}
+
+	fmt.Printf("  %d blocks kept, %d dropped, %d bytes of garbage or truncated blocks skipped\n",
+		result.Blocks_kept, result.Blocks_dropped, result.Bytes_skipped)
+
+	for _, damaged := range result.Damaged_blocks {
+		fmt.Printf("  samples %d - %d dropped: crc error\n", damaged.Block_index,
+			damaged.Block_index+damaged.Block_samples-1)
+	}
+
+	for _, gap := range result.Gaps {
+		fmt.Printf("  samples %d - %d missing, the audio after them moved up %d samples\n", gap.Block_index,
+			gap.Block_index+gap.Block_samples-1, gap.Block_samples)
+	}
+
+	if result.Old_total_samples == -1 {
+		fmt.Printf("  total samples was unknown, now %d\n", result.Total_samples)
+	} else if result.Old_total_samples != result.Total_samples {
+		fmt.Printf("  total samples was %d, now %d\n", result.Old_total_samples, result.Total_samples)
+	} else {
+		fmt.Printf("  total samples is %d\n", result.Total_samples)
+	}
+
+	if result.Md5_dropped == wvencode.TRUE {
+		fmt.Printf("  MD5 signature dropped, since audio was lost\n")
+	}
+
+	if result.Tag_bytes != 0 {
+		fmt.Printf("  APEv2 tag kept (%d bytes)\n", result.Tag_bytes)
+	}
+
+	return wvencode.NO_ERROR
+}
@@ -0,0 +1,133 @@
+package main
+
+/*
+** WvRepair_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"testing"
+	"../wvencode"
+)
+
+// Pack "num_samples" of 16-bit stereo audio in blocks of 10000 samples, with
+// an MD5 signature, and return the WavPack file.
+func pack_test_audio(t *testing.T, num_samples int) []byte {
+	var config wvencode.WavpackConfig
+	var samples []int = make([]int, num_samples*2)
+	var pcm []byte = make([]byte, num_samples*4)
+	var output bytes.Buffer
+
+	for i := range samples {
+		samples[i] = (i*37)%65536 - 32768
+	}
+
+	wvencode.WavpackFormatPCM(pcm, samples, 2, 0)
+	md5_sum := md5.Sum(pcm)
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Block_samples = 10000
+	config.Flags = wvencode.CONFIG_MD5_CHECKSUM
+
+	wpc := new(wvencode.WavpackContext)
+	wpc.Outfile = &output
+
+	if wvencode.WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == wvencode.FALSE {
+		t.Fatalf("can't configure the encoder: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	wvencode.WavpackPackInit(wpc)
+	wpc.Byte_idx = 0
+
+	if (wvencode.WavpackPackSamples(wpc, samples, uint(num_samples)) == wvencode.FALSE) ||
+		(wvencode.WavpackStoreMD5Sum(wpc, md5_sum[:]) == wvencode.FALSE) ||
+		(wvencode.WavpackFlushSamples(wpc) == wvencode.FALSE) {
+		t.Fatalf("can't pack the samples: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	return output.Bytes()
+}
+
+// Verify the WavPack file "filename" and return what was found.
+func verify_test_file(t *testing.T, filename string, result *wvencode.WavpackVerifyResult) int {
+	infile, err := os.Open(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer infile.Close()
+
+	wpc := new(wvencode.WavpackContext)
+
+	if wvencode.WavpackOpenFileInput(wpc, infile, nil) == wvencode.FALSE {
+		t.Fatalf("%s: %s", filename, wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	return wvencode.WavpackVerifyFile(wpc, result)
+}
+
+// A file that was cut off and has garbage in it is repaired to one that
+// verifies, with the samples that were recovered.
+func TestRepairFile(t *testing.T) {
+	var dir string = t.TempDir()
+	var damaged_name string = filepath.Join(dir, "damaged.wv")
+	var repaired_name string = filepath.Join(dir, "repaired.wv")
+	var result wvencode.WavpackVerifyResult
+
+	wv := pack_test_audio(t, 50000)
+	damaged := append([]byte("garbage"), wv[0:len(wv)*3/4]...)
+
+	if err := os.WriteFile(damaged_name, damaged, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if verify_test_file(t, damaged_name, &result) == wvencode.TRUE {
+		t.Fatalf("the damaged file verifies")
+	}
+
+	if status := repair_file(damaged_name, repaired_name); status != wvencode.NO_ERROR {
+		t.Fatalf("repair_file returned %d", status)
+	}
+
+	if verify_test_file(t, repaired_name, &result) == wvencode.FALSE {
+		t.Errorf("the repaired file doesn't verify")
+	}
+
+	if (result.Total_samples != result.Samples_unpacked) || (result.Total_samples%10000 != 0) ||
+		(result.Total_samples < 30000) || (result.Total_samples >= 50000) {
+		t.Errorf("the repaired file has %d samples of %d", result.Samples_unpacked, result.Total_samples)
+	}
+}
+
+// With nothing to recover the repair fails and there's no output file.
+func TestRepairNothing(t *testing.T) {
+	var dir string = t.TempDir()
+	var damaged_name string = filepath.Join(dir, "damaged.wv")
+	var repaired_name string = filepath.Join(dir, "repaired.wv")
+
+	if err := os.WriteFile(damaged_name, []byte("wvpk is not enough"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if status := repair_file(damaged_name, repaired_name); status == wvencode.NO_ERROR {
+		t.Errorf("repair_file returned %d", status)
+	}
+
+	if _, err := os.Stat(repaired_name); err == nil {
+		t.Errorf("the output file was left behind")
+	}
+}
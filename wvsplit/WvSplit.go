@@ -0,0 +1,397 @@
+package main
+
+/*
+** WvSplit.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"../wvencode"
+)
+
+const usage0 = "\n"
+const usage1 string = " Usage:   WvSplit [-options] infile.wv split_point [split_point ...]\n"
+const usage2 string = "          WvSplit [-options] --cue cuefile infile.wv\n"
+const usage3 string = "\n"
+const usage4 string = "  Options: \n       -b  = move each split point to the nearest block boundary, so that\n"
+const usage5 string = "             nothing is packed again\n"
+const usage6 string = "       -n  = don't split the correction file (.wvc) even if it exists\n"
+const usage7 string = "       -y  = overwrite existing output files\n"
+const usage8 string = "       --cue cuefile = split at the start (INDEX 01) of each track of a cuesheet\n"
+const usage9 string = "\n"
+const usage10 string = " Split points are sample numbers or times as [h:]m:ss[.sss]. The pieces are\n"
+const usage11 string = " written to infile-01.wv, infile-02.wv and so on (numbered by track with a\n"
+const usage12 string = " cuesheet). Whole blocks are copied as they are and only the blocks that\n"
+const usage13 string = " straddle a split point are packed again, losslessly.\n"
+
+func usage() {
+	fmt.Printf(usage0)
+	fmt.Printf(usage1)
+	fmt.Printf(usage2)
+	fmt.Printf(usage3)
+	fmt.Printf(usage4)
+	fmt.Printf(usage5)
+	fmt.Printf(usage6)
+	fmt.Printf(usage7)
+	fmt.Printf(usage8)
+	fmt.Printf(usage9)
+	fmt.Printf(usage10)
+	fmt.Printf(usage11)
+	fmt.Printf(usage12)
+	fmt.Printf(usage13)
+
+	os.Exit(1)
+}
+
+func main() {
+	// This is the main module for the WavPack file splitter. It cuts a
+	// WavPack file (and its correction file) into pieces, such as the tracks
+	// of a long recording, without unpacking and packing the whole file.
+
+	var infilename string = ""
+	var cuefilename string = ""
+	var split_args []string
+	var use_wvc int = wvencode.TRUE
+	var block_boundaries int = wvencode.FALSE
+	var overwrite int = wvencode.FALSE
+	var error_count int = 0
+
+	for arg_idx := 1; arg_idx < len(os.Args); arg_idx++ {
+		var arg string = os.Args[arg_idx]
+
+		if (arg == "--cue") && (arg_idx+1 < len(os.Args)) {
+			arg_idx++
+			cuefilename = os.Args[arg_idx]
+		} else if strings.HasPrefix(arg, "--cue=") {
+			cuefilename = arg[6:]
+		} else if arg[0] == '-' && len(arg) > 1 {
+			if arg == "-b" || arg == "-B" {
+				block_boundaries = wvencode.TRUE
+			} else if arg == "-n" || arg == "-N" {
+				use_wvc = wvencode.FALSE
+			} else if arg == "-y" || arg == "-Y" {
+				overwrite = wvencode.TRUE
+			} else {
+				fmt.Printf("illegal option: %s\n", arg)
+				error_count++
+			}
+		} else if len(infilename) == 0 {
+			infilename = arg
+		} else {
+			split_args = append(split_args, arg)
+		}
+	}
+
+	if error_count != 0 {
+		os.Exit(1)
+	}
+
+	if (len(infilename) == 0) || ((len(split_args) == 0) == (len(cuefilename) == 0)) {
+		usage()
+	}
+
+	if split_file(infilename, cuefilename, split_args, use_wvc, block_boundaries, overwrite) != wvencode.NO_ERROR {
+		os.Exit(1)
+	}
+}
+
+// Split "infilename" (and its correction file if there is one and "use_wvc"
+// is TRUE) at the split points given on the command line, or at the tracks
+// of the cuesheet "cuefilename" if it isn't empty. The output files are
+// removed if the split fails.
+func split_file(infilename string, cuefilename string, split_args []string, use_wvc int,
+	block_boundaries int, overwrite int) int {
+	var wvc_reader io.Reader = nil
+	var split_points []int64
+	var track_numbers []int
+	var outfilenames []string
+	var outfiles []io.Writer
+	var wvc_outfiles []io.Writer = nil
+	var created []*os.File
+	var result wvencode.WavpackSplitResult
+	var sample_rate uint
+	var total_samples int64
+	var base string = infilename
+
+	wpc := new(wvencode.WavpackContext)
+
+	wv_file, err := os.Open(infilename)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file %s\n", infilename)
+		return wvencode.HARD_ERROR
+	}
+
+	defer wv_file.Close()
+
+	// the file is opened for unpacking just to get the sample rate and length
+	if wvencode.WavpackOpenFileInput(wpc, wv_file, nil) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		return wvencode.HARD_ERROR
+	}
+
+	sample_rate = wvencode.WavpackGetSampleRate(wpc)
+	total_samples = wvencode.WavpackGetNumSamples64(wpc)
+
+	if len(cuefilename) != 0 {
+		split_points, track_numbers = read_cuesheet(cuefilename, sample_rate)
+
+		if split_points == nil {
+			return wvencode.HARD_ERROR
+		}
+	} else {
+		for _, arg := range split_args {
+			var sample int64 = parse_split_point(arg, sample_rate)
+
+			if sample < 0 {
+				fmt.Printf("bad split point: %s\n", arg)
+				return wvencode.HARD_ERROR
+			}
+
+			split_points = append(split_points, sample)
+		}
+
+		for i := 0; i <= len(split_points); i++ {
+			track_numbers = append(track_numbers, i+1)
+		}
+	}
+
+	if _, err = wv_file.Seek(0, io.SeekStart); err != nil {
+		fmt.Printf("can't seek in %s!\n", infilename)
+		return wvencode.HARD_ERROR
+	}
+
+	if use_wvc == wvencode.TRUE {
+		wvc_file, err := os.Open(infilename + "c")
+
+		if err == nil {
+			defer wvc_file.Close()
+			wvc_reader = wvc_file
+		}
+	}
+
+	if dot := strings.LastIndex(infilename, "."); dot > strings.LastIndexAny(infilename, "/\\") {
+		base = infilename[0:dot]
+	}
+
+	for _, track := range track_numbers {
+		outfilenames = append(outfilenames, fmt.Sprintf("%s-%02d.wv", base, track))
+	}
+
+	for _, outfilename := range outfilenames {
+		if overwrite == wvencode.FALSE {
+			if _, err := os.Stat(outfilename); err == nil {
+				fmt.Printf("output file %s already exists (use -y to overwrite)!\n", outfilename)
+				return wvencode.HARD_ERROR
+			}
+		}
+	}
+
+	if wvc_reader != nil {
+		wvc_outfiles = make([]io.Writer, 0, len(outfilenames))
+	}
+
+	for _, outfilename := range outfilenames {
+		out_file, err := os.OpenFile(outfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+
+		if err != nil {
+			fmt.Printf("Error creating output file %s - error code is %s\n", outfilename, err)
+			remove_files(created)
+			return wvencode.HARD_ERROR
+		}
+
+		created = append(created, out_file)
+		outfiles = append(outfiles, out_file)
+
+		if wvc_reader != nil {
+			wvc_out_file, err := os.OpenFile(outfilename+"c", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+
+			if err != nil {
+				fmt.Printf("Error creating output file %s - error code is %s\n", outfilename+"c", err)
+				remove_files(created)
+				return wvencode.HARD_ERROR
+			}
+
+			created = append(created, wvc_out_file)
+			wvc_outfiles = append(wvc_outfiles, wvc_out_file)
+		}
+	}
+
+	if wvc_reader != nil {
+		fmt.Printf("splitting %s (with correction file) into %d pieces\n", infilename, len(outfilenames))
+	} else {
+		fmt.Printf("splitting %s into %d pieces\n", infilename, len(outfilenames))
+	}
+
+	if wvencode.WavpackSplitFile(wpc, wv_file, wvc_reader, split_points, block_boundaries, outfiles,
+		wvc_outfiles, &result) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		remove_files(created)
+		return wvencode.HARD_ERROR
+	}
+
+	for _, file := range created {
+		if err = file.Close(); err != nil {
+			fmt.Printf("%s is not writable!\n", file.Name())
+			remove_files(created)
+			return wvencode.HARD_ERROR
+		}
+	}
+
+	for i, outfilename := range outfilenames {
+		var start int64 = 0
+		var end int64 = total_samples
+
+		if i > 0 {
+			start = result.Split_points[i-1]
+		}
+
+		if i < len(result.Split_points) {
+			end = result.Split_points[i]
+		}
+
+		if end >= 0 {
+			fmt.Printf("  %s: samples %d - %d (%s - %s)\n", outfilename, start, end-1,
+				format_time(start, sample_rate), format_time(end, sample_rate))
+		} else {
+			fmt.Printf("  %s: samples %d - end (%s - end)\n", outfilename, start, format_time(start, sample_rate))
+		}
+	}
+
+	fmt.Printf("  %d blocks copied, %d blocks packed again\n", result.Blocks_copied, result.Blocks_packed)
+
+	return wvencode.NO_ERROR
+}
+
+// Close and remove the output files created so far, after an error.
+func remove_files(files []*os.File) {
+	for _, file := range files {
+		file.Close()
+		os.Remove(file.Name())
+	}
+}
+
+// Format the time of "sample" as h:mm:ss.sss.
+func format_time(sample int64, sample_rate uint) string {
+	var seconds float64 = float64(sample) / float64(sample_rate)
+	var minutes int = int(seconds) / 60
+
+	return fmt.Sprintf("%d:%02d:%06.3f", minutes/60, minutes%60, seconds-float64(minutes*60))
+}
+
+// Parse a split point given on the command line, which is either a sample
+// number or a time as [h:]m:ss[.sss]. A return of -1 means that it isn't
+// valid.
+func parse_split_point(arg string, sample_rate uint) int64 {
+	var seconds float64 = 0
+
+	if !strings.Contains(arg, ":") {
+		sample, err := strconv.ParseInt(arg, 10, 64)
+
+		if (err != nil) || (sample < 0) {
+			return -1
+		}
+
+		return sample
+	}
+
+	for i, field := range strings.Split(arg, ":") {
+		value, err := strconv.ParseFloat(field, 64)
+
+		if (err != nil) || (value < 0) || (i > 2) {
+			return -1
+		}
+
+		seconds = seconds*60 + value
+	}
+
+	return int64(seconds*float64(sample_rate) + 0.5)
+}
+
+// Read the cuesheet "cuefilename" and return the split points for its tracks
+// (the start of each track after the first, from its INDEX 01 entry) and
+// the numbers of the tracks. Cuesheet times are mm:ss:ff, where there are
+// 75 frames in a second. A return of nil means that the cuesheet couldn't
+// be used (and why has been printed).
+func read_cuesheet(cuefilename string, sample_rate uint) ([]int64, []int) {
+	var split_points []int64
+	var track_numbers []int
+	var track int = -1
+
+	cue_file, err := os.Open(cuefilename)
+
+	if err != nil {
+		fmt.Printf("Cannot open cuesheet %s\n", cuefilename)
+		return nil, nil
+	}
+
+	defer cue_file.Close()
+
+	scanner := bufio.NewScanner(cue_file)
+
+	for scanner.Scan() {
+		var fields []string = strings.Fields(scanner.Text())
+
+		if (len(fields) >= 2) && (strings.ToUpper(fields[0]) == "TRACK") {
+			if track, err = strconv.Atoi(fields[1]); err != nil {
+				fmt.Printf("%s: bad track number %s\n", cuefilename, fields[1])
+				return nil, nil
+			}
+
+			track_numbers = append(track_numbers, track)
+		} else if (len(fields) >= 3) && (strings.ToUpper(fields[0]) == "INDEX") && (fields[1] == "01") && (track >= 0) {
+			var frames int64 = 0
+			var parts []string = strings.Split(fields[2], ":")
+
+			if len(parts) != 3 {
+				fmt.Printf("%s: bad index time %s\n", cuefilename, fields[2])
+				return nil, nil
+			}
+
+			// minutes and seconds, then the frames
+			for i, part := range parts {
+				value, err := strconv.Atoi(part)
+
+				if (err != nil) || (value < 0) {
+					fmt.Printf("%s: bad index time %s\n", cuefilename, fields[2])
+					return nil, nil
+				}
+
+				if i < 2 {
+					frames = frames*60 + int64(value)
+				} else {
+					frames = frames*75 + int64(value)
+				}
+			}
+
+			// the first track starts at the start of the file
+			if len(track_numbers) > 1 {
+				split_points = append(split_points, frames*int64(sample_rate)/75)
+			}
+		}
+	}
+
+	if len(track_numbers) < 2 {
+		fmt.Printf("%s: there must be at least two tracks to split the file!\n", cuefilename)
+		return nil, nil
+	}
+
+	if len(split_points) != len(track_numbers)-1 {
+		fmt.Printf("%s: every track must have an INDEX 01!\n", cuefilename)
+		return nil, nil
+	}
+
+	return split_points, track_numbers
+}
@@ -0,0 +1,175 @@
+package main
+
+/*
+** WvSplit_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"../wvencode"
+)
+
+// Pack "num_samples" of 16-bit stereo audio (a ramp, so that every sample
+// is different) to the WavPack file "filename" and return the samples.
+func pack_test_file(t *testing.T, filename string, num_samples int) []int {
+	var config wvencode.WavpackConfig
+	var samples []int = make([]int, num_samples*2)
+	var buffer []int = make([]int, num_samples*2)
+	var output bytes.Buffer
+
+	for i := range samples {
+		samples[i] = (i*37)%65536 - 32768
+	}
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+
+	wpc := new(wvencode.WavpackContext)
+	wpc.Outfile = &output
+
+	if wvencode.WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == wvencode.FALSE {
+		t.Fatalf("can't configure the encoder: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	// the samples are changed by packing them
+	copy(buffer, samples)
+	wvencode.WavpackPackInit(wpc)
+	wpc.Byte_idx = 0
+
+	if (wvencode.WavpackPackSamples(wpc, buffer, uint(num_samples)) == wvencode.FALSE) ||
+		(wvencode.WavpackFlushSamples(wpc) == wvencode.FALSE) {
+		t.Fatalf("can't pack the samples: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	if err := os.WriteFile(filename, output.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	return samples
+}
+
+// Unpack all the audio of the WavPack file "filename".
+func unpack_test_file(t *testing.T, filename string) []int {
+	var buffer []int = make([]int, 2000)
+	var samples []int
+
+	infile, err := os.Open(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer infile.Close()
+
+	wpc := new(wvencode.WavpackContext)
+
+	if wvencode.WavpackOpenFileInput(wpc, infile, nil) == wvencode.FALSE {
+		t.Fatalf("%s: %s", filename, wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	for {
+		var samples_unpacked uint = wvencode.WavpackUnpackSamples(wpc, buffer, 1000)
+
+		if samples_unpacked == 0 {
+			break
+		}
+
+		samples = append(samples, buffer[0:samples_unpacked*2]...)
+	}
+
+	return samples
+}
+
+func TestParseSplitPoint(t *testing.T) {
+	for _, test := range []struct {
+		arg    string
+		sample int64
+	}{
+		{"12345", 12345}, {"0", 0}, {"1:00", 44100 * 60}, {"0:01.5", 66150}, {"1:02:03.25", 44100*3723 + 11025},
+		{"-5", -1}, {"1:x", -1}, {"1:2:3:4", -1}, {"", -1},
+	} {
+		if sample := parse_split_point(test.arg, 44100); sample != test.sample {
+			t.Errorf("%q parsed as %d instead of %d", test.arg, sample, test.sample)
+		}
+	}
+}
+
+// The tracks of a cuesheet give the split points from the INDEX 01 of each
+// track after the first, at 75 frames a second.
+func TestReadCuesheet(t *testing.T) {
+	var cuefilename string = filepath.Join(t.TempDir(), "test.cue")
+	var cuesheet string = "FILE \"test.wav\" WAVE\n" +
+		"  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n" +
+		"  TRACK 02 AUDIO\n    INDEX 00 00:01:00\n    INDEX 01 00:02:00\n" +
+		"  TRACK 03 AUDIO\n    INDEX 01 01:00:15\n"
+
+	if err := os.WriteFile(cuefilename, []byte(cuesheet), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	split_points, track_numbers := read_cuesheet(cuefilename, 44100)
+
+	if (len(split_points) != 2) || (split_points[0] != 88200) || (split_points[1] != 44100*60+8820) {
+		t.Errorf("split points read as %v", split_points)
+	}
+
+	if (len(track_numbers) != 3) || (track_numbers[2] != 3) {
+		t.Errorf("track numbers read as %v", track_numbers)
+	}
+
+	if err := os.WriteFile(cuefilename, []byte("  TRACK 01 AUDIO\n    INDEX 01 00:00:00\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if split_points, _ = read_cuesheet(cuefilename, 44100); split_points != nil {
+		t.Errorf("a cuesheet with one track gave split points %v", split_points)
+	}
+}
+
+// The pieces written by split_file() join up to give the original audio.
+func TestSplitFile(t *testing.T) {
+	var dir string = t.TempDir()
+	var infilename string = filepath.Join(dir, "test.wv")
+	var joined []int
+
+	samples := pack_test_file(t, infilename, 100000)
+
+	if result := split_file(infilename, "", []string{"0:00.5", "70000"}, wvencode.FALSE, wvencode.FALSE,
+		wvencode.FALSE); result != wvencode.NO_ERROR {
+		t.Fatalf("split_file returned %d", result)
+	}
+
+	for i, length := range []int{22050, 70000 - 22050, 30000} {
+		var piece []int = unpack_test_file(t, filepath.Join(dir, fmt.Sprintf("test-%02d.wv", i+1)))
+
+		if len(piece) != length*2 {
+			t.Errorf("piece %d is %d samples instead of %d", i+1, len(piece)/2, length)
+		}
+
+		joined = append(joined, piece...)
+	}
+
+	if !reflect.DeepEqual(joined, samples) {
+		t.Errorf("the pieces don't join up to the original audio")
+	}
+
+	// the pieces are there now, so they're not overwritten without -y
+	if result := split_file(infilename, "", []string{"70000"}, wvencode.FALSE, wvencode.FALSE,
+		wvencode.FALSE); result == wvencode.NO_ERROR {
+		t.Errorf("split_file overwrote the pieces")
+	}
+}
@@ -0,0 +1,468 @@
+package main
+
+/*
+** WvUnpack.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"strings"
+	"../wvencode"
+	"../wvencode/wav"
+)
+
+const usage0 = "\n"
+const usage1 string = " Usage:   WvUnpack [-options] infile.wv [outfile]\n"
+const usage2 string = " (default outfile is infile with the extension changed to .wav, or to .dsf\n"
+const usage3 string = "  or .dff for DSD audio, - is stdout)\n"
+const usage4 string = "\n"
+const usage5 string = "  Options: \n       -c  = conceal damaged blocks (with silence, or lossy audio if only the\n"
+const usage6 string = "             correction block is damaged) and list them\n"
+const usage7 string = "       -n  = don't use the correction file (.wvc) even if it exists\n"
+const usage8 string = "       -y  = overwrite an existing output file\n"
+const usage9 string = "\n"
+const usage10 string = " If infile.wvc exists it is used to restore the lossless audio of a hybrid\n"
+const usage11 string = " file. The original RIFF header is used if one was stored, otherwise a\n"
+const usage12 string = " canonical WAV header is written for the format of the audio. DSD audio is\n"
+const usage13 string = " written as the DSF or DSDIFF file it was packed from, or as a DSF file if\n"
+const usage14 string = " no header was stored.\n"
+
+const SAMPLES_PER_READ uint = 4096
+
+const DSF_BLOCK_SIZE uint = 4096 // bytes of each channel in a block of a DSF file
+
+// where messages go, which is stderr when the WAV file is written to stdout
+var msg_out io.Writer = os.Stdout
+
+func usage() {
+	fmt.Printf(usage0)
+	fmt.Printf(usage1)
+	fmt.Printf(usage2)
+	fmt.Printf(usage3)
+	fmt.Printf(usage4)
+	fmt.Printf(usage5)
+	fmt.Printf(usage6)
+	fmt.Printf(usage7)
+	fmt.Printf(usage8)
+	fmt.Printf(usage9)
+	fmt.Printf(usage10)
+	fmt.Printf(usage11)
+	fmt.Printf(usage12)
+	fmt.Printf(usage13)
+	fmt.Printf(usage14)
+
+	os.Exit(1)
+}
+
+func main() {
+	// This is the main module for the demonstration WavPack command-line
+	// decoder. It accepts a WavPack file (.wv) and an optional output WAV
+	// file on the command-line, and uses the correction file (.wvc) beside
+	// the WavPack file if there is one. All the integer and floating point
+	// formats that the encoder produces are unpacked, and DSD audio is
+	// written back to the DSF or DSDIFF file it came from.
+
+	var VERSION_STR string = "4.40"
+	var DATE_STR string = "2007-01-16"
+
+	var sign_on1 string = "Go WavPack Decoder (c) 2013 Peter McQuillan\n"
+	var sign_on2 string = "based on TINYPACK - Tiny Audio Compressor  Version " + VERSION_STR +
+		" " + DATE_STR + " Copyright (c) 1998 - 2013 Conifer Software.  All Rights Reserved.\n"
+
+	var infilename string = ""
+	var outfilename string = ""
+	var use_wvc int = wvencode.TRUE
+	var conceal int = wvencode.FALSE
+	var overwrite int = wvencode.FALSE
+	var error_count int = 0
+	var result int
+
+	for arg_idx := 1; arg_idx < len(os.Args); arg_idx++ {
+		var arg string = os.Args[arg_idx]
+
+		if arg[0] == '-' && len(arg) > 1 {
+			if arg == "-c" || arg == "-C" {
+				conceal = wvencode.TRUE
+			} else if arg == "-n" || arg == "-N" {
+				use_wvc = wvencode.FALSE
+			} else if arg == "-y" || arg == "-Y" {
+				overwrite = wvencode.TRUE
+			} else {
+				fmt.Printf("illegal option: %s\n", arg)
+				error_count++
+			}
+		} else if len(infilename) == 0 {
+			infilename = arg
+		} else if len(outfilename) == 0 {
+			outfilename = arg
+		} else {
+			fmt.Printf("extra unknown argument: %s\n", arg)
+			error_count++
+		}
+	}
+
+	if outfilename == "-" {
+		msg_out = os.Stderr
+	}
+
+	if error_count == 0 {
+		fmt.Fprint(msg_out, sign_on1)
+		fmt.Fprint(msg_out, sign_on2)
+	} else {
+		os.Exit(1)
+	}
+
+	if len(infilename) == 0 {
+		usage()
+	}
+
+	result = unpack_file(infilename, outfilename, use_wvc, conceal, overwrite)
+
+	if result > 0 {
+		fmt.Fprintf(msg_out, "error occured!\n")
+		os.Exit(1)
+	}
+}
+
+// This function unpacks "infilename" (and its correction file, if there is
+// one and "use_wvc" is TRUE) to the WAV file "outfilename" ("-" for stdout,
+// and if it's empty the name of the input file with its extension changed).
+// The stored RIFF header and trailer are written if there are any, otherwise
+// the WAV writer makes a header for the format of the audio. DSD audio is
+// written with the stored DSF or DSDIFF header and trailer, or as a DSF file
+// if there is no header. An existing output file is only replaced if
+// "overwrite" is TRUE. Blocks with crc errors are still unpacked, but are
+// counted and reported as an error at the end. If "conceal" is TRUE then
+// damaged blocks are concealed instead, and each concealed region is listed
+// as it is found.
+func unpack_file(infilename string, outfilename string, use_wvc int, conceal int, overwrite int) int {
+	var wvc_reader io.Reader = nil
+	var out_file *os.File
+	var ww *wav.WavWriter = nil
+	var total_samples int64
+	var samples_unpacked int64 = 0
+	var samples_missing int64 = 0
+	var result int = wvencode.NO_ERROR
+	var num_channels int
+	var bytes_per_sample int
+	var header []byte
+	var output_buffer []byte
+	var samples_per_read uint = SAMPLES_PER_READ
+	var dsd_mode int = 0
+
+	wpc := new(wvencode.WavpackContext)
+
+	wv_file, err := os.Open(infilename)
+
+	if err != nil {
+		fmt.Fprintf(msg_out, "Cannot open input file %s\n", infilename)
+		return wvencode.HARD_ERROR
+	}
+
+	defer wv_file.Close()
+
+	if use_wvc == wvencode.TRUE {
+		wvc_file, err := os.Open(infilename + "c")
+
+		if err == nil {
+			defer wvc_file.Close()
+			wvc_reader = wvc_file
+		}
+	}
+
+	if wvencode.WavpackOpenFileInput(wpc, wv_file, wvc_reader) == wvencode.FALSE {
+		fmt.Fprintf(msg_out, "%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		return wvencode.HARD_ERROR
+	}
+
+	total_samples = wvencode.WavpackGetNumSamples64(wpc)
+	num_channels = wvencode.WavpackGetNumChannels(wpc)
+	bytes_per_sample = wvencode.WavpackGetBytesPerSample(wpc)
+	header = wvencode.WavpackGetWrapperData(wpc)
+
+	// DSD audio goes back into the file it came from, if its header was kept
+	if (wvencode.WavpackGetQualifyMode(wpc) & wvencode.QMODE_DSD_AUDIO) != 0 {
+		var file_format int = wvencode.WavpackGetFileFormat(wpc)
+
+		dsd_mode = wvencode.WavpackGetQualifyMode(wpc)
+
+		if ((file_format != wvencode.WP_FORMAT_DSF) && (file_format != wvencode.WP_FORMAT_DFF)) || (len(header) == 0) {
+			if total_samples == -1 {
+				fmt.Fprintf(msg_out, "%s: can't write a DSF header for DSD audio of unknown length!\n", infilename)
+				return wvencode.HARD_ERROR
+			}
+
+			header = dsf_header(num_channels, wvencode.WavpackGetSampleRate(wpc), total_samples)
+			dsd_mode = wvencode.QMODE_DSD_LSB_FIRST | wvencode.QMODE_DSD_IN_BLOCKS
+		}
+
+		if (dsd_mode & wvencode.QMODE_DSD_IN_BLOCKS) != 0 {
+			samples_per_read = DSF_BLOCK_SIZE
+		}
+	}
+
+	if len(outfilename) == 0 {
+		var dot int = strings.LastIndex(infilename, ".")
+		var extension string = ".wav"
+
+		if dsd_mode != 0 {
+			if string(header[0:4]) == "FRM8" {
+				extension = ".dff"
+			} else {
+				extension = ".dsf"
+			}
+		}
+
+		if dot > strings.LastIndexAny(infilename, "/\\") {
+			outfilename = infilename[0:dot] + extension
+		} else {
+			outfilename = infilename + extension
+		}
+	}
+
+	if (overwrite == wvencode.FALSE) && (outfilename != "-") {
+		if _, err := os.Stat(outfilename); err == nil {
+			fmt.Fprintf(msg_out, "output file %s already exists (use -y to overwrite)!\n", outfilename)
+			return wvencode.HARD_ERROR
+		}
+	}
+
+	if conceal == wvencode.TRUE {
+		wvencode.WavpackSetErrorConcealment(wpc, wvencode.TRUE, func(region wvencode.WavpackDamagedBlock) {
+			var problem string = "crc error, unpacked as silence"
+
+			if region.Missing == wvencode.TRUE {
+				problem = "missing, unpacked as silence"
+			} else if region.Correction == wvencode.TRUE {
+				problem = "correction block missing or damaged, unpacked lossy"
+			}
+
+			fmt.Fprintf(msg_out, "%s: samples %d - %d: %s\n", infilename, region.Block_index,
+				region.Block_index+region.Block_samples-1, problem)
+		})
+	}
+
+	if outfilename == "-" {
+		out_file = os.Stdout
+	} else {
+		out_file, err = os.OpenFile(outfilename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+
+		if err != nil {
+			fmt.Fprintf(msg_out, "Error creating output file %s - error code is %s\n", outfilename, err)
+			return wvencode.HARD_ERROR
+		}
+
+		defer out_file.Close()
+	}
+
+	// the stored header is only any use to us if it is from a WAV file (or
+	// a DSD file, for DSD audio)
+	if dsd_mode != 0 {
+		if _, err = out_file.Write(header); err != nil {
+			fmt.Fprintf(msg_out, "%s is not writable!\n", outfilename)
+			return wvencode.HARD_ERROR
+		}
+
+		output_buffer = make([]byte, int(samples_per_read)*num_channels)
+	} else if (wvencode.WavpackGetFileFormat(wpc) == wvencode.WP_FORMAT_WAV) && (len(header) >= 12) &&
+		((string(header[0:4]) == "RIFF") || (string(header[0:4]) == "RF64")) {
+		if _, err = out_file.Write(header); err != nil {
+			fmt.Fprintf(msg_out, "%s is not writable!\n", outfilename)
+			return wvencode.HARD_ERROR
+		}
+
+		output_buffer = make([]byte, int(SAMPLES_PER_READ)*num_channels*bytes_per_sample)
+	} else {
+		var format wav.WavFormat
+
+		format.Sample_rate = wvencode.WavpackGetSampleRate(wpc)
+		format.Num_channels = num_channels
+		format.Bits_per_sample = wvencode.WavpackGetBitsPerSample(wpc)
+		format.Bytes_per_sample = bytes_per_sample
+		format.Channel_mask = wvencode.WavpackGetChannelMask(wpc)
+
+		if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_FLOAT) != 0 {
+			format.Float_data = wav.TRUE
+		}
+
+		ww = new(wav.WavWriter)
+
+		if wav.WavOpenOutput(ww, out_file, &format, total_samples) == wav.FALSE {
+			fmt.Fprintf(msg_out, "%s: %s\n", outfilename, wav.WavGetErrorMessage(ww))
+			return wvencode.HARD_ERROR
+		}
+	}
+
+	wvencode.WavpackFreeWrapper(wpc)
+
+	if (wvencode.WavpackGetMode(wpc) & wvencode.MODE_WVC) != 0 {
+		fmt.Fprintf(msg_out, "unpacking %s (with correction file) to %s\n", infilename, outfilename)
+	} else {
+		fmt.Fprintf(msg_out, "unpacking %s to %s\n", infilename, outfilename)
+	}
+
+	sample_buffer := make([]int, samples_per_read*uint(num_channels))
+
+	for {
+		var samples_read uint = wvencode.WavpackUnpackSamples(wpc, sample_buffer, samples_per_read)
+		var num_values int = int(samples_read) * num_channels
+
+		if samples_read == 0 {
+			break
+		}
+
+		if dsd_mode != 0 {
+			var num_bytes int = format_dsd(output_buffer, sample_buffer[0:num_values], num_channels, dsd_mode)
+
+			if _, err = out_file.Write(output_buffer[0:num_bytes]); err != nil {
+				fmt.Fprintf(msg_out, "%s is not writable!\n", outfilename)
+				return wvencode.HARD_ERROR
+			}
+		} else if ww != nil {
+			if wav.WavWriteSamples(ww, sample_buffer, samples_read) == wav.FALSE {
+				fmt.Fprintf(msg_out, "%s: %s\n", outfilename, wav.WavGetErrorMessage(ww))
+				return wvencode.HARD_ERROR
+			}
+		} else {
+			wvencode.WavpackFormatPCM(output_buffer[0:num_values*bytes_per_sample], sample_buffer[0:num_values], bytes_per_sample, 0)
+
+			if _, err = out_file.Write(output_buffer[0 : num_values*bytes_per_sample]); err != nil {
+				fmt.Fprintf(msg_out, "%s is not writable!\n", outfilename)
+				return wvencode.HARD_ERROR
+			}
+		}
+
+		samples_unpacked += int64(samples_read)
+	}
+
+	if ww != nil {
+		if wav.WavClose(ww) == wav.FALSE {
+			fmt.Fprintf(msg_out, "%s: %s\n", outfilename, wav.WavGetErrorMessage(ww))
+			return wvencode.HARD_ERROR
+		}
+	} else {
+		// anything after the audio in the original file comes last
+		wvencode.WavpackSeekTrailingWrapper(wpc)
+
+		if trailer := wvencode.WavpackGetWrapperData(wpc); len(trailer) != 0 {
+			out_file.Write(trailer)
+		}
+	}
+
+	if (total_samples != -1) && (samples_unpacked != total_samples) {
+		fmt.Fprintf(msg_out, "%s: only %d of %d samples were unpacked!\n", infilename, samples_unpacked, total_samples)
+		return wvencode.SOFT_ERROR
+	}
+
+	// the audio is still good, it just isn't all lossless
+	if ((wvencode.WavpackGetMode(wpc) & wvencode.MODE_WVC) != 0) && (wvencode.WavpackLossyBlocks(wpc) == wvencode.TRUE) {
+		fmt.Fprintf(msg_out, "%s: the correction file is incomplete or damaged, some blocks were unpacked lossy!\n", infilename)
+	}
+
+	// samples that no block was found for were unpacked as silence
+	for _, region := range wvencode.WavpackGetDamagedBlocks(wpc) {
+		if region.Missing == wvencode.TRUE {
+			samples_missing += region.Block_samples
+		}
+	}
+
+	if samples_missing != 0 {
+		fmt.Fprintf(msg_out, "%s: %d samples were missing and unpacked as silence!\n", infilename, samples_missing)
+		result = wvencode.SOFT_ERROR
+	}
+
+	if wvencode.WavpackGetNumErrors(wpc) != 0 {
+		fmt.Fprintf(msg_out, "%s: %d crc errors detected!\n", infilename, wvencode.WavpackGetNumErrors(wpc))
+		result = wvencode.SOFT_ERROR
+	}
+
+	return result
+}
+
+// Put the DSD bytes in "samples" (one for each channel of each sample) into
+// "output" in the layout of the file being written, as given by the
+// QMODE_DSD_xxx bits of "dsd_mode", and return the number of bytes. DSF
+// files have a block of DSF_BLOCK_SIZE bytes for each channel in turn (the
+// last ones padded with zeros), and DSDIFF files have the channels
+// interleaved. The bits of each byte are reversed for LSB first files.
+func format_dsd(output []byte, samples []int, num_channels int, dsd_mode int) int {
+	var sample_count int = len(samples) / num_channels
+	var num_bytes int = len(samples)
+
+	if (dsd_mode & wvencode.QMODE_DSD_IN_BLOCKS) != 0 {
+		num_bytes = int(DSF_BLOCK_SIZE) * num_channels
+
+		for ch := 0; ch < num_channels; ch++ {
+			var block []byte = output[ch*int(DSF_BLOCK_SIZE) : (ch+1)*int(DSF_BLOCK_SIZE)]
+
+			for i := range block {
+				if i < sample_count {
+					block[i] = byte(samples[i*num_channels+ch])
+				} else {
+					block[i] = 0
+				}
+			}
+		}
+	} else {
+		for i := 0; i < num_bytes; i++ {
+			output[i] = byte(samples[i])
+		}
+	}
+
+	if (dsd_mode & wvencode.QMODE_DSD_LSB_FIRST) != 0 {
+		for i := 0; i < num_bytes; i++ {
+			output[i] = bits.Reverse8(output[i])
+		}
+	}
+
+	return num_bytes
+}
+
+// Make the header of a DSF file (the "DSD ", "fmt " and "data" chunks) for
+// "total_samples" samples of DSD audio with "num_channels" channels, at a
+// "sample_rate" of that many bytes per second for each channel. The audio
+// is padded to whole blocks of DSF_BLOCK_SIZE bytes, and there is no
+// metadata chunk after it.
+func dsf_header(num_channels int, sample_rate uint, total_samples int64) []byte {
+	var header []byte = make([]byte, 92)
+	var num_blocks int64 = (total_samples + int64(DSF_BLOCK_SIZE) - 1) / int64(DSF_BLOCK_SIZE)
+	var data_bytes int64 = num_blocks * int64(DSF_BLOCK_SIZE) * int64(num_channels)
+	var channel_type uint32 = 2
+
+	if num_channels == 1 {
+		channel_type = 1
+	}
+
+	copy(header[0:4], "DSD ")
+	binary.LittleEndian.PutUint64(header[4:12], 28)
+	binary.LittleEndian.PutUint64(header[12:20], uint64(int64(len(header))+data_bytes))
+	binary.LittleEndian.PutUint64(header[20:28], 0)
+
+	copy(header[28:32], "fmt ")
+	binary.LittleEndian.PutUint64(header[32:40], 52)
+	binary.LittleEndian.PutUint32(header[40:44], 1) // format version
+	binary.LittleEndian.PutUint32(header[44:48], 0) // format id (raw DSD)
+	binary.LittleEndian.PutUint32(header[48:52], channel_type)
+	binary.LittleEndian.PutUint32(header[52:56], uint32(num_channels))
+	binary.LittleEndian.PutUint32(header[56:60], uint32(sample_rate*8))
+	binary.LittleEndian.PutUint32(header[60:64], 1) // bits per sample
+	binary.LittleEndian.PutUint64(header[64:72], uint64(total_samples*8))
+	binary.LittleEndian.PutUint32(header[72:76], uint32(DSF_BLOCK_SIZE))
+	binary.LittleEndian.PutUint32(header[76:80], 0)
+
+	copy(header[80:84], "data")
+	binary.LittleEndian.PutUint64(header[84:92], uint64(12+data_bytes))
+
+	return header
+}
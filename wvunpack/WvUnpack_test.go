@@ -0,0 +1,111 @@
+package main
+
+/*
+** WvUnpack_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"../wvencode"
+)
+
+// Pack "samples" (interleaved, as values of "bytes_per_sample" bytes with
+// only the top "bits_per_sample" bits used) to the WavPack file "filename".
+func pack_test_file(t *testing.T, filename string, bits_per_sample int, bytes_per_sample int, num_channels int, samples []int) {
+	var config wvencode.WavpackConfig
+	var num_samples int = len(samples) / num_channels
+	var output bytes.Buffer
+
+	config.Bits_per_sample = bits_per_sample
+	config.Bytes_per_sample = bytes_per_sample
+	config.Num_channels = uint(num_channels)
+	config.Sample_rate = 44100
+
+	wpc := new(wvencode.WavpackContext)
+	wpc.Outfile = &output
+
+	if wvencode.WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == wvencode.FALSE {
+		t.Fatalf("can't configure the encoder: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	wvencode.WavpackPackInit(wpc)
+	wpc.Byte_idx = 0
+
+	if (wvencode.WavpackPackSamples(wpc, samples, uint(num_samples)) == wvencode.FALSE) ||
+		(wvencode.WavpackFlushSamples(wpc) == wvencode.FALSE) {
+		t.Fatalf("can't pack the samples: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	if err := os.WriteFile(filename, output.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Return the data chunk of the WAV file "filename".
+func wav_data(t *testing.T, filename string) []byte {
+	data, err := os.ReadFile(filename)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 12; index+8 <= len(data); {
+		var size int = int(binary.LittleEndian.Uint32(data[index+4:]))
+
+		if string(data[index:index+4]) == "data" {
+			return data[index+8 : index+8+size]
+		}
+
+		index += 8 + size + (size & 1)
+	}
+
+	t.Fatalf("%s has no data chunk", filename)
+
+	return nil
+}
+
+// Audio with fewer valid bits than bytes (12 bits in 2 bytes and 20 bits in
+// 3) has to come back with the samples unchanged, not shifted again.
+func TestUnpackPartialBytes(t *testing.T) {
+	var dir string = t.TempDir()
+
+	for _, format := range []struct{ bits, bytes int }{{12, 2}, {20, 3}, {16, 2}, {24, 3}} {
+		var shift uint = uint(format.bytes*8 - format.bits)
+		var samples []int = make([]int, 2*5000)
+		var expected []byte = make([]byte, len(samples)*format.bytes)
+		var wv_name string = filepath.Join(dir, "partial.wv")
+		var wav_name string = filepath.Join(dir, "partial.wav")
+
+		for i := range samples {
+			var value int = ((i*7919)%(1<<uint(format.bits)) - (1 << uint(format.bits-1))) << shift
+
+			samples[i] = value
+			wvencode.WavpackFormatPCM(expected[i*format.bytes:(i+1)*format.bytes], samples[i:i+1], format.bytes, 0)
+		}
+
+		// a 12-bit sample of 304 has to stay 304
+		samples[0] = 304
+		wvencode.WavpackFormatPCM(expected[0:format.bytes], samples[0:1], format.bytes, 0)
+
+		pack_test_file(t, wv_name, format.bits, format.bytes, 2, samples)
+
+		if result := unpack_file(wv_name, wav_name, wvencode.FALSE, wvencode.FALSE, wvencode.TRUE); result != wvencode.NO_ERROR {
+			t.Fatalf("%d-bit: unpack_file returned %d", format.bits, result)
+		}
+
+		if data := wav_data(t, wav_name); !bytes.Equal(data, expected) {
+			t.Errorf("%d-bit: the unpacked audio doesn't match what was packed", format.bits)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+/*
+** WvVerify.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"../wvencode"
+)
+
+const usage0 = "\n"
+const usage1 string = " Usage:   WvVerify [-options] infile.wv [infile.wv ...]\n"
+const usage2 string = "\n"
+const usage3 string = "  Options: \n       -n  = don't verify the correction file (.wvc) even if it exists\n"
+const usage4 string = "       -q  = quiet, only report the files that fail\n"
+const usage5 string = "\n"
+const usage6 string = " Every block is unpacked and checked against its crc (and the MD5 signature\n"
+const usage7 string = " is checked if there is one). The command fails if any file is damaged.\n"
+
+func usage() {
+	fmt.Printf(usage0)
+	fmt.Printf(usage1)
+	fmt.Printf(usage2)
+	fmt.Printf(usage3)
+	fmt.Printf(usage4)
+	fmt.Printf(usage5)
+	fmt.Printf(usage6)
+	fmt.Printf(usage7)
+
+	os.Exit(1)
+}
+
+func main() {
+	// This is the main module for the WavPack file verifier. It checks that
+	// WavPack files (and their correction files) are intact without the
+	// original audio, and for those that aren't it reports where they are
+	// damaged, by block and by time.
+
+	var infilenames []string
+	var use_wvc int = wvencode.TRUE
+	var quiet int = wvencode.FALSE
+	var error_count int = 0
+	var failed_count int = 0
+
+	for arg_idx := 1; arg_idx < len(os.Args); arg_idx++ {
+		var arg string = os.Args[arg_idx]
+
+		if arg[0] == '-' && len(arg) > 1 {
+			if arg == "-n" || arg == "-N" {
+				use_wvc = wvencode.FALSE
+			} else if arg == "-q" || arg == "-Q" {
+				quiet = wvencode.TRUE
+			} else {
+				fmt.Printf("illegal option: %s\n", arg)
+				error_count++
+			}
+		} else {
+			infilenames = append(infilenames, arg)
+		}
+	}
+
+	if error_count != 0 {
+		os.Exit(1)
+	}
+
+	if len(infilenames) == 0 {
+		usage()
+	}
+
+	for _, infilename := range infilenames {
+		if verify_file(infilename, use_wvc, quiet) != wvencode.NO_ERROR {
+			failed_count++
+		}
+	}
+
+	if len(infilenames) > 1 {
+		fmt.Printf("%d of %d files verified\n", len(infilenames)-failed_count, len(infilenames))
+	}
+
+	if failed_count != 0 {
+		os.Exit(1)
+	}
+}
+
+// Verify "infilename" (and its correction file if there is one and "use_wvc"
+// is TRUE), printing what is wrong with it if it isn't intact (and, unless
+// "quiet" is TRUE, that it is if it is).
+func verify_file(infilename string, use_wvc int, quiet int) int {
+	var wvc_reader io.Reader = nil
+	var result wvencode.WavpackVerifyResult
+	var sample_rate uint
+	var name string = infilename
+
+	wpc := new(wvencode.WavpackContext)
+
+	wv_file, err := os.Open(infilename)
+
+	if err != nil {
+		fmt.Printf("Cannot open input file %s\n", infilename)
+		return wvencode.HARD_ERROR
+	}
+
+	defer wv_file.Close()
+
+	if use_wvc == wvencode.TRUE {
+		wvc_file, err := os.Open(infilename + "c")
+
+		if err == nil {
+			defer wvc_file.Close()
+			wvc_reader = wvc_file
+			name = infilename + " (with correction file)"
+		}
+	}
+
+	if wvencode.WavpackOpenFileInput(wpc, wv_file, wvc_reader) == wvencode.FALSE {
+		fmt.Printf("%s: %s\n", infilename, wvencode.WavpackGetErrorMessage(wpc))
+		return wvencode.HARD_ERROR
+	}
+
+	sample_rate = wvencode.WavpackGetSampleRate(wpc)
+
+	if wvencode.WavpackVerifyFile(wpc, &result) == wvencode.TRUE {
+		if quiet == wvencode.FALSE {
+			if result.Md5_checked == wvencode.TRUE {
+				fmt.Printf("%s: ok, MD5 signature verified\n", name)
+			} else {
+				fmt.Printf("%s: ok\n", name)
+			}
+		}
+
+		return wvencode.NO_ERROR
+	}
+
+	fmt.Printf("%s: FAILED, %s\n", name, wvencode.WavpackGetErrorMessage(wpc))
+
+	for _, damaged := range result.Damaged_blocks {
+		var last_sample int64 = damaged.Block_index + damaged.Block_samples - 1
+		var problem string = "crc error"
+
+		if damaged.Missing == wvencode.TRUE {
+			problem = "missing"
+		} else if damaged.Correction == wvencode.TRUE {
+			problem = "correction block missing or damaged"
+		}
+
+		fmt.Printf("  samples %d - %d (%s - %s): %s\n", damaged.Block_index, last_sample,
+			format_time(damaged.Block_index, sample_rate), format_time(last_sample+1, sample_rate), problem)
+	}
+
+	if (result.Md5_checked == wvencode.TRUE) && (result.Md5_match == wvencode.FALSE) {
+		fmt.Printf("  MD5 signature doesn't match\n")
+	}
+
+	return wvencode.SOFT_ERROR
+}
+
+// Format the time of "sample" as h:mm:ss.sss.
+func format_time(sample int64, sample_rate uint) string {
+	var seconds float64 = float64(sample) / float64(sample_rate)
+	var minutes int = int(seconds) / 60
+
+	return fmt.Sprintf("%d:%02d:%06.3f", minutes/60, minutes%60, seconds-float64(minutes*60))
+}
@@ -0,0 +1,115 @@
+package main
+
+/*
+** WvVerify_test.go
+**
+** Copyright (c) 2013 Peter McQuillan
+**
+** All Rights Reserved.
+**
+** Distributed under the BSD Software License (see license.txt)
+**
+ */
+
+import (
+	"bytes"
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"testing"
+	"../wvencode"
+)
+
+// Pack "num_samples" of 16-bit stereo audio in blocks of 10000 samples to
+// the WavPack file "filename", with "md5_sum" as its MD5 signature (which
+// is the right one if it's nil).
+func pack_test_file(t *testing.T, filename string, num_samples int, md5_sum []byte) []byte {
+	var config wvencode.WavpackConfig
+	var samples []int = make([]int, num_samples*2)
+	var pcm []byte = make([]byte, num_samples*4)
+	var output bytes.Buffer
+
+	for i := range samples {
+		samples[i] = (i*37)%65536 - 32768
+	}
+
+	wvencode.WavpackFormatPCM(pcm, samples, 2, 0)
+
+	if md5_sum == nil {
+		sum := md5.Sum(pcm)
+		md5_sum = sum[:]
+	}
+
+	config.Bits_per_sample = 16
+	config.Bytes_per_sample = 2
+	config.Num_channels = 2
+	config.Sample_rate = 44100
+	config.Block_samples = 10000
+	config.Flags = wvencode.CONFIG_MD5_CHECKSUM
+
+	wpc := new(wvencode.WavpackContext)
+	wpc.Outfile = &output
+
+	if wvencode.WavpackSetConfiguration64(wpc, &config, int64(num_samples)) == wvencode.FALSE {
+		t.Fatalf("can't configure the encoder: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	wvencode.WavpackPackInit(wpc)
+	wpc.Byte_idx = 0
+
+	if (wvencode.WavpackPackSamples(wpc, samples, uint(num_samples)) == wvencode.FALSE) ||
+		(wvencode.WavpackStoreMD5Sum(wpc, md5_sum) == wvencode.FALSE) ||
+		(wvencode.WavpackFlushSamples(wpc) == wvencode.FALSE) {
+		t.Fatalf("can't pack the samples: %s", wvencode.WavpackGetErrorMessage(wpc))
+	}
+
+	if err := os.WriteFile(filename, output.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.Bytes()
+}
+
+// An intact file verifies, while one that is cut off or whose MD5 signature
+// doesn't match fails.
+func TestVerifyFile(t *testing.T) {
+	var dir string = t.TempDir()
+	var good_name string = filepath.Join(dir, "good.wv")
+	var cut_name string = filepath.Join(dir, "cut.wv")
+	var md5_name string = filepath.Join(dir, "md5.wv")
+
+	wv := pack_test_file(t, good_name, 50000, nil)
+
+	if result := verify_file(good_name, wvencode.TRUE, wvencode.TRUE); result != wvencode.NO_ERROR {
+		t.Errorf("the intact file failed with %d", result)
+	}
+
+	if err := os.WriteFile(cut_name, wv[0:len(wv)*2/3], 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if result := verify_file(cut_name, wvencode.TRUE, wvencode.TRUE); result != wvencode.SOFT_ERROR {
+		t.Errorf("the file that was cut off gave %d", result)
+	}
+
+	pack_test_file(t, md5_name, 50000, make([]byte, 16))
+
+	if result := verify_file(md5_name, wvencode.TRUE, wvencode.TRUE); result != wvencode.SOFT_ERROR {
+		t.Errorf("the file with the wrong MD5 signature gave %d", result)
+	}
+
+	if result := verify_file(filepath.Join(dir, "missing.wv"), wvencode.TRUE, wvencode.TRUE); result != wvencode.HARD_ERROR {
+		t.Errorf("a missing file gave %d", result)
+	}
+}
+
+func TestFormatTime(t *testing.T) {
+	for _, test := range []struct {
+		sample int64
+		time   string
+	}{{0, "0:00:00.000"}, {66150, "0:00:01.500"}, {44100*3723 + 11025, "1:02:03.250"}} {
+		if time := format_time(test.sample, 44100); time != test.time {
+			t.Errorf("sample %d formatted as %s instead of %s", test.sample, time, test.time)
+		}
+	}
+}